@@ -1,17 +1,21 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 
+	"mailflow/internals/ai"
 	"mailflow/internals/config"
 	"mailflow/internals/data"
+	"mailflow/internals/graph"
 	"mailflow/internals/llm"
 	"mailflow/internals/rag"
 	"mailflow/internals/rag/adapter"
+	"mailflow/internals/review"
 	"mailflow/pkg/logging"
 
 	"github.com/gorilla/mux"
@@ -25,12 +29,22 @@ func main() {
 	if err != nil {
 		logging.Fatal("Failed to load configuration: %v", err)
 	}
+	if len(cfg.GoogleAPIKey) < 5 {
+		logging.Fatal("GOOGLE_API_KEY is required for Gemini embeddings.")
+	}
 	logging.Info("Configuration loaded successfully. Port: %d, Google API Key: %s (first 5 chars)", cfg.Port, cfg.GoogleAPIKey[:5])
 
-	geminiEmbedder := llm.NewGeminiEmbedder(cfg.GoogleAPIKey)
-	vectorStore := adapter.NewInMemoryVectorStore()
-	chunker := rag.NewSimpleTextChunker(rag.DefaultChunkSize, rag.DefaultChunkOverlap)
-	ragSystem := rag.NewRAGSystem(chunker, geminiEmbedder, vectorStore)
+	geminiEmbedder := llm.NewGeminiEmbedder(cfg.GoogleAPIKey, "").WithRetryPolicy(cfg.LLM.Retry)
+	vectorStore, err := adapter.New(cfg.VectorStore)
+	if err != nil {
+		logging.Fatal("Failed to open %s vector store: %v", cfg.VectorStore.Store, err)
+	}
+	defer vectorStore.Close()
+	chunkers, err := rag.NewChunkers(cfg.Chunkers, cfg.ChunkLimits)
+	if err != nil {
+		logging.Fatal("Invalid chunkers: %v", err)
+	}
+	ragSystem := rag.NewRAGSystem(nil, geminiEmbedder, vectorStore).WithChunkers(chunkers)
 	logging.Info("RAG system initialized for API service.")
 
 	dataSvc := data.NewDataUploadService(ragSystem)
@@ -41,6 +55,17 @@ func main() {
 	r := mux.NewRouter()
 	data.MakeHTTPHandler(r, endpoints)
 
+	r.HandleFunc("/workflow/graph", serveWorkflowGraph).Methods("GET")
+	r.HandleFunc("/llm/stats", serveLLMStats).Methods("GET")
+
+	// Replies awaiting approval are queued by the mail worker in the same database.
+	reviews, err := review.Open(cfg.ReviewDB)
+	if err != nil {
+		logging.Fatal("Failed to open review queue: %v", err)
+	}
+	defer reviews.Close()
+	review.MakeHTTPHandler(r, review.NewEndpoints(reviews))
+
 	serveWebBuild(r, "./web/dist")
 
 	port := os.Getenv("PORT")
@@ -56,6 +81,34 @@ func main() {
 	}
 }
 
+// serveLLMStats reports requests, retries, rate limiting and breaker trips per model.
+func serveLLMStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(llm.Stats()); err != nil {
+		logging.Error("Failed to encode LLM stats: %v", err)
+	}
+}
+
+// serveWorkflowGraph renders the email workflow for the dashboard, ?format=mermaid (default) or dot.
+func serveWorkflowGraph(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = graph.FormatMermaid
+	}
+	workflowApp, err := ai.NewWorkflow(nil, nil, ai.Options{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rendered, err := workflowApp.Graph.Export(format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, rendered)
+}
+
 func serveWebBuild(router *mux.Router, staticFilesPath string) {
 	router.PathPrefix("/static/").Handler(http.FileServer(http.Dir(staticFilesPath)))
 	router.PathPrefix("/").Handler(http.FileServer(http.Dir(staticFilesPath)))
@@ -9,7 +9,6 @@ import (
 	"mailflow/internals/rag"
 	"mailflow/internals/rag/adapter"
 	"mailflow/pkg/logging"
-	"math"
 	"time"
 )
 
@@ -23,6 +22,9 @@ func main() {
 	if err != nil {
 		logging.Fatal("Failed to load configuration: %v", err)
 	}
+	if len(cfg.GoogleAPIKey) < 5 {
+		logging.Fatal("GOOGLE_API_KEY is required for Gemini embeddings.")
+	}
 	logging.Info("Configuration loaded successfully. Google API Key: %s (first 5 chars)", cfg.GoogleAPIKey[:5])
 
 	agencyContent, err := ioutil.ReadFile(agencyDataPath)
@@ -31,12 +33,19 @@ func main() {
 	}
 	logging.Info("Successfully read %d bytes from %s.", len(agencyContent), agencyDataPath)
 
-	chunker := rag.NewSimpleTextChunker(rag.DefaultChunkSize, rag.DefaultChunkOverlap)
+	chunkers, err := rag.NewChunkers(cfg.Chunkers, cfg.ChunkLimits)
+	if err != nil {
+		logging.Fatal("Invalid chunkers: %v", err)
+	}
 
-	geminiEmbedder := llm.NewGeminiEmbedder(cfg.GoogleAPIKey)
-	vectorStore := adapter.NewInMemoryVectorStore()
+	geminiEmbedder := llm.NewGeminiEmbedder(cfg.GoogleAPIKey, "").WithRetryPolicy(cfg.LLM.Retry)
+	vectorStore, err := adapter.New(cfg.VectorStore)
+	if err != nil {
+		logging.Fatal("Failed to open %s vector store: %v", cfg.VectorStore.Store, err)
+	}
+	defer vectorStore.Close()
 
-	ragSystem := rag.NewRAGSystem(chunker, geminiEmbedder, vectorStore)
+	ragSystem := rag.NewRAGSystem(nil, geminiEmbedder, vectorStore).WithChunkers(chunkers)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
 	defer cancel()
@@ -57,57 +66,17 @@ func main() {
 
 	fmt.Println("\n--- Demonstrating RAG Retrieval ---")
 	query := "What services does the agency provide?"
-	retrievedChunks, err := ragSystem.Retrieve(ctx, query, 3)
+	retrievedChunks, err := ragSystem.Retrieve(ctx, query, rag.RetrieveOptions{
+		SearchOptions: rag.SearchOptions{TopN: cfg.RAGTopK, MinScore: cfg.RAGMinScore},
+		Mode:          cfg.RAGMode,
+		Fusion:        cfg.RAGFusion,
+	})
 	if err != nil {
 		logging.Error("Failed to retrieve chunks: %v", err)
 	} else {
 		logging.Info("Retrieved %d chunks for query '%s':", len(retrievedChunks), query)
 		for i, chunk := range retrievedChunks {
-			fmt.Printf("Chunk %d (ID: %s, Score: %.4f):\n---\n%s\n---\n", i+1, chunk.ID, calculateSimilarity(query, chunk.Content, geminiEmbedder), chunk.Content)
+			fmt.Printf("Chunk %d (ID: %s, Score: %.4f):\n---\n%s\n---\n", i+1, chunk.ID, chunk.Score, chunk.Content)
 		}
 	}
 }
-
-// calculateSimilarity is a helper function to demonstrate similarity for display purposes.
-// In a real scenario, the score would come directly from the vector store search.
-func calculateSimilarity(text1, text2 string, embedder rag.Embedder) float64 {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	emb1, err := embedder.Embed(ctx, text1)
-	if err != nil {
-		logging.Error("Error embedding text1 for similarity calculation: %v", err)
-		return 0.0
-	}
-	emb2, err := embedder.Embed(ctx, text2)
-	if err != nil {
-		logging.Error("Error embedding text2 for similarity calculation: %v", err)
-		return 0.0
-	}
-
-	return cosineSimilarity(emb1, emb2)
-}
-
-// cosineSimilarity calculates the cosine similarity between two vectors.
-// This is duplicated from inmemory.go for demonstration in main.
-func cosineSimilarity(vecA, vecB []float32) float64 {
-	if len(vecA) != len(vecB) {
-		return 0.0
-	}
-
-	var dotProduct float64
-	var normA float64
-	var normB float64
-
-	for i := 0; i < len(vecA); i++ {
-		dotProduct += float64(vecA[i] * vecB[i])
-		normA += float64(vecA[i] * vecA[i])
-		normB += float64(vecB[i] * vecB[i])
-	}
-
-	if normA == 0 || normB == 0 {
-		return 0.0
-	}
-
-	return dotProduct / (math.Sqrt(normA) * math.Sqrt(normB))
-}
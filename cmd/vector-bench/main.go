@@ -0,0 +1,186 @@
+// Command vector-bench compares the exhaustive in-memory vector store with the
+// HNSW index on synthetic, clustered embeddings: build time, query latency and
+// recall against the exact results.
+//
+//	go run ./cmd/vector-bench -n 50000 -dim 768 -ef 16,32,64,128,256
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"mailflow/internals/rag"
+	"mailflow/internals/rag/adapter"
+)
+
+func main() {
+	n := flag.Int("n", 20000, "chunks to index")
+	dim := flag.Int("dim", 256, "embedding dimension")
+	clusters := flag.Int("clusters", 200, "topics the synthetic embeddings are grouped around")
+	queries := flag.Int("queries", 200, "queries to run per configuration")
+	k := flag.Int("k", 10, "results per query")
+	m := flag.Int("m", adapter.DefaultHNSWConfig().M, "HNSW neighbours per node")
+	efConstruction := flag.Int("ef-construction", adapter.DefaultHNSWConfig().EfConstruction, "HNSW candidates per insert")
+	efList := flag.String("ef", "16,32,64,128,256", "comma-separated HNSW efSearch values to measure")
+	seed := flag.Int64("seed", 1, "random seed")
+	flag.Parse()
+
+	efs, err := parseInts(*efList)
+	if err != nil {
+		log.Fatalf("invalid -ef: %v", err)
+	}
+	// The stores log every insert and search; keep that out of the timings.
+	log.SetOutput(io.Discard)
+
+	rng := rand.New(rand.NewSource(*seed))
+	chunks := generateChunks(rng, *n, *dim, *clusters)
+	queryVectors := make([][]float32, *queries)
+	for i := range queryVectors {
+		queryVectors[i] = chunks[rng.Intn(len(chunks))].Embedding
+		queryVectors[i] = jitter(rng, queryVectors[i], 0.3)
+	}
+	ctx := context.Background()
+
+	exact := adapter.NewInMemoryVectorStore()
+	exactBuild := timeIt(func() { exact.AddChunks(ctx, chunks) })
+	truth := make([][]string, len(queryVectors))
+	exactLatencies := make([]time.Duration, len(queryVectors))
+	for i, q := range queryVectors {
+		var results []rag.ScoredChunk
+		exactLatencies[i] = timeIt(func() { results, _ = exact.Search(ctx, q, rag.SearchOptions{TopN: *k}) })
+		truth[i] = ids(results)
+	}
+
+	config := adapter.HNSWConfig{M: *m, EfConstruction: *efConstruction, EfSearch: efs[0], Seed: *seed}
+	index := adapter.NewHNSW(config)
+	hnswBuild := timeIt(func() {
+		for _, chunk := range chunks {
+			index.Add(chunk.Embedding)
+		}
+	})
+
+	fmt.Fprintf(os.Stderr, "%d chunks, %d dimensions, %d clusters, %d queries, top %d\n\n", *n, *dim, *clusters, *queries, *k)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "index\tbuild\tmean\tp50\tp95\tqueries/s\trecall@k\t")
+	report(w, "exact", exactBuild, exactLatencies, 1)
+
+	for _, ef := range efs {
+		latencies := make([]time.Duration, len(queryVectors))
+		var recall float64
+		for i, q := range queryVectors {
+			var hits []adapter.Neighbor
+			latencies[i] = timeIt(func() { hits = index.Search(q, *k, ef) })
+			found := make([]string, len(hits))
+			for j, hit := range hits {
+				found[j] = chunks[hit.ID].ID
+			}
+			recall += overlap(truth[i], found)
+		}
+		report(w, fmt.Sprintf("hnsw ef=%d", ef), hnswBuild, latencies, recall/float64(len(queryVectors)))
+	}
+	w.Flush()
+}
+
+// generateChunks scatters n unit vectors around random cluster centres, which
+// resembles embeddings of documents about a limited set of topics.
+func generateChunks(rng *rand.Rand, n, dim, clusters int) []rag.Chunk {
+	centres := make([][]float32, clusters)
+	for i := range centres {
+		centres[i] = randomVector(rng, dim)
+	}
+	chunks := make([]rag.Chunk, n)
+	for i := range chunks {
+		chunks[i] = rag.Chunk{
+			ID:         fmt.Sprintf("chunk-%d", i),
+			DocumentID: fmt.Sprintf("doc-%d", i/50),
+			Embedding:  jitter(rng, centres[rng.Intn(clusters)], 0.6),
+		}
+	}
+	return chunks
+}
+
+func randomVector(rng *rand.Rand, dim int) []float32 {
+	v := make([]float32, dim)
+	for i := range v {
+		v[i] = float32(rng.NormFloat64())
+	}
+	return v
+}
+
+// jitter adds gaussian noise of the given relative strength to v.
+func jitter(rng *rand.Rand, v []float32, strength float64) []float32 {
+	var norm float64
+	for _, x := range v {
+		norm += float64(x) * float64(x)
+	}
+	scale := strength * math.Sqrt(norm/float64(len(v)))
+	out := make([]float32, len(v))
+	for i, x := range v {
+		out[i] = x + float32(rng.NormFloat64()*scale)
+	}
+	return out
+}
+
+func report(w io.Writer, name string, build time.Duration, latencies []time.Duration, recall float64) {
+	slices.Sort(latencies)
+	var total time.Duration
+	for _, l := range latencies {
+		total += l
+	}
+	mean := total / time.Duration(len(latencies))
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%.0f\t%.3f\t\n", name,
+		build.Round(time.Millisecond), mean.Round(time.Microsecond),
+		latencies[len(latencies)/2].Round(time.Microsecond), latencies[len(latencies)*95/100].Round(time.Microsecond),
+		float64(len(latencies))/total.Seconds(), recall)
+}
+
+// overlap returns the share of want found in got.
+func overlap(want, got []string) float64 {
+	if len(want) == 0 {
+		return 1
+	}
+	hits := 0
+	for _, id := range want {
+		if slices.Contains(got, id) {
+			hits++
+		}
+	}
+	return float64(hits) / float64(len(want))
+}
+
+func ids(chunks []rag.ScoredChunk) []string {
+	out := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		out[i] = chunk.ID
+	}
+	return out
+}
+
+func timeIt(f func()) time.Duration {
+	start := time.Now()
+	f()
+	return time.Since(start)
+}
+
+func parseInts(list string) ([]int, error) {
+	var out []int
+	for _, field := range strings.Split(list, ",") {
+		v, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || v <= 0 {
+			return nil, fmt.Errorf("%q is not a positive number", field)
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"mailflow/internals/config"
+	"mailflow/internals/email/gmail"
+	"mailflow/internals/inbox"
+	"mailflow/pkg/logging"
+)
+
+// gmailWatchRenewal is how often users.watch is renewed. Gmail expires a watch
+// after 7 days and recommends renewing it daily.
+const gmailWatchRenewal = 24 * time.Hour
+
+// startGmailNotifications registers a Gmail watch on cfg.PubSubTopic and serves
+// Pub/Sub push deliveries on cfg.PushAddr, waking the poller for each. Both are
+// optional: without them the daemon still picks up new mail on every poll.
+func startGmailNotifications(ctx context.Context, svc *gmail.GmailUtils, cfg config.GmailConfig, poller *inbox.Poller) {
+	if cfg.PubSubTopic != "" {
+		go func() {
+			for {
+				if _, err := svc.Watch(ctx, cfg.PubSubTopic); err != nil && ctx.Err() == nil {
+					logging.Error("Failed to renew Gmail watch: %v", err)
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(gmailWatchRenewal):
+				}
+			}
+		}()
+	}
+
+	if cfg.PushAddr != "" {
+		handler, err := gmail.PushHandler(gmail.PushAuth{
+			Token:          cfg.PushToken,
+			Audience:       cfg.PushAudience,
+			ServiceAccount: cfg.PushServiceAccount,
+		}, poller.Wake)
+		if err != nil {
+			logging.Error("Gmail push endpoint not started: %v", err)
+			return
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/gmail/push", handler)
+		server := &http.Server{Addr: cfg.PushAddr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			logging.Info("Listening for Gmail push notifications on %s/gmail/push", cfg.PushAddr)
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logging.Error("Gmail push endpoint stopped: %v", err)
+			}
+		}()
+		go func() {
+			<-ctx.Done()
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			server.Shutdown(shutdownCtx)
+		}()
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"mailflow/internals/ai"
+	"mailflow/internals/graph"
+)
+
+// runGraphCommand prints the email workflow topology, e.g.
+//
+//	go run . graph -format dot | dot -Tsvg > workflow.svg
+//
+// The workflow is only built, never executed, so no mail or LLM configuration is needed.
+func runGraphCommand(args []string) error {
+	flags := flag.NewFlagSet("graph", flag.ContinueOnError)
+	format := flags.String("format", graph.FormatMermaid, "output format: mermaid or dot")
+	output := flags.String("o", "", "write to this file instead of stdout")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	workflowApp, err := ai.NewWorkflow(nil, nil, ai.Options{})
+	if err != nil {
+		return fmt.Errorf("failed to build workflow: %w", err)
+	}
+	rendered, err := workflowApp.Graph.Export(*format)
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		_, err = fmt.Print(rendered)
+		return err
+	}
+	return os.WriteFile(*output, []byte(rendered), 0o644)
+}
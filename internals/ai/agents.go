@@ -5,31 +5,37 @@ import (
 	"fmt"
 	"strings"
 
-	"github.com/zaynkorai/mailflow/internals/prompts"
+	"mailflow/internals/llm"
+	"mailflow/internals/prompts"
 )
 
 type Agents struct {
-	llmService LLMService
-	jsonParser *jsonResponseParser
-	textParser *textResponseParser
+	generator      llm.Generator
+	taxonomy       *Taxonomy
+	categorySchema *llm.Schema
+	jsonParser     *jsonResponseParser
+	textParser     *textResponseParser
 }
 
-func NewAgents(ctx context.Context, googleAPIKey string) (*Agents, error) {
-	geminiService, err := NewGeminiService(ctx, googleAPIKey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to initialize Gemini service: %w", err)
+func NewAgents(generator llm.Generator, taxonomy *Taxonomy) *Agents {
+	// Only the configured category names are valid labels.
+	categorySchema := llm.SchemaFor[CategorizeEmailOutput]()
+	categoryField := categorySchema.Property("labels", "category")
+	for _, c := range taxonomy.Categories {
+		categoryField.Enum = append(categoryField.Enum, c.Name)
 	}
-
 	return &Agents{
-		llmService: geminiService,
-		jsonParser: NewJSONResponseParser(),
-		textParser: NewTextResponseParser(),
-	}, nil
+		generator:      generator,
+		taxonomy:       taxonomy,
+		categorySchema: categorySchema,
+		jsonParser:     NewJSONResponseParser(),
+		textParser:     NewTextResponseParser(),
+	}
 }
 
 func (a *Agents) CategorizeEmail(ctx context.Context, emailBody string) (*CategorizeEmailOutput, error) {
-	prompt := fmt.Sprintf(prompts.CATEGORIZE_EMAIL, emailBody)
-	output, err := callLLMWithStructuredOutput[CategorizeEmailOutput](ctx, a.llmService, prompt, a.jsonParser)
+	prompt := fmt.Sprintf(prompts.CATEGORIZE_EMAIL, a.taxonomy.PromptRules(), a.taxonomy.Categories[0].Name, emailBody)
+	output, err := callLLMWithStructuredOutput[CategorizeEmailOutput](ctx, a.generator, TagCategorizeEmail, prompt, a.categorySchema, a.jsonParser)
 	if err != nil {
 		return nil, fmt.Errorf("failed to categorize email: %w", err)
 	}
@@ -38,7 +44,7 @@ func (a *Agents) CategorizeEmail(ctx context.Context, emailBody string) (*Catego
 
 func (a *Agents) DesignRAGQueries(ctx context.Context, emailBody string) (*RAGQueriesOutput, error) {
 	prompt := fmt.Sprintf(prompts.GENERATE_RAG_QUERIES, emailBody)
-	output, err := callLLMWithStructuredOutput[RAGQueriesOutput](ctx, a.llmService, prompt, a.jsonParser)
+	output, err := callLLMWithStructuredOutput[RAGQueriesOutput](ctx, a.generator, TagDesignRAGQueries, prompt, nil, a.jsonParser)
 	if err != nil {
 		return nil, fmt.Errorf("failed to design RAG queries: %w", err)
 	}
@@ -46,8 +52,8 @@ func (a *Agents) DesignRAGQueries(ctx context.Context, emailBody string) (*RAGQu
 }
 
 func (a *Agents) GenerateRAGAnswer(ctx context.Context, contextStr, question string) (string, error) {
-	prompt := fmt.Sprintf(prompts.GENERATE_RAG_ANSWER, contextStr, question)
-	answer, err := callLLMForTextOutput(ctx, a.llmService, prompt, a.textParser)
+	prompt := fmt.Sprintf(prompts.GENERATE_RAG_ANSWER, question, contextStr)
+	answer, err := callLLMForTextOutput(ctx, a.generator, TagGenerateRAGAnswer, prompt, a.textParser)
 	if err != nil {
 		return "", fmt.Errorf("failed to generate RAG answer: %w", err)
 	}
@@ -61,7 +67,7 @@ func (a *Agents) EmailWriter(ctx context.Context, emailInformation string, histo
 	}
 	fullPrompt += "Instructions:\n" + emailInformation
 
-	output, err := callLLMWithStructuredOutput[WriterOutput](ctx, a.llmService, fullPrompt, a.jsonParser)
+	output, err := callLLMWithStructuredOutput[WriterOutput](ctx, a.generator, TagEmailWriter, fullPrompt, nil, a.jsonParser)
 	if err != nil {
 		return nil, fmt.Errorf("failed to write email draft: %w", err)
 	}
@@ -70,7 +76,7 @@ func (a *Agents) EmailWriter(ctx context.Context, emailInformation string, histo
 
 func (a *Agents) EmailProofreader(ctx context.Context, initialEmail, generatedEmail string) (*ProofReaderOutput, error) {
 	prompt := fmt.Sprintf(prompts.EMAIL_PROOFREADER, initialEmail, generatedEmail)
-	output, err := callLLMWithStructuredOutput[ProofReaderOutput](ctx, a.llmService, prompt, a.jsonParser)
+	output, err := callLLMWithStructuredOutput[ProofReaderOutput](ctx, a.generator, TagEmailProofreader, prompt, nil, a.jsonParser)
 	if err != nil {
 		return nil, fmt.Errorf("failed to proofread email: %w", err)
 	}
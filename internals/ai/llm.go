@@ -1,41 +1,13 @@
 package ai
 
 import (
-	"context"
 	"fmt"
-	"strings"
 
-	"github.com/google/generative-ai-go/genai"
-	"google.golang.org/api/option"
+	"mailflow/internals/llm"
 )
 
-type LLMService interface {
-	GenerateContent(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error)
-}
-
-type GeminiService struct {
-	model *genai.GenerativeModel
-}
-
-func NewGeminiService(ctx context.Context, apiKey string) (*GeminiService, error) {
-	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
-	if err != nil {
-		return nil, fmt.Errorf("error creating Gemini API client: %w", err)
-	}
-
-	model := client.GenerativeModel("gemini-2.0-flash")
-	model.SetTemperature(0.1)
-
-	return &GeminiService{model: model}, nil
-}
-
-func (s *GeminiService) GenerateContent(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
-	resp, err := s.model.GenerateContent(ctx, parts...)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate content with Gemini model: %w", err)
-	}
-	return resp, nil
-}
+// defaultTemperature keeps agent outputs close to deterministic.
+var defaultTemperature float32 = 0.1
 
 type jsonResponseParser struct{}
 
@@ -43,19 +15,17 @@ func NewJSONResponseParser() *jsonResponseParser {
 	return &jsonResponseParser{}
 }
 
-func (p *jsonResponseParser) Parse(rawResponse *genai.GenerateContentResponse) (string, error) {
-	if rawResponse == nil || len(rawResponse.Candidates) == 0 || len(rawResponse.Candidates[0].Content.Parts) == 0 {
+func (p *jsonResponseParser) Parse(rawResponse *llm.Response) (string, error) {
+	if rawResponse == nil || rawResponse.Text == "" {
 		return "", fmt.Errorf("LLM returned no candidates or no content")
 	}
 
-	rawLLMResponse := fmt.Sprint(rawResponse.Candidates[0].Content.Parts[0])
-
-	cleanedResponse := strings.TrimSpace(rawLLMResponse)
-	cleanedResponse = strings.TrimPrefix(cleanedResponse, "```json")
-	cleanedResponse = strings.TrimSuffix(cleanedResponse, "```")
-	cleanedResponse = strings.TrimSpace(cleanedResponse)
-
-	return cleanedResponse, nil
+	// Tolerates Markdown fences and prose around the JSON object.
+	cleanedResponse, err := llm.ExtractJSON(rawResponse.Text)
+	if err != nil {
+		return "", err
+	}
+	return string(cleanedResponse), nil
 }
 
 type textResponseParser struct{}
@@ -64,16 +34,9 @@ func NewTextResponseParser() *textResponseParser {
 	return &textResponseParser{}
 }
 
-func (p *textResponseParser) Parse(rawResponse *genai.GenerateContentResponse) (string, error) {
-	if rawResponse == nil || len(rawResponse.Candidates) == 0 || len(rawResponse.Candidates[0].Content.Parts) == 0 {
+func (p *textResponseParser) Parse(rawResponse *llm.Response) (string, error) {
+	if rawResponse == nil || rawResponse.Text == "" {
 		return "", fmt.Errorf("no content generated by LLM")
 	}
-
-	var sb strings.Builder
-	for _, part := range rawResponse.Candidates[0].Content.Parts {
-		if text, ok := part.(genai.Text); ok {
-			sb.WriteString(string(text))
-		}
-	}
-	return sb.String(), nil
+	return rawResponse.Text, nil
 }
@@ -3,109 +3,197 @@ package ai
 import (
 	"context"
 	"fmt"
+	"slices"
 	"strings"
 
+	"mailflow/internals/email"
+	"mailflow/internals/llm"
+	"mailflow/internals/rag"
+	"mailflow/internals/review"
+
 	"github.com/fatih/color"
-	"github.com/zaynkorai/mailflow/internals/email/gmail"
 )
 
+// maxWriterTrials is how many drafts the writer may produce for one email.
+const maxWriterTrials = 5
+
 type Nodes struct {
-	Agents *Agents
+	Agents    *Agents
+	Email     email.Service
+	Reviews   *review.Store
+	Taxonomy  *Taxonomy
+	Threshold float64
+	Policy    DeliveryPolicy
+	ForwardTo string
+	Retriever Retriever
+	TopK      int
+	MinScore  float64
+	Retrieval rag.RetrievalMode
+	Fusion    rag.Fusion
 }
 
-func NewNodes(ctx context.Context, googleAPIKey string) (*Nodes, error) {
-	agents, err := NewAgents(ctx, googleAPIKey)
-	if err != nil {
-		return nil, err
+func NewNodes(generator llm.Generator, mailService email.Service, opts Options) *Nodes {
+	taxonomy := opts.Taxonomy
+	if taxonomy == nil {
+		taxonomy = DefaultTaxonomy()
 	}
 	return &Nodes{
-		Agents: agents,
-	}, nil
+		Agents:    NewAgents(generator, taxonomy),
+		Email:     mailService,
+		Reviews:   opts.Reviews,
+		Taxonomy:  taxonomy,
+		Threshold: opts.Threshold,
+		Policy:    opts.Policy,
+		ForwardTo: opts.ForwardTo,
+		Retriever: opts.Retriever,
+		TopK:      opts.TopK,
+		MinScore:  opts.MinScore,
+		Retrieval: opts.Retrieval,
+		Fusion:    opts.Fusion,
+	}
 }
 
-func (n *Nodes) LoadNewEmails(ctx context.Context, state *GraphState) (*GraphState, string, error) {
-	fmt.Println(color.YellowString("Loading new emails..."))
-	gut, _ := gmail.NewGmailUtils()
-	recentEmailsData, _ := gut.FetchUnansweredEmails(50)
-
-	state.EmailsInfo = recentEmailsData
-	return state, "", nil
-}
+func (n *Nodes) CategorizeEmail(ctx context.Context, state *GraphState) (*GraphState, error) {
+	fmt.Println(color.YellowString("Checking email category..."))
+	result, err := n.Agents.CategorizeEmail(ctx, state.CurrentEmailInfo.Body)
+	if err != nil {
+		return state, fmt.Errorf("error categorizing email: %w", err)
+	}
+	scores := n.Taxonomy.Rank(result)
+	labels := make([]string, len(scores))
+	for i, score := range scores {
+		labels[i] = fmt.Sprintf("%s (%.2f)", score.Category, score.Confidence)
+	}
+	fmt.Println(color.MagentaString("Email category: %s", strings.Join(labels, ", ")))
 
-func (n *Nodes) CheckNewEmails(ctx context.Context, state *GraphState) (*GraphState, string, error) {
-	if len(state.EmailsInfo) == 0 {
-		fmt.Println(color.RedString("No new emails"))
-		return state, "empty", nil
-	} else {
-		fmt.Println(color.GreenString("New emails to process"))
-		return state, "process", nil
+	state.EmailCategory = string(scores[0].Category)
+	state.CategoryScores = scores
+	state.Delivery = n.Policy.Decide(state.EmailCategory, state.CurrentEmailInfo.Sender)
+	if scores[0].Confidence < n.Threshold {
+		fmt.Println(color.RedString("Category confidence below %.2f, the reply needs human review", n.Threshold))
+		state.LowConfidence = true
+		state.Delivery = DeliveryApproval
 	}
+	return state, nil
 }
 
-func (n *Nodes) IsEmailInboxEmpty(ctx context.Context, state *GraphState) (*GraphState, string, error) {
-	// This node's primary purpose is to just mark a point in the graph,
-	// The actual check and routing decision happens in CheckNewEmails.
-	return state, "", nil
+func (n *Nodes) RouteEmailBasedOnCategory(ctx context.Context, state *GraphState) (string, error) {
+	fmt.Println(color.YellowString("Routing email based on category..."))
+	switch state.Delivery {
+	case DeliveryForward:
+		return "forward", nil
+	case DeliveryIgnore:
+		return "ignore", nil
+	}
+	return string(n.categoryRoute(state)), nil
 }
 
-func (n *Nodes) CategorizeEmail(ctx context.Context, state *GraphState) (*GraphState, string, error) {
-	fmt.Println(color.YellowString("Checking email category..."))
-	if len(state.EmailsInfo) == 0 {
-		return state, "", fmt.Errorf("error: No emails in state to categorize")
+// categoryRoute combines the routes of every label that reached the threshold,
+// so an email that is both a complaint and a product question still gets the
+// knowledge base lookup. Emails below the threshold are answered for a
+// reviewer even if their likeliest category would skip them.
+func (n *Nodes) categoryRoute(state *GraphState) Route {
+	if len(state.CategoryScores) == 0 {
+		// Runs checkpointed before categories were scored.
+		return n.Taxonomy.Resolve(state.EmailCategory).Route
 	}
-	currentEmail := state.EmailsInfo[len(state.EmailsInfo)-1]
-	result, err := n.Agents.CategorizeEmail(ctx, currentEmail.Body)
-	if err != nil {
-		return state, "", fmt.Errorf("error categorizing email: %w", err)
+	route := RouteSkip
+	if state.LowConfidence {
+		route = RouteReply
 	}
-	fmt.Println(color.MagentaString("Email category: %s", result.Category))
-	state.EmailCategory = string(result.Category)
-	state.CurrentEmailInfo = currentEmail
-	return state, "", nil
-}
-
-func (n *Nodes) RouteEmailBasedOnCategory(ctx context.Context, state *GraphState) (*GraphState, string, error) {
-	fmt.Println(color.YellowString("Routing email based on category..."))
-	category := EmailCategory(state.EmailCategory)
-	switch category {
-	case ProductEnquiry:
-		return state, "product related", nil
-	case Unrelated:
-		return state, "unrelated", nil
-	default:
-		return state, "not product related", nil
+	for _, score := range state.CategoryScores {
+		if !state.LowConfidence && score.Confidence < n.Threshold {
+			continue
+		}
+		switch n.Taxonomy.Resolve(string(score.Category)).Route {
+		case RouteRAG:
+			return RouteRAG
+		case RouteReply:
+			route = RouteReply
+		}
 	}
+	return route
 }
 
-func (n *Nodes) ConstructRAGQueries(ctx context.Context, state *GraphState) (*GraphState, string, error) {
+func (n *Nodes) ConstructRAGQueries(ctx context.Context, state *GraphState) (*GraphState, error) {
 	fmt.Println(color.YellowString("Designing RAG query..."))
 	emailContent := state.CurrentEmailInfo.Body
 	queryResult, err := n.Agents.DesignRAGQueries(ctx, emailContent)
 	if err != nil {
-		return state, "", fmt.Errorf("error designing RAG queries: %w", err)
+		return state, fmt.Errorf("error designing RAG queries: %w", err)
 	}
 	state.RAGQueries = queryResult.Queries
-	return state, "", nil
+	return state, nil
 }
 
-func (n *Nodes) RetrieveFromRAG(ctx context.Context, state *GraphState) (*GraphState, string, error) {
+// RetrieveFromRAG answers every RAG query from the chunks the retriever finds
+// for it, citing their sources, and records the IDs of those chunks. Searches
+// are restricted to the knowledge configured for the email's category and use
+// its retrieval mode, if it sets one.
+func (n *Nodes) RetrieveFromRAG(ctx context.Context, state *GraphState) (*GraphState, error) {
 	fmt.Println(color.YellowString("Retrieving information from internal knowledge..."))
+	if n.Retriever == nil {
+		fmt.Println(color.RedString("No knowledge base configured, answering RAG queries without context"))
+	}
+	category := n.Taxonomy.Resolve(state.EmailCategory)
+	opts := rag.RetrieveOptions{
+		SearchOptions: rag.SearchOptions{
+			TopN:     n.TopK,
+			MinScore: n.MinScore,
+			Filter:   category.Knowledge,
+		},
+		Mode:   n.Retrieval,
+		Fusion: n.Fusion,
+	}
+	if category.Retrieval != "" {
+		opts.Mode = category.Retrieval
+	}
+	if opts.TopN <= 0 {
+		opts.TopN = DefaultRetrievalTopK
+	}
+
 	finalAnswer := strings.Builder{}
+	seen := make(map[string]bool)
+	state.RetrievedChunkIDs = []string{}
 	for _, query := range state.RAGQueries {
-		ragResult, err := n.Agents.GenerateRAGAnswer(ctx, "", query)
+		var chunks []rag.ScoredChunk
+		if n.Retriever != nil {
+			var err error
+			chunks, err = n.Retriever.Retrieve(ctx, query, opts)
+			if err != nil {
+				return state, fmt.Errorf("error retrieving knowledge for query '%s': %w", query, err)
+			}
+		}
+		ragResult, err := n.Agents.GenerateRAGAnswer(ctx, formatRAGContext(chunks), query)
 		if err != nil {
-			return state, "", fmt.Errorf("error generating RAG answer for query '%s': %w", query, err)
+			return state, fmt.Errorf("error generating RAG answer for query '%s': %w", query, err)
 		}
 		finalAnswer.WriteString(query)
 		finalAnswer.WriteString("\n")
 		finalAnswer.WriteString(ragResult)
-		finalAnswer.WriteString("\n\n")
+		finalAnswer.WriteString("\n")
+
+		var sources []string
+		for _, chunk := range chunks {
+			if source := chunkSource(chunk.Chunk); !slices.Contains(sources, source) {
+				sources = append(sources, source)
+			}
+			if !seen[chunk.ID] {
+				seen[chunk.ID] = true
+				state.RetrievedChunkIDs = append(state.RetrievedChunkIDs, chunk.ID)
+			}
+		}
+		if len(sources) > 0 {
+			fmt.Fprintf(&finalAnswer, "(Sources: %s)\n", strings.Join(sources, "; "))
+		}
+		finalAnswer.WriteString("\n")
 	}
+	fmt.Println(color.MagentaString("Retrieved %d knowledge base chunks", len(state.RetrievedChunkIDs)))
 	state.RetrievedDocuments = finalAnswer.String()
-	return state, "", nil
+	return state, nil
 }
 
-func (n *Nodes) WriteDraftEmail(ctx context.Context, state *GraphState) (*GraphState, string, error) {
+func (n *Nodes) WriteDraftEmail(ctx context.Context, state *GraphState) (*GraphState, error) {
 	fmt.Println(color.YellowString("Writing draft email..."))
 	inputs := fmt.Sprintf(
 		"# **EMAIL CATEGORY:** %s\n\n# **EMAIL CONTENT:**\n%s\n\n# **INFORMATION:**\n%s",
@@ -118,80 +206,129 @@ func (n *Nodes) WriteDraftEmail(ctx context.Context, state *GraphState) (*GraphS
 	}
 	draftResult, err := n.Agents.EmailWriter(ctx, inputs, state.WriterMessages)
 	if err != nil {
-		return state, "", fmt.Errorf("error writing email draft: %w", err)
+		return state, fmt.Errorf("error writing email draft: %w", err)
 	}
 	email := draftResult.Email
 	state.Trials++
 	state.WriterMessages = append(state.WriterMessages, fmt.Sprintf("**Draft %d:**\n%s", state.Trials, email))
 	state.GeneratedEmail = email
-	return state, "", nil
+	return state, nil
 }
 
-func (n *Nodes) VerifyGeneratedEmail(ctx context.Context, state *GraphState) (*GraphState, string, error) {
+func (n *Nodes) VerifyGeneratedEmail(ctx context.Context, state *GraphState) (*GraphState, error) {
 	fmt.Println(color.YellowString("Verifying generated email..."))
 	review, err := n.Agents.EmailProofreader(ctx, state.CurrentEmailInfo.Body, state.GeneratedEmail)
 	if err != nil {
-		return state, "", fmt.Errorf("error verifying generated email: %w", err)
+		return state, fmt.Errorf("error verifying generated email: %w", err)
 	}
 	if state.WriterMessages == nil {
 		state.WriterMessages = []string{}
 	}
 	state.WriterMessages = append(state.WriterMessages, fmt.Sprintf("**Proofreader Feedback:**\n%s", review.Feedback))
 	state.Sendable = review.Send
-	return state, "", nil
+	return state, nil
 }
 
-func (n *Nodes) MustRewrite(ctx context.Context, state *GraphState) (*GraphState, string, error) {
-	emailSendable := state.Sendable
-	if emailSendable {
+func (n *Nodes) MustRewrite(ctx context.Context, state *GraphState) (string, error) {
+	if state.Sendable {
 		fmt.Println(color.GreenString("Email is good, ready to be sent!!!"))
-		if len(state.EmailsInfo) > 0 {
-			state.EmailsInfo = state.EmailsInfo[:len(state.EmailsInfo)-1]
-		}
-		state.WriterMessages = []string{}
-		return state, "send", nil
-	} else if state.Trials >= 5 {
+		return "send", nil
+	} else if state.Trials >= maxWriterTrials {
 		fmt.Println(color.RedString("Email is not good, we reached max trials must stop!!!"))
-		if len(state.EmailsInfo) > 0 {
-			state.EmailsInfo = state.EmailsInfo[:len(state.EmailsInfo)-1]
-		}
-		state.WriterMessages = []string{}
-		return state, "stop", nil
+		return "stop", nil
 	} else {
 		fmt.Println(color.RedString("Email is not good, must rewrite it..."))
-		return state, "rewrite", nil
+		return "rewrite", nil
 	}
 }
 
-func (n *Nodes) CreateDraftResponse(ctx context.Context, state *GraphState) (*GraphState, string, error) {
-	fmt.Println(color.YellowString("Creating draft email..."))
-	gut, _ := gmail.NewGmailUtils()
+func (n *Nodes) DeliverReply(ctx context.Context, state *GraphState) (*GraphState, error) {
+	// This node's primary purpose is to just mark a point in the graph,
+	// The actual delivery decision happens in ChooseDelivery.
+	return state, nil
+}
 
-	_, err := gut.CreateDraftReply(state.CurrentEmailInfo, state.GeneratedEmail)
+func (n *Nodes) ChooseDelivery(ctx context.Context, state *GraphState) (string, error) {
+	mode := state.Delivery
+	if mode == "" {
+		// Runs checkpointed before the policy decision was kept in the state.
+		mode = n.Policy.Decide(state.EmailCategory, state.CurrentEmailInfo.Sender)
+	}
+	if !mode.replies() {
+		mode = DeliveryDraft
+	}
+	return string(mode), nil
+}
+
+func (n *Nodes) CreateDraftResponse(ctx context.Context, state *GraphState) (*GraphState, error) {
+	fmt.Println(color.YellowString("Creating draft email..."))
+	_, err := n.Email.CreateDraftReply(ctx, state.CurrentEmailInfo, state.GeneratedEmail)
 	if err != nil {
-		return state, "", fmt.Errorf("error creating draft reply: %w", err)
+		return state, fmt.Errorf("error creating draft reply: %w", err)
 	}
-	state.RetrievedDocuments = ""
-	state.Trials = 0
-	return state, "", nil
+	state.Outcome = OutcomeDrafted
+	return state, nil
 }
 
-func (n *Nodes) SendEmailResponse(ctx context.Context, state *GraphState) (*GraphState, string, error) {
+func (n *Nodes) SendEmailResponse(ctx context.Context, state *GraphState) (*GraphState, error) {
 	fmt.Println(color.YellowString("Sending email..."))
-	gut, _ := gmail.NewGmailUtils()
-	_, err := gut.SendReply(state.CurrentEmailInfo, state.GeneratedEmail)
+	_, err := n.Email.SendReply(ctx, state.CurrentEmailInfo, state.GeneratedEmail)
 	if err != nil {
-		return state, "", fmt.Errorf("error sending email: %w", err)
+		return state, fmt.Errorf("error sending email: %w", err)
 	}
-	state.RetrievedDocuments = ""
-	state.Trials = 0
-	return state, "", nil
+	state.Outcome = OutcomeSent
+	return state, nil
 }
 
-func (n *Nodes) SkipUnrelatedEmail(ctx context.Context, state *GraphState) (*GraphState, string, error) {
-	fmt.Println("Skipping unrelated email...")
-	if len(state.EmailsInfo) > 0 {
-		state.EmailsInfo = state.EmailsInfo[:len(state.EmailsInfo)-1]
+func (n *Nodes) QueueForApproval(ctx context.Context, state *GraphState) (*GraphState, error) {
+	fmt.Println(color.YellowString("Queueing reply for human approval..."))
+	if n.Reviews == nil {
+		return state, fmt.Errorf("approval delivery requires a review queue")
+	}
+	item, err := n.Reviews.Add(ctx, review.Item{
+		RunID:       state.RunID,
+		ParentID:    state.ReviewParentID,
+		Revision:    state.ReviewRevision,
+		Email:       state.CurrentEmailInfo,
+		Category:    state.EmailCategory,
+		Draft:       state.GeneratedEmail,
+		Information: state.RetrievedDocuments,
+		Feedback:    state.WriterMessages,
+	})
+	if err != nil {
+		return state, fmt.Errorf("error queueing reply for approval: %w", err)
+	}
+	fmt.Println(color.CyanString("Reply queued for review as %s", item.ID))
+	state.Outcome = OutcomePendingApproval
+	return state, nil
+}
+
+func (n *Nodes) ForwardEmail(ctx context.Context, state *GraphState) (*GraphState, error) {
+	fmt.Println(color.YellowString("Forwarding email to %s...", n.ForwardTo))
+	note := fmt.Sprintf("Forwarded by Mailflow: %s emails from this sender are handled by a person.", state.EmailCategory)
+	_, err := n.Email.ForwardEmail(ctx, state.CurrentEmailInfo, n.ForwardTo, note)
+	if err != nil {
+		return state, fmt.Errorf("error forwarding email: %w", err)
 	}
-	return state, "", nil
+	state.Outcome = OutcomeForwarded
+	return state, nil
+}
+
+func (n *Nodes) IgnoreEmail(ctx context.Context, state *GraphState) (*GraphState, error) {
+	fmt.Println("Ignoring email as configured by the delivery policy...")
+	state.Outcome = OutcomeIgnored
+	return state, nil
+}
+
+func (n *Nodes) SkipUnrelatedEmail(ctx context.Context, state *GraphState) (*GraphState, error) {
+	fmt.Println("Skipping unrelated email...")
+	state.Outcome = OutcomeSkipped
+	return state, nil
+}
+
+// GiveUpOnEmail ends the run when no draft passed proofreading within maxWriterTrials.
+func (n *Nodes) GiveUpOnEmail(ctx context.Context, state *GraphState) (*GraphState, error) {
+	fmt.Println(color.RedString("Giving up on email from %s after %d drafts", state.CurrentEmailInfo.Sender, state.Trials))
+	state.Outcome = OutcomeGaveUp
+	return state, nil
 }
@@ -5,11 +5,14 @@ import (
 	"encoding/json"
 	"fmt"
 
-	"github.com/google/generative-ai-go/genai"
+	"mailflow/internals/llm"
+	"mailflow/internals/prompts"
+	"mailflow/pkg/logging"
 )
 
 type EmailCategory string
 
+// Categories of the DefaultTaxonomy.
 const (
 	ProductEnquiry    EmailCategory = "PRODUCT_ENQUIRY"
 	CustomerComplaint EmailCategory = "CUSTOMER_COMPLAINT"
@@ -17,8 +20,23 @@ const (
 	Unrelated         EmailCategory = "UNRELATED"
 )
 
+// Tags identifying each agent's requests, used by scripted and replayed LLM fixtures.
+const (
+	TagCategorizeEmail   = "CategorizeEmail"
+	TagDesignRAGQueries  = "DesignRAGQueries"
+	TagGenerateRAGAnswer = "GenerateRAGAnswer"
+	TagEmailWriter       = "EmailWriter"
+	TagEmailProofreader  = "EmailProofreader"
+)
+
+// CategoryScore is one label assigned by the categorizer with its confidence in [0, 1].
+type CategoryScore struct {
+	Category   EmailCategory `json:"category"`
+	Confidence float64       `json:"confidence" schema:"min=0,max=1"`
+}
+
 type CategorizeEmailOutput struct {
-	Category EmailCategory `json:"category"`
+	Labels []CategoryScore `json:"labels"`
 }
 
 type RAGQueriesOutput struct {
@@ -34,28 +52,65 @@ type ProofReaderOutput struct {
 	Send     bool   `json:"send"`
 }
 
-func callLLMWithStructuredOutput[T any](ctx context.Context, service LLMService, prompt string, parser *jsonResponseParser) (*T, error) {
-	resp, err := service.GenerateContent(ctx, genai.Text(prompt))
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate LLM content for structured output: %w", err)
+// maxRepairAttempts is how often an agent is re-prompted with the validation
+// error after answering with JSON that does not match its output schema.
+const maxRepairAttempts = 2
+
+// callLLMWithStructuredOutput asks for JSON matching schema (derived from T
+// when nil), validates the answer and, while attempts remain, shows the model
+// its invalid answer together with the validation error to get a corrected one.
+func callLLMWithStructuredOutput[T any](ctx context.Context, generator llm.Generator, tag, prompt string, schema *llm.Schema, parser *jsonResponseParser) (*T, error) {
+	if schema == nil {
+		schema = llm.SchemaFor[T]()
 	}
+	req := llm.UserPrompt(tag, prompt)
+	req.JSON = true
+	req.Schema = schema
+	req.Temperature = &defaultTemperature
+	original := req.Messages
 
+	for attempt := 0; ; attempt++ {
+		resp, err := generator.Generate(ctx, req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate LLM content for structured output: %w", err)
+		}
+
+		output, err := decodeStructuredOutput[T](resp, schema, parser)
+		if err == nil {
+			return output, nil
+		}
+		if attempt == maxRepairAttempts {
+			return nil, fmt.Errorf("LLM response still invalid after %d repair attempts: %w", maxRepairAttempts, err)
+		}
+		logging.Info("Invalid %s response, asking for a corrected one: %v", tag, err)
+		req.Messages = append(append([]llm.Message{}, original...),
+			llm.Message{Role: llm.RoleAssistant, Content: resp.Text},
+			llm.Message{Role: llm.RoleUser, Content: fmt.Sprintf(prompts.REPAIR_STRUCTURED_OUTPUT, err)},
+		)
+	}
+}
+
+func decodeStructuredOutput[T any](resp *llm.Response, schema *llm.Schema, parser *jsonResponseParser) (*T, error) {
 	cleanedResponse, err := parser.Parse(resp)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse LLM response: %w", err)
 	}
+	if err := schema.Validate([]byte(cleanedResponse)); err != nil {
+		return nil, err
+	}
 
 	var output T
 	err = json.Unmarshal([]byte(cleanedResponse), &output)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal LLM response to struct: %w. Cleaned Response: %s", err, cleanedResponse)
 	}
-
 	return &output, nil
 }
 
-func callLLMForTextOutput(ctx context.Context, service LLMService, prompt string, parser *textResponseParser) (string, error) {
-	resp, err := service.GenerateContent(ctx, genai.Text(prompt))
+func callLLMForTextOutput(ctx context.Context, generator llm.Generator, tag, prompt string, parser *textResponseParser) (string, error) {
+	req := llm.UserPrompt(tag, prompt)
+	req.Temperature = &defaultTemperature
+	resp, err := generator.Generate(ctx, req)
 	if err != nil {
 		return "", fmt.Errorf("failed to generate LLM content for text output: %w", err)
 	}
@@ -0,0 +1,106 @@
+package ai
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"mailflow/internals/llm"
+)
+
+const testTag = "Test"
+
+func TestCallLLMWithStructuredOutput(t *testing.T) {
+	const (
+		valid   = `{"feedback": "Looks good.", "send": true}`
+		missing = `{"feedback": "Looks good."}`
+	)
+	tests := []struct {
+		name      string
+		script    []llm.ScriptedResponse
+		want      *ProofReaderOutput
+		wantErr   string // Contained in the error; empty for success
+		wantCalls int
+	}{
+		{
+			name:      "fenced JSON",
+			script:    []llm.ScriptedResponse{{Tag: testTag, Response: "```json\n" + valid + "\n```"}},
+			want:      &ProofReaderOutput{Feedback: "Looks good.", Send: true},
+			wantCalls: 1,
+		},
+		{
+			name:      "JSON wrapped in prose",
+			script:    []llm.ScriptedResponse{{Tag: testTag, Response: "Here is my review:\n" + valid + "\nHope this helps!"}},
+			want:      &ProofReaderOutput{Feedback: "Looks good.", Send: true},
+			wantCalls: 1,
+		},
+		{
+			// The corrected answer is only given once the validation error
+			// has been shown to the model.
+			name: "repair succeeds on retry",
+			script: []llm.ScriptedResponse{
+				{Tag: testTag, Contains: `missing required field "send"`, Response: valid},
+				{Tag: testTag, Response: missing},
+			},
+			want:      &ProofReaderOutput{Feedback: "Looks good.", Send: true},
+			wantCalls: 2,
+		},
+		{
+			name: "unknown field repaired",
+			script: []llm.ScriptedResponse{
+				{Tag: testTag, Contains: `unexpected field "score"`, Response: valid},
+				{Tag: testTag, Response: `{"feedback": "", "send": true, "score": 9}`},
+			},
+			want:      &ProofReaderOutput{Feedback: "Looks good.", Send: true},
+			wantCalls: 2,
+		},
+		{
+			name:      "retries run out",
+			script:    []llm.ScriptedResponse{{Tag: testTag, Response: missing}},
+			wantErr:   `still invalid after 2 repair attempts: response: missing required field "send"`,
+			wantCalls: maxRepairAttempts + 1,
+		},
+		{
+			name:      "no JSON at all",
+			script:    []llm.ScriptedResponse{{Tag: testTag, Response: "I would send it."}},
+			wantErr:   "response contains no JSON object",
+			wantCalls: maxRepairAttempts + 1,
+		},
+		{
+			name:      "generator error is not repaired",
+			wantErr:   `no scripted response for tag "Test"`,
+			wantCalls: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			generator := llm.NewScriptedGenerator(tt.script...)
+			got, err := callLLMWithStructuredOutput[ProofReaderOutput](context.Background(), generator, testTag, "Review this draft.", nil, NewJSONResponseParser())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Errorf("callLLMWithStructuredOutput: %v", err)
+			} else if *got != *tt.want {
+				t.Errorf("output = %+v, want %+v", *got, *tt.want)
+			}
+
+			calls := generator.Calls()
+			if len(calls) != tt.wantCalls {
+				t.Fatalf("made %d calls, want %d", len(calls), tt.wantCalls)
+			}
+			first := calls[0]
+			if !first.JSON || first.Schema == nil || first.Schema.Property("send") == nil {
+				t.Errorf("request asks for JSON %v with schema %+v, want the ProofReaderOutput schema", first.JSON, first.Schema)
+			}
+			// A repair resends the prompt with the invalid answer and the error.
+			for _, call := range calls[1:] {
+				if len(call.Messages) != 3 || call.Messages[0] != first.Messages[0] ||
+					call.Messages[1].Role != llm.RoleAssistant || call.Messages[2].Role != llm.RoleUser {
+					t.Errorf("repair request messages = %+v, want prompt, answer and error", call.Messages)
+				}
+			}
+		})
+	}
+}
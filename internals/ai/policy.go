@@ -0,0 +1,164 @@
+package ai
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+)
+
+// DeliveryMode decides what happens to an email once it is categorized.
+type DeliveryMode string
+
+const (
+	DeliveryDraft    DeliveryMode = "draft"    // Store the reply as a draft in the mailbox
+	DeliverySend     DeliveryMode = "send"     // Send the reply right away
+	DeliveryApproval DeliveryMode = "approval" // Queue the reply for a human to approve, edit or reject
+	DeliveryForward  DeliveryMode = "forward"  // Forward the email to a human instead of replying
+	DeliveryIgnore   DeliveryMode = "ignore"   // Leave the email alone
+)
+
+func (m DeliveryMode) valid() bool {
+	switch m {
+	case DeliveryDraft, DeliverySend, DeliveryApproval, DeliveryForward, DeliveryIgnore:
+		return true
+	}
+	return false
+}
+
+// replies reports whether the mode needs a generated reply.
+func (m DeliveryMode) replies() bool {
+	return m == DeliveryDraft || m == DeliverySend || m == DeliveryApproval
+}
+
+// DeliveryRule applies Mode to emails of Category from senders at Domain.
+// An empty Category or Domain matches any.
+type DeliveryRule struct {
+	Category string
+	Domain   string // Matches the domain itself and its subdomains
+	Mode     DeliveryMode
+}
+
+// DeliveryPolicy picks the DeliveryMode of each email. When several rules
+// match, one naming both category and domain wins over one naming only the
+// domain, which wins over one naming only the category.
+type DeliveryPolicy struct {
+	Default DeliveryMode // DeliveryDraft when empty
+	Rules   []DeliveryRule
+}
+
+// ParseDeliveryPolicy reads rules written as "KEY=mode" pairs separated by
+// commas or semicolons, where KEY is a category ("CUSTOMER_COMPLAINT"), a
+// sender domain ("*@example.com") or both ("CUSTOMER_COMPLAINT@example.com"):
+//
+//	PRODUCT_ENQUIRY=send, CUSTOMER_COMPLAINT=draft, *@partner.example.com=forward
+func ParseDeliveryPolicy(spec string, defaultMode DeliveryMode) (DeliveryPolicy, error) {
+	policy := DeliveryPolicy{Default: defaultMode}
+	fields := strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == ';' || r == '\n' })
+	for _, field := range fields {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		key, mode, ok := strings.Cut(field, "=")
+		if !ok {
+			return DeliveryPolicy{}, fmt.Errorf("delivery rule %q is not KEY=mode", field)
+		}
+		category, domain, _ := strings.Cut(strings.TrimSpace(key), "@")
+		if category == "*" {
+			category = ""
+		}
+		rule := DeliveryRule{
+			Category: strings.ToUpper(strings.TrimSpace(category)),
+			Domain:   strings.ToLower(strings.TrimSpace(domain)),
+			Mode:     DeliveryMode(strings.ToLower(strings.TrimSpace(mode))),
+		}
+		if rule.Category == "" && rule.Domain == "" {
+			return DeliveryPolicy{}, fmt.Errorf("delivery rule %q names neither a category nor a domain", field)
+		}
+		policy.Rules = append(policy.Rules, rule)
+	}
+	return policy, policy.Validate()
+}
+
+// Validate checks that every mode in the policy is known.
+func (p DeliveryPolicy) Validate() error {
+	if p.Default != "" && !p.Default.valid() {
+		return fmt.Errorf("unknown delivery mode %q", p.Default)
+	}
+	for _, rule := range p.Rules {
+		if !rule.Mode.valid() {
+			return fmt.Errorf("unknown delivery mode %q for %s", rule.Mode, rule.key())
+		}
+	}
+	return nil
+}
+
+// Uses reports whether any email can be delivered with mode.
+func (p DeliveryPolicy) Uses(mode DeliveryMode) bool {
+	if p.defaultMode() == mode {
+		return true
+	}
+	for _, rule := range p.Rules {
+		if rule.Mode == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// Decide returns the mode for an email of category from sender.
+func (p DeliveryPolicy) Decide(category, sender string) DeliveryMode {
+	category = strings.ToUpper(category)
+	domain := senderDomain(sender)
+
+	best, bestScore := p.defaultMode(), 0
+	for _, rule := range p.Rules {
+		score := 0
+		if rule.Category != "" {
+			if rule.Category != category {
+				continue
+			}
+			score++
+		}
+		if rule.Domain != "" {
+			if domain != rule.Domain && !strings.HasSuffix(domain, "."+rule.Domain) {
+				continue
+			}
+			score += 2
+		}
+		if score > bestScore {
+			best, bestScore = rule.Mode, score
+		}
+	}
+	return best
+}
+
+func (p DeliveryPolicy) defaultMode() DeliveryMode {
+	if p.Default == "" {
+		return DeliveryDraft
+	}
+	return p.Default
+}
+
+func (r DeliveryRule) key() string {
+	switch {
+	case r.Domain == "":
+		return r.Category
+	case r.Category == "":
+		return "*@" + r.Domain
+	default:
+		return r.Category + "@" + r.Domain
+	}
+}
+
+// senderDomain extracts the lower-cased domain of a From header value.
+func senderDomain(sender string) string {
+	address := sender
+	if parsed, err := mail.ParseAddress(sender); err == nil {
+		address = parsed.Address
+	}
+	if at := strings.LastIndex(address, "@"); at >= 0 {
+		return strings.ToLower(strings.Trim(address[at+1:], "> "))
+	}
+	return ""
+}
@@ -0,0 +1,159 @@
+package ai
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"mailflow/internals/email"
+	"mailflow/internals/llm"
+)
+
+// describeRules renders rules as "KEY=mode" pairs, in order.
+func describeRules(rules []DeliveryRule) string {
+	pairs := make([]string, len(rules))
+	for i, rule := range rules {
+		pairs[i] = rule.key() + "=" + string(rule.Mode)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func TestParseDeliveryPolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		spec        string
+		defaultMode DeliveryMode
+		want        string
+		wantErr     string // Contained in the error; empty for success
+	}{
+		{name: "empty", spec: "", want: ""},
+		{name: "category", spec: "PRODUCT_ENQUIRY=send", want: "PRODUCT_ENQUIRY=send"},
+		{name: "domain", spec: "*@partner.example.com=forward", want: "*@partner.example.com=forward"},
+		{name: "category and domain", spec: "CUSTOMER_COMPLAINT@example.com=approval", want: "CUSTOMER_COMPLAINT@example.com=approval"},
+		{
+			name: "every separator",
+			spec: "PRODUCT_ENQUIRY=send, CUSTOMER_COMPLAINT=draft;\n*@example.com=ignore\n",
+			want: "PRODUCT_ENQUIRY=send,CUSTOMER_COMPLAINT=draft,*@example.com=ignore",
+		},
+		{name: "blank fields skipped", spec: " ,;; PRODUCT_ENQUIRY=send ,", want: "PRODUCT_ENQUIRY=send"},
+		{
+			name: "case and spaces normalized",
+			spec: " customer_complaint @ Example.COM = Approval ",
+			want: "CUSTOMER_COMPLAINT@example.com=approval",
+		},
+		{name: "default mode", spec: "PRODUCT_ENQUIRY=send", defaultMode: DeliveryApproval, want: "PRODUCT_ENQUIRY=send"},
+		{name: "no equals sign", spec: "PRODUCT_ENQUIRY send", wantErr: `delivery rule "PRODUCT_ENQUIRY send" is not KEY=mode`},
+		{name: "empty key", spec: "=send", wantErr: `delivery rule "=send" names neither a category nor a domain`},
+		{name: "wildcard key", spec: "*=send", wantErr: `delivery rule "*=send" names neither a category nor a domain`},
+		{name: "wildcard without domain", spec: "*@=send", wantErr: "names neither a category nor a domain"},
+		{name: "unknown mode", spec: "PRODUCT_ENQUIRY=reply", wantErr: `unknown delivery mode "reply" for PRODUCT_ENQUIRY`},
+		{name: "empty mode", spec: "*@example.com=", wantErr: `unknown delivery mode "" for *@example.com`},
+		{name: "unknown default", spec: "PRODUCT_ENQUIRY=send", defaultMode: "later", wantErr: `unknown delivery mode "later"`},
+		{name: "malformed rule after valid ones", spec: "PRODUCT_ENQUIRY=send, oops", wantErr: `delivery rule "oops" is not KEY=mode`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := ParseDeliveryPolicy(tt.spec, tt.defaultMode)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseDeliveryPolicy error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseDeliveryPolicy: %v", err)
+			}
+			if got := describeRules(policy.Rules); got != tt.want {
+				t.Errorf("rules = %q, want %q", got, tt.want)
+			}
+			if policy.Default != tt.defaultMode {
+				t.Errorf("default = %q, want %q", policy.Default, tt.defaultMode)
+			}
+		})
+	}
+}
+
+func TestDecide(t *testing.T) {
+	policy, err := ParseDeliveryPolicy(
+		"PRODUCT_ENQUIRY=send, CUSTOMER_COMPLAINT=approval, *@partner.example.com=forward, "+
+			"PRODUCT_ENQUIRY@partner.example.com=draft, *@spam.test=ignore, *@spam.test=send",
+		DeliveryApproval)
+	if err != nil {
+		t.Fatalf("ParseDeliveryPolicy: %v", err)
+	}
+	tests := []struct {
+		name     string
+		category string
+		sender   string
+		want     DeliveryMode
+	}{
+		{name: "category", category: "PRODUCT_ENQUIRY", sender: "jane@customer.test", want: DeliverySend},
+		{name: "category in lower case", category: "customer_complaint", sender: "jane@customer.test", want: DeliveryApproval},
+		{name: "domain", category: "CUSTOMER_FEEDBACK", sender: "sam@partner.example.com", want: DeliveryForward},
+		{name: "subdomain", category: "CUSTOMER_FEEDBACK", sender: "sam@eu.partner.example.com", want: DeliveryForward},
+		{name: "domain in upper case", category: "CUSTOMER_FEEDBACK", sender: "sam@PARTNER.Example.com", want: DeliveryForward},
+		{name: "domain beats category", category: "CUSTOMER_COMPLAINT", sender: "sam@partner.example.com", want: DeliveryForward},
+		{name: "category and domain beat domain", category: "PRODUCT_ENQUIRY", sender: "sam@partner.example.com", want: DeliveryDraft},
+		{name: "lookalike domain", category: "CUSTOMER_FEEDBACK", sender: "sam@notpartner.example.com", want: DeliveryApproval},
+		{name: "parent domain", category: "CUSTOMER_FEEDBACK", sender: "sam@example.com", want: DeliveryApproval},
+		{name: "display name", category: "CUSTOMER_FEEDBACK", sender: `"Sam, Partner" <sam@partner.example.com>`, want: DeliveryForward},
+		{name: "unparsable sender", category: "CUSTOMER_FEEDBACK", sender: "Sam <sam@partner.example.com", want: DeliveryForward},
+		{name: "no domain", category: "PRODUCT_ENQUIRY", sender: "postmaster", want: DeliverySend},
+		{name: "first of equal rules wins", category: "UNRELATED", sender: "bot@spam.test", want: DeliveryIgnore},
+		{name: "no match", category: "UNRELATED", sender: "jane@customer.test", want: DeliveryApproval},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy.Decide(tt.category, tt.sender); got != tt.want {
+				t.Errorf("Decide(%q, %q) = %q, want %q", tt.category, tt.sender, got, tt.want)
+			}
+		})
+	}
+
+	// Without a default, emails no rule matches are drafted.
+	if got := (DeliveryPolicy{}).Decide("UNRELATED", "jane@customer.test"); got != DeliveryDraft {
+		t.Errorf("Decide without a default = %q, want %q", got, DeliveryDraft)
+	}
+}
+
+func TestCategorizeEmailDelivery(t *testing.T) {
+	policy, err := ParseDeliveryPolicy("PRODUCT_ENQUIRY=send, *@partner.example.com=forward", DeliveryDraft)
+	if err != nil {
+		t.Fatalf("ParseDeliveryPolicy: %v", err)
+	}
+	tests := []struct {
+		name       string
+		sender     string
+		confidence string
+		threshold  float64
+		want       DeliveryMode
+		wantLow    bool
+	}{
+		{name: "policy applies above the threshold", sender: "jane@customer.test", confidence: "0.9", threshold: 0.7, want: DeliverySend},
+		{name: "at the threshold", sender: "jane@customer.test", confidence: "0.7", threshold: 0.7, want: DeliverySend},
+		{name: "low confidence needs approval", sender: "jane@customer.test", confidence: "0.6", threshold: 0.7, want: DeliveryApproval, wantLow: true},
+		{name: "low confidence overrides a domain rule", sender: "sam@partner.example.com", confidence: "0.6", threshold: 0.7, want: DeliveryApproval, wantLow: true},
+		{name: "no threshold", sender: "sam@partner.example.com", confidence: "0.1", want: DeliveryForward},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			generator := llm.NewScriptedGenerator(llm.ScriptedResponse{
+				Tag:      TagCategorizeEmail,
+				Response: `{"labels": [{"category": "PRODUCT_ENQUIRY", "confidence": ` + tt.confidence + `}]}`,
+			})
+			nodes := NewNodes(generator, nil, Options{Policy: policy, Threshold: tt.threshold})
+			state := NewGraphState(email.EmailInfo{Sender: tt.sender, Body: "Does product A work offline?"})
+
+			state, err := nodes.CategorizeEmail(context.Background(), state)
+			if err != nil {
+				t.Fatalf("CategorizeEmail: %v", err)
+			}
+			if state.EmailCategory != "PRODUCT_ENQUIRY" {
+				t.Errorf("category = %q, want PRODUCT_ENQUIRY", state.EmailCategory)
+			}
+			if state.Delivery != tt.want || state.LowConfidence != tt.wantLow {
+				t.Errorf("delivery = %q, low confidence %v, want %q, %v", state.Delivery, state.LowConfidence, tt.want, tt.wantLow)
+			}
+		})
+	}
+}
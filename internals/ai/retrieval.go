@@ -0,0 +1,43 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"mailflow/internals/rag"
+)
+
+// DefaultRetrievalTopK is how many knowledge base chunks answer each RAG query.
+const DefaultRetrievalTopK = 3
+
+// Retriever finds the knowledge base chunks most relevant to a query.
+// *rag.RAGSystem implements it.
+type Retriever interface {
+	Retrieve(ctx context.Context, query string, opts rag.RetrieveOptions) ([]rag.ScoredChunk, error)
+}
+
+// formatRAGContext renders chunks as numbered passages, each introduced by
+// where it comes from, for the GENERATE_RAG_ANSWER prompt.
+func formatRAGContext(chunks []rag.ScoredChunk) string {
+	var context strings.Builder
+	for i, chunk := range chunks {
+		fmt.Fprintf(&context, "[%d] Source: %s\n%s\n\n", i+1, chunkSource(chunk.Chunk), strings.TrimSpace(chunk.Content))
+	}
+	return strings.TrimRight(context.String(), "\n")
+}
+
+// chunkSource describes where a chunk comes from, e.g. "pricing.md, section Plans".
+func chunkSource(chunk rag.Chunk) string {
+	parts := []string{chunk.Metadata.Source}
+	if parts[0] == "" {
+		parts[0] = chunk.DocumentID
+	}
+	if chunk.Metadata.Section != "" {
+		parts = append(parts, "section "+chunk.Metadata.Section)
+	}
+	if chunk.Metadata.PageNumber > 0 {
+		parts = append(parts, fmt.Sprintf("page %d", chunk.Metadata.PageNumber))
+	}
+	return strings.Join(parts, ", ")
+}
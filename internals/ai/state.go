@@ -1,22 +1,45 @@
 package ai
 
-import "github.com/zaynkorai/mailflow/internals/email/gmail"
+import "mailflow/internals/email"
 
+// Outcome is how a workflow run finished for its email.
+type Outcome string
+
+const (
+	OutcomeDrafted Outcome = "drafted" // A reply draft was created
+	OutcomeSent    Outcome = "sent"    // A reply was sent
+	OutcomeSkipped Outcome = "skipped" // The email was unrelated and left alone
+	OutcomeGaveUp  Outcome = "gave_up" // No draft passed proofreading within the trial limit
+	OutcomeFailed  Outcome = "failed"  // The run stopped with an error
+
+	OutcomePendingApproval Outcome = "pending_approval" // The reply waits in the review queue
+	OutcomeForwarded       Outcome = "forwarded"        // The email was forwarded to a human
+	OutcomeIgnored         Outcome = "ignored"          // The delivery policy left the email alone
+)
+
+// GraphState is the state of one workflow run, which always handles a single email.
 type GraphState struct {
-	EmailsInfo         []gmail.EmailInfo // List of emails to process
-	CurrentEmailInfo   gmail.EmailInfo   // The email info currently being processed
-	EmailCategory      string            // Category assigned to the current email
-	GeneratedEmail     string            // The draft email generated by the writer agent
-	RAGQueries         []string          // Queries generated for RAG retrieval
-	RetrievedDocuments string            // Concatenated documents retrieved from RAG
-	WriterMessages     []string          // History of writer's drafts and proofreader feedback
-	Sendable           bool              // Indicates if the generated email is sendable
-	Trials             int               // Number of attempts to generate a sendable email
+	RunID              string          // ID of the workflow run owning this state
+	CurrentEmailInfo   email.EmailInfo // The email processed by this run
+	EmailCategory      string          // Category assigned to the current email
+	CategoryScores     []CategoryScore // Every label of the categorizer, most confident first
+	LowConfidence      bool            // No label reached the confidence threshold, so a human reviews the reply
+	Delivery           DeliveryMode    // What the delivery policy decided for the current email
+	GeneratedEmail     string          // The draft email generated by the writer agent
+	RAGQueries         []string        // Queries generated for RAG retrieval
+	RetrievedDocuments string          // Concatenated documents retrieved from RAG
+	RetrievedChunkIDs  []string        // Knowledge base chunks the RAG answers were based on, in retrieval order
+	WriterMessages     []string        // History of writer's drafts and proofreader feedback
+	Sendable           bool            // Indicates if the generated email is sendable
+	Trials             int             // Number of attempts to generate a sendable email
+	Outcome            Outcome         // Set by the node that finishes the run
+	ReviewParentID     string          // Rejected review item this run redrafts
+	ReviewRevision     int             // Revision number of the reply within its review history
 }
 
-func NewGraphState() *GraphState {
+func NewGraphState(emailInfo email.EmailInfo) *GraphState {
 	return &GraphState{
-		EmailsInfo:         []gmail.EmailInfo{},
+		CurrentEmailInfo:   emailInfo,
 		WriterMessages:     []string{},
 		RAGQueries:         []string{},
 		RetrievedDocuments: "",
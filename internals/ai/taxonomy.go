@@ -0,0 +1,179 @@
+package ai
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"mailflow/internals/rag"
+)
+
+// Route is the branch of the workflow an email takes after categorization.
+type Route string
+
+const (
+	RouteRAG   Route = "rag"   // Look up the knowledge base before writing a reply
+	RouteReply Route = "reply" // Write a reply from the email alone
+	RouteSkip  Route = "skip"  // Leave the email unanswered
+)
+
+// Category is one class the categorizer may assign to an email.
+type Category struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Examples    []string `json:"examples,omitempty"`
+	Route       Route    `json:"route"`
+	// Knowledge restricts the knowledge base chunks RAG queries of emails in
+	// this category are answered from, e.g. billing questions to documents
+	// tagged "topic": "billing". The zero Filter searches everything.
+	Knowledge rag.Filter `json:"knowledge,omitempty"`
+	// Retrieval overrides how RAG queries of this category search the
+	// knowledge base, e.g. "hybrid" for questions naming plans or error codes.
+	Retrieval rag.RetrievalMode `json:"retrieval,omitempty"`
+}
+
+// Taxonomy is the set of categories the categorizer chooses from. Fallback
+// names the category assigned when the model answers with an unknown one.
+type Taxonomy struct {
+	Categories []Category `json:"categories"`
+	Fallback   string     `json:"fallback,omitempty"`
+}
+
+// DefaultTaxonomy returns the categories Mailflow uses when none are configured.
+func DefaultTaxonomy() *Taxonomy {
+	return &Taxonomy{
+		Categories: []Category{
+			{Name: string(ProductEnquiry), Description: "When the email seeks information about a product feature, benefit, service, or pricing.", Route: RouteRAG},
+			{Name: string(CustomerComplaint), Description: "When the email communicates dissatisfaction or a complaint.", Route: RouteReply},
+			{Name: string(CustomerFeedback), Description: "When the email provides feedback or suggestions regarding a product or service.", Route: RouteReply},
+			{Name: string(Unrelated), Description: "When the email content does not match any of the above categories.", Route: RouteSkip},
+		},
+		Fallback: string(Unrelated),
+	}
+}
+
+// LoadTaxonomy reads a taxonomy from a JSON file such as internals/data/categories.example.json.
+func LoadTaxonomy(path string) (*Taxonomy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read categories %s: %w", path, err)
+	}
+	var taxonomy Taxonomy
+	if err := json.Unmarshal(data, &taxonomy); err != nil {
+		return nil, fmt.Errorf("failed to parse categories %s: %w", path, err)
+	}
+	if err := taxonomy.normalize(); err != nil {
+		return nil, fmt.Errorf("invalid categories %s: %w", path, err)
+	}
+	return &taxonomy, nil
+}
+
+// normalize upper-cases names, checks routes and picks a fallback: the
+// configured one, else the last category that skips, else the last category.
+func (t *Taxonomy) normalize() error {
+	if len(t.Categories) == 0 {
+		return fmt.Errorf("no categories defined")
+	}
+	seen := make(map[string]bool)
+	for i := range t.Categories {
+		c := &t.Categories[i]
+		c.Name = strings.ToUpper(strings.TrimSpace(c.Name))
+		if c.Name == "" {
+			return fmt.Errorf("category %d has no name", i+1)
+		}
+		if seen[c.Name] {
+			return fmt.Errorf("category %s is defined twice", c.Name)
+		}
+		seen[c.Name] = true
+		switch c.Route {
+		case RouteRAG, RouteReply, RouteSkip:
+		default:
+			return fmt.Errorf("category %s has unknown route %q (want %s, %s or %s)", c.Name, c.Route, RouteRAG, RouteReply, RouteSkip)
+		}
+		if c.Retrieval != "" {
+			if _, err := rag.ParseRetrievalMode(string(c.Retrieval)); err != nil {
+				return fmt.Errorf("category %s: %w", c.Name, err)
+			}
+		}
+	}
+
+	t.Fallback = strings.ToUpper(strings.TrimSpace(t.Fallback))
+	if t.Fallback != "" {
+		if !seen[t.Fallback] {
+			return fmt.Errorf("fallback category %s is not defined", t.Fallback)
+		}
+		return nil
+	}
+	t.Fallback = t.Categories[len(t.Categories)-1].Name
+	for _, c := range t.Categories {
+		if c.Route == RouteSkip {
+			t.Fallback = c.Name
+		}
+	}
+	return nil
+}
+
+// Lookup returns the category called name, ignoring case.
+func (t *Taxonomy) Lookup(name string) (Category, bool) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	for _, c := range t.Categories {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Category{}, false
+}
+
+// Resolve maps a category returned by the model onto the taxonomy, using the
+// fallback for names it does not define.
+func (t *Taxonomy) Resolve(name string) Category {
+	if c, ok := t.Lookup(name); ok {
+		return c
+	}
+	c, _ := t.Lookup(t.Fallback)
+	return c
+}
+
+// Rank maps the labels of a categorizer answer onto the taxonomy and returns
+// them most confident first. Unknown names count as the fallback category,
+// labels named twice keep their highest confidence, and confidences are
+// clamped to [0, 1]. The result is never empty: without labels it holds the
+// fallback with confidence 0.
+func (t *Taxonomy) Rank(output *CategorizeEmailOutput) []CategoryScore {
+	best := make(map[string]float64)
+	var order []string
+	for _, label := range output.Labels {
+		name := t.Resolve(string(label.Category)).Name
+		confidence := min(max(label.Confidence, 0), 1)
+		if current, ok := best[name]; !ok {
+			order = append(order, name)
+			best[name] = confidence
+		} else if confidence > current {
+			best[name] = confidence
+		}
+	}
+	if len(order) == 0 {
+		return []CategoryScore{{Category: EmailCategory(t.Fallback), Confidence: 0}}
+	}
+
+	scores := make([]CategoryScore, len(order))
+	for i, name := range order {
+		scores[i] = CategoryScore{Category: EmailCategory(name), Confidence: best[name]}
+	}
+	sort.SliceStable(scores, func(i, j int) bool { return scores[i].Confidence > scores[j].Confidence })
+	return scores
+}
+
+// PromptRules renders the categories as the rule list of the categorizer prompt.
+func (t *Taxonomy) PromptRules() string {
+	var rules strings.Builder
+	for _, c := range t.Categories {
+		fmt.Fprintf(&rules, "   - **%s**: %s\n", c.Name, c.Description)
+		for _, example := range c.Examples {
+			fmt.Fprintf(&rules, "     - Example: %q\n", example)
+		}
+	}
+	return strings.TrimRight(rules.String(), "\n")
+}
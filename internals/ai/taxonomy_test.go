@@ -0,0 +1,51 @@
+package ai
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestRank(t *testing.T) {
+	tests := []struct {
+		name   string
+		answer string
+		want   string
+	}{
+		{
+			name:   "most confident first",
+			answer: `{"labels": [{"category": "CUSTOMER_FEEDBACK", "confidence": 0.4}, {"category": "CUSTOMER_COMPLAINT", "confidence": 0.8}]}`,
+			want:   "[{CUSTOMER_COMPLAINT 0.8} {CUSTOMER_FEEDBACK 0.4}]",
+		},
+		{
+			name:   "duplicates keep their highest confidence",
+			answer: `{"labels": [{"category": "PRODUCT_ENQUIRY", "confidence": 0.3}, {"category": "PRODUCT_ENQUIRY", "confidence": 0.7}]}`,
+			want:   "[{PRODUCT_ENQUIRY 0.7}]",
+		},
+		{
+			name:   "no labels",
+			answer: `{"labels": []}`,
+			want:   "[{UNRELATED 0}]",
+		},
+	}
+	agents := NewAgents(nil, DefaultTaxonomy())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := agents.categorySchema.Validate([]byte(tt.answer)); err != nil {
+				t.Fatalf("categorizer schema rejected %s: %v", tt.answer, err)
+			}
+			var output CategorizeEmailOutput
+			if err := json.Unmarshal([]byte(tt.answer), &output); err != nil {
+				t.Fatal(err)
+			}
+			if got := fmt.Sprint(DefaultTaxonomy().Rank(&output)); got != tt.want {
+				t.Errorf("Rank = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	// Answers without labels are re-prompted, not ranked.
+	if err := agents.categorySchema.Validate([]byte(`{"category": "PRODUCT_ENQUIRY"}`)); err == nil {
+		t.Error("categorizer schema accepted a single-label answer")
+	}
+}
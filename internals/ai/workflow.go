@@ -3,76 +3,286 @@ package ai
 import (
 	"context"
 	"fmt"
+
+	"mailflow/internals/email"
+	"mailflow/internals/graph"
+	"mailflow/internals/llm"
+	"mailflow/internals/rag"
+	"mailflow/internals/review"
+	"mailflow/pkg/logging"
+
+	"github.com/google/uuid"
 )
 
-type Workflow struct {
-	Graph *Graph
+// maxRunSteps bounds the nodes executed for one email: categorize, RAG, every
+// writer/proofreader round and the final node fit comfortably.
+const maxRunSteps = 4 + 2*maxWriterTrials + 2
+
+// Options configures NewWorkflow.
+type Options struct {
+	Taxonomy  *Taxonomy         // DefaultTaxonomy when nil
+	Threshold float64           // Replies to emails whose top category is less confident go to review; 0 disables
+	Policy    DeliveryPolicy    // Drafts every reply when empty
+	Reviews   *review.Store     // Required when the policy uses DeliveryApproval
+	ForwardTo string            // Required when the policy uses DeliveryForward
+	Retriever Retriever         // Knowledge base answering RAG queries; without one they are answered from no context
+	TopK      int               // Chunks retrieved per RAG query, DefaultRetrievalTopK when 0
+	MinScore  float64           // Chunks less similar to a RAG query are not used to answer it; 0 disables
+	Retrieval rag.RetrievalMode // How RAG queries search the knowledge base unless their category says otherwise; vector when empty
+	Fusion    rag.Fusion        // Weights of hybrid retrieval; zero selects rag.DefaultFusion
 }
 
-func NewWorkflow(ctx context.Context, googleAPIKey string) (*Workflow, error) {
-	nodesImpl, err := NewNodes(ctx, googleAPIKey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to initialize nodes implementation: %w", err)
-	}
+type Workflow struct {
+	Graph *graph.Graph[*GraphState]
+	nodes *Nodes
+}
 
-	graph := NewGraph(nodesImpl)
+// RunResult reports what a workflow run did with its email.
+type RunResult struct {
+	RunID   string
+	Email   email.EmailInfo
+	Outcome Outcome
+	State   *GraphState
+	Err     error
+}
 
-	graph.AddNode("LoadInboxEmails", nodesImpl.LoadNewEmails)
-	graph.AddNode("IsEmailInboxEmpty", nodesImpl.IsEmailInboxEmpty)
-	graph.AddNode("CategorizeEmail", nodesImpl.CategorizeEmail)
-	graph.AddNode("ConstructRagQueries", nodesImpl.ConstructRAGQueries)
-	graph.AddNode("RetrieveFromRag", nodesImpl.RetrieveFromRAG)
-	graph.AddNode("EmailWriter", nodesImpl.WriteDraftEmail)
-	graph.AddNode("EmailProofreader", nodesImpl.VerifyGeneratedEmail)
-	graph.AddNode("SendEmail", nodesImpl.CreateDraftResponse)
-	graph.AddNode("SkipUnrelatedEmail", nodesImpl.SkipUnrelatedEmail)
+func NewWorkflow(generator llm.Generator, mailService email.Service, opts Options) (*Workflow, error) {
+	if opts.Taxonomy != nil {
+		if err := opts.Taxonomy.normalize(); err != nil {
+			return nil, fmt.Errorf("invalid categories: %w", err)
+		}
+	} else {
+		opts.Taxonomy = DefaultTaxonomy()
+	}
+	if err := opts.Policy.Validate(); err != nil {
+		return nil, err
+	}
+	for _, rule := range opts.Policy.Rules {
+		if _, ok := opts.Taxonomy.Lookup(rule.Category); rule.Category != "" && !ok {
+			return nil, fmt.Errorf("delivery rule %s names an unknown category", rule.key())
+		}
+	}
+	if _, err := rag.ParseRetrievalMode(string(opts.Retrieval)); err != nil {
+		return nil, err
+	}
+	if opts.Threshold < 0 || opts.Threshold > 1 {
+		return nil, fmt.Errorf("category confidence threshold %v is not between 0 and 1", opts.Threshold)
+	}
+	if (opts.Policy.Uses(DeliveryApproval) || opts.Threshold > 0) && opts.Reviews == nil {
+		return nil, fmt.Errorf("delivery mode %q requires a review queue", DeliveryApproval)
+	}
+	if opts.Policy.Uses(DeliveryForward) && opts.ForwardTo == "" {
+		return nil, fmt.Errorf("delivery mode %q requires an address to forward to", DeliveryForward)
+	}
+	nodesImpl := NewNodes(generator, mailService, opts)
 
-	graph.SetEntryPoint("LoadInboxEmails")
+	g := graph.NewGraph[*GraphState]()
+	nodes := []struct {
+		name string
+		fn   graph.NodeFunc[*GraphState]
+	}{
+		{"CategorizeEmail", nodesImpl.CategorizeEmail},
+		{"ConstructRagQueries", nodesImpl.ConstructRAGQueries},
+		{"RetrieveFromRag", nodesImpl.RetrieveFromRAG},
+		{"EmailWriter", nodesImpl.WriteDraftEmail},
+		{"EmailProofreader", nodesImpl.VerifyGeneratedEmail},
+		{"DeliverReply", nodesImpl.DeliverReply},
+		{"CreateDraft", nodesImpl.CreateDraftResponse},
+		{"SendReply", nodesImpl.SendEmailResponse},
+		{"QueueForApproval", nodesImpl.QueueForApproval},
+		{"ForwardEmail", nodesImpl.ForwardEmail},
+		{"IgnoreEmail", nodesImpl.IgnoreEmail},
+		{"SkipUnrelatedEmail", nodesImpl.SkipUnrelatedEmail},
+		{"GiveUpOnEmail", nodesImpl.GiveUpOnEmail},
+	}
+	for _, node := range nodes {
+		if err := g.AddNode(node.name, node.fn); err != nil {
+			return nil, err
+		}
+	}
 
-	graph.AddEdge("LoadInboxEmails", "IsEmailInboxEmpty")
+	g.SetEntryPoint("CategorizeEmail")
 
-	// check if there are emails to process (conditional routing)
-	graph.AddConditionalEdges(
-		"IsEmailInboxEmpty",
-		nodesImpl.CheckNewEmails, // This node returns the routing decision
-		map[string]string{
-			"process": "CategorizeEmail",
-			"empty":   GraphEnd, // Map to our custom END sentinel
-		},
-	)
+	edges := []struct{ from, to string }{
+		// pass constructed queries to RAG chain to retrieve information
+		{"ConstructRagQueries", "RetrieveFromRag"},
+		// give information to writer agent to create draft email
+		{"RetrieveFromRag", "EmailWriter"},
+		// proofread the generated draft email
+		{"EmailWriter", "EmailProofreader"},
+		// every run handles exactly one email
+		{"CreateDraft", graph.GraphEnd},
+		{"SendReply", graph.GraphEnd},
+		{"QueueForApproval", graph.GraphEnd},
+		{"ForwardEmail", graph.GraphEnd},
+		{"IgnoreEmail", graph.GraphEnd},
+		{"SkipUnrelatedEmail", graph.GraphEnd},
+		{"GiveUpOnEmail", graph.GraphEnd},
+	}
+	for _, e := range edges {
+		if err := g.AddEdge(e.from, e.to); err != nil {
+			return nil, err
+		}
+	}
 
-	// route email based on category (conditional routing)
-	graph.AddConditionalEdges(
+	// route email based on the route of its category (conditional routing)
+	if err := g.AddConditionalEdges(
 		"CategorizeEmail",
-		nodesImpl.RouteEmailBasedOnCategory, // This node returns the routing decision
+		nodesImpl.RouteEmailBasedOnCategory,
 		map[string]string{
-			"product related":     "ConstructRagQueries",
-			"not product related": "EmailWriter", // Feedback or Complaint
-			"unrelated":           "SkipUnrelatedEmail",
+			string(RouteRAG):   "ConstructRagQueries",
+			string(RouteReply): "EmailWriter",
+			string(RouteSkip):  "SkipUnrelatedEmail",
+			"forward":          "ForwardEmail",
+			"ignore":           "IgnoreEmail",
 		},
-	)
-
-	// pass constructed queries to RAG chain to retrieve information
-	graph.AddEdge("ConstructRagQueries", "RetrieveFromRag")
-	// give information to writer agent to create draft email
-	graph.AddEdge("RetrieveFromRag", "EmailWriter")
-	// proofread the generated draft email
-	graph.AddEdge("EmailWriter", "EmailProofreader")
+	); err != nil {
+		return nil, err
+	}
 
 	// check if email is sendable or not, if not rewrite the email (conditional routing)
-	graph.AddConditionalEdges(
+	if err := g.AddConditionalEdges(
 		"EmailProofreader",
-		nodesImpl.MustRewrite, // This node returns the routing decision
+		nodesImpl.MustRewrite,
 		map[string]string{
-			"send":    "SendEmail",
+			"send":    "DeliverReply",
 			"rewrite": "EmailWriter",
-			"stop":    "CategorizeEmail", // LangGraph example loops back to categorize for next email or stop
+			"stop":    "GiveUpOnEmail",
 		},
-	)
+	); err != nil {
+		return nil, err
+	}
 
-	// check if there are still emails to be processed (after sending/skipping)
-	graph.AddEdge("SendEmail", "IsEmailInboxEmpty")
-	graph.AddEdge("SkipUnrelatedEmail", "IsEmailInboxEmpty")
+	// draft, send or queue the reply for approval depending on the delivery mode
+	if err := g.AddConditionalEdges(
+		"DeliverReply",
+		nodesImpl.ChooseDelivery,
+		map[string]string{
+			string(DeliveryDraft):    "CreateDraft",
+			string(DeliverySend):     "SendReply",
+			string(DeliveryApproval): "QueueForApproval",
+		},
+	); err != nil {
+		return nil, err
+	}
+
+	routes := []struct {
+		from string
+		keys []string
+	}{
+		{"CategorizeEmail", []string{string(RouteRAG), string(RouteReply), string(RouteSkip), "forward", "ignore"}},
+		{"EmailProofreader", []string{"send", "rewrite", "stop"}},
+		{"DeliverReply", []string{string(DeliveryDraft), string(DeliverySend), string(DeliveryApproval)}},
+	}
+	for _, r := range routes {
+		if err := g.DeclareRoutes(r.from, r.keys...); err != nil {
+			return nil, err
+		}
+	}
 
-	return &Workflow{Graph: graph.Compile()}, nil
+	// guard the rewrite loop, MustRewrite stops after maxWriterTrials drafts
+	if err := g.SetMaxVisits("EmailWriter", maxWriterTrials); err != nil {
+		return nil, err
+	}
+
+	compiled, err := g.Compile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile email workflow: %w", err)
+	}
+	return &Workflow{Graph: compiled, nodes: nodesImpl}, nil
+}
+
+// Run processes a single email in its own run. Failures are reported in the
+// result rather than returned, so one bad email does not stop the others.
+func (w *Workflow) Run(ctx context.Context, emailInfo email.EmailInfo) RunResult {
+	return w.RunWithID(ctx, uuid.NewString(), emailInfo)
+}
+
+// RunWithID is Run under a run ID chosen by the caller, e.g. one already
+// recorded in the ledger.
+func (w *Workflow) RunWithID(ctx context.Context, runID string, emailInfo email.EmailInfo) RunResult {
+	initial := NewGraphState(emailInfo)
+	initial.RunID = runID
+	state, err := w.Graph.ExecuteRun(ctx, runID, initial, maxRunSteps)
+	return newRunResult(runID, emailInfo, state, err)
+}
+
+// Resume finishes a run interrupted by a crash or restart from its last checkpoint.
+func (w *Workflow) Resume(ctx context.Context, runID string) RunResult {
+	state, err := w.Graph.Resume(ctx, runID, maxRunSteps)
+	var emailInfo email.EmailInfo
+	if state != nil {
+		emailInfo = state.CurrentEmailInfo
+	}
+	return newRunResult(runID, emailInfo, state, err)
+}
+
+// SendApproved sends a reply a reviewer approved, as edited in the queue. The
+// item is claimed before sending, so it is sent at most once even if marking
+// it sent fails afterwards.
+func (w *Workflow) SendApproved(ctx context.Context, item review.Item) RunResult {
+	claimed, err := w.nodes.Reviews.ClaimSend(ctx, item.ID)
+	if err != nil {
+		return newRunResult(item.RunID, item.Email, nil, err)
+	}
+
+	state := NewGraphState(claimed.Email)
+	state.RunID = claimed.RunID
+	state.EmailCategory = claimed.Category
+	state.GeneratedEmail = claimed.Draft
+
+	state, err = w.nodes.SendEmailResponse(ctx, state)
+	if err != nil {
+		if releaseErr := w.nodes.Reviews.ReleaseSend(context.WithoutCancel(ctx), claimed.ID); releaseErr != nil {
+			logging.Error("Failed to release review item %s after a failed send: %v", claimed.ID, releaseErr)
+		}
+		return newRunResult(claimed.RunID, claimed.Email, state, err)
+	}
+	if err := w.nodes.Reviews.MarkSent(context.WithoutCancel(ctx), claimed.ID); err != nil {
+		logging.Error("Reply of review item %s was sent but not marked sent: %v", claimed.ID, err)
+	}
+	return newRunResult(claimed.RunID, claimed.Email, state, nil)
+}
+
+// Redraft writes a new reply for a rejected review item in a new run. The
+// reviewer's reason is added to the writer's feedback and the result goes
+// through proofreading and delivery like any other draft. The item is marked
+// redrafted before the run, so a second Redraft of it queues no second draft,
+// and released again if the run fails.
+func (w *Workflow) Redraft(ctx context.Context, item review.Item) RunResult {
+	if err := w.nodes.Reviews.MarkRedrafted(ctx, item.ID); err != nil {
+		return newRunResult(item.RunID, item.Email, nil, err)
+	}
+
+	runID := uuid.NewString()
+	initial := NewGraphState(item.Email)
+	initial.RunID = runID
+	initial.EmailCategory = item.Category
+	initial.RetrievedDocuments = item.Information
+	initial.WriterMessages = append(append([]string{}, item.Feedback...), fmt.Sprintf("**Reviewer Feedback:**\n%s", item.RejectReason))
+	initial.ReviewParentID = item.ID
+	initial.ReviewRevision = item.Revision + 1
+	// A rejected reply goes back to the reviewer, whatever the policy says today.
+	initial.Delivery = DeliveryApproval
+
+	state, err := w.Graph.ExecuteRunFrom(ctx, runID, "EmailWriter", initial, maxRunSteps)
+	// A run stopped by cancellation stays pending and queues its draft when it
+	// is resumed; only one that failed outright hands the item back.
+	if err != nil && ctx.Err() == nil && (state == nil || state.Outcome != OutcomePendingApproval) {
+		if releaseErr := w.nodes.Reviews.ReleaseRedraft(context.WithoutCancel(ctx), item.ID); releaseErr != nil {
+			logging.Error("Failed to release review item %s after a failed redraft: %v", item.ID, releaseErr)
+		}
+	}
+	return newRunResult(runID, item.Email, state, err)
+}
+
+func newRunResult(runID string, emailInfo email.EmailInfo, state *GraphState, err error) RunResult {
+	result := RunResult{RunID: runID, Email: emailInfo, State: state, Err: err}
+	if state != nil {
+		result.Outcome = state.Outcome
+	}
+	if err != nil || result.Outcome == "" {
+		result.Outcome = OutcomeFailed
+	}
+	return result
 }
@@ -0,0 +1,275 @@
+package ai_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"mailflow/internals/ai"
+	"mailflow/internals/email/memory"
+	"mailflow/internals/llm"
+	"mailflow/internals/review"
+)
+
+const (
+	inboxFixtures = "../data/fixtures/inbox"
+	llmScript     = "../data/fixtures/llm/script.json"
+	myEmail       = "support@agency.example.com"
+)
+
+// loadMailbox seeds an in-memory mailbox with the inbox fixtures: a product
+// enquiry from Jane, a complaint from John and feedback from Maria.
+func loadMailbox(t *testing.T) *memory.Mailbox {
+	t.Helper()
+	mailbox := memory.NewMailbox(myEmail)
+	if err := mailbox.LoadFixtures(inboxFixtures); err != nil {
+		t.Fatalf("LoadFixtures: %v", err)
+	}
+	return mailbox
+}
+
+// runInbox runs the workflow on every unanswered email and returns the
+// outcome per sender address.
+func runInbox(t *testing.T, workflow *ai.Workflow, mailbox *memory.Mailbox) map[string]ai.Outcome {
+	t.Helper()
+	ctx := context.Background()
+	emails, err := mailbox.FetchUnansweredEmails(ctx, 10)
+	if err != nil {
+		t.Fatalf("FetchUnansweredEmails: %v", err)
+	}
+	if len(emails) != 3 {
+		t.Fatalf("got %d unanswered fixture emails, want 3", len(emails))
+	}
+	outcomes := make(map[string]ai.Outcome)
+	for _, msg := range emails {
+		result := workflow.Run(ctx, msg)
+		if result.Err != nil {
+			t.Fatalf("run for %s failed: %v", msg.Sender, result.Err)
+		}
+		outcomes[senderAddress(msg.Sender)] = result.Outcome
+	}
+	return outcomes
+}
+
+func senderAddress(sender string) string {
+	if start := strings.LastIndex(sender, "<"); start >= 0 {
+		return strings.TrimSuffix(sender[start+1:], ">")
+	}
+	return sender
+}
+
+func TestWorkflowOnFixtures(t *testing.T) {
+	tests := []struct {
+		name         string
+		policy       string
+		forwardTo    string
+		wantOutcomes map[string]ai.Outcome
+		wantDrafts   []string // Recipients, sorted
+		wantSent     []string
+		wantForwards []string
+	}{
+		{
+			name: "drafts by default",
+			wantOutcomes: map[string]ai.Outcome{
+				"jane@customer.example.com":  ai.OutcomeDrafted,
+				"john@customer.example.com":  ai.OutcomeDrafted,
+				"maria@customer.example.com": ai.OutcomeDrafted,
+			},
+			wantDrafts: []string{"jane@customer.example.com", "john@customer.example.com", "maria@customer.example.com"},
+		},
+		{
+			name:      "per-category policy",
+			policy:    "PRODUCT_ENQUIRY=send, CUSTOMER_COMPLAINT=forward",
+			forwardTo: "escalations@agency.example.com",
+			wantOutcomes: map[string]ai.Outcome{
+				"jane@customer.example.com":  ai.OutcomeSent,
+				"john@customer.example.com":  ai.OutcomeForwarded,
+				"maria@customer.example.com": ai.OutcomeDrafted,
+			},
+			wantDrafts:   []string{"maria@customer.example.com"},
+			wantSent:     []string{"jane@customer.example.com"},
+			wantForwards: []string{"john@customer.example.com"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			generator, err := llm.LoadScriptedGenerator(llmScript)
+			if err != nil {
+				t.Fatalf("LoadScriptedGenerator: %v", err)
+			}
+			policy, err := ai.ParseDeliveryPolicy(tt.policy, ai.DeliveryDraft)
+			if err != nil {
+				t.Fatalf("ParseDeliveryPolicy: %v", err)
+			}
+			mailbox := loadMailbox(t)
+			workflow, err := ai.NewWorkflow(generator, mailbox, ai.Options{Policy: policy, ForwardTo: tt.forwardTo})
+			if err != nil {
+				t.Fatalf("NewWorkflow: %v", err)
+			}
+
+			outcomes := runInbox(t, workflow, mailbox)
+			for sender, want := range tt.wantOutcomes {
+				if outcomes[sender] != want {
+					t.Errorf("outcome for %s = %q, want %q", sender, outcomes[sender], want)
+				}
+			}
+
+			var drafts, sent, forwards []string
+			for _, draft := range mailbox.Drafts() {
+				if !strings.Contains(draft.Body, "Thank you for reaching out") {
+					t.Errorf("draft to %s has body %q, want the scripted reply", draft.InReplyTo.Sender, draft.Body)
+				}
+				drafts = append(drafts, senderAddress(draft.InReplyTo.Sender))
+			}
+			for _, reply := range mailbox.Sent() {
+				sent = append(sent, senderAddress(reply.InReplyTo.Sender))
+			}
+			for _, forward := range mailbox.Forwards() {
+				if forward.To != tt.forwardTo {
+					t.Errorf("forwarded to %s, want %s", forward.To, tt.forwardTo)
+				}
+				forwards = append(forwards, senderAddress(forward.Original.Sender))
+			}
+			assertRecipients(t, "drafts", drafts, tt.wantDrafts)
+			assertRecipients(t, "sent replies", sent, tt.wantSent)
+			assertRecipients(t, "forwards", forwards, tt.wantForwards)
+
+			if again, err := mailbox.FetchUnansweredEmails(context.Background(), 10); err != nil || len(again) != 0 {
+				t.Errorf("unanswered after the run = %v, %v, want none", again, err)
+			}
+		})
+	}
+}
+
+// TestWorkflowReplaysRecording records a run on the scripted generator and
+// checks that replaying the fixture drafts the same replies.
+func TestWorkflowReplaysRecording(t *testing.T) {
+	fixture := filepath.Join(t.TempDir(), "recorded.json")
+	scripted, err := llm.LoadScriptedGenerator(llmScript)
+	if err != nil {
+		t.Fatalf("LoadScriptedGenerator: %v", err)
+	}
+	recorder, err := llm.NewRecordingGenerator(scripted, fixture)
+	if err != nil {
+		t.Fatalf("NewRecordingGenerator: %v", err)
+	}
+	run := func(generator llm.Generator) map[string]string {
+		mailbox := loadMailbox(t)
+		workflow, err := ai.NewWorkflow(generator, mailbox, ai.Options{})
+		if err != nil {
+			t.Fatalf("NewWorkflow: %v", err)
+		}
+		for sender, outcome := range runInbox(t, workflow, mailbox) {
+			if outcome != ai.OutcomeDrafted {
+				t.Errorf("outcome for %s = %q, want %q", sender, outcome, ai.OutcomeDrafted)
+			}
+		}
+		bodies := make(map[string]string)
+		for _, draft := range mailbox.Drafts() {
+			bodies[senderAddress(draft.InReplyTo.Sender)] = draft.Body
+		}
+		return bodies
+	}
+
+	recorded := run(recorder)
+	if len(scripted.Calls()) == 0 {
+		t.Fatal("recording run made no model calls")
+	}
+	replayer, err := llm.NewReplayGenerator(fixture)
+	if err != nil {
+		t.Fatalf("NewReplayGenerator: %v", err)
+	}
+	replayed := run(replayer)
+	if len(replayed) != 3 {
+		t.Fatalf("replay drafted %d replies, want 3", len(replayed))
+	}
+	for sender, body := range recorded {
+		if replayed[sender] != body {
+			t.Errorf("replayed draft to %s = %q, want %q", sender, replayed[sender], body)
+		}
+	}
+}
+
+// failingGenerator fails every call, as a provider that is down would.
+type failingGenerator struct{}
+
+func (failingGenerator) Generate(ctx context.Context, req llm.Request) (*llm.Response, error) {
+	return nil, errors.New("provider down")
+}
+
+func TestRedraft(t *testing.T) {
+	ctx := context.Background()
+	reviews, err := review.Open(filepath.Join(t.TempDir(), "reviews.db"))
+	if err != nil {
+		t.Fatalf("review.Open: %v", err)
+	}
+	defer reviews.Close()
+	mailbox := loadMailbox(t)
+	emails, err := mailbox.FetchUnansweredEmails(ctx, 10)
+	if err != nil || len(emails) == 0 {
+		t.Fatalf("FetchUnansweredEmails = %d emails, %v", len(emails), err)
+	}
+	queued, err := reviews.Add(ctx, review.Item{RunID: "first-run", Email: emails[0], Category: "PRODUCT_ENQUIRY", Draft: "Too short."})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	rejected, err := reviews.Reject(ctx, queued.ID, "Answer the pricing question.")
+	if err != nil {
+		t.Fatalf("Reject: %v", err)
+	}
+	newWorkflow := func(generator llm.Generator) *ai.Workflow {
+		workflow, err := ai.NewWorkflow(generator, mailbox, ai.Options{Reviews: reviews})
+		if err != nil {
+			t.Fatalf("NewWorkflow: %v", err)
+		}
+		return workflow
+	}
+	assertStatus := func(what string, want review.Status, wantPending int) {
+		t.Helper()
+		item, err := reviews.Get(ctx, rejected.ID)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		pending, err := reviews.List(ctx, review.StatusPending)
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		if item.Status != want || len(pending) != wantPending {
+			t.Errorf("%s: item is %s with %d drafts pending, want %s with %d", what, item.Status, len(pending), want, wantPending)
+		}
+	}
+
+	// A run that fails hands the item back for the next redraft.
+	if result := newWorkflow(failingGenerator{}).Redraft(ctx, *rejected); result.Err == nil {
+		t.Fatal("redraft on a failing provider succeeded")
+	}
+	assertStatus("after a failed run", review.StatusRejected, 0)
+
+	generator, err := llm.LoadScriptedGenerator(llmScript)
+	if err != nil {
+		t.Fatalf("LoadScriptedGenerator: %v", err)
+	}
+	workflow := newWorkflow(generator)
+	if result := workflow.Redraft(ctx, *rejected); result.Err != nil || result.Outcome != ai.OutcomePendingApproval {
+		t.Fatalf("Redraft = %s, %v, want %s", result.Outcome, result.Err, ai.OutcomePendingApproval)
+	}
+	assertStatus("after a redraft", review.StatusRedrafted, 1)
+
+	// The item can no longer be marked redrafted, so a second redraft, e.g.
+	// of the same poll result, fails before it queues another draft.
+	if result := workflow.Redraft(ctx, *rejected); !errors.Is(result.Err, review.ErrConflict) {
+		t.Fatalf("second Redraft returned %v, want %v", result.Err, review.ErrConflict)
+	}
+	assertStatus("after a second redraft", review.StatusRedrafted, 1)
+}
+
+func assertRecipients(t *testing.T, what string, got, want []string) {
+	t.Helper()
+	sort.Strings(got)
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("%s went to %v, want %v", what, got, want)
+	}
+}
@@ -15,25 +15,10 @@ import (
 type WorkflowContext struct {
 	EmailService email.Service
 	RAGSystem    *rag.RAGSystem
-	LLMGenerator *llm.GeminiGenerator
+	LLMGenerator llm.Generator
 	// Add other shared resources like database connections, metrics, etc.
 }
 
-// EmailCategory represents the categorization of an email.
-type EmailCategory string
-
-const (
-	ProductEnquiry    EmailCategory = "PRODUCT_ENQUIRY"
-	CustomerComplaint EmailCategory = "CUSTOMER_COMPLAINT"
-	CustomerFeedback  EmailCategory = "CUSTOMER_FEEDBACK"
-	Unrelated         EmailCategory = "UNRELATED"
-)
-
-// CategorizeEmailOutput represents the structured output from the email categorization agent.
-type CategorizeEmailOutput struct {
-	Category EmailCategory `json:"category"`
-}
-
 // RAGQueriesOutput represents the structured output of RAG queries.
 type RAGQueriesOutput struct {
 	Queries []string `json:"queries"`
@@ -131,13 +116,15 @@ func (p *TextResponseParser) Parse(rawLLMResponse string) (string, error) {
 }
 
 // CallLLMWithStructuredOutput is a helper function to call the LLM and parse a structured JSON output.
-func CallLLMWithStructuredOutput[T any](ctx context.Context, generator *llm.GeminiGenerator, prompt string, parser *JSONResponseParser) (*T, error) {
-	rawResponse, err := generator.GenerateContent(ctx, prompt, nil) // No specific generation config for now
+func CallLLMWithStructuredOutput[T any](ctx context.Context, generator llm.Generator, prompt string, parser *JSONResponseParser) (*T, error) {
+	req := llm.UserPrompt("", prompt)
+	req.JSON = true
+	rawResponse, err := generator.Generate(ctx, req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate LLM content for structured output: %w", err)
 	}
 
-	cleanedResponse, err := parser.Parse(rawResponse)
+	cleanedResponse, err := parser.Parse(rawResponse.Text)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse LLM response: %w", err)
 	}
@@ -152,13 +139,13 @@ func CallLLMWithStructuredOutput[T any](ctx context.Context, generator *llm.Gemi
 }
 
 // CallLLMForTextOutput is a helper function to call the LLM and parse a plain text output.
-func CallLLMForTextOutput(ctx context.Context, generator *llm.GeminiGenerator, prompt string, parser *TextResponseParser) (string, error) {
-	textOutput, err := generator.GenerateContent(ctx, prompt, nil) // No specific generation config for now
+func CallLLMForTextOutput(ctx context.Context, generator llm.Generator, prompt string, parser *TextResponseParser) (string, error) {
+	textOutput, err := generator.Generate(ctx, llm.UserPrompt("", prompt))
 	if err != nil {
 		return "", fmt.Errorf("failed to generate LLM content for text output: %w", err)
 	}
 
-	cleanedOutput, err := parser.Parse(textOutput)
+	cleanedOutput, err := parser.Parse(textOutput.Text)
 	if err != nil {
 		return "", fmt.Errorf("failed to parse LLM response: %w", err)
 	}
@@ -3,6 +3,14 @@ package config
 import (
 	"fmt"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"mailflow/internals/llm"
+	"mailflow/internals/rag"
+	"mailflow/internals/rag/adapter"
 
 	"github.com/joho/godotenv"
 	"github.com/spf13/viper"
@@ -12,6 +20,91 @@ type Config struct {
 	Port         int
 	MyEmail      string
 	GoogleAPIKey string
+	LLM          LLMConfig
+	MailProvider string        // "gmail" (default), "imap" or "memory"
+	MailFixtures string        // Fixture file or directory seeding the "memory" provider
+	MailLookback time.Duration // How far back unanswered emails are searched
+	IMAP         IMAPConfig
+	SMTP         SMTPConfig
+	Gmail        GmailConfig
+	Checkpoint   CheckpointConfig
+	Ledger       LedgerConfig
+	VectorStore  adapter.Config    // Knowledge base indexed by cmd/rag-indexer and the API and searched by the workflow
+	RAGTopK      int               // Knowledge base chunks retrieved per RAG query
+	RAGMinScore  float64           // Similarity below which retrieved chunks are discarded; 0 disables
+	RAGMode      rag.RetrievalMode // "vector" (default), "keyword" or "hybrid"
+	RAGFusion    rag.Fusion        // Weights of the rankings hybrid retrieval fuses
+	ChunkLimits  rag.TokenLimits   // Size of the chunks documents are indexed in
+	Chunkers     map[string]string // Chunker per document type, e.g. "markdown_file": "markdown"
+	Categories   string            // JSON file defining the email categories; the built-in four when empty
+	Threshold    float64           // Category confidence below which replies go to human review; 0 disables
+	ReplyMode    string            // Default delivery: "draft" (default), "send", "approval", "forward" or "ignore"
+	ReplyPolicy  string            // Per-category/sender-domain overrides of ReplyMode, e.g. "PRODUCT_ENQUIRY=send"
+	ForwardTo    string            // Address receiving emails whose delivery is "forward"
+	ReviewDB     string            // Review queue shared with the API when replies need approval
+	Workers      int               // Emails processed in parallel
+	DrainTimeout time.Duration     // How long shutdown waits for in-flight emails
+	PollInterval time.Duration     // When set, keep polling the inbox at this interval instead of exiting
+}
+
+// GmailConfig holds the incremental sync settings used when MailProvider is "gmail".
+type GmailConfig struct {
+	HistoryFile string // Persisted history cursor, so restarts only fetch messages added since
+	PubSubTopic string // "projects/<project>/topics/<topic>" for users.watch push notifications
+	PushAddr    string // Listen address for Pub/Sub push deliveries, e.g. ":8090"
+
+	// Push deliveries are only accepted with PushToken as ?token= in the
+	// endpoint URL, or an OIDC token for PushAudience, or both.
+	PushToken          string
+	PushAudience       string
+	PushServiceAccount string // Expected OIDC token email, any when empty
+}
+
+// LedgerConfig locates the processed-message ledger. An empty Path disables it.
+type LedgerConfig struct {
+	Path        string
+	MaxAttempts int // Runs attempted per message before a failing one is left alone
+}
+
+// CheckpointConfig enables durable workflow checkpoints. An empty Store disables them.
+type CheckpointConfig struct {
+	Store     string        // "", "file" or "sqlite"
+	Path      string        // Directory for "file", database file for "sqlite"
+	Retention time.Duration // How long checkpoints of finished runs are kept; 0 keeps them forever
+}
+
+// LLMConfig selects the text generation backend used by the agents.
+type LLMConfig struct {
+	Provider string // "gemini" (default), "openai", "ollama", "scripted" or "replay"
+	Model    string
+	BaseURL  string
+	APIKey   string
+	Fixture  string          // Script or recording served by the "scripted" and "replay" providers
+	RecordTo string          // Fixture file capturing the responses of a live provider
+	Retry    llm.RetryPolicy // Retries, rate limit and circuit breaker of live LLM and embedding calls
+}
+
+// IMAPConfig holds the mailbox settings used when MailProvider is "imap".
+type IMAPConfig struct {
+	Host               string
+	Port               int
+	Username           string
+	Password           string
+	TLS                string // "tls", "starttls" or "none"
+	InsecureSkipVerify bool
+	Mailbox            string
+	DraftsMailbox      string
+	SentMailbox        string
+}
+
+// SMTPConfig holds the outgoing server settings used when MailProvider is "imap".
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	TLS      string // "tls", "starttls" or "none"
+	From     string
 }
 
 func LoadConfig() (*Config, error) {
@@ -33,14 +126,386 @@ func LoadConfig() (*Config, error) {
 		}
 	}
 
+	cfg.LLM = LLMConfig{
+		Provider: getEnv("LLM_PROVIDER", "gemini"),
+		Model:    os.Getenv("LLM_MODEL"),
+		BaseURL:  os.Getenv("LLM_BASE_URL"),
+		APIKey:   os.Getenv("LLM_API_KEY"),
+		Fixture:  os.Getenv("LLM_FIXTURE"),
+		RecordTo: os.Getenv("LLM_RECORD_TO"),
+	}
+	if (cfg.LLM.Provider == "scripted" || cfg.LLM.Provider == "replay") && cfg.LLM.Fixture == "" {
+		return nil, &ConfigError{Key: "LLM_FIXTURE", Value: "", Err: ErrMissingConfig}
+	}
+	if err := loadRetryConfig(&cfg.LLM.Retry); err != nil {
+		return nil, err
+	}
+
+	// Gemini is needed for embeddings and for generation unless a local or
+	// OpenAI-compatible model is selected.
 	cfg.GoogleAPIKey = os.Getenv("GOOGLE_API_KEY")
-	if cfg.GoogleAPIKey == "" {
-		return nil, &ConfigError{Key: "GOOGLE_API_KEY", Value: "", Err: ErrMissingConfig}
+	if cfg.LLM.Provider == "gemini" {
+		if cfg.GoogleAPIKey == "" {
+			return nil, &ConfigError{Key: "GOOGLE_API_KEY", Value: "", Err: ErrMissingConfig}
+		}
+		if cfg.LLM.APIKey == "" {
+			cfg.LLM.APIKey = cfg.GoogleAPIKey
+		}
+	}
+
+	cfg.MailProvider = getEnv("MAIL_PROVIDER", "gmail")
+	cfg.MailFixtures = os.Getenv("MAIL_FIXTURES")
+	if err := loadMailConfig(&cfg); err != nil {
+		return nil, err
+	}
+
+	if err := loadRuntimeConfig(&cfg); err != nil {
+		return nil, err
 	}
 
 	return &cfg, nil
 }
 
+func loadMailConfig(cfg *Config) error {
+	var err error
+	cfg.IMAP = IMAPConfig{
+		Host:          os.Getenv("IMAP_HOST"),
+		Username:      getEnv("IMAP_USERNAME", cfg.MyEmail),
+		Password:      os.Getenv("IMAP_PASSWORD"),
+		TLS:           getEnv("IMAP_TLS", "tls"),
+		Mailbox:       getEnv("IMAP_MAILBOX", "INBOX"),
+		DraftsMailbox: getEnv("IMAP_DRAFTS_MAILBOX", "Drafts"),
+		SentMailbox:   os.Getenv("IMAP_SENT_MAILBOX"),
+	}
+	if cfg.IMAP.Port, err = getEnvInt("IMAP_PORT", 0); err != nil {
+		return err
+	}
+	if cfg.IMAP.InsecureSkipVerify, err = getEnvBool("IMAP_INSECURE_SKIP_VERIFY", false); err != nil {
+		return err
+	}
+
+	cfg.SMTP = SMTPConfig{
+		Host:     getEnv("SMTP_HOST", cfg.IMAP.Host),
+		Username: getEnv("SMTP_USERNAME", cfg.IMAP.Username),
+		Password: getEnv("SMTP_PASSWORD", cfg.IMAP.Password),
+		TLS:      getEnv("SMTP_TLS", "starttls"),
+		From:     getEnv("SMTP_FROM", cfg.MyEmail),
+	}
+	if cfg.SMTP.Port, err = getEnvInt("SMTP_PORT", 0); err != nil {
+		return err
+	}
+
+	cfg.Gmail = GmailConfig{
+		HistoryFile: getEnv("GMAIL_HISTORY_FILE", "gmail_history.json"),
+		PubSubTopic: os.Getenv("GMAIL_PUBSUB_TOPIC"),
+		PushAddr:    os.Getenv("GMAIL_PUSH_ADDR"),
+
+		PushToken:          os.Getenv("GMAIL_PUSH_TOKEN"),
+		PushAudience:       os.Getenv("GMAIL_PUSH_AUDIENCE"),
+		PushServiceAccount: os.Getenv("GMAIL_PUSH_SERVICE_ACCOUNT"),
+	}
+	if cfg.Gmail.PushAddr != "" && cfg.Gmail.PushToken == "" && cfg.Gmail.PushAudience == "" {
+		return &ConfigError{Key: "GMAIL_PUSH_TOKEN", Value: "", Err: ErrMissingConfig}
+	}
+	if cfg.MailLookback, err = getEnvDuration("MAIL_LOOKBACK", 8*time.Hour); err != nil {
+		return err
+	}
+
+	switch cfg.MailProvider {
+	case "gmail", "memory":
+	case "imap":
+		if cfg.IMAP.Host == "" {
+			return &ConfigError{Key: "IMAP_HOST", Value: "", Err: ErrMissingConfig}
+		}
+	default:
+		return &ConfigError{Key: "MAIL_PROVIDER", Value: cfg.MailProvider, Err: ErrInvalidConfig}
+	}
+	return nil
+}
+
+// loadRetryConfig reads how LLM and embedding calls cope with rate limits and outages.
+func loadRetryConfig(policy *llm.RetryPolicy) error {
+	var err error
+	defaults := llm.DefaultRetryPolicy()
+	if policy.MaxRetries, err = getEnvInt("LLM_MAX_RETRIES", defaults.MaxRetries); err != nil {
+		return err
+	}
+	if policy.BaseDelay, err = getEnvDuration("LLM_RETRY_BASE_DELAY", defaults.BaseDelay); err != nil {
+		return err
+	}
+	if policy.MaxDelay, err = getEnvDuration("LLM_RETRY_MAX_DELAY", defaults.MaxDelay); err != nil {
+		return err
+	}
+	if policy.RequestsPerMinute, err = getEnvFloat("LLM_REQUESTS_PER_MINUTE", defaults.RequestsPerMinute); err != nil {
+		return err
+	}
+	if policy.Burst, err = getEnvInt("LLM_RATE_BURST", defaults.Burst); err != nil {
+		return err
+	}
+	if policy.BreakerFailures, err = getEnvInt("LLM_BREAKER_FAILURES", defaults.BreakerFailures); err != nil {
+		return err
+	}
+	if policy.BreakerCooldown, err = getEnvDuration("LLM_BREAKER_COOLDOWN", defaults.BreakerCooldown); err != nil {
+		return err
+	}
+	switch {
+	case policy.MaxRetries < 0:
+		return &ConfigError{Key: "LLM_MAX_RETRIES", Value: os.Getenv("LLM_MAX_RETRIES"), Err: ErrInvalidConfig}
+	case policy.RequestsPerMinute < 0:
+		return &ConfigError{Key: "LLM_REQUESTS_PER_MINUTE", Value: os.Getenv("LLM_REQUESTS_PER_MINUTE"), Err: ErrInvalidConfig}
+	case policy.Burst < 1:
+		return &ConfigError{Key: "LLM_RATE_BURST", Value: os.Getenv("LLM_RATE_BURST"), Err: ErrInvalidConfig}
+	case policy.BreakerFailures < 0:
+		return &ConfigError{Key: "LLM_BREAKER_FAILURES", Value: os.Getenv("LLM_BREAKER_FAILURES"), Err: ErrInvalidConfig}
+	}
+	return nil
+}
+
+// loadVectorStoreConfig reads where the knowledge base is kept and how it is searched.
+func loadVectorStoreConfig(store *adapter.Config) error {
+	var err error
+	store.Store = getEnv("VECTOR_STORE", adapter.StoreSQLite)
+	store.Path = getEnv("VECTOR_DB", "vectors.db")
+	store.Index = getEnv("VECTOR_INDEX", adapter.IndexExact)
+	switch store.Store {
+	case adapter.StoreSQLite, adapter.StoreMemory:
+	default:
+		return &ConfigError{Key: "VECTOR_STORE", Value: store.Store, Err: ErrInvalidConfig}
+	}
+	switch store.Index {
+	case adapter.IndexExact, adapter.IndexHNSW:
+	default:
+		return &ConfigError{Key: "VECTOR_INDEX", Value: store.Index, Err: ErrInvalidConfig}
+	}
+
+	defaults := adapter.DefaultHNSWConfig()
+	store.HNSW.Seed = defaults.Seed
+	if store.HNSW.M, err = getEnvInt("HNSW_M", defaults.M); err != nil {
+		return err
+	}
+	if store.HNSW.EfConstruction, err = getEnvInt("HNSW_EF_CONSTRUCTION", defaults.EfConstruction); err != nil {
+		return err
+	}
+	if store.HNSW.EfSearch, err = getEnvInt("HNSW_EF_SEARCH", defaults.EfSearch); err != nil {
+		return err
+	}
+	switch {
+	case store.HNSW.M < 2:
+		return &ConfigError{Key: "HNSW_M", Value: os.Getenv("HNSW_M"), Err: ErrInvalidConfig}
+	case store.HNSW.EfConstruction < 1:
+		return &ConfigError{Key: "HNSW_EF_CONSTRUCTION", Value: os.Getenv("HNSW_EF_CONSTRUCTION"), Err: ErrInvalidConfig}
+	case store.HNSW.EfSearch < 1:
+		return &ConfigError{Key: "HNSW_EF_SEARCH", Value: os.Getenv("HNSW_EF_SEARCH"), Err: ErrInvalidConfig}
+	}
+	return nil
+}
+
+// loadRetrievalConfig reads how RAG queries are answered from the knowledge base.
+func loadRetrievalConfig(cfg *Config) error {
+	var err error
+	if cfg.RAGTopK, err = getEnvInt("RAG_TOP_K", 3); err != nil {
+		return err
+	}
+	if cfg.RAGTopK < 1 {
+		return &ConfigError{Key: "RAG_TOP_K", Value: os.Getenv("RAG_TOP_K"), Err: ErrInvalidConfig}
+	}
+	if cfg.RAGMinScore, err = getEnvFloat("RAG_MIN_SCORE", 0); err != nil {
+		return err
+	}
+	if cfg.RAGMinScore < -1 || cfg.RAGMinScore > 1 {
+		return &ConfigError{Key: "RAG_MIN_SCORE", Value: os.Getenv("RAG_MIN_SCORE"), Err: ErrInvalidConfig}
+	}
+	if cfg.RAGMode, err = rag.ParseRetrievalMode(os.Getenv("RAG_MODE")); err != nil {
+		return &ConfigError{Key: "RAG_MODE", Value: os.Getenv("RAG_MODE"), Err: ErrInvalidConfig}
+	}
+
+	defaults := rag.DefaultFusion()
+	fusion := &cfg.RAGFusion
+	if fusion.VectorWeight, err = getEnvFloat("RAG_VECTOR_WEIGHT", defaults.VectorWeight); err != nil {
+		return err
+	}
+	if fusion.KeywordWeight, err = getEnvFloat("RAG_KEYWORD_WEIGHT", defaults.KeywordWeight); err != nil {
+		return err
+	}
+	if fusion.K, err = getEnvInt("RAG_RRF_K", defaults.K); err != nil {
+		return err
+	}
+	if fusion.VectorWeight < 0 {
+		return &ConfigError{Key: "RAG_VECTOR_WEIGHT", Value: os.Getenv("RAG_VECTOR_WEIGHT"), Err: ErrInvalidConfig}
+	}
+	if fusion.KeywordWeight < 0 {
+		return &ConfigError{Key: "RAG_KEYWORD_WEIGHT", Value: os.Getenv("RAG_KEYWORD_WEIGHT"), Err: ErrInvalidConfig}
+	}
+	if fusion.K < 1 {
+		return &ConfigError{Key: "RAG_RRF_K", Value: os.Getenv("RAG_RRF_K"), Err: ErrInvalidConfig}
+	}
+	return nil
+}
+
+// loadChunkingConfig reads how documents are split before they are indexed.
+// CHUNKERS overrides the chunker of some document types, e.g.
+// "text_file=fixed,html_file=sentence".
+func loadChunkingConfig(cfg *Config) error {
+	var err error
+	if cfg.ChunkLimits.MaxTokens, err = getEnvInt("CHUNK_MAX_TOKENS", rag.DefaultChunkTokens); err != nil {
+		return err
+	}
+	if cfg.ChunkLimits.MaxTokens < 16 {
+		return &ConfigError{Key: "CHUNK_MAX_TOKENS", Value: os.Getenv("CHUNK_MAX_TOKENS"), Err: ErrInvalidConfig}
+	}
+	if cfg.ChunkLimits.OverlapTokens, err = getEnvInt("CHUNK_OVERLAP_TOKENS", rag.DefaultOverlapTokens); err != nil {
+		return err
+	}
+	if cfg.ChunkLimits.OverlapTokens < 0 || cfg.ChunkLimits.OverlapTokens >= cfg.ChunkLimits.MaxTokens/2 {
+		return &ConfigError{Key: "CHUNK_OVERLAP_TOKENS", Value: os.Getenv("CHUNK_OVERLAP_TOKENS"), Err: ErrInvalidConfig}
+	}
+
+	cfg.Chunkers = rag.DefaultChunkerNames()
+	spec := os.Getenv("CHUNKERS")
+	for _, pair := range strings.Split(spec, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		docType, name, ok := strings.Cut(pair, "=")
+		docType, name = strings.TrimSpace(docType), strings.TrimSpace(name)
+		if !ok || !slices.Contains(rag.DocumentTypes(), docType) {
+			return &ConfigError{Key: "CHUNKERS", Value: spec, Err: ErrInvalidConfig}
+		}
+		if _, err := rag.NewChunker(name, cfg.ChunkLimits); err != nil {
+			return &ConfigError{Key: "CHUNKERS", Value: spec, Err: ErrInvalidConfig}
+		}
+		cfg.Chunkers[docType] = name
+	}
+	return nil
+}
+
+// loadRuntimeConfig reads the settings that control how workflow runs are executed.
+func loadRuntimeConfig(cfg *Config) error {
+	var err error
+	cfg.Checkpoint = CheckpointConfig{
+		Store: os.Getenv("CHECKPOINT_STORE"),
+		Path:  os.Getenv("CHECKPOINT_PATH"),
+	}
+	switch cfg.Checkpoint.Store {
+	case "", "file", "sqlite":
+	default:
+		return &ConfigError{Key: "CHECKPOINT_STORE", Value: cfg.Checkpoint.Store, Err: ErrInvalidConfig}
+	}
+	if cfg.Checkpoint.Retention, err = getEnvDuration("CHECKPOINT_RETENTION", 7*24*time.Hour); err != nil {
+		return err
+	}
+	if cfg.Checkpoint.Retention < 0 {
+		return &ConfigError{Key: "CHECKPOINT_RETENTION", Value: os.Getenv("CHECKPOINT_RETENTION"), Err: ErrInvalidConfig}
+	}
+
+	if err := loadVectorStoreConfig(&cfg.VectorStore); err != nil {
+		return err
+	}
+	if err := loadRetrievalConfig(cfg); err != nil {
+		return err
+	}
+	if err := loadChunkingConfig(cfg); err != nil {
+		return err
+	}
+
+	// Live mailboxes always keep a ledger so no customer is answered twice; the
+	// in-memory mailbox is reseeded every run, so there it is opt-in.
+	defaultLedger := "ledger.db"
+	if cfg.MailProvider == "memory" {
+		defaultLedger = ""
+	}
+	cfg.Ledger.Path = getEnv("LEDGER_PATH", defaultLedger)
+	if cfg.Ledger.MaxAttempts, err = getEnvInt("LEDGER_MAX_ATTEMPTS", 3); err != nil {
+		return err
+	}
+
+	cfg.Categories = os.Getenv("CATEGORIES_FILE")
+	if cfg.Threshold, err = getEnvFloat("CATEGORY_THRESHOLD", 0); err != nil {
+		return err
+	}
+	if cfg.Threshold < 0 || cfg.Threshold > 1 {
+		return &ConfigError{Key: "CATEGORY_THRESHOLD", Value: os.Getenv("CATEGORY_THRESHOLD"), Err: ErrInvalidConfig}
+	}
+	cfg.ReplyMode = getEnv("REPLY_MODE", "draft")
+	switch cfg.ReplyMode {
+	case "draft", "send", "approval", "forward", "ignore":
+	default:
+		return &ConfigError{Key: "REPLY_MODE", Value: cfg.ReplyMode, Err: ErrInvalidConfig}
+	}
+	cfg.ReplyPolicy = os.Getenv("REPLY_POLICY")
+	cfg.ForwardTo = os.Getenv("FORWARD_TO")
+	cfg.ReviewDB = getEnv("REVIEW_DB", "reviews.db")
+
+	if cfg.Workers, err = getEnvInt("WORKERS", 4); err != nil {
+		return err
+	}
+	if cfg.Workers < 1 {
+		return &ConfigError{Key: "WORKERS", Value: strconv.Itoa(cfg.Workers), Err: ErrInvalidConfig}
+	}
+	if cfg.DrainTimeout, err = getEnvDuration("DRAIN_TIMEOUT", 2*time.Minute); err != nil {
+		return err
+	}
+	if cfg.PollInterval, err = getEnvDuration("POLL_INTERVAL", 0); err != nil {
+		return err
+	}
+	return nil
+}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, &ConfigError{Key: key, Value: value, Err: ErrInvalidConfig}
+	}
+	return n, nil
+}
+
+func getEnvBool(key string, fallback bool) (bool, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, &ConfigError{Key: key, Value: value, Err: ErrInvalidConfig}
+	}
+	return b, nil
+}
+
+func getEnvFloat(key string, fallback float64) (float64, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, &ConfigError{Key: key, Value: value, Err: ErrInvalidConfig}
+	}
+	return f, nil
+}
+
+func getEnvDuration(key string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, &ConfigError{Key: key, Value: value, Err: ErrInvalidConfig}
+	}
+	return d, nil
+}
+
 type ConfigError struct {
 	Key   string
 	Value string
@@ -52,3 +517,5 @@ func (e *ConfigError) Error() string {
 }
 
 var ErrMissingConfig = os.ErrNotExist
+
+var ErrInvalidConfig = os.ErrInvalid
@@ -21,7 +21,7 @@ func NewEndpoints(s DataUploadService) Endpoints {
 func MakeUploadFileEndpoint(s DataUploadService) func(ctx context.Context, request interface{}) (response interface{}, err error) {
 	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
 		req := request.(UploadFileRequest)
-		msg, err := s.UploadFile(req.File, req.FileHeader)
+		msg, err := s.UploadFile(req.File, req.FileHeader, req.Tags)
 		return UploadFileResponse{Message: msg, Err: err}, nil
 	}
 }
@@ -39,6 +39,7 @@ func MakeListFilesEndpoint(s DataUploadService) func(ctx context.Context, reques
 type UploadFileRequest struct {
 	File       multipart.File
 	FileHeader *multipart.FileHeader
+	Tags       map[string]string
 }
 
 type UploadFileResponse struct {
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"net/http"
 
+	"mailflow/internals/rag"
+
 	"github.com/gorilla/mux"
 )
 
@@ -30,9 +32,20 @@ func decodeUploadFileRequest(endpoint func(ctx context.Context, request interfac
 			return
 		}
 
+		// Optional tags, e.g. "topic=billing,audience=customers", label every
+		// chunk of the file so RAG searches can be restricted to it.
+		tags, err := rag.ParseTags(r.FormValue("tags"))
+		if err != nil {
+			fmt.Println("Error parsing tags:", err)
+			file.Close()
+			encodeErrorResponse(context.Background(), err, w)
+			return
+		}
+
 		req := UploadFileRequest{
 			File:       file,
 			FileHeader: header,
+			Tags:       tags,
 		}
 
 		resp, err := endpoint(context.Background(), req)
@@ -15,7 +15,9 @@ import (
 )
 
 type DataUploadService interface {
-	UploadFile(file multipart.File, header *multipart.FileHeader) (string, error)
+	// UploadFile saves a file and indexes it into the knowledge base with tags,
+	// which RAG searches can be filtered on.
+	UploadFile(file multipart.File, header *multipart.FileHeader, tags map[string]string) (string, error)
 	ListFiles() ([]FileInfo, error)
 }
 
@@ -31,7 +33,7 @@ func NewDataUploadService(ragSystem *rag.RAGSystem) DataUploadService {
 	}
 }
 
-func (s *dataUploadService) UploadFile(file multipart.File, header *multipart.FileHeader) (string, error) {
+func (s *dataUploadService) UploadFile(file multipart.File, header *multipart.FileHeader, tags map[string]string) (string, error) {
 	defer file.Close()
 
 	uploadDir := "./uploads"
@@ -59,12 +61,14 @@ func (s *dataUploadService) UploadFile(file multipart.File, header *multipart.Fi
 		return fmt.Sprintf("File '%s' uploaded successfully to %s (empty content, not indexed)", header.Filename, filePath), nil
 	}
 
-	docID := fmt.Sprintf("uploaded-file-%s-%d", header.Filename, time.Now().UnixNano())
+	// Re-uploading a file replaces its chunks in the knowledge base.
+	docID := "uploaded-file-" + header.Filename
 	doc := rag.Document{
 		ID:        docID,
 		Source:    header.Filename,
 		Content:   fileContent,
 		CreatedAt: time.Now(),
+		Tags:      tags,
 	}
 
 	logging.Info("Attempting to index uploaded file '%s' into RAG system...", header.Filename)
@@ -0,0 +1,59 @@
+package backend
+
+import (
+	"fmt"
+
+	"mailflow/internals/config"
+	"mailflow/internals/email"
+	"mailflow/internals/email/gmail"
+	"mailflow/internals/email/imap"
+	"mailflow/internals/email/memory"
+)
+
+// New builds the email.Service selected by cfg.MailProvider.
+func New(cfg *config.Config) (email.Service, error) {
+	switch cfg.MailProvider {
+	case "", "gmail":
+		svc, err := gmail.NewGmailUtils(gmail.Options{Lookback: cfg.MailLookback, HistoryFile: cfg.Gmail.HistoryFile})
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize Gmail backend: %w", err)
+		}
+		return svc, nil
+	case "imap":
+		svc, err := imap.New(imap.Config{
+			Host:               cfg.IMAP.Host,
+			Port:               cfg.IMAP.Port,
+			Username:           cfg.IMAP.Username,
+			Password:           cfg.IMAP.Password,
+			TLS:                imap.TLSMode(cfg.IMAP.TLS),
+			InsecureSkipVerify: cfg.IMAP.InsecureSkipVerify,
+			Mailbox:            cfg.IMAP.Mailbox,
+			DraftsMailbox:      cfg.IMAP.DraftsMailbox,
+			SentMailbox:        cfg.IMAP.SentMailbox,
+			SMTP: imap.SMTPConfig{
+				Host:     cfg.SMTP.Host,
+				Port:     cfg.SMTP.Port,
+				Username: cfg.SMTP.Username,
+				Password: cfg.SMTP.Password,
+				TLS:      imap.TLSMode(cfg.SMTP.TLS),
+				From:     cfg.SMTP.From,
+			},
+			MyEmail:  cfg.MyEmail,
+			Lookback: cfg.MailLookback,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize IMAP backend: %w", err)
+		}
+		return svc, nil
+	case "memory":
+		mailbox := memory.NewMailbox(cfg.MyEmail)
+		if cfg.MailFixtures != "" {
+			if err := mailbox.LoadFixtures(cfg.MailFixtures); err != nil {
+				return nil, fmt.Errorf("failed to seed in-memory mailbox: %w", err)
+			}
+		}
+		return mailbox, nil
+	default:
+		return nil, fmt.Errorf("unknown mail provider %q", cfg.MailProvider)
+	}
+}
@@ -0,0 +1,58 @@
+package email
+
+import (
+	"context"
+	"errors"
+)
+
+// EmailInfo is the provider-neutral representation of an inbound message.
+type EmailInfo struct {
+	ID         string `json:"id"`
+	ThreadID   string `json:"threadId"`
+	MessageID  string `json:"messageId"`
+	References string `json:"references"`
+	Sender     string `json:"sender"`
+	Subject    string `json:"subject"`
+	Body       string `json:"body"`
+}
+
+// DraftInfo identifies a draft reply stored by the mail provider.
+type DraftInfo struct {
+	DraftID   string
+	ThreadID  string
+	MessageID string
+}
+
+// ErrNotFound is returned when a message or draft does not exist in the mailbox.
+var ErrNotFound = errors.New("email: message not found")
+
+// Service is the mail backend used by the workflow. Implementations exist for
+// Gmail (internals/email/gmail) and generic IMAP+SMTP servers (internals/email/imap).
+type Service interface {
+	// FetchUnansweredEmails returns up to maxResults recent emails whose thread
+	// has no draft reply yet. Emails sent by the mailbox owner are skipped.
+	FetchUnansweredEmails(ctx context.Context, maxResults int64) ([]EmailInfo, error)
+
+	// GetEmailInfo loads a single message by its provider ID.
+	GetEmailInfo(ctx context.Context, msgID string) (EmailInfo, error)
+
+	// CreateDraftReply stores replyText as a draft reply to initialEmail.
+	CreateDraftReply(ctx context.Context, initialEmail EmailInfo, replyText string) (*DraftInfo, error)
+
+	// SendReply sends replyText as a reply to initialEmail and returns the ID of the sent message.
+	SendReply(ctx context.Context, initialEmail EmailInfo, replyText string) (string, error)
+
+	// ForwardEmail forwards initialEmail to the address to, with note above the
+	// original message, and returns the ID of the sent message.
+	ForwardEmail(ctx context.Context, initialEmail EmailInfo, to, note string) (string, error)
+
+	// FetchDraftReplies lists the drafts currently stored in the mailbox.
+	FetchDraftReplies(ctx context.Context) ([]DraftInfo, error)
+}
+
+// NewEmailFetcher is implemented by services that can list only the emails
+// that arrived since the previous call (e.g. Gmail history), instead of
+// rescanning a time window. Pollers prefer it over FetchUnansweredEmails.
+type NewEmailFetcher interface {
+	FetchNewEmails(ctx context.Context, maxResults int64) ([]EmailInfo, error)
+}
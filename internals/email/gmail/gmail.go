@@ -6,17 +6,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"mime/multipart"
-	"mime/quotedprintable"
-	"net/mail"
-	"net/textproto"
+	"mailflow/internals/email"
 	"os"
-	"regexp"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/PuerkitoBio/goquery"
-	"github.com/google/uuid"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/gmail/v1"
@@ -32,28 +27,29 @@ var (
 	SCOPES = []string{gmail.GmailModifyScope}
 )
 
-type EmailInfo struct {
-	ID         string `json:"id"`
-	ThreadID   string `json:"threadId"`
-	MessageID  string `json:"messageId"`
-	References string `json:"references"`
-	Sender     string `json:"sender"`
-	Subject    string `json:"subject"`
-	Body       string `json:"body"`
-}
-
-type DraftInfo struct {
-	DraftID   string
-	ThreadID  string
-	MessageID string
+// Options tunes how GmailUtils discovers new mail.
+type Options struct {
+	Lookback    time.Duration // Window searched by FetchUnansweredEmails, 8h when zero
+	HistoryFile string        // Persists the history cursor used by FetchNewEmails; empty keeps it in memory only
 }
 
 type GmailUtils struct {
-	service *gmail.Service
-	myEmail string // Stores the user's own email address for skipping self-sent emails.
+	service  *gmail.Service
+	myEmail  string // Stores the user's own email address for skipping self-sent emails.
+	lookback time.Duration
+
+	historyMu   sync.Mutex
+	historyFile string
+	historyID   uint64 // Cursor for history.list, 0 until the first FetchNewEmails
+	caughtUp    bool   // Whether this process already ran its startup catch-up
 }
 
-func NewGmailUtils() (*GmailUtils, error) {
+var (
+	_ email.Service         = (*GmailUtils)(nil)
+	_ email.NewEmailFetcher = (*GmailUtils)(nil)
+)
+
+func NewGmailUtils(opts Options) (*GmailUtils, error) {
 	ctx := context.Background()
 
 	b, err := os.ReadFile(credentialsFile)
@@ -83,7 +79,10 @@ func NewGmailUtils() (*GmailUtils, error) {
 		log.Println("WARNING: MY_EMAIL environment variable not set. Self-sent emails may not be skipped.")
 	}
 
-	return &GmailUtils{service: srv, myEmail: myEmail}, nil
+	if opts.Lookback <= 0 {
+		opts.Lookback = 8 * time.Hour
+	}
+	return &GmailUtils{service: srv, myEmail: myEmail, lookback: opts.Lookback, historyFile: opts.HistoryFile}, nil
 }
 
 func getToken(config *oauth2.Config) (*oauth2.Token, error) {
@@ -134,19 +133,25 @@ func saveToken(file string, token *oauth2.Token) {
 
 // FetchUnansweredEmails fetches all emails included in unanswered threads.
 // An "unanswered" thread is one that doesn't have a draft reply yet.
-func (gut *GmailUtils) FetchUnansweredEmails(maxResults int64) ([]EmailInfo, error) {
+func (gut *GmailUtils) FetchUnansweredEmails(ctx context.Context, maxResults int64) ([]email.EmailInfo, error) {
 	log.Printf("Fetching unanswered emails (maxResults: %d)...", maxResults)
 
-	recentEmails, err := gut.FetchRecentEmails(maxResults)
+	recentEmails, err := gut.FetchRecentEmails(ctx, maxResults)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch recent emails: %w", err)
 	}
 	if len(recentEmails) == 0 {
 		log.Println("No recent emails found.")
-		return []EmailInfo{}, nil
+		return []email.EmailInfo{}, nil
 	}
 
-	drafts, err := gut.FetchDraftReplies()
+	return gut.unansweredFromMessages(ctx, recentEmails)
+}
+
+// unansweredFromMessages resolves messages to EmailInfo, keeping one email per
+// thread and dropping threads that already have a draft and self-sent mail.
+func (gut *GmailUtils) unansweredFromMessages(ctx context.Context, messages []*gmail.Message) ([]email.EmailInfo, error) {
+	drafts, err := gut.FetchDraftReplies(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch draft replies: %w", err)
 	}
@@ -158,15 +163,15 @@ func (gut *GmailUtils) FetchUnansweredEmails(maxResults int64) ([]EmailInfo, err
 	log.Printf("Found %d threads with existing drafts.", len(threadsWithDrafts))
 
 	seenThreads := make(map[string]bool)
-	var unansweredEmails []EmailInfo
-	for _, email := range recentEmails {
-		threadID := email.ThreadId
+	var unansweredEmails []email.EmailInfo
+	for _, message := range messages {
+		threadID := message.ThreadId
 		// Check if the thread has already been processed or has a draft
 		if !seenThreads[threadID] && !threadsWithDrafts[threadID] {
 			seenThreads[threadID] = true // Mark thread as seen to avoid duplicates
-			emailInfo, err := gut.GetEmailInfo(email.Id)
+			emailInfo, err := gut.GetEmailInfo(ctx, message.Id)
 			if err != nil {
-				log.Printf("Error getting email info for ID %s: %v", email.Id, err)
+				log.Printf("Error getting email info for ID %s: %v", message.Id, err)
 				continue
 			}
 
@@ -181,11 +186,11 @@ func (gut *GmailUtils) FetchUnansweredEmails(maxResults int64) ([]EmailInfo, err
 	return unansweredEmails, nil
 }
 
-func (gut *GmailUtils) FetchRecentEmails(maxResults int64) ([]*gmail.Message, error) {
+func (gut *GmailUtils) FetchRecentEmails(ctx context.Context, maxResults int64) ([]*gmail.Message, error) {
 	log.Printf("Fetching recent emails (maxResults: %d)...", maxResults)
 	now := time.Now()
 
-	delay := now.Add(-8 * time.Hour)
+	delay := now.Add(-gut.lookback)
 
 	afterTimestamp := delay.Unix()
 	beforeTimestamp := now.Unix()
@@ -193,7 +198,7 @@ func (gut *GmailUtils) FetchRecentEmails(maxResults int64) ([]*gmail.Message, er
 	query := fmt.Sprintf("after:%d before:%d", afterTimestamp, beforeTimestamp)
 	log.Printf("Gmail query: %s", query)
 
-	results, err := gut.service.Users.Messages.List("me").Q(query).MaxResults(maxResults).Do()
+	results, err := gut.service.Users.Messages.List("me").Q(query).MaxResults(maxResults).Context(ctx).Do()
 	if err != nil {
 		return nil, fmt.Errorf("unable to retrieve messages: %w", err)
 	}
@@ -207,18 +212,18 @@ func (gut *GmailUtils) FetchRecentEmails(maxResults int64) ([]*gmail.Message, er
 	return messages, nil
 }
 
-func (gut *GmailUtils) FetchDraftReplies() ([]DraftInfo, error) {
+func (gut *GmailUtils) FetchDraftReplies(ctx context.Context) ([]email.DraftInfo, error) {
 	log.Println("Fetching draft replies...")
-	draftsResponse, err := gut.service.Users.Drafts.List("me").Do()
+	draftsResponse, err := gut.service.Users.Drafts.List("me").Context(ctx).Do()
 	if err != nil {
 		return nil, fmt.Errorf("unable to retrieve drafts: %w", err)
 	}
 
-	var draftList []DraftInfo
+	var draftList []email.DraftInfo
 	if draftsResponse.Drafts != nil {
 		for _, draft := range draftsResponse.Drafts {
 			if draft.Message != nil {
-				draftList = append(draftList, DraftInfo{
+				draftList = append(draftList, email.DraftInfo{
 					DraftID:   draft.Id,
 					ThreadID:  draft.Message.ThreadId,
 					MessageID: draft.Message.Id,
@@ -230,7 +235,7 @@ func (gut *GmailUtils) FetchDraftReplies() ([]DraftInfo, error) {
 	return draftList, nil
 }
 
-func (gut *GmailUtils) CreateDraftReply(initialEmail EmailInfo, replyText string) (*gmail.Draft, error) {
+func (gut *GmailUtils) CreateDraftReply(ctx context.Context, initialEmail email.EmailInfo, replyText string) (*email.DraftInfo, error) {
 	log.Printf("Creating draft reply for email ID: %s", initialEmail.ID)
 
 	message, err := gut.createReplyMessage(initialEmail, replyText, false)
@@ -238,81 +243,87 @@ func (gut *GmailUtils) CreateDraftReply(initialEmail EmailInfo, replyText string
 		return nil, fmt.Errorf("failed to create reply message: %w", err)
 	}
 
-	draft, err := gut.service.Users.Drafts.Create("me", &gmail.Draft{Message: message}).Do()
+	draft, err := gut.service.Users.Drafts.Create("me", &gmail.Draft{Message: message}).Context(ctx).Do()
 	if err != nil {
 		return nil, fmt.Errorf("unable to create draft: %w", err)
 	}
 	log.Printf("Draft created with ID: %s", draft.Id)
-	return draft, nil
+
+	info := &email.DraftInfo{DraftID: draft.Id, ThreadID: initialEmail.ThreadID}
+	if draft.Message != nil {
+		info.ThreadID = draft.Message.ThreadId
+		info.MessageID = draft.Message.Id
+	}
+	return info, nil
 }
 
-func (gut *GmailUtils) SendReply(initialEmail EmailInfo, replyText string) (*gmail.Message, error) {
+func (gut *GmailUtils) SendReply(ctx context.Context, initialEmail email.EmailInfo, replyText string) (string, error) {
 	log.Printf("Sending reply for email ID: %s", initialEmail.ID)
 
 	message, err := gut.createReplyMessage(initialEmail, replyText, true) // true for sending
 	if err != nil {
-		return nil, fmt.Errorf("failed to create reply message: %w", err)
+		return "", fmt.Errorf("failed to create reply message: %w", err)
 	}
 
-	sentMessage, err := gut.service.Users.Messages.Send("me", message).Do()
+	sentMessage, err := gut.service.Users.Messages.Send("me", message).Context(ctx).Do()
 	if err != nil {
-		return nil, fmt.Errorf("unable to send message: %w", err)
+		return "", fmt.Errorf("unable to send message: %w", err)
 	}
 	log.Printf("Reply sent with message ID: %s", sentMessage.Id)
-	return sentMessage, nil
+	return sentMessage.Id, nil
 }
 
-func (gut *GmailUtils) createReplyMessage(email EmailInfo, replyText string, send bool) (*gmail.Message, error) {
+func (gut *GmailUtils) ForwardEmail(ctx context.Context, initialEmail email.EmailInfo, to, note string) (string, error) {
+	log.Printf("Forwarding email ID %s to %s", initialEmail.ID, to)
 
-	msg, bodyContent, err := gut.createHTMLEmailMessage(email.Sender, email.Subject, replyText)
+	rawEmail, err := email.ComposeForward(initialEmail, to, note, email.ReplyOptions{MessageID: email.NewMessageID("gmail.com")})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create HTML email message: %w", err)
+		return "", fmt.Errorf("failed to create forward message: %w", err)
 	}
 
-	if email.MessageID != "" {
-		msg.Header["In-Reply-To"] = []string{email.MessageID}
-		references := strings.TrimSpace(fmt.Sprintf("%s %s", email.References, email.MessageID))
-		msg.Header["References"] = []string{references}
-
-		if send {
-			msg.Header["Message-ID"] = []string{fmt.Sprintf("<%s@gmail.com>", uuid.New().String())}
-		}
+	message := &gmail.Message{Raw: base64.URLEncoding.EncodeToString(rawEmail)}
+	sentMessage, err := gut.service.Users.Messages.Send("me", message).Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("unable to forward message: %w", err)
 	}
+	log.Printf("Email forwarded with message ID: %s", sentMessage.Id)
+	return sentMessage.Id, nil
+}
 
-	var rawEmail strings.Builder
-	for k, v := range msg.Header {
-		for _, s := range v {
-			rawEmail.WriteString(fmt.Sprintf("%s: %s\r\n", k, s))
-		}
+func (gut *GmailUtils) createReplyMessage(initialEmail email.EmailInfo, replyText string, send bool) (*gmail.Message, error) {
+	var opts email.ReplyOptions
+	if send {
+		opts.MessageID = email.NewMessageID("gmail.com")
 	}
-	rawEmail.WriteString("\r\n")
-	rawEmail.WriteString(bodyContent)
 
-	raw := base64.URLEncoding.EncodeToString([]byte(rawEmail.String()))
+	rawEmail, err := email.ComposeReply(initialEmail, replyText, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTML email message: %w", err)
+	}
 
 	return &gmail.Message{
-		Raw:      raw,
-		ThreadId: email.ThreadID,
+		Raw:      base64.URLEncoding.EncodeToString(rawEmail),
+		ThreadId: initialEmail.ThreadID,
 	}, nil
 }
 
-func (gut *GmailUtils) ShouldSkipEmail(emailInfo EmailInfo) bool {
+func (gut *GmailUtils) ShouldSkipEmail(emailInfo email.EmailInfo) bool {
 	if gut.myEmail == "" {
 		return false
 	}
 	return strings.Contains(emailInfo.Sender, gut.myEmail)
 }
 
-func (gut *GmailUtils) GetEmailInfo(msgID string) (EmailInfo, error) {
+func (gut *GmailUtils) GetEmailInfo(ctx context.Context, msgID string) (email.EmailInfo, error) {
 	log.Printf("Getting email info for message ID: %s", msgID)
-	message, err := gut.service.Users.Messages.Get("me", msgID).Format("full").Do()
+	message, err := gut.service.Users.Messages.Get("me", msgID).Format("full").Context(ctx).Do()
 	if err != nil {
-		return EmailInfo{}, fmt.Errorf("unable to retrieve message %s: %w", msgID, err)
+		return email.EmailInfo{}, fmt.Errorf("unable to retrieve message %s: %w", msgID, err)
 	}
 
 	payload := message.Payload
 	if payload == nil {
-		return EmailInfo{}, fmt.Errorf("message payload is nil for ID %s", msgID)
+		return email.EmailInfo{}, fmt.Errorf("message payload is nil for ID %s", msgID)
 	}
 
 	headers := make(map[string]string)
@@ -326,7 +337,7 @@ func (gut *GmailUtils) GetEmailInfo(msgID string) (EmailInfo, error) {
 		body = ""
 	}
 
-	return EmailInfo{
+	return email.EmailInfo{
 		ID:         msgID,
 		ThreadID:   message.ThreadId,
 		MessageID:  headers["message-id"],
@@ -361,7 +372,7 @@ func (gut *GmailUtils) GetEmailBody(payload *gmail.MessagePart) (string, error)
 			}
 			if mimeType == "text/html" {
 				htmlContent := decodeData(data)
-				return gut.extractMainContentFromHTML(htmlContent)
+				return email.ExtractMainContentFromHTML(htmlContent)
 			}
 			if len(part.Parts) > 0 {
 				result := extractBody(part.Parts)
@@ -380,75 +391,9 @@ func (gut *GmailUtils) GetEmailBody(payload *gmail.MessagePart) (string, error)
 		data := payload.Body.Data
 		body = decodeData(data)
 		if payload.MimeType == "text/html" {
-			body = gut.extractMainContentFromHTML(body)
+			body = email.ExtractMainContentFromHTML(body)
 		}
 	}
 
-	return gut.cleanBodyText(body), nil
-}
-
-func (gut *GmailUtils) extractMainContentFromHTML(htmlContent string) string {
-	doc, err := goquery.NewDocumentFromReader(strings.NewReader(htmlContent))
-	if err != nil {
-		log.Printf("Error parsing HTML content: %v", err)
-		return htmlContent // Return original content if parsing fails
-	}
-	doc.Find("script, style, head, meta, title").Remove()
-
-	return strings.TrimSpace(doc.Text())
-}
-
-func (gut *GmailUtils) cleanBodyText(text string) string {
-
-	reSpaces := regexp.MustCompile(`\s+`)
-	cleanedText := reSpaces.ReplaceAllString(text, " ")
-	return strings.TrimSpace(cleanedText)
-}
-
-func (gut *GmailUtils) createHTMLEmailMessage(recipient, subject, replyText string) (*mail.Message, string, error) {
-	msg := &mail.Message{
-		Header: make(mail.Header),
-	}
-	msg.Header["To"] = []string{recipient}
-	if !strings.HasPrefix(subject, "Re: ") {
-		msg.Header["Subject"] = []string{"Re: " + subject}
-	} else {
-		msg.Header["Subject"] = []string{subject}
-	}
-	msg.Header["MIME-Version"] = []string{"1.0"}
-
-	var b strings.Builder
-	mw := multipart.NewWriter(&b)
-	boundary := mw.Boundary()
-	msg.Header["Content-Type"] = []string{fmt.Sprintf(`multipart/alternative; boundary="%s"`, boundary)}
-
-	htmlPartHeader := make(textproto.MIMEHeader)
-	htmlPartHeader.Set("Content-Type", "text/html; charset=UTF-8")
-	htmlPartHeader.Set("Content-Transfer-Encoding", "quoted-printable")
-	htmlPart, err := mw.CreatePart(htmlPartHeader)
-	if err != nil {
-		return nil, "", fmt.Errorf("failed to create HTML part: %w", err)
-	}
-
-	htmlText := strings.ReplaceAll(replyText, "\n", "<br>")
-	htmlContent := fmt.Sprintf(`
-		<!DOCTYPE html>
-		<html>
-		<head>
-			<meta charset="utf-8">
-			<meta name="viewport" content="width=device-width, initial-scale=1.0">
-		</head>
-		<body>%s</body>
-		</html>
-		`, htmlText)
-	qpWriter := quotedprintable.NewWriter(htmlPart)
-	_, err = qpWriter.Write([]byte(htmlContent))
-	if err != nil {
-		return nil, "", fmt.Errorf("failed to write HTML content to quoted-printable writer: %w", err)
-	}
-	qpWriter.Close()
-
-	mw.Close()
-
-	return msg, b.String(), nil
+	return email.CleanBodyText(body), nil
 }
@@ -0,0 +1,336 @@
+package gmail
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"mailflow/internals/email"
+
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/idtoken"
+)
+
+// historyState is the on-disk form of the history cursor.
+type historyState struct {
+	HistoryID uint64    `json:"history_id"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// historyPageSize is how many history records one history.list page holds.
+const historyPageSize = 100
+
+// FetchNewEmails returns up to maxResults unanswered emails added to the inbox
+// since the previous call, paging through history.list from a persisted
+// historyId. The cursor moves forward page by page, and stops after the last
+// record used once maxResults emails are found, so the rest is listed by the
+// next call rather than again from the start.
+//
+// The first call of a process also runs FetchUnansweredEmails, so emails that
+// were fetched but not finished before a restart are picked up again; the
+// cursor then covers any downtime longer than the lookback window. When Gmail
+// no longer has history for the stored cursor, a full fetch is done instead.
+func (gut *GmailUtils) FetchNewEmails(ctx context.Context, maxResults int64) ([]email.EmailInfo, error) {
+	gut.historyMu.Lock()
+	defer gut.historyMu.Unlock()
+
+	if gut.historyID == 0 {
+		if err := gut.loadHistoryID(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Taken before listing, so messages arriving meanwhile are seen by the next call.
+	profile, err := gut.service.Users.GetProfile("me").Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve Gmail profile: %w", err)
+	}
+
+	var emails []email.EmailInfo
+	fullFetch := !gut.caughtUp || gut.historyID == 0
+	if fullFetch {
+		if emails, err = gut.FetchUnansweredEmails(ctx, maxResults); err != nil {
+			return nil, err
+		}
+	}
+	if gut.historyID == 0 {
+		// No history to page through yet, start the cursor at the present.
+		if err := gut.saveHistoryID(profile.HistoryId); err != nil {
+			return nil, err
+		}
+		gut.caughtUp = true
+		return emails, nil
+	}
+
+	if int64(len(emails)) < maxResults {
+		added, err := gut.fetchHistory(ctx, profile.HistoryId, maxResults-int64(len(emails)))
+		switch {
+		case isHistoryExpired(err):
+			log.Printf("Gmail history %d is no longer available, falling back to a full fetch.", gut.historyID)
+			if !fullFetch {
+				if emails, err = gut.FetchUnansweredEmails(ctx, maxResults); err != nil {
+					return nil, err
+				}
+			}
+			if err := gut.saveHistoryID(profile.HistoryId); err != nil {
+				return nil, err
+			}
+		case err != nil && len(emails)+len(added) == 0:
+			return nil, err
+		case err != nil:
+			// The cursor already moved past the pages these came from.
+			log.Printf("Gmail history listing stopped early: %v", err)
+		}
+		emails = mergeEmails(emails, added)
+	}
+	gut.caughtUp = true
+	return emails, nil
+}
+
+// fetchHistory returns up to limit unanswered emails added to the inbox after
+// the cursor, saving the cursor after every page. Once limit is reached it
+// stops after the current history record; when history runs out the cursor
+// moves to latest, the historyId of the mailbox before listing began.
+func (gut *GmailUtils) fetchHistory(ctx context.Context, latest uint64, limit int64) ([]email.EmailInfo, error) {
+	startID := gut.historyID
+	var emails []email.EmailInfo
+	seenMessages := make(map[string]bool)
+	seenThreads := make(map[string]bool)
+	pageToken := ""
+	for {
+		call := gut.service.Users.History.List("me").
+			StartHistoryId(startID).
+			HistoryTypes("messageAdded").
+			LabelId("INBOX").
+			MaxResults(historyPageSize).
+			Context(ctx)
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+		page, err := call.Do()
+		if err != nil {
+			return emails, fmt.Errorf("unable to list Gmail history since %d: %w", startID, err)
+		}
+
+		var messages []*gmail.Message
+		for _, h := range page.History {
+			for _, added := range h.MessagesAdded {
+				if added.Message == nil || seenMessages[added.Message.Id] {
+					continue
+				}
+				seenMessages[added.Message.Id] = true
+				messages = append(messages, added.Message)
+			}
+		}
+		unanswered := make(map[string]email.EmailInfo)
+		if len(messages) > 0 {
+			found, err := gut.unansweredFromMessages(ctx, messages)
+			if err != nil {
+				return emails, err
+			}
+			for _, e := range found {
+				unanswered[e.ID] = e
+			}
+		}
+
+		for _, h := range page.History {
+			for _, added := range h.MessagesAdded {
+				if added.Message == nil {
+					continue
+				}
+				e, ok := unanswered[added.Message.Id]
+				if !ok || seenThreads[e.ThreadID] {
+					continue
+				}
+				seenThreads[e.ThreadID] = true
+				emails = append(emails, e)
+			}
+			if int64(len(emails)) >= limit {
+				log.Printf("Gmail history since %d: stopped at %d after %d new email(s).", startID, h.Id, len(emails))
+				return emails, gut.saveHistoryID(h.Id)
+			}
+		}
+
+		if n := len(page.History); n > 0 {
+			latest = max(latest, page.History[n-1].Id)
+			if page.NextPageToken != "" {
+				if err := gut.saveHistoryID(page.History[n-1].Id); err != nil {
+					return emails, err
+				}
+			}
+		}
+		if page.NextPageToken == "" {
+			break
+		}
+		pageToken = page.NextPageToken
+	}
+	log.Printf("Gmail history since %d: %d new email(s).", startID, len(emails))
+	return emails, gut.saveHistoryID(latest)
+}
+
+// Watch asks Gmail to publish inbox changes to a Cloud Pub/Sub topic
+// ("projects/<project>/topics/<topic>"). Gmail stops publishing at the
+// returned expiration, so the watch must be renewed before then.
+func (gut *GmailUtils) Watch(ctx context.Context, topic string) (time.Time, error) {
+	resp, err := gut.service.Users.Watch("me", &gmail.WatchRequest{
+		TopicName:         topic,
+		LabelIds:          []string{"INBOX"},
+		LabelFilterAction: "include",
+	}).Context(ctx).Do()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unable to watch Gmail inbox: %w", err)
+	}
+	expiration := time.UnixMilli(resp.Expiration)
+	log.Printf("Gmail watch on %s active until %s (historyId %d).", topic, expiration.Format(time.RFC3339), resp.HistoryId)
+	return expiration, nil
+}
+
+// PushAuth configures how PushHandler authenticates Pub/Sub push deliveries.
+// At least one of Token and Audience must be set; when both are, a delivery
+// must pass both checks.
+type PushAuth struct {
+	Token          string // Shared secret the push endpoint URL carries as ?token=
+	Audience       string // Audience of the OIDC token Pub/Sub signs every push with
+	ServiceAccount string // Service account that token must belong to, any when empty
+}
+
+// ErrPushAuthMissing is returned by PushHandler when PushAuth checks nothing.
+var ErrPushAuthMissing = errors.New("gmail: push endpoint needs a token or an OIDC audience")
+
+// validateIDToken verifies a Google-signed ID token, replaced in tests.
+var validateIDToken = idtoken.Validate
+
+// PushHandler receives Pub/Sub push deliveries of Gmail notifications and
+// calls notify for each one that passes auth. The payload only says that
+// history changed, the actual messages are read by the next FetchNewEmails.
+func PushHandler(auth PushAuth, notify func()) (http.Handler, error) {
+	if auth.Token == "" && auth.Audience == "" {
+		return nil, ErrPushAuthMissing
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := auth.verify(r); err != nil {
+			log.Printf("Rejected Gmail push notification from %s: %v", r.RemoteAddr, err)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var push struct {
+			Message struct {
+				Data string `json:"data"`
+			} `json:"message"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&push); err != nil {
+			http.Error(w, "invalid push payload", http.StatusBadRequest)
+			return
+		}
+		var notification struct {
+			EmailAddress string `json:"emailAddress"`
+			HistoryID    uint64 `json:"historyId"`
+		}
+		if data, err := base64.StdEncoding.DecodeString(push.Message.Data); err == nil {
+			_ = json.Unmarshal(data, &notification)
+		}
+		log.Printf("Gmail push notification for %s (historyId %d).", notification.EmailAddress, notification.HistoryID)
+		notify()
+		// Any 2xx acknowledges the delivery, anything else makes Pub/Sub retry.
+		w.WriteHeader(http.StatusNoContent)
+	}), nil
+}
+
+// verify checks the ?token= secret and the bearer OIDC token of a push request.
+func (a PushAuth) verify(r *http.Request) error {
+	if a.Token != "" {
+		token := r.URL.Query().Get("token")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) != 1 {
+			return errors.New("missing or wrong token")
+		}
+	}
+	if a.Audience == "" {
+		return nil
+	}
+	bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || bearer == "" {
+		return errors.New("missing bearer token")
+	}
+	payload, err := validateIDToken(r.Context(), bearer, a.Audience)
+	if err != nil {
+		return fmt.Errorf("invalid OIDC token: %w", err)
+	}
+	if a.ServiceAccount != "" {
+		if email, _ := payload.Claims["email"].(string); email != a.ServiceAccount {
+			return fmt.Errorf("OIDC token belongs to %q, want %q", email, a.ServiceAccount)
+		}
+		if verified, _ := payload.Claims["email_verified"].(bool); !verified {
+			return errors.New("OIDC token email is not verified")
+		}
+	}
+	return nil
+}
+
+func (gut *GmailUtils) loadHistoryID() error {
+	if gut.historyFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(gut.historyFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read Gmail history file %s: %w", gut.historyFile, err)
+	}
+	var state historyState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("failed to decode Gmail history file %s: %w", gut.historyFile, err)
+	}
+	gut.historyID = state.HistoryID
+	return nil
+}
+
+func (gut *GmailUtils) saveHistoryID(id uint64) error {
+	gut.historyID = id
+	if gut.historyFile == "" {
+		return nil
+	}
+	data, err := json.Marshal(historyState{HistoryID: id, UpdatedAt: time.Now().UTC()})
+	if err != nil {
+		return fmt.Errorf("failed to encode Gmail history cursor: %w", err)
+	}
+	tmp := gut.historyFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write Gmail history file %s: %w", gut.historyFile, err)
+	}
+	return os.Rename(tmp, gut.historyFile)
+}
+
+// isHistoryExpired reports whether Gmail rejected a startHistoryId as too old.
+func isHistoryExpired(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
+
+// mergeEmails appends the emails of b whose ID is not already in a.
+func mergeEmails(a, b []email.EmailInfo) []email.EmailInfo {
+	seen := make(map[string]bool, len(a))
+	for _, e := range a {
+		seen[e.ID] = true
+	}
+	for _, e := range b {
+		if !seen[e.ID] {
+			seen[e.ID] = true
+			a = append(a, e)
+		}
+	}
+	return a
+}
@@ -0,0 +1,180 @@
+package gmail
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/idtoken"
+	"google.golang.org/api/option"
+)
+
+// fakeHistory serves the Gmail endpoints FetchNewEmails uses: every message
+// arrives in its own thread and history record, listed two records per page.
+type fakeHistory struct {
+	latest   uint64
+	records  []uint64 // History IDs; record i adds message "m<i+1>"
+	pageSize int
+}
+
+func (f *fakeHistory) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/gmail/v1/users/me/")
+	var resp any
+	switch {
+	case path == "profile":
+		resp = gmail.Profile{HistoryId: f.latest}
+	case path == "drafts":
+		resp = gmail.ListDraftsResponse{}
+	case path == "history":
+		start, _ := strconv.ParseUint(r.URL.Query().Get("startHistoryId"), 10, 64)
+		var after []*gmail.History
+		for i, id := range f.records {
+			if id > start {
+				msg := &gmail.Message{Id: fmt.Sprintf("m%d", i+1), ThreadId: fmt.Sprintf("t%d", i+1)}
+				after = append(after, &gmail.History{Id: id, MessagesAdded: []*gmail.HistoryMessageAdded{{Message: msg}}})
+			}
+		}
+		offset, _ := strconv.Atoi(r.URL.Query().Get("pageToken"))
+		page := &gmail.ListHistoryResponse{HistoryId: f.latest, History: after[offset:min(offset+f.pageSize, len(after))]}
+		if offset+f.pageSize < len(after) {
+			page.NextPageToken = strconv.Itoa(offset + f.pageSize)
+		}
+		resp = page
+	case strings.HasPrefix(path, "messages/"):
+		id := strings.TrimPrefix(path, "messages/")
+		resp = gmail.Message{Id: id, ThreadId: "t" + strings.TrimPrefix(id, "m"), Payload: &gmail.MessagePart{
+			MimeType: "text/plain",
+			Headers:  []*gmail.MessagePartHeader{{Name: "From", Value: "customer@example.com"}, {Name: "Subject", Value: id}},
+			Body:     &gmail.MessagePartBody{Data: base64.URLEncoding.EncodeToString([]byte("Hello"))},
+		}}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func newTestGmail(t *testing.T, handler http.Handler, historyID uint64) *GmailUtils {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	srv, err := gmail.NewService(context.Background(), option.WithEndpoint(server.URL+"/"), option.WithHTTPClient(server.Client()))
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+	return &GmailUtils{
+		service:     srv,
+		myEmail:     "support@example.com",
+		historyFile: filepath.Join(t.TempDir(), "history.json"),
+		historyID:   historyID,
+		caughtUp:    true,
+	}
+}
+
+func emailIDs(t *testing.T, gut *GmailUtils, maxResults int64) []string {
+	t.Helper()
+	emails, err := gut.FetchNewEmails(context.Background(), maxResults)
+	if err != nil {
+		t.Fatalf("FetchNewEmails: %v", err)
+	}
+	ids := make([]string, len(emails))
+	for i, e := range emails {
+		ids[i] = e.ID
+	}
+	return ids
+}
+
+func TestFetchNewEmailsPagesAndMovesCursor(t *testing.T) {
+	fake := &fakeHistory{latest: 500, records: []uint64{101, 102, 103, 104, 105}, pageSize: 2}
+	gut := newTestGmail(t, fake, 100)
+
+	steps := []struct {
+		want   string
+		cursor uint64
+	}{
+		{want: "m1,m2,m3", cursor: 103},
+		{want: "m4,m5", cursor: 500},
+		{want: "", cursor: 500},
+	}
+	for i, step := range steps {
+		if got := strings.Join(emailIDs(t, gut, 3), ","); got != step.want {
+			t.Fatalf("call %d returned %q, want %q", i+1, got, step.want)
+		}
+		if gut.historyID != step.cursor {
+			t.Fatalf("cursor after call %d = %d, want %d", i+1, gut.historyID, step.cursor)
+		}
+	}
+
+	// The cursor survives a restart.
+	restarted := &GmailUtils{historyFile: gut.historyFile}
+	if err := restarted.loadHistoryID(); err != nil {
+		t.Fatalf("loadHistoryID: %v", err)
+	}
+	if restarted.historyID != 500 {
+		t.Fatalf("persisted cursor = %d, want 500", restarted.historyID)
+	}
+}
+
+func TestPushHandlerAuth(t *testing.T) {
+	validateIDToken = func(ctx context.Context, token, audience string) (*idtoken.Payload, error) {
+		if token != "good-jwt" || audience != "https://mailflow.example.com/gmail/push" {
+			return nil, errors.New("invalid token")
+		}
+		return &idtoken.Payload{Claims: map[string]any{"email": "push@project.iam.gserviceaccount.com", "email_verified": true}}, nil
+	}
+	t.Cleanup(func() { validateIDToken = idtoken.Validate })
+
+	tokenAuth := PushAuth{Token: "s3cret"}
+	oidcAuth := PushAuth{Audience: "https://mailflow.example.com/gmail/push", ServiceAccount: "push@project.iam.gserviceaccount.com"}
+	tests := []struct {
+		name   string
+		auth   PushAuth
+		query  string
+		bearer string
+		want   int
+	}{
+		{name: "token", auth: tokenAuth, query: "?token=s3cret", want: http.StatusNoContent},
+		{name: "wrong token", auth: tokenAuth, query: "?token=guess", want: http.StatusUnauthorized},
+		{name: "no token", auth: tokenAuth, want: http.StatusUnauthorized},
+		{name: "oidc", auth: oidcAuth, bearer: "good-jwt", want: http.StatusNoContent},
+		{name: "bad oidc", auth: oidcAuth, bearer: "forged-jwt", want: http.StatusUnauthorized},
+		{name: "no oidc", auth: oidcAuth, want: http.StatusUnauthorized},
+		{name: "other service account", auth: PushAuth{Audience: oidcAuth.Audience, ServiceAccount: "other@example.com"}, bearer: "good-jwt", want: http.StatusUnauthorized},
+		{name: "token and oidc", auth: PushAuth{Token: "s3cret", Audience: oidcAuth.Audience}, query: "?token=s3cret", want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notified := 0
+			handler, err := PushHandler(tt.auth, func() { notified++ })
+			if err != nil {
+				t.Fatalf("PushHandler: %v", err)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/gmail/push"+tt.query, strings.NewReader(`{"message":{"data":""}}`))
+			if tt.bearer != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if wantNotified := tt.want == http.StatusNoContent; (notified == 1) != wantNotified {
+				t.Fatalf("notified %d times, want notification %v", notified, wantNotified)
+			}
+		})
+	}
+
+	if _, err := PushHandler(PushAuth{}, func() {}); !errors.Is(err, ErrPushAuthMissing) {
+		t.Fatalf("PushHandler without auth returned %v, want %v", err, ErrPushAuthMissing)
+	}
+}
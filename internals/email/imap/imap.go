@@ -0,0 +1,540 @@
+package imap
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/mail"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"mailflow/internals/email"
+	"mailflow/pkg/logging"
+
+	goimap "github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+)
+
+// TLSMode selects how the connection to the IMAP or SMTP server is secured.
+type TLSMode string
+
+const (
+	TLSImplicit TLSMode = "tls"      // TLS from the first byte (IMAPS 993, SMTPS 465)
+	TLSStartTLS TLSMode = "starttls" // Plain connection upgraded with STARTTLS (143, 587)
+	TLSNone     TLSMode = "none"     // Plain text, only meant for local test servers
+)
+
+const (
+	defaultMailbox       = "INBOX"
+	defaultDraftsMailbox = "Drafts"
+	defaultLookback      = 8 * time.Hour
+	defaultTimeout       = 30 * time.Second
+)
+
+// SMTPConfig holds the outgoing server settings used by SendReply.
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	TLS      TLSMode
+	From     string // Envelope and header sender, defaults to Username
+}
+
+// Config holds the settings for an IMAP mailbox plus its SMTP relay.
+type Config struct {
+	Host               string
+	Port               int
+	Username           string
+	Password           string
+	TLS                TLSMode
+	InsecureSkipVerify bool // Accept self-signed certificates, e.g. a local Dovecot
+
+	Mailbox       string // Mailbox polled for new emails, defaults to INBOX
+	DraftsMailbox string // Mailbox where draft replies are appended, defaults to Drafts
+	SentMailbox   string // Optional mailbox receiving a copy of sent replies
+
+	SMTP SMTPConfig
+
+	MyEmail  string        // Emails from this address are skipped
+	Lookback time.Duration // How far back FetchUnansweredEmails searches, defaults to 8h
+	Timeout  time.Duration // Network timeout for every command, defaults to 30s
+}
+
+// Client implements email.Service on top of any IMAP4rev1 server (Fastmail,
+// Exchange, Dovecot, ...) and submits replies over SMTP.
+type Client struct {
+	cfg Config
+}
+
+var _ email.Service = (*Client)(nil)
+
+func New(cfg Config) (*Client, error) {
+	if cfg.Host == "" {
+		return nil, fmt.Errorf("IMAP host is not set")
+	}
+	if cfg.TLS == "" {
+		cfg.TLS = TLSImplicit
+	}
+	if cfg.Port == 0 {
+		cfg.Port = 993
+		if cfg.TLS != TLSImplicit {
+			cfg.Port = 143
+		}
+	}
+	if cfg.Mailbox == "" {
+		cfg.Mailbox = defaultMailbox
+	}
+	if cfg.DraftsMailbox == "" {
+		cfg.DraftsMailbox = defaultDraftsMailbox
+	}
+	if cfg.Lookback <= 0 {
+		cfg.Lookback = defaultLookback
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.SMTP.Host == "" {
+		cfg.SMTP.Host = cfg.Host
+	}
+	if cfg.SMTP.TLS == "" {
+		cfg.SMTP.TLS = TLSStartTLS
+	}
+	if cfg.SMTP.Port == 0 {
+		switch cfg.SMTP.TLS {
+		case TLSImplicit:
+			cfg.SMTP.Port = 465
+		case TLSStartTLS:
+			cfg.SMTP.Port = 587
+		default:
+			cfg.SMTP.Port = 25
+		}
+	}
+	if cfg.SMTP.Username == "" {
+		cfg.SMTP.Username = cfg.Username
+		cfg.SMTP.Password = cfg.Password
+	}
+	if cfg.SMTP.From == "" {
+		cfg.SMTP.From = cfg.SMTP.Username
+	}
+	return &Client{cfg: cfg}, nil
+}
+
+// FetchUnansweredEmails returns the newest messages in the polled mailbox that
+// are not flagged \Answered and whose thread has no draft in the drafts mailbox.
+func (c *Client) FetchUnansweredEmails(ctx context.Context, maxResults int64) ([]email.EmailInfo, error) {
+	logging.Info("Fetching unanswered IMAP emails (maxResults: %d)...", maxResults)
+
+	var unansweredEmails []email.EmailInfo
+	err := c.withSession(ctx, func(cl *client.Client) error {
+		drafts, err := c.listDrafts(cl)
+		if err != nil {
+			return err
+		}
+		threadsWithDrafts := make(map[string]bool)
+		for _, draft := range drafts {
+			threadsWithDrafts[draft.ThreadID] = true
+		}
+
+		if _, err := cl.Select(c.cfg.Mailbox, true); err != nil {
+			return fmt.Errorf("unable to select mailbox %s: %w", c.cfg.Mailbox, err)
+		}
+
+		criteria := goimap.NewSearchCriteria()
+		criteria.Since = time.Now().Add(-c.cfg.Lookback)
+		criteria.WithoutFlags = []string{goimap.AnsweredFlag, goimap.DeletedFlag, goimap.DraftFlag}
+		uids, err := cl.UidSearch(criteria)
+		if err != nil {
+			return fmt.Errorf("unable to search mailbox %s: %w", c.cfg.Mailbox, err)
+		}
+		if len(uids) == 0 {
+			return nil
+		}
+
+		// Newest first, like the Gmail messages.list ordering.
+		sort.Slice(uids, func(i, j int) bool { return uids[i] > uids[j] })
+		if maxResults > 0 && int64(len(uids)) > maxResults {
+			uids = uids[:maxResults]
+		}
+
+		messages, err := c.fetchMessages(cl, uids)
+		if err != nil {
+			return err
+		}
+
+		seenThreads := make(map[string]bool)
+		for _, msg := range messages {
+			if seenThreads[msg.ThreadID] || threadsWithDrafts[msg.ThreadID] {
+				continue
+			}
+			seenThreads[msg.ThreadID] = true
+			if c.shouldSkipEmail(msg) {
+				logging.Info("Skipping email from sender: %s (ID: %s)", msg.Sender, msg.ID)
+				continue
+			}
+			unansweredEmails = append(unansweredEmails, msg)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch unanswered emails: %w", err)
+	}
+
+	logging.Info("Found %d unanswered IMAP emails.", len(unansweredEmails))
+	return unansweredEmails, nil
+}
+
+// GetEmailInfo loads a message from the polled mailbox by its UID.
+func (c *Client) GetEmailInfo(ctx context.Context, msgID string) (email.EmailInfo, error) {
+	uid, err := parseUID(msgID)
+	if err != nil {
+		return email.EmailInfo{}, err
+	}
+
+	var info email.EmailInfo
+	err = c.withSession(ctx, func(cl *client.Client) error {
+		if _, err := cl.Select(c.cfg.Mailbox, true); err != nil {
+			return fmt.Errorf("unable to select mailbox %s: %w", c.cfg.Mailbox, err)
+		}
+		messages, err := c.fetchMessages(cl, []uint32{uid})
+		if err != nil {
+			return err
+		}
+		if len(messages) == 0 {
+			return email.ErrNotFound
+		}
+		info = messages[0]
+		return nil
+	})
+	if err != nil {
+		return email.EmailInfo{}, fmt.Errorf("unable to retrieve message %s: %w", msgID, err)
+	}
+	return info, nil
+}
+
+// CreateDraftReply appends the reply to the drafts mailbox flagged \Draft.
+func (c *Client) CreateDraftReply(ctx context.Context, initialEmail email.EmailInfo, replyText string) (*email.DraftInfo, error) {
+	logging.Info("Creating IMAP draft reply for email ID: %s", initialEmail.ID)
+
+	now := time.Now()
+	messageID := email.NewMessageID(c.messageIDDomain())
+	raw, err := email.ComposeReply(initialEmail, replyText, email.ReplyOptions{
+		From:      c.cfg.SMTP.From,
+		MessageID: messageID,
+		Date:      now,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create reply message: %w", err)
+	}
+
+	err = c.withSession(ctx, func(cl *client.Client) error {
+		return cl.Append(c.cfg.DraftsMailbox, []string{goimap.DraftFlag, goimap.SeenFlag}, now, bytes.NewBuffer(raw))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to append draft to %s: %w", c.cfg.DraftsMailbox, err)
+	}
+
+	logging.Info("Draft created with Message-ID: %s", messageID)
+	return &email.DraftInfo{
+		DraftID:   messageID,
+		ThreadID:  initialEmail.ThreadID,
+		MessageID: messageID,
+	}, nil
+}
+
+// SendReply submits the reply over SMTP, flags the original \Answered and,
+// when a sent mailbox is configured, stores a copy there.
+func (c *Client) SendReply(ctx context.Context, initialEmail email.EmailInfo, replyText string) (string, error) {
+	logging.Info("Sending IMAP/SMTP reply for email ID: %s", initialEmail.ID)
+
+	recipient, err := mail.ParseAddress(initialEmail.Sender)
+	if err != nil {
+		return "", fmt.Errorf("invalid recipient address %q: %w", initialEmail.Sender, err)
+	}
+
+	now := time.Now()
+	messageID := email.NewMessageID(c.messageIDDomain())
+	raw, err := email.ComposeReply(initialEmail, replyText, email.ReplyOptions{
+		From:      c.cfg.SMTP.From,
+		MessageID: messageID,
+		Date:      now,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create reply message: %w", err)
+	}
+
+	if err := c.sendMail(ctx, recipient.Address, raw); err != nil {
+		return "", fmt.Errorf("unable to send message: %w", err)
+	}
+
+	err = c.withSession(ctx, func(cl *client.Client) error {
+		if uid, err := parseUID(initialEmail.ID); err == nil {
+			if _, err := cl.Select(c.cfg.Mailbox, false); err != nil {
+				return fmt.Errorf("unable to select mailbox %s: %w", c.cfg.Mailbox, err)
+			}
+			seqset := new(goimap.SeqSet)
+			seqset.AddNum(uid)
+			flags := []interface{}{goimap.AnsweredFlag}
+			if err := cl.UidStore(seqset, goimap.FormatFlagsOp(goimap.AddFlags, true), flags, nil); err != nil {
+				return fmt.Errorf("unable to flag message %s as answered: %w", initialEmail.ID, err)
+			}
+		}
+		if c.cfg.SentMailbox != "" {
+			if err := cl.Append(c.cfg.SentMailbox, []string{goimap.SeenFlag}, now, bytes.NewBuffer(raw)); err != nil {
+				return fmt.Errorf("unable to append reply to %s: %w", c.cfg.SentMailbox, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		// The reply is already delivered; report the bookkeeping failure without failing the send.
+		logging.Error("Reply %s sent but mailbox update failed: %v", messageID, err)
+	}
+
+	logging.Info("Reply sent with Message-ID: %s", messageID)
+	return messageID, nil
+}
+
+// ForwardEmail submits an inline forward over SMTP and, when a sent mailbox is
+// configured, stores a copy there.
+func (c *Client) ForwardEmail(ctx context.Context, initialEmail email.EmailInfo, to, note string) (string, error) {
+	logging.Info("Forwarding email ID %s to %s", initialEmail.ID, to)
+
+	recipient, err := mail.ParseAddress(to)
+	if err != nil {
+		return "", fmt.Errorf("invalid forward address %q: %w", to, err)
+	}
+
+	now := time.Now()
+	messageID := email.NewMessageID(c.messageIDDomain())
+	raw, err := email.ComposeForward(initialEmail, to, note, email.ReplyOptions{
+		From:      c.cfg.SMTP.From,
+		MessageID: messageID,
+		Date:      now,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create forward message: %w", err)
+	}
+
+	if err := c.sendMail(ctx, recipient.Address, raw); err != nil {
+		return "", fmt.Errorf("unable to forward message: %w", err)
+	}
+
+	if c.cfg.SentMailbox != "" {
+		err = c.withSession(ctx, func(cl *client.Client) error {
+			return cl.Append(c.cfg.SentMailbox, []string{goimap.SeenFlag}, now, bytes.NewBuffer(raw))
+		})
+		if err != nil {
+			// The forward is already delivered; report the bookkeeping failure without failing it.
+			logging.Error("Forward %s sent but appending it to %s failed: %v", messageID, c.cfg.SentMailbox, err)
+		}
+	}
+
+	logging.Info("Email forwarded with Message-ID: %s", messageID)
+	return messageID, nil
+}
+
+// FetchDraftReplies lists the drafts stored in the drafts mailbox.
+func (c *Client) FetchDraftReplies(ctx context.Context) ([]email.DraftInfo, error) {
+	var drafts []email.DraftInfo
+	err := c.withSession(ctx, func(cl *client.Client) error {
+		var err error
+		drafts, err = c.listDrafts(cl)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return drafts, nil
+}
+
+// withSession dials, authenticates, runs fn and logs out. The connection is
+// torn down early if ctx is cancelled.
+func (c *Client) withSession(ctx context.Context, fn func(cl *client.Client) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	cl, err := c.dial()
+	if err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			cl.Terminate()
+		case <-done:
+		}
+	}()
+	defer cl.Logout()
+
+	if err := cl.Login(c.cfg.Username, c.cfg.Password); err != nil {
+		return fmt.Errorf("IMAP login failed: %w", err)
+	}
+	if err := fn(cl); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return err
+	}
+	return nil
+}
+
+func (c *Client) dial() (*client.Client, error) {
+	addr := net.JoinHostPort(c.cfg.Host, strconv.Itoa(c.cfg.Port))
+	dialer := &net.Dialer{Timeout: c.cfg.Timeout}
+
+	var (
+		cl  *client.Client
+		err error
+	)
+	if c.cfg.TLS == TLSImplicit {
+		cl, err = client.DialWithDialerTLS(dialer, addr, c.tlsConfig(c.cfg.Host))
+	} else {
+		cl, err = client.DialWithDialer(dialer, addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to IMAP server %s: %w", addr, err)
+	}
+	cl.Timeout = c.cfg.Timeout
+
+	if c.cfg.TLS == TLSStartTLS {
+		if err := cl.StartTLS(c.tlsConfig(c.cfg.Host)); err != nil {
+			cl.Logout()
+			return nil, fmt.Errorf("IMAP STARTTLS failed: %w", err)
+		}
+	}
+	return cl, nil
+}
+
+func (c *Client) tlsConfig(host string) *tls.Config {
+	return &tls.Config{ServerName: host, InsecureSkipVerify: c.cfg.InsecureSkipVerify}
+}
+
+// fetchMessages downloads and parses the given UIDs from the selected mailbox.
+func (c *Client) fetchMessages(cl *client.Client, uids []uint32) ([]email.EmailInfo, error) {
+	seqset := new(goimap.SeqSet)
+	seqset.AddNum(uids...)
+	section := &goimap.BodySectionName{Peek: true}
+	items := []goimap.FetchItem{goimap.FetchUid, section.FetchItem()}
+
+	messages := make(chan *goimap.Message, len(uids))
+	done := make(chan error, 1)
+	go func() {
+		done <- cl.UidFetch(seqset, items, messages)
+	}()
+
+	byUID := make(map[uint32]email.EmailInfo, len(uids))
+	for msg := range messages {
+		body := msg.GetBody(section)
+		if body == nil {
+			logging.Error("IMAP server returned no body for UID %d", msg.Uid)
+			continue
+		}
+		info, err := email.ParseMessage(body)
+		if err != nil {
+			logging.Error("Error parsing IMAP message UID %d: %v", msg.Uid, err)
+			continue
+		}
+		info.ID = strconv.FormatUint(uint64(msg.Uid), 10)
+		byUID[msg.Uid] = info
+	}
+	if err := <-done; err != nil {
+		return nil, fmt.Errorf("unable to fetch messages: %w", err)
+	}
+
+	// Preserve the caller's ordering, FETCH responses come back in mailbox order.
+	result := make([]email.EmailInfo, 0, len(byUID))
+	for _, uid := range uids {
+		if info, ok := byUID[uid]; ok {
+			result = append(result, info)
+		}
+	}
+	return result, nil
+}
+
+// listDrafts selects the drafts mailbox and reads the threading headers of every draft.
+func (c *Client) listDrafts(cl *client.Client) ([]email.DraftInfo, error) {
+	status, err := cl.Select(c.cfg.DraftsMailbox, true)
+	if err != nil {
+		return nil, fmt.Errorf("unable to select drafts mailbox %s: %w", c.cfg.DraftsMailbox, err)
+	}
+	if status.Messages == 0 {
+		return nil, nil
+	}
+
+	seqset := new(goimap.SeqSet)
+	seqset.AddRange(1, 0) // 1:*
+	section := &goimap.BodySectionName{
+		BodyPartName: goimap.BodyPartName{
+			Specifier: goimap.HeaderSpecifier,
+			Fields:    []string{"Message-Id", "In-Reply-To", "References"},
+		},
+		Peek: true,
+	}
+	items := []goimap.FetchItem{goimap.FetchUid, section.FetchItem()}
+
+	messages := make(chan *goimap.Message, status.Messages)
+	done := make(chan error, 1)
+	go func() {
+		done <- cl.UidFetch(seqset, items, messages)
+	}()
+
+	var drafts []email.DraftInfo
+	for msg := range messages {
+		body := msg.GetBody(section)
+		if body == nil {
+			continue
+		}
+		header, err := mail.ReadMessage(body)
+		if err != nil {
+			logging.Error("Error parsing draft headers for UID %d: %v", msg.Uid, err)
+			continue
+		}
+		messageID := header.Header.Get("Message-Id")
+		draftID := messageID
+		if draftID == "" {
+			draftID = strconv.FormatUint(uint64(msg.Uid), 10)
+		}
+		drafts = append(drafts, email.DraftInfo{
+			DraftID:   draftID,
+			ThreadID:  email.ThreadIDFromHeaders(messageID, header.Header.Get("In-Reply-To"), header.Header.Get("References")),
+			MessageID: messageID,
+		})
+	}
+	if err := <-done; err != nil {
+		return nil, fmt.Errorf("unable to fetch drafts: %w", err)
+	}
+
+	logging.Info("Found %d IMAP draft replies.", len(drafts))
+	return drafts, nil
+}
+
+func (c *Client) shouldSkipEmail(emailInfo email.EmailInfo) bool {
+	if c.cfg.MyEmail == "" {
+		return false
+	}
+	return strings.Contains(emailInfo.Sender, c.cfg.MyEmail)
+}
+
+func (c *Client) messageIDDomain() string {
+	if at := strings.LastIndex(c.cfg.SMTP.From, "@"); at >= 0 {
+		return strings.Trim(c.cfg.SMTP.From[at+1:], "> ")
+	}
+	return c.cfg.Host
+}
+
+func parseUID(msgID string) (uint32, error) {
+	uid, err := strconv.ParseUint(msgID, 10, 32)
+	if err != nil || uid == 0 {
+		return 0, fmt.Errorf("invalid IMAP UID %q", msgID)
+	}
+	return uint32(uid), nil
+}
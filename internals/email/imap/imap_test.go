@@ -0,0 +1,224 @@
+package imap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"mailflow/internals/email"
+
+	goimap "github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/server"
+)
+
+// startIMAP serves the go-imap in-memory backend on a local port, with a
+// Drafts mailbox added. Its only user is "username"/"password".
+func startIMAP(t *testing.T) (host string, port int) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := server.New(memory.New())
+	s.AllowInsecureAuth = true
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+
+	addr := l.Addr().(*net.TCPAddr)
+	cl, err := client.Dial(addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Logout()
+	if err := cl.Login("username", "password"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Create(defaultDraftsMailbox); err != nil {
+		t.Fatal(err)
+	}
+	return addr.IP.String(), addr.Port
+}
+
+// startSMTP accepts SMTP submissions on a local port and sends every message
+// it receives to the returned channel. With dropAtQuit it hangs up on QUIT
+// instead of answering it.
+func startSMTP(t *testing.T, dropAtQuit bool) (port int, received <-chan []byte) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	messages := make(chan []byte, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, messages, dropAtQuit)
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port, messages
+}
+
+func serveSMTP(conn net.Conn, messages chan<- []byte, dropAtQuit bool) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 localhost ready")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		switch verb, _, _ := strings.Cut(line, " "); strings.ToUpper(verb) {
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			messages <- data
+			tp.PrintfLine("250 queued")
+		case "QUIT":
+			if !dropAtQuit {
+				tp.PrintfLine("221 bye")
+			}
+			return
+		default:
+			tp.PrintfLine("250 ok")
+		}
+	}
+}
+
+// newTestClient connects a client to fresh IMAP and SMTP stand-ins.
+func newTestClient(t *testing.T, dropAtQuit bool) (*Client, <-chan []byte) {
+	t.Helper()
+	host, imapPort := startIMAP(t)
+	smtpPort, received := startSMTP(t, dropAtQuit)
+	c, err := New(Config{
+		Host:     host,
+		Port:     imapPort,
+		Username: "username",
+		Password: "password",
+		TLS:      TLSNone,
+		SMTP:     SMTPConfig{Host: host, Port: smtpPort, TLS: TLSNone, From: "support@agency.example.com"},
+		Timeout:  5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return c, received
+}
+
+func TestRepliesCarryMessageID(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c, received := newTestClient(t, false)
+
+	// A message from a client that sets no Message-ID.
+	original := "From: Customer <customer@example.com>\r\nTo: support@agency.example.com\r\nSubject: Refund\r\n\r\nWhere is my refund?\r\n"
+	err := c.withSession(ctx, func(cl *client.Client) error {
+		return cl.Append(defaultMailbox, nil, time.Now(), bytes.NewBufferString(original))
+	})
+	if err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	unanswered, err := c.FetchUnansweredEmails(ctx, 10)
+	if err != nil {
+		t.Fatalf("FetchUnansweredEmails: %v", err)
+	}
+	var msg email.EmailInfo
+	for _, e := range unanswered {
+		if e.Subject == "Refund" {
+			msg = e
+		}
+	}
+	if msg.ID == "" || msg.MessageID != "" {
+		t.Fatalf("unanswered emails = %+v, want the Refund email without a Message-ID", unanswered)
+	}
+
+	draft, err := c.CreateDraftReply(ctx, msg, "Your refund is on its way.")
+	if err != nil {
+		t.Fatalf("CreateDraftReply: %v", err)
+	}
+	drafts, err := c.FetchDraftReplies(ctx)
+	if err != nil {
+		t.Fatalf("FetchDraftReplies: %v", err)
+	}
+	if len(drafts) != 1 || drafts[0].MessageID != draft.MessageID || drafts[0].DraftID != draft.DraftID {
+		t.Fatalf("drafts = %+v, want the stored draft to match %+v", drafts, draft)
+	}
+
+	sentID, err := c.SendReply(ctx, msg, "Your refund is on its way.")
+	if err != nil {
+		t.Fatalf("SendReply: %v", err)
+	}
+	select {
+	case raw := <-received:
+		sent, err := mail.ReadMessage(bufio.NewReader(bytes.NewReader(raw)))
+		if err != nil {
+			t.Fatalf("SMTP stand-in received an invalid message: %v", err)
+		}
+		if got := sent.Header.Get("Message-Id"); got == "" || got != sentID {
+			t.Fatalf("sent Message-ID = %q, want %q", got, sentID)
+		}
+	case <-ctx.Done():
+		t.Fatal("SMTP stand-in received nothing")
+	}
+
+	uid, _ := strconv.ParseUint(msg.ID, 10, 32)
+	var flags []string
+	err = c.withSession(ctx, func(cl *client.Client) error {
+		if _, err := cl.Select(defaultMailbox, true); err != nil {
+			return err
+		}
+		seqset := new(goimap.SeqSet)
+		seqset.AddNum(uint32(uid))
+		messages := make(chan *goimap.Message, 1)
+		if err := cl.UidFetch(seqset, []goimap.FetchItem{goimap.FetchFlags}, messages); err != nil {
+			return err
+		}
+		for m := range messages {
+			flags = m.Flags
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("fetching flags: %v", err)
+	}
+	if !strings.Contains(strings.Join(flags, " "), goimap.AnsweredFlag) {
+		t.Fatalf("original flags = %v, want %s", flags, goimap.AnsweredFlag)
+	}
+}
+
+func TestSendMailIgnoresQuitAfterDelivery(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c, received := newTestClient(t, true)
+
+	original := email.EmailInfo{ID: "1", Sender: "customer@example.com", Subject: "Refund", MessageID: "<a@example.com>"}
+	raw, err := email.ComposeReply(original, "Your refund is on its way.", email.ReplyOptions{From: "support@agency.example.com"})
+	if err != nil {
+		t.Fatalf("ComposeReply: %v", err)
+	}
+	// The server accepted the message, so the send must not be reported as
+	// failed and retried into a duplicate.
+	if err := c.sendMail(ctx, "customer@example.com", raw); err != nil {
+		t.Fatalf("sendMail with a dropped QUIT returned %v, want nil", err)
+	}
+	select {
+	case <-received:
+	case <-ctx.Done():
+		t.Fatal("SMTP stand-in received nothing")
+	}
+}
@@ -0,0 +1,83 @@
+package imap
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+
+	"mailflow/pkg/logging"
+)
+
+// sendMail delivers raw to a single recipient through the configured SMTP relay.
+func (c *Client) sendMail(ctx context.Context, to string, raw []byte) error {
+	cfg := c.cfg.SMTP
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	dialer := &net.Dialer{Timeout: c.cfg.Timeout}
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	if cfg.TLS == TLSImplicit {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: c.tlsConfig(cfg.Host)}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to connect to SMTP server %s: %w", addr, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	sc, err := smtp.NewClient(conn, cfg.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("SMTP handshake failed: %w", err)
+	}
+	defer sc.Close()
+
+	if cfg.TLS == TLSStartTLS {
+		if err := sc.StartTLS(c.tlsConfig(cfg.Host)); err != nil {
+			return fmt.Errorf("SMTP STARTTLS failed: %w", err)
+		}
+	}
+	if cfg.Username != "" {
+		if ok, _ := sc.Extension("AUTH"); ok {
+			if err := sc.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)); err != nil {
+				return fmt.Errorf("SMTP authentication failed: %w", err)
+			}
+		}
+	}
+
+	from := cfg.From
+	if addr, err := mail.ParseAddress(cfg.From); err == nil {
+		from = addr.Address
+	}
+	if err := sc.Mail(from); err != nil {
+		return fmt.Errorf("SMTP MAIL FROM rejected: %w", err)
+	}
+	if err := sc.Rcpt(to); err != nil {
+		return fmt.Errorf("SMTP RCPT TO rejected: %w", err)
+	}
+	w, err := sc.Data()
+	if err != nil {
+		return fmt.Errorf("SMTP DATA failed: %w", err)
+	}
+	if _, err := w.Write(raw); err != nil {
+		return fmt.Errorf("failed to write message body: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("SMTP server rejected message: %w", err)
+	}
+	// The server has accepted the message, so it will be delivered whatever
+	// happens to QUIT. Reporting a failure here would have it sent again.
+	if err := sc.Quit(); err != nil {
+		logging.Error("SMTP QUIT after delivering to %s failed: %v", to, err)
+	}
+	return nil
+}
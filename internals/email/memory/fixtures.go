@@ -0,0 +1,98 @@
+package memory
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"mailflow/internals/email"
+)
+
+// LoadFixtures seeds the mailbox from fixture files. Each path may be a JSON
+// file holding one email.EmailInfo or an array of them, a raw .eml message, or
+// a directory whose .json and .eml files are loaded in name order.
+func (m *Mailbox) LoadFixtures(paths ...string) error {
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("failed to stat fixture %s: %w", path, err)
+		}
+		if !info.IsDir() {
+			if err := m.loadFixtureFile(path); err != nil {
+				return err
+			}
+			continue
+		}
+
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return fmt.Errorf("failed to read fixture directory %s: %w", path, err)
+		}
+		var files []string
+		for _, entry := range entries {
+			ext := strings.ToLower(filepath.Ext(entry.Name()))
+			if !entry.IsDir() && (ext == ".json" || ext == ".eml") {
+				files = append(files, filepath.Join(path, entry.Name()))
+			}
+		}
+		sort.Strings(files)
+		for _, file := range files {
+			if err := m.loadFixtureFile(file); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *Mailbox) loadFixtureFile(path string) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return m.loadJSON(path)
+	case ".eml":
+		return m.loadEML(path)
+	default:
+		return fmt.Errorf("unsupported fixture format %s (expected .json or .eml)", path)
+	}
+}
+
+func (m *Mailbox) loadJSON(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read fixture %s: %w", path, err)
+	}
+
+	var messages []email.EmailInfo
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "[") {
+		err = json.Unmarshal(data, &messages)
+	} else {
+		var single email.EmailInfo
+		err = json.Unmarshal(data, &single)
+		messages = append(messages, single)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to decode fixture %s: %w", path, err)
+	}
+
+	m.Add(messages...)
+	return nil
+}
+
+func (m *Mailbox) loadEML(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open fixture %s: %w", path, err)
+	}
+	defer f.Close()
+
+	msg, err := email.ParseMessage(f)
+	if err != nil {
+		return fmt.Errorf("failed to parse fixture %s: %w", path, err)
+	}
+	msg.ID = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	m.Add(msg)
+	return nil
+}
@@ -0,0 +1,218 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"mailflow/internals/email"
+)
+
+// Draft is a draft reply recorded by the Mailbox.
+type Draft struct {
+	email.DraftInfo
+	InReplyTo email.EmailInfo
+	Body      string
+	CreatedAt time.Time
+}
+
+// SentReply is a reply recorded by Mailbox.SendReply.
+type SentReply struct {
+	ID        string
+	InReplyTo email.EmailInfo
+	Body      string
+	SentAt    time.Time
+}
+
+// Forward is an email forwarded by Mailbox.ForwardEmail.
+type Forward struct {
+	ID          string
+	Original    email.EmailInfo
+	To          string
+	Note        string
+	ForwardedAt time.Time
+}
+
+// Mailbox is an in-memory email.Service. It is seeded with fixture messages and
+// records every draft and sent reply, so workflows can run without network
+// access or Gmail credentials.
+type Mailbox struct {
+	mu       sync.RWMutex
+	myEmail  string
+	messages []email.EmailInfo
+	drafts   []Draft
+	sent     []SentReply
+	forwards []Forward
+	nextID   int
+}
+
+var _ email.Service = (*Mailbox)(nil)
+
+func NewMailbox(myEmail string) *Mailbox {
+	return &Mailbox{myEmail: myEmail}
+}
+
+// Add seeds the inbox. Missing IDs are generated and missing thread IDs are
+// derived from the threading headers, mirroring what a real provider returns.
+func (m *Mailbox) Add(messages ...email.EmailInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, msg := range messages {
+		m.nextID++
+		if msg.ID == "" {
+			msg.ID = fmt.Sprintf("msg-%d", m.nextID)
+		}
+		if msg.ThreadID == "" {
+			msg.ThreadID = email.ThreadIDFromHeaders(msg.MessageID, "", msg.References)
+		}
+		if msg.ThreadID == "" {
+			msg.ThreadID = msg.ID
+		}
+		m.messages = append(m.messages, msg)
+	}
+}
+
+// FetchUnansweredEmails returns the newest seeded messages whose thread has
+// no draft or sent reply and was not forwarded.
+func (m *Mailbox) FetchUnansweredEmails(ctx context.Context, maxResults int64) ([]email.EmailInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	answeredThreads := make(map[string]bool)
+	for _, draft := range m.drafts {
+		answeredThreads[draft.ThreadID] = true
+	}
+	for _, reply := range m.sent {
+		answeredThreads[reply.InReplyTo.ThreadID] = true
+	}
+	for _, forward := range m.forwards {
+		answeredThreads[forward.Original.ThreadID] = true
+	}
+
+	seenThreads := make(map[string]bool)
+	unansweredEmails := []email.EmailInfo{}
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if maxResults > 0 && int64(len(unansweredEmails)) >= maxResults {
+			break
+		}
+		msg := m.messages[i]
+		if seenThreads[msg.ThreadID] || answeredThreads[msg.ThreadID] {
+			continue
+		}
+		seenThreads[msg.ThreadID] = true
+		if m.myEmail != "" && strings.Contains(msg.Sender, m.myEmail) {
+			continue
+		}
+		unansweredEmails = append(unansweredEmails, msg)
+	}
+	return unansweredEmails, nil
+}
+
+func (m *Mailbox) GetEmailInfo(ctx context.Context, msgID string) (email.EmailInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, msg := range m.messages {
+		if msg.ID == msgID {
+			return msg, nil
+		}
+	}
+	return email.EmailInfo{}, fmt.Errorf("message %s: %w", msgID, email.ErrNotFound)
+}
+
+func (m *Mailbox) CreateDraftReply(ctx context.Context, initialEmail email.EmailInfo, replyText string) (*email.DraftInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextID++
+	info := email.DraftInfo{
+		DraftID:   fmt.Sprintf("draft-%d", m.nextID),
+		ThreadID:  initialEmail.ThreadID,
+		MessageID: fmt.Sprintf("draft-msg-%d", m.nextID),
+	}
+	m.drafts = append(m.drafts, Draft{
+		DraftInfo: info,
+		InReplyTo: initialEmail,
+		Body:      replyText,
+		CreatedAt: time.Now(),
+	})
+	return &info, nil
+}
+
+func (m *Mailbox) SendReply(ctx context.Context, initialEmail email.EmailInfo, replyText string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextID++
+	id := fmt.Sprintf("sent-%d", m.nextID)
+	m.sent = append(m.sent, SentReply{
+		ID:        id,
+		InReplyTo: initialEmail,
+		Body:      replyText,
+		SentAt:    time.Now(),
+	})
+	return id, nil
+}
+
+func (m *Mailbox) ForwardEmail(ctx context.Context, initialEmail email.EmailInfo, to, note string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextID++
+	id := fmt.Sprintf("fwd-%d", m.nextID)
+	m.forwards = append(m.forwards, Forward{
+		ID:          id,
+		Original:    initialEmail,
+		To:          to,
+		Note:        note,
+		ForwardedAt: time.Now(),
+	})
+	return id, nil
+}
+
+func (m *Mailbox) FetchDraftReplies(ctx context.Context) ([]email.DraftInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	drafts := make([]email.DraftInfo, 0, len(m.drafts))
+	for _, draft := range m.drafts {
+		drafts = append(drafts, draft.DraftInfo)
+	}
+	return drafts, nil
+}
+
+// Drafts returns a copy of every draft recorded so far, oldest first.
+func (m *Mailbox) Drafts() []Draft {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]Draft(nil), m.drafts...)
+}
+
+// Sent returns a copy of every reply sent so far, oldest first.
+func (m *Mailbox) Sent() []SentReply {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]SentReply(nil), m.sent...)
+}
+
+// Forwards returns a copy of every forwarded email so far, oldest first.
+func (m *Mailbox) Forwards() []Forward {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]Forward(nil), m.forwards...)
+}
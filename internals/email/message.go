@@ -0,0 +1,292 @@
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/google/uuid"
+)
+
+var reSpaces = regexp.MustCompile(`\s+`)
+
+// ReplyOptions controls the headers written by ComposeReply.
+type ReplyOptions struct {
+	From      string    // Optional, providers such as Gmail fill it in themselves.
+	MessageID string    // Optional, providers such as Gmail assign their own.
+	Date      time.Time // Optional, omitted when zero.
+}
+
+// NewMessageID generates an RFC 5322 Message-ID for the given domain.
+func NewMessageID(domain string) string {
+	return fmt.Sprintf("<%s@%s>", uuid.New().String(), domain)
+}
+
+// ReplySubject prefixes subject with "Re: " unless it already is a reply.
+func ReplySubject(subject string) string {
+	if strings.HasPrefix(subject, "Re: ") {
+		return subject
+	}
+	return "Re: " + subject
+}
+
+// ComposeReply renders replyText as an HTML reply to original, threaded through
+// In-Reply-To and References, and returns the raw RFC 5322 message.
+func ComposeReply(original EmailInfo, replyText string, opts ReplyOptions) ([]byte, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	htmlPartHeader := make(textproto.MIMEHeader)
+	htmlPartHeader.Set("Content-Type", "text/html; charset=UTF-8")
+	htmlPartHeader.Set("Content-Transfer-Encoding", "quoted-printable")
+	htmlPart, err := mw.CreatePart(htmlPartHeader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTML part: %w", err)
+	}
+
+	htmlText := strings.ReplaceAll(replyText, "\n", "<br>")
+	htmlContent := fmt.Sprintf(`
+		<!DOCTYPE html>
+		<html>
+		<head>
+			<meta charset="utf-8">
+			<meta name="viewport" content="width=device-width, initial-scale=1.0">
+		</head>
+		<body>%s</body>
+		</html>
+		`, htmlText)
+	qpWriter := quotedprintable.NewWriter(htmlPart)
+	if _, err := qpWriter.Write([]byte(htmlContent)); err != nil {
+		return nil, fmt.Errorf("failed to write HTML content to quoted-printable writer: %w", err)
+	}
+	qpWriter.Close()
+	mw.Close()
+
+	var raw bytes.Buffer
+	writeHeader := func(key, value string) {
+		if value != "" {
+			fmt.Fprintf(&raw, "%s: %s\r\n", key, value)
+		}
+	}
+	writeHeader("From", opts.From)
+	writeHeader("To", original.Sender)
+	writeHeader("Subject", mime.QEncoding.Encode("utf-8", ReplySubject(original.Subject)))
+	if !opts.Date.IsZero() {
+		writeHeader("Date", opts.Date.Format(time.RFC1123Z))
+	}
+	writeHeader("Message-ID", opts.MessageID)
+	if original.MessageID != "" {
+		writeHeader("In-Reply-To", original.MessageID)
+		writeHeader("References", strings.TrimSpace(original.References+" "+original.MessageID))
+	}
+	writeHeader("MIME-Version", "1.0")
+	writeHeader("Content-Type", fmt.Sprintf(`multipart/alternative; boundary="%s"`, mw.Boundary()))
+	raw.WriteString("\r\n")
+	raw.Write(body.Bytes())
+
+	return raw.Bytes(), nil
+}
+
+// ForwardSubject prefixes subject with "Fwd: " unless it already is a forward.
+func ForwardSubject(subject string) string {
+	if strings.HasPrefix(subject, "Fwd: ") {
+		return subject
+	}
+	return "Fwd: " + subject
+}
+
+// ComposeForward renders original as an inline plain-text forward to the
+// address to, preceded by note, and returns the raw RFC 5322 message.
+func ComposeForward(original EmailInfo, to, note string, opts ReplyOptions) ([]byte, error) {
+	if to == "" {
+		return nil, fmt.Errorf("forward recipient is empty")
+	}
+
+	var text strings.Builder
+	if note != "" {
+		text.WriteString(note)
+		text.WriteString("\n\n")
+	}
+	text.WriteString("---------- Forwarded message ----------\n")
+	fmt.Fprintf(&text, "From: %s\nSubject: %s\n\n%s\n", original.Sender, original.Subject, original.Body)
+
+	var body bytes.Buffer
+	qpWriter := quotedprintable.NewWriter(&body)
+	if _, err := qpWriter.Write([]byte(text.String())); err != nil {
+		return nil, fmt.Errorf("failed to write forwarded message to quoted-printable writer: %w", err)
+	}
+	qpWriter.Close()
+
+	var raw bytes.Buffer
+	writeHeader := func(key, value string) {
+		if value != "" {
+			fmt.Fprintf(&raw, "%s: %s\r\n", key, value)
+		}
+	}
+	writeHeader("From", opts.From)
+	writeHeader("To", to)
+	writeHeader("Subject", mime.QEncoding.Encode("utf-8", ForwardSubject(original.Subject)))
+	if !opts.Date.IsZero() {
+		writeHeader("Date", opts.Date.Format(time.RFC1123Z))
+	}
+	writeHeader("Message-ID", opts.MessageID)
+	if original.MessageID != "" {
+		writeHeader("References", strings.TrimSpace(original.References+" "+original.MessageID))
+	}
+	writeHeader("MIME-Version", "1.0")
+	writeHeader("Content-Type", "text/plain; charset=UTF-8")
+	writeHeader("Content-Transfer-Encoding", "quoted-printable")
+	raw.WriteString("\r\n")
+	raw.Write(body.Bytes())
+
+	return raw.Bytes(), nil
+}
+
+// ThreadIDFromHeaders derives a stable thread identifier for providers without
+// native threading: the root of References, then In-Reply-To, then Message-ID.
+func ThreadIDFromHeaders(messageID, inReplyTo, references string) string {
+	if refs := strings.Fields(references); len(refs) > 0 {
+		return refs[0]
+	}
+	if id := strings.TrimSpace(inReplyTo); id != "" {
+		return id
+	}
+	return strings.TrimSpace(messageID)
+}
+
+// ParseMessage reads a raw RFC 5322 message and extracts its headers and
+// readable body. The ID field is left for the caller to set.
+func ParseMessage(r io.Reader) (EmailInfo, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return EmailInfo{}, fmt.Errorf("failed to read message: %w", err)
+	}
+
+	dec := new(mime.WordDecoder)
+	decodeHeader := func(key string) string {
+		value := msg.Header.Get(key)
+		if decoded, err := dec.DecodeHeader(value); err == nil {
+			return decoded
+		}
+		return value
+	}
+
+	body, err := extractBody(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body)
+	if err != nil {
+		return EmailInfo{}, fmt.Errorf("failed to extract message body: %w", err)
+	}
+
+	messageID := msg.Header.Get("Message-Id")
+	references := msg.Header.Get("References")
+	return EmailInfo{
+		ThreadID:   ThreadIDFromHeaders(messageID, msg.Header.Get("In-Reply-To"), references),
+		MessageID:  messageID,
+		References: references,
+		Sender:     decodeHeader("From"),
+		Subject:    decodeHeader("Subject"),
+		Body:       CleanBodyText(body),
+	}, nil
+}
+
+// extractBody walks a MIME entity, preferring text/plain over text/html.
+func extractBody(contentType, transferEncoding string, r io.Reader) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(r, params["boundary"])
+		var htmlFallback string
+		for {
+			part, err := mr.NextRawPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return "", err
+			}
+			partType := part.Header.Get("Content-Type")
+			text, err := extractBody(partType, part.Header.Get("Content-Transfer-Encoding"), part)
+			if err != nil || text == "" {
+				continue
+			}
+			if strings.HasPrefix(partType, "text/html") {
+				if htmlFallback == "" {
+					htmlFallback = text
+				}
+				continue
+			}
+			return text, nil
+		}
+		return htmlFallback, nil
+	}
+
+	if mediaType != "text/plain" && mediaType != "text/html" {
+		return "", nil
+	}
+
+	switch strings.ToLower(strings.TrimSpace(transferEncoding)) {
+	case "base64":
+		r = base64.NewDecoder(base64.StdEncoding, &newlineStripper{r: r})
+	case "quoted-printable":
+		r = quotedprintable.NewReader(r)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+
+	text := strings.TrimSpace(string(data))
+	if mediaType == "text/html" {
+		text = ExtractMainContentFromHTML(text)
+	}
+	return text, nil
+}
+
+// ExtractMainContentFromHTML strips markup, scripts and styles from an HTML body.
+func ExtractMainContentFromHTML(htmlContent string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(htmlContent))
+	if err != nil {
+		return htmlContent // Return original content if parsing fails
+	}
+	doc.Find("script, style, head, meta, title").Remove()
+
+	return strings.TrimSpace(doc.Text())
+}
+
+// CleanBodyText collapses runs of whitespace into single spaces.
+func CleanBodyText(text string) string {
+	return strings.TrimSpace(reSpaces.ReplaceAllString(text, " "))
+}
+
+// newlineStripper drops CR and LF bytes so base64 bodies wrapped at 76 columns decode cleanly.
+type newlineStripper struct {
+	r io.Reader
+}
+
+func (n *newlineStripper) Read(p []byte) (int, error) {
+	for {
+		count, err := n.r.Read(p)
+		j := 0
+		for _, b := range p[:count] {
+			if b != '\r' && b != '\n' {
+				p[j] = b
+				j++
+			}
+		}
+		if j > 0 || err != nil {
+			return j, err
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package email
+
+import (
+	"bytes"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestComposeReplyHeaders(t *testing.T) {
+	tests := []struct {
+		name           string
+		original       EmailInfo
+		wantInReplyTo  string
+		wantReferences string
+	}{
+		{
+			name:           "threaded",
+			original:       EmailInfo{Sender: "customer@example.com", Subject: "Refund", MessageID: "<b@example.com>", References: "<a@example.com>"},
+			wantInReplyTo:  "<b@example.com>",
+			wantReferences: "<a@example.com> <b@example.com>",
+		},
+		{
+			name:     "original without Message-ID",
+			original: EmailInfo{Sender: "customer@example.com", Subject: "Refund"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := ComposeReply(tt.original, "Hello\nThanks", ReplyOptions{From: "support@example.com", MessageID: "<reply@example.com>"})
+			if err != nil {
+				t.Fatalf("ComposeReply: %v", err)
+			}
+			msg, err := mail.ReadMessage(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatalf("reply is not a valid message: %v", err)
+			}
+			headers := map[string]string{
+				"Message-Id":  "<reply@example.com>",
+				"In-Reply-To": tt.wantInReplyTo,
+				"References":  tt.wantReferences,
+				"Subject":     "Re: Refund",
+				"To":          "customer@example.com",
+			}
+			for key, want := range headers {
+				if got := msg.Header.Get(key); got != want {
+					t.Errorf("%s = %q, want %q", key, got, want)
+				}
+			}
+
+			parsed, err := ParseMessage(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatalf("ParseMessage: %v", err)
+			}
+			if !strings.Contains(parsed.Body, "Hello") || parsed.MessageID != "<reply@example.com>" {
+				t.Errorf("parsed reply = %+v, want its body and Message-ID back", parsed)
+			}
+		})
+	}
+}
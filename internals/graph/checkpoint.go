@@ -0,0 +1,41 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrCheckpointNotFound is returned by a Checkpointer that has nothing stored for a run.
+var ErrCheckpointNotFound = errors.New("graph: checkpoint not found")
+
+// Checkpoint is the durable position of a run: the state after the last
+// completed node and the node to execute next.
+type Checkpoint[S any] struct {
+	RunID     string         `json:"run_id"`
+	Step      int            `json:"step"` // Number of nodes completed so far
+	Node      string         `json:"node"` // Last node that completed
+	Next      string         `json:"next"` // Node to run on resume, GraphEnd once Done unless the run failed
+	Done      bool           `json:"done"`
+	Error     string         `json:"error,omitempty"` // Why a Done run failed, empty if it reached END
+	Visits    map[string]int `json:"visits"`          // Visit counts, so SetMaxVisits guards survive a resume
+	State     S              `json:"state"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// Checkpointer persists checkpoints. Save is called after every node; each
+// call replaces the previous checkpoint of the same run.
+type Checkpointer[S any] interface {
+	Save(ctx context.Context, cp Checkpoint[S]) error
+	// Load returns the latest checkpoint of runID or ErrCheckpointNotFound.
+	Load(ctx context.Context, runID string) (*Checkpoint[S], error)
+	// Pending lists the runs that are not Done, i.e. were interrupted by a
+	// crash or shutdown before reaching END or failing, oldest first.
+	Pending(ctx context.Context) ([]string, error)
+	// Delete drops the checkpoint of runID, for runs that must not be resumed.
+	// Deleting a run without a checkpoint is not an error.
+	Delete(ctx context.Context, runID string) error
+	// Prune deletes the checkpoints of Done runs last updated before cutoff,
+	// so stores do not grow with every run, and returns how many it deleted.
+	Prune(ctx context.Context, cutoff time.Time) (int, error)
+}
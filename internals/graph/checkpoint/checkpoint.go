@@ -0,0 +1,40 @@
+package checkpoint
+
+import (
+	"fmt"
+
+	"mailflow/internals/graph"
+)
+
+// Store names accepted by New.
+const (
+	StoreFile   = "file"
+	StoreSQLite = "sqlite"
+)
+
+// New opens the checkpointer named by store. An empty path selects
+// "checkpoints" for the file store and "checkpoints.db" for SQLite.
+func New[S any](store, path string) (graph.Checkpointer[S], error) {
+	switch store {
+	case StoreFile:
+		if path == "" {
+			path = "checkpoints"
+		}
+		fileStore, err := NewFileStore[S](path)
+		if err != nil {
+			return nil, err
+		}
+		return fileStore, nil
+	case StoreSQLite:
+		if path == "" {
+			path = "checkpoints.db"
+		}
+		sqliteStore, err := NewSQLiteStore[S](path)
+		if err != nil {
+			return nil, err
+		}
+		return sqliteStore, nil
+	default:
+		return nil, fmt.Errorf("unknown checkpoint store %q", store)
+	}
+}
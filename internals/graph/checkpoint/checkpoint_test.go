@@ -0,0 +1,161 @@
+package checkpoint
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"mailflow/internals/graph"
+)
+
+// newTestGraph builds start -> work -> END, where work runs fn.
+func newTestGraph(t *testing.T, cp graph.Checkpointer[int], fn graph.NodeFunc[int]) *graph.Graph[int] {
+	t.Helper()
+	g := graph.NewGraph[int]()
+	g.AddNode("start", func(ctx context.Context, n int) (int, error) { return n + 1, nil })
+	g.AddNode("work", fn)
+	g.SetEntryPoint("start")
+	g.AddEdge("start", "work")
+	g.AddEdge("work", graph.GraphEnd)
+	if _, err := g.Compile(); err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	g.SetCheckpointer(cp)
+	return g
+}
+
+// stores opens an empty store of every kind.
+var stores = map[string]func(t *testing.T) graph.Checkpointer[int]{
+	StoreFile: func(t *testing.T) graph.Checkpointer[int] {
+		store, err := NewFileStore[int](t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	},
+	StoreSQLite: func(t *testing.T) graph.Checkpointer[int] {
+		store, err := NewSQLiteStore[int](filepath.Join(t.TempDir(), "checkpoints.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { store.Close() })
+		return store
+	},
+}
+
+func TestPendingSkipsFailedRuns(t *testing.T) {
+	for name, open := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			store := open(t)
+
+			boom := errors.New("boom")
+			failing := newTestGraph(t, store, func(ctx context.Context, n int) (int, error) { return n, boom })
+			if _, err := failing.ExecuteRun(ctx, "failed", 0, 10); !errors.Is(err, boom) {
+				t.Fatalf("failed run returned %v, want %v", err, boom)
+			}
+
+			cancelCtx, cancel := context.WithCancel(ctx)
+			interrupted := newTestGraph(t, store, func(ctx context.Context, n int) (int, error) {
+				cancel()
+				return n, ctx.Err()
+			})
+			if _, err := interrupted.ExecuteRun(cancelCtx, "interrupted", 0, 10); !errors.Is(err, context.Canceled) {
+				t.Fatalf("interrupted run returned %v, want %v", err, context.Canceled)
+			}
+
+			pending, err := store.Pending(ctx)
+			if err != nil {
+				t.Fatalf("Pending: %v", err)
+			}
+			if len(pending) != 1 || pending[0] != "interrupted" {
+				t.Fatalf("Pending = %v, want [interrupted]", pending)
+			}
+
+			cp, err := store.Load(ctx, "failed")
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if !cp.Done || cp.Error == "" || cp.Next != "work" || cp.State != 1 {
+				t.Fatalf("failed checkpoint = %+v, want Done at node work with an error and state 1", cp)
+			}
+			if _, err := failing.Resume(ctx, "failed", 10); !errors.Is(err, graph.ErrRunFailed) {
+				t.Fatalf("Resume of failed run returned %v, want %v", err, graph.ErrRunFailed)
+			}
+
+			resumed := newTestGraph(t, store, func(ctx context.Context, n int) (int, error) { return n * 10, nil })
+			state, err := resumed.Resume(ctx, "interrupted", 10)
+			if err != nil || state != 10 {
+				t.Fatalf("Resume = %d, %v, want 10, nil", state, err)
+			}
+			if pending, _ := store.Pending(ctx); len(pending) != 0 {
+				t.Fatalf("Pending after resume = %v, want none", pending)
+			}
+		})
+	}
+}
+
+func TestPrune(t *testing.T) {
+	for name, open := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			store := open(t)
+			cutoff := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+			checkpoints := []graph.Checkpoint[int]{
+				{RunID: "finished-old", Done: true, UpdatedAt: cutoff.Add(-time.Hour)},
+				{RunID: "failed-old", Done: true, Error: "boom", UpdatedAt: cutoff.Add(-time.Hour)},
+				{RunID: "finished-new", Done: true, UpdatedAt: cutoff.Add(time.Hour)},
+				{RunID: "pending-old", UpdatedAt: cutoff.Add(-time.Hour)},
+			}
+			for _, cp := range checkpoints {
+				if err := store.Save(ctx, cp); err != nil {
+					t.Fatalf("Save: %v", err)
+				}
+			}
+
+			pruned, err := store.Prune(ctx, cutoff)
+			if err != nil || pruned != 2 {
+				t.Fatalf("Prune = %d, %v, want 2, nil", pruned, err)
+			}
+			wantKept := map[string]bool{"finished-old": false, "failed-old": false, "finished-new": true, "pending-old": true}
+			for runID, want := range wantKept {
+				_, err := store.Load(ctx, runID)
+				if kept := !errors.Is(err, graph.ErrCheckpointNotFound); kept != want {
+					t.Errorf("checkpoint %s kept = %v after Prune, want %v", runID, kept, want)
+				}
+			}
+			if pending, _ := store.Pending(ctx); len(pending) != 1 || pending[0] != "pending-old" {
+				t.Errorf("Pending after Prune = %v, want [pending-old]", pending)
+			}
+		})
+	}
+}
+
+func TestFileStorePendingSkipsUnreadableFiles(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := NewFileStore[int](dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(ctx, graph.Checkpoint[int]{RunID: "interrupted", Next: "work"}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "truncated.json"), []byte(`{"run_id": "trunc`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := store.Pending(ctx)
+	if err != nil {
+		t.Fatalf("Pending with a truncated file: %v", err)
+	}
+	if len(pending) != 1 || pending[0] != "interrupted" {
+		t.Fatalf("Pending = %v, want [interrupted]", pending)
+	}
+	if _, err := store.Prune(ctx, time.Now()); err != nil {
+		t.Fatalf("Prune with a truncated file: %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+// Package checkpoint provides durable graph.Checkpointer implementations.
+package checkpoint
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"mailflow/internals/graph"
+	"mailflow/pkg/logging"
+)
+
+// FileStore keeps the latest checkpoint of every run as <dir>/<runID>.json.
+type FileStore[S any] struct {
+	mu  sync.Mutex
+	dir string
+}
+
+var _ graph.Checkpointer[struct{}] = (*FileStore[struct{}])(nil)
+
+// NewFileStore creates dir if needed and stores checkpoints in it.
+func NewFileStore[S any](dir string) (*FileStore[S], error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create checkpoint directory %s: %w", dir, err)
+	}
+	return &FileStore[S]{dir: dir}, nil
+}
+
+func (f *FileStore[S]) Save(ctx context.Context, cp graph.Checkpoint[S]) error {
+	path, err := f.path(cp.RunID)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint: %w", err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// Write then rename, so a crash mid-write leaves the previous checkpoint intact.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write checkpoint %s: %w", path, err)
+	}
+	return os.Rename(tmp, path)
+}
+
+func (f *FileStore[S]) Load(ctx context.Context, runID string) (*graph.Checkpoint[S], error) {
+	path, err := f.path(runID)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return readCheckpoint[S](path)
+}
+
+func (f *FileStore[S]) Pending(ctx context.Context) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	paths, err := filepath.Glob(filepath.Join(f.dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list checkpoints in %s: %w", f.dir, err)
+	}
+	var pending []*graph.Checkpoint[S]
+	for _, path := range paths {
+		cp, err := readCheckpoint[S](path)
+		if err != nil {
+			// One damaged file must not keep every other run from resuming.
+			logging.Error("Skipping unreadable checkpoint: %v", err)
+			continue
+		}
+		if !cp.Done {
+			pending = append(pending, cp)
+		}
+	}
+	sort.SliceStable(pending, func(i, j int) bool { return pending[i].UpdatedAt.Before(pending[j].UpdatedAt) })
+
+	runIDs := make([]string, 0, len(pending))
+	for _, cp := range pending {
+		runIDs = append(runIDs, cp.RunID)
+	}
+	return runIDs, nil
+}
+
+func (f *FileStore[S]) Delete(ctx context.Context, runID string) error {
+	path, err := f.path(runID)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete checkpoint %s: %w", path, err)
+	}
+	return nil
+}
+
+func (f *FileStore[S]) Prune(ctx context.Context, cutoff time.Time) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	paths, err := filepath.Glob(filepath.Join(f.dir, "*.json"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to list checkpoints in %s: %w", f.dir, err)
+	}
+	pruned := 0
+	for _, path := range paths {
+		cp, err := readCheckpoint[S](path)
+		if err != nil || !cp.Done || !cp.UpdatedAt.Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return pruned, fmt.Errorf("failed to delete checkpoint %s: %w", path, err)
+		}
+		pruned++
+	}
+	return pruned, nil
+}
+
+func (f *FileStore[S]) path(runID string) (string, error) {
+	if runID == "" || strings.ContainsAny(runID, `/\`) || runID == "." || runID == ".." {
+		return "", fmt.Errorf("invalid run ID %q for file checkpoints", runID)
+	}
+	return filepath.Join(f.dir, runID+".json"), nil
+}
+
+func readCheckpoint[S any](path string) (*graph.Checkpoint[S], error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, graph.ErrCheckpointNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint %s: %w", path, err)
+	}
+	var cp graph.Checkpoint[S]
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("failed to decode checkpoint %s: %w", path, err)
+	}
+	return &cp, nil
+}
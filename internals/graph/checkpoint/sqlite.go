@@ -0,0 +1,163 @@
+package checkpoint
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"mailflow/internals/graph"
+
+	_ "modernc.org/sqlite"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS checkpoints (
+	run_id     TEXT PRIMARY KEY,
+	step       INTEGER NOT NULL,
+	node       TEXT NOT NULL,
+	next       TEXT NOT NULL,
+	done       INTEGER NOT NULL,
+	error      TEXT NOT NULL DEFAULT '',
+	visits     TEXT NOT NULL,
+	state      TEXT NOT NULL,
+	created_at TEXT NOT NULL,
+	updated_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS checkpoints_pending ON checkpoints (done, created_at);`
+
+// timeLayout has a fixed width, so timestamps stored as text sort chronologically.
+const timeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// SQLiteStore keeps the latest checkpoint of every run in a SQLite database.
+type SQLiteStore[S any] struct {
+	db *sql.DB
+}
+
+var _ graph.Checkpointer[struct{}] = (*SQLiteStore[struct{}])(nil)
+
+// NewSQLiteStore opens (or creates) the database at path.
+func NewSQLiteStore[S any](path string) (*SQLiteStore[S], error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open checkpoint database %s: %w", path, err)
+	}
+	// A single connection serializes writers, which SQLite requires anyway.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec("PRAGMA journal_mode=WAL; PRAGMA busy_timeout=5000;" + sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize checkpoint database %s: %w", path, err)
+	}
+	if err := upgradeSchema(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to upgrade checkpoint database %s: %w", path, err)
+	}
+	return &SQLiteStore[S]{db: db}, nil
+}
+
+func (s *SQLiteStore[S]) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLiteStore[S]) Save(ctx context.Context, cp graph.Checkpoint[S]) error {
+	visits, err := json.Marshal(cp.Visits)
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint visits: %w", err)
+	}
+	state, err := json.Marshal(cp.State)
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint state: %w", err)
+	}
+	updatedAt := cp.UpdatedAt.UTC().Format(timeLayout)
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO checkpoints (run_id, step, node, next, done, error, visits, state, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (run_id) DO UPDATE SET
+			step = excluded.step, node = excluded.node, next = excluded.next, done = excluded.done,
+			error = excluded.error, visits = excluded.visits, state = excluded.state, updated_at = excluded.updated_at`,
+		cp.RunID, cp.Step, cp.Node, cp.Next, cp.Done, cp.Error, string(visits), string(state), updatedAt, updatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save checkpoint of run '%s': %w", cp.RunID, err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore[S]) Load(ctx context.Context, runID string) (*graph.Checkpoint[S], error) {
+	var (
+		cp        graph.Checkpoint[S]
+		visits    string
+		state     string
+		updatedAt string
+	)
+	err := s.db.QueryRowContext(ctx, `
+		SELECT run_id, step, node, next, done, error, visits, state, updated_at
+		FROM checkpoints WHERE run_id = ?`, runID).
+		Scan(&cp.RunID, &cp.Step, &cp.Node, &cp.Next, &cp.Done, &cp.Error, &visits, &state, &updatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, graph.ErrCheckpointNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load checkpoint of run '%s': %w", runID, err)
+	}
+	if err := json.Unmarshal([]byte(visits), &cp.Visits); err != nil {
+		return nil, fmt.Errorf("failed to decode checkpoint visits of run '%s': %w", runID, err)
+	}
+	if err := json.Unmarshal([]byte(state), &cp.State); err != nil {
+		return nil, fmt.Errorf("failed to decode checkpoint state of run '%s': %w", runID, err)
+	}
+	if cp.UpdatedAt, err = time.Parse(timeLayout, updatedAt); err != nil {
+		return nil, fmt.Errorf("failed to decode checkpoint time of run '%s': %w", runID, err)
+	}
+	return &cp, nil
+}
+
+func (s *SQLiteStore[S]) Pending(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT run_id FROM checkpoints WHERE done = 0 ORDER BY created_at, run_id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pending checkpoints: %w", err)
+	}
+	defer rows.Close()
+
+	var runIDs []string
+	for rows.Next() {
+		var runID string
+		if err := rows.Scan(&runID); err != nil {
+			return nil, fmt.Errorf("failed to list pending checkpoints: %w", err)
+		}
+		runIDs = append(runIDs, runID)
+	}
+	return runIDs, rows.Err()
+}
+
+func (s *SQLiteStore[S]) Delete(ctx context.Context, runID string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM checkpoints WHERE run_id = ?`, runID); err != nil {
+		return fmt.Errorf("failed to delete checkpoint of run '%s': %w", runID, err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore[S]) Prune(ctx context.Context, cutoff time.Time) (int, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM checkpoints WHERE done = 1 AND updated_at < ?`,
+		cutoff.UTC().Format(timeLayout))
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune checkpoints: %w", err)
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// upgradeSchema adds the error column missing from databases created before
+// failed runs were recorded.
+func upgradeSchema(db *sql.DB) error {
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('checkpoints') WHERE name = 'error'`).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	_, err := db.Exec(`ALTER TABLE checkpoints ADD COLUMN error TEXT NOT NULL DEFAULT ''`)
+	return err
+}
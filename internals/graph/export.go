@@ -0,0 +1,131 @@
+package graph
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Export formats accepted by Graph.Export.
+const (
+	FormatMermaid = "mermaid"
+	FormatDOT     = "dot"
+)
+
+const graphStart = "__START__"
+
+// exportEdge is a transition as drawn in a diagram. Conditional edges carry the
+// route keys leading to the same target joined into one label.
+type exportEdge struct {
+	from, to string
+	label    string
+}
+
+// Export renders the graph topology in the given format ("mermaid" or "dot").
+func (g *Graph[S]) Export(format string) (string, error) {
+	switch format {
+	case FormatMermaid:
+		return g.Mermaid(), nil
+	case FormatDOT:
+		return g.DOT(), nil
+	default:
+		return "", fmt.Errorf("unknown graph export format %q, expected %q or %q", format, FormatMermaid, FormatDOT)
+	}
+}
+
+// Mermaid renders the graph as a Mermaid flowchart. Conditional edges are
+// dashed and labelled with their route keys.
+func (g *Graph[S]) Mermaid() string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	ids := map[string]string{graphStart: "start", GraphEnd: "finish"}
+	for i, name := range g.nodeOrder {
+		ids[name] = fmt.Sprintf("n%d", i)
+	}
+	id := func(name string) string {
+		if v, ok := ids[name]; ok {
+			return v
+		}
+		// Undefined targets are still drawn so broken graphs remain debuggable.
+		ids[name] = fmt.Sprintf("u%d", len(ids))
+		return ids[name]
+	}
+
+	var b strings.Builder
+	b.WriteString("flowchart TD\n")
+	b.WriteString("    start((START))\n")
+	for _, name := range g.nodeOrder {
+		fmt.Fprintf(&b, "    %s[%s]\n", ids[name], mermaidQuote(name))
+	}
+	b.WriteString("    finish((END))\n")
+	for _, e := range g.exportEdges() {
+		if e.label == "" {
+			fmt.Fprintf(&b, "    %s --> %s\n", id(e.from), id(e.to))
+		} else {
+			fmt.Fprintf(&b, "    %s -.->|%s| %s\n", id(e.from), mermaidQuote(e.label), id(e.to))
+		}
+	}
+	return b.String()
+}
+
+// DOT renders the graph in Graphviz DOT syntax.
+func (g *Graph[S]) DOT() string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	var b strings.Builder
+	b.WriteString("digraph workflow {\n")
+	b.WriteString("    rankdir=TB;\n")
+	b.WriteString("    node [shape=box, style=rounded];\n")
+	fmt.Fprintf(&b, "    %s [shape=circle, label=\"START\"];\n", dotQuote(graphStart))
+	for _, name := range g.nodeOrder {
+		fmt.Fprintf(&b, "    %s;\n", dotQuote(name))
+	}
+	fmt.Fprintf(&b, "    %s [shape=doublecircle, label=\"END\"];\n", dotQuote(GraphEnd))
+	for _, e := range g.exportEdges() {
+		if e.label == "" {
+			fmt.Fprintf(&b, "    %s -> %s;\n", dotQuote(e.from), dotQuote(e.to))
+		} else {
+			fmt.Fprintf(&b, "    %s -> %s [label=%s, style=dashed];\n", dotQuote(e.from), dotQuote(e.to), dotQuote(e.label))
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// exportEdges lists the edges to draw in node insertion order. Nodes without
+// outgoing edges get a direct edge to END, matching how Execute treats them.
+func (g *Graph[S]) exportEdges() []exportEdge {
+	var edges []exportEdge
+	if g.entryPoint != "" {
+		edges = append(edges, exportEdge{from: graphStart, to: g.entryPoint})
+	}
+	for _, from := range g.edgeSources() {
+		e := g.edges[from]
+		if !e.isConditional() {
+			edges = append(edges, exportEdge{from: from, to: e.to})
+			continue
+		}
+		keysByTarget := make(map[string][]string)
+		for _, key := range sortedKeys(e.routes) {
+			keysByTarget[e.routes[key]] = append(keysByTarget[e.routes[key]], key)
+		}
+		for _, to := range g.targets(from) {
+			edges = append(edges, exportEdge{from: from, to: to, label: strings.Join(keysByTarget[to], " / ")})
+		}
+	}
+	for _, name := range g.nodeOrder {
+		if _, ok := g.edges[name]; !ok {
+			edges = append(edges, exportEdge{from: name, to: GraphEnd})
+		}
+	}
+	return edges
+}
+
+func mermaidQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, "#quot;") + `"`
+}
+
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
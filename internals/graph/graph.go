@@ -2,186 +2,345 @@ package graph
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"mailflow/internals/common/types"
 	"mailflow/pkg/logging"
 	"sync"
+	"time"
+
+	"github.com/google/uuid"
 )
 
-// Directed graph.
-type Graph struct {
-	nodes            map[string]Node
-	edges            map[string][]string          // Adjacency list: nodeID -> list of nextNodeIDs
-	conditionalEdges map[string]map[string]string // nodeID -> (routeKey -> nextNodeID)
-	startNodeID      string
-	mu               sync.RWMutex
-	// CompiledGraph stores the compiled, immutable version of the graph for execution.
-	// This is a placeholder for a more complex compilation step if needed.
-	CompiledGraph *Graph
+// GraphEnd is the sentinel node name that terminates a run.
+const GraphEnd = "__END__"
+
+// ErrMaxIterations is returned when a run does not reach GraphEnd within its step budget.
+var ErrMaxIterations = errors.New("graph: maximum iterations reached before END")
+
+// ErrMaxVisits is returned when a node guarded by SetMaxVisits is entered too often in one run.
+var ErrMaxVisits = errors.New("graph: node visit limit exceeded")
+
+// ErrRunFailed is returned by Resume for a run whose checkpoint records a failure.
+var ErrRunFailed = errors.New("graph: run failed")
+
+// NodeFunc is a step of the workflow. It receives the current state and returns the updated state.
+type NodeFunc[S any] func(ctx context.Context, state S) (S, error)
+
+// RouterFunc inspects the state after a node has run and returns a route key
+// that is looked up in the conditional edge map of that node.
+type RouterFunc[S any] func(ctx context.Context, state S) (string, error)
+
+// edge describes how to leave a node: either a direct transition or a router plus its route map.
+type edge[S any] struct {
+	to     string
+	router RouterFunc[S]
+	routes map[string]string
+}
+
+func (e edge[S]) isConditional() bool {
+	return e.router != nil
 }
 
-const GraphEnd = "GRAPH_END"
+// Graph is a directed workflow over a typed state S.
+type Graph[S any] struct {
+	mu         sync.RWMutex
+	nodes      map[string]NodeFunc[S]
+	nodeOrder  []string // Insertion order, keeps diagnostics and exports deterministic
+	edges      map[string]edge[S]
+	entryPoint string
+	routeSets  map[string][]string // Route keys each router may return, see DeclareRoutes
+	maxVisits  map[string]int      // Per-run visit limits acting as cycle guards, see SetMaxVisits
+
+	checkpointer Checkpointer[S]
+}
 
-func NewGraph() *Graph {
-	return &Graph{
-		nodes:            make(map[string]Node),
-		edges:            make(map[string][]string),
-		conditionalEdges: make(map[string]map[string]string),
+func NewGraph[S any]() *Graph[S] {
+	return &Graph[S]{
+		nodes:     make(map[string]NodeFunc[S]),
+		edges:     make(map[string]edge[S]),
+		routeSets: make(map[string][]string),
+		maxVisits: make(map[string]int),
 	}
 }
 
-func (g *Graph) AddNode(node Node) error {
+// AddNode registers a node function under a unique name.
+func (g *Graph[S]) AddNode(name string, fn NodeFunc[S]) error {
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
-	if _, exists := g.nodes[node.ID()]; exists {
-		return fmt.Errorf("node with ID '%s' already exists", node.ID())
+	if name == "" || name == GraphEnd {
+		return fmt.Errorf("invalid node name '%s'", name)
 	}
-	g.nodes[node.ID()] = node
-	g.edges[node.ID()] = []string{}
-	logging.Debug("Added node: %s", node.ID())
+	if _, exists := g.nodes[name]; exists {
+		return fmt.Errorf("node with ID '%s' already exists", name)
+	}
+	g.nodes[name] = fn
+	g.nodeOrder = append(g.nodeOrder, name)
+	logging.Debug("Added node: %s", name)
 	return nil
 }
 
-func (g *Graph) AddEdge(fromNodeID, toNodeID string) error {
+// SetEntryPoint sets the node a run starts from. It is validated by Compile.
+func (g *Graph[S]) SetEntryPoint(name string) {
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
-	if _, exists := g.nodes[fromNodeID]; !exists {
-		return fmt.Errorf("source node '%s' not found", fromNodeID)
-	}
+	g.entryPoint = name
+	logging.Debug("Set entry point to: %s", name)
+}
 
-	if toNodeID != GraphEnd {
-		if _, exists := g.nodes[toNodeID]; !exists {
-			return fmt.Errorf("destination node '%s' not found", toNodeID)
-		}
-	}
+// AddEdge defines a direct (unconditional) transition. toNode may be GraphEnd.
+func (g *Graph[S]) AddEdge(fromNode, toNode string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
 
-	// Prevent duplicate edges
-	for _, existingTo := range g.edges[fromNodeID] {
-		if existingTo == toNodeID {
-			return fmt.Errorf("edge from '%s' to '%s' already exists", fromNodeID, toNodeID)
-		}
+	if _, exists := g.edges[fromNode]; exists {
+		return fmt.Errorf("node '%s' already has outgoing edges", fromNode)
 	}
-
-	g.edges[fromNodeID] = append(g.edges[fromNodeID], toNodeID)
-	logging.Debug("Added edge: %s -> %s", fromNodeID, toNodeID)
+	g.edges[fromNode] = edge[S]{to: toNode}
+	logging.Debug("Added edge: %s -> %s", fromNode, toNode)
 	return nil
 }
 
-func (g *Graph) AddConditionalEdges(routerNodeID string, router ConditionalRouterNode, routes map[string]string) error {
+// AddConditionalEdges routes out of fromNode using the key returned by router.
+// Values of routes are node names or GraphEnd.
+func (g *Graph[S]) AddConditionalEdges(fromNode string, router RouterFunc[S], routes map[string]string) error {
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
-	if _, exists := g.nodes[routerNodeID]; !exists {
-		return fmt.Errorf("router node '%s' not found", routerNodeID)
+	if router == nil {
+		return fmt.Errorf("conditional edges from '%s' need a router", fromNode)
 	}
-
-	// Ensure the routerNodeID is associated with a ConditionalRouterNode
-	if _, ok := g.nodes[routerNodeID].(ConditionalRouterNode); !ok {
-		return fmt.Errorf("node '%s' is not a ConditionalRouterNode", routerNodeID)
+	if _, exists := g.edges[fromNode]; exists {
+		return fmt.Errorf("node '%s' already has outgoing edges", fromNode)
+	}
+	copied := make(map[string]string, len(routes))
+	for key, to := range routes {
+		copied[key] = to
 	}
+	g.edges[fromNode] = edge[S]{router: router, routes: copied}
+	logging.Debug("Added conditional edges for node '%s': %v", fromNode, routes)
+	return nil
+}
 
-	g.conditionalEdges[routerNodeID] = routes
-	logging.Debug("Added conditional edges for router node '%s': %v", routerNodeID, routes)
+// DeclareRoutes records every key the router of fromNode can return, letting
+// Compile verify that the conditional edge map handles all of them.
+func (g *Graph[S]) DeclareRoutes(fromNode string, keys ...string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
 
-	// Validate all destination nodes exist (except for GraphEnd)
-	for _, destNodeID := range routes {
-		if destNodeID != GraphEnd {
-			if _, exists := g.nodes[destNodeID]; !exists {
-				return fmt.Errorf("conditional destination node '%s' not found", destNodeID)
-			}
-		}
+	if len(keys) == 0 {
+		return fmt.Errorf("no routes declared for node '%s'", fromNode)
 	}
+	g.routeSets[fromNode] = append([]string(nil), keys...)
+	logging.Debug("Declared routes for node '%s': %v", fromNode, keys)
 	return nil
 }
 
-func (g *Graph) SetStartNode(nodeID string) error {
+// SetMaxVisits limits how many times a node may run within a single Execute.
+// Every cycle in the graph must pass through at least one such guarded node.
+func (g *Graph[S]) SetMaxVisits(name string, limit int) error {
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
-	if _, exists := g.nodes[nodeID]; !exists {
-		return fmt.Errorf("start node '%s' not found in graph", nodeID)
+	if limit <= 0 {
+		return fmt.Errorf("visit limit for node '%s' must be positive, got %d", name, limit)
 	}
-	g.startNodeID = nodeID
-	logging.Info("Set start node to: %s", nodeID)
+	g.maxVisits[name] = limit
+	logging.Debug("Set visit limit for node '%s' to %d", name, limit)
 	return nil
 }
 
-// Compile "compiles" the graph. For this simple implementation, it just returns itself.
-// In a more complex system, this might involve validation, optimization, or creating an immutable execution plan.
-func (g *Graph) Compile() *Graph {
+// Compile validates the graph and returns it ready for execution. All errors
+// found are reported together as a *CompileError; warnings are only logged.
+func (g *Graph[S]) Compile() (*Graph[S], error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
 	logging.Info("Compiling graph...")
-	// [TODO]Perform validation here if needed (e.g., check for cycles, unreachable nodes)
-	return g // For now, just return the graph itself
+	var errs []Problem
+	for _, p := range g.validate() {
+		if p.Severity == SeverityWarning {
+			logging.Info("Graph warning: %s", p)
+			continue
+		}
+		errs = append(errs, p)
+	}
+	if len(errs) > 0 {
+		return nil, &CompileError{Problems: errs}
+	}
+	return g, nil
+}
+
+// SetCheckpointer makes every run persist a checkpoint after each node, which
+// lets Resume continue a run interrupted by a crash or restart.
+func (g *Graph[S]) SetCheckpointer(cp Checkpointer[S]) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.checkpointer = cp
+}
+
+// Execute runs the graph from its entry point under a new run ID.
+func (g *Graph[S]) Execute(ctx context.Context, initial S, maxIterations int) (S, error) {
+	return g.ExecuteRun(ctx, uuid.NewString(), initial, maxIterations)
+}
+
+// ExecuteRun runs the graph from its entry point until GraphEnd, a node without
+// outgoing edges, an error, or maxIterations node executions. runID identifies
+// the run's checkpoints when a Checkpointer is set.
+func (g *Graph[S]) ExecuteRun(ctx context.Context, runID string, initial S, maxIterations int) (S, error) {
+	g.mu.RLock()
+	entryPoint := g.entryPoint
+	g.mu.RUnlock()
+
+	if entryPoint == "" {
+		return initial, fmt.Errorf("no entry point defined for the graph")
+	}
+	return g.ExecuteRunFrom(ctx, runID, entryPoint, initial, maxIterations)
 }
 
-func (g *Graph) Execute(ctx context.Context, initialTask types.Task, wfCtx *types.WorkflowContext) (types.Task, error) {
+// ExecuteRunFrom is ExecuteRun starting at node start instead of the entry
+// point, for runs that pick up work done elsewhere (e.g. a redraft after review).
+func (g *Graph[S]) ExecuteRunFrom(ctx context.Context, runID, start string, initial S, maxIterations int) (S, error) {
 	g.mu.RLock()
 	defer g.mu.RUnlock()
 
-	if g.startNodeID == "" {
-		return initialTask, fmt.Errorf("no start node defined for the graph")
-	}
-
-	currentNodeID := g.startNodeID
-	currentTask := initialTask
-
-	logging.Info("Starting graph execution for task ID: %s, from node: %s", currentTask.ID, currentNodeID)
-
-	for {
-		select {
-		case <-ctx.Done():
-			logging.Info("Graph execution cancelled for task %s: %v", currentTask.ID, ctx.Err())
-			return currentTask, ctx.Err()
-		default:
-			if currentNodeID == GraphEnd {
-				logging.Info("Reached GRAPH_END sentinel for task %s.", currentTask.ID)
-				return currentTask, nil // Workflow completed successfully
-			}
-
-			node, exists := g.nodes[currentNodeID]
-			if !exists {
-				return currentTask, fmt.Errorf("node '%s' not found during execution for task %s", currentNodeID, currentTask.ID)
-			}
-
-			logging.Info("Executing node '%s' for task %s (Current State: %s)", node.ID(), currentTask.ID, currentTask.CurrentState)
-			updatedTask, err := node.Execute(ctx, currentTask, wfCtx)
-			if err != nil {
-				logging.Error("Error executing node '%s' for task %s: %v", node.ID(), currentTask.ID, err)
-				updatedTask.Error = err // Store the error in the task
-				return updatedTask, fmt.Errorf("node '%s' failed: %w", node.ID(), err)
-			}
-			currentTask = updatedTask
-			logging.Info("Node '%s' completed for task %s (New State: %s)", node.ID(), currentTask.ID, currentTask.CurrentState)
-
-			// Determine next node
-			if routes, isConditional := g.conditionalEdges[currentNodeID]; isConditional {
-				// This is a conditional router node
-				routerNode, ok := node.(ConditionalRouterNode)
-				if !ok {
-					return currentTask, fmt.Errorf("node '%s' is defined as conditional but does not implement ConditionalRouterNode", currentNodeID)
-				}
-				routeKey, err := routerNode.Route(ctx, currentTask, wfCtx)
-				if err != nil {
-					return currentTask, fmt.Errorf("failed to route from node '%s': %w", currentNodeID, err)
-				}
-				next, found := routes[routeKey]
-				if !found {
-					return currentTask, fmt.Errorf("no route found for key '%s' from node '%s'", routeKey, currentNodeID)
-				}
-				currentNodeID = next
-				logging.Debug("Conditional routing from '%s' to '%s' via key '%s'", node.ID(), currentNodeID, routeKey)
-			} else {
-				// This is a sequential node
-				nextNodes := g.edges[currentNodeID]
-				if len(nextNodes) == 0 {
-					logging.Info("Reached end of sequential path for task %s at node %s.", currentTask.ID, currentNodeID)
-					return currentTask, nil // Workflow completed
-				}
-				currentNodeID = nextNodes[0] // Move to the next node (first edge)
-				logging.Debug("Sequential routing from '%s' to '%s'", node.ID(), currentNodeID)
-			}
+	if _, ok := g.nodes[start]; !ok {
+		return initial, fmt.Errorf("start node '%s' not found in graph definition", start)
+	}
+	return g.run(ctx, &Checkpoint[S]{RunID: runID, Next: start, Visits: map[string]int{}, State: initial}, maxIterations)
+}
+
+// Resume continues runID from its last checkpoint. The node that was running
+// when the process stopped is executed again, so nodes with side effects must
+// tolerate being repeated. A run that already finished returns its final state,
+// along with ErrRunFailed if it ended in an error.
+func (g *Graph[S]) Resume(ctx context.Context, runID string, maxIterations int) (S, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	var zero S
+	if g.checkpointer == nil {
+		return zero, fmt.Errorf("cannot resume run '%s': no checkpointer configured", runID)
+	}
+	cp, err := g.checkpointer.Load(ctx, runID)
+	if err != nil {
+		return zero, fmt.Errorf("failed to load checkpoint of run '%s': %w", runID, err)
+	}
+	if cp.Done && cp.Error != "" {
+		return cp.State, fmt.Errorf("%w: run '%s' stopped at node '%s': %s", ErrRunFailed, runID, cp.Next, cp.Error)
+	}
+	if cp.Done {
+		logging.Info("Run '%s' already reached END, nothing to resume.", runID)
+		return cp.State, nil
+	}
+	if cp.Visits == nil {
+		cp.Visits = map[string]int{}
+	}
+	logging.Info("Resuming run '%s' at node '%s' after %d steps", runID, cp.Next, cp.Step)
+	return g.run(ctx, cp, maxIterations)
+}
+
+// run executes nodes from cp.Next. A run that fails is checkpointed as Done
+// with its error, so it is not resumed; one stopped by the cancellation of
+// ctx keeps its last checkpoint and stays pending.
+func (g *Graph[S]) run(ctx context.Context, cp *Checkpoint[S], maxIterations int) (S, error) {
+	state, err := g.step(ctx, cp, maxIterations)
+	if err != nil && ctx.Err() == nil && !errors.Is(err, errCheckpointSave) {
+		cp.Done = true
+		cp.Error = err.Error()
+		if saveErr := g.saveCheckpoint(ctx, cp); saveErr != nil {
+			logging.Error("Failed to record the failure of run '%s': %v", cp.RunID, saveErr)
+		}
+	}
+	return state, err
+}
+
+func (g *Graph[S]) step(ctx context.Context, cp *Checkpoint[S], maxIterations int) (S, error) {
+	for i := 0; i < maxIterations; i++ {
+		current := cp.Next
+		if err := ctx.Err(); err != nil {
+			logging.Info("Graph execution cancelled at node '%s': %v", current, err)
+			return cp.State, err
+		}
+		if current == GraphEnd {
+			logging.Info("Workflow reached END.")
+			return cp.State, nil
+		}
+
+		nodeFunc, ok := g.nodes[current]
+		if !ok {
+			return cp.State, fmt.Errorf("node '%s' not found in graph definition", current)
+		}
+
+		cp.Visits[current]++
+		if limit, guarded := g.maxVisits[current]; guarded && cp.Visits[current] > limit {
+			return cp.State, fmt.Errorf("%w: node '%s' reached its limit of %d visits", ErrMaxVisits, current, limit)
+		}
+
+		logging.Info("Executing node: %s", current)
+		updated, err := nodeFunc(ctx, cp.State)
+		if err != nil {
+			return updated, fmt.Errorf("error executing node '%s': %w", current, err)
 		}
+
+		next, err := g.next(ctx, current, updated)
+		if err != nil {
+			return updated, err
+		}
+		logging.Debug("Transitioning from '%s' to '%s'", current, next)
+
+		cp.State = updated
+		cp.Step++
+		cp.Node = current
+		cp.Next = next
+		cp.Done = next == GraphEnd
+		if err := g.saveCheckpoint(ctx, cp); err != nil {
+			return cp.State, err
+		}
+	}
+
+	if cp.Next == GraphEnd {
+		return cp.State, nil
+	}
+	return cp.State, fmt.Errorf("%w (%d iterations, next node '%s')", ErrMaxIterations, maxIterations, cp.Next)
+}
+
+// errCheckpointSave marks a failed Save; a run that cannot be checkpointed
+// cannot have its failure recorded either.
+var errCheckpointSave = errors.New("failed to checkpoint")
+
+func (g *Graph[S]) saveCheckpoint(ctx context.Context, cp *Checkpoint[S]) error {
+	if g.checkpointer == nil {
+		return nil
+	}
+	cp.UpdatedAt = time.Now().UTC()
+	if err := g.checkpointer.Save(ctx, *cp); err != nil {
+		return fmt.Errorf("%w: run '%s' after node '%s': %w", errCheckpointSave, cp.RunID, cp.Node, err)
+	}
+	return nil
+}
+
+// next resolves the node that follows current, GraphEnd if it has no outgoing edges.
+func (g *Graph[S]) next(ctx context.Context, current string, state S) (string, error) {
+	e, ok := g.edges[current]
+	if !ok {
+		logging.Info("Node '%s' has no outgoing edges. Implicitly ending path.", current)
+		return GraphEnd, nil
+	}
+	if !e.isConditional() {
+		return e.to, nil
+	}
+
+	routeKey, err := e.router(ctx, state)
+	if err != nil {
+		return "", fmt.Errorf("failed to route from node '%s': %w", current, err)
+	}
+	next, found := e.routes[routeKey]
+	if !found {
+		return "", fmt.Errorf("conditional edge from '%s' has no mapping for decision '%s'", current, routeKey)
 	}
+	logging.Debug("Node '%s' router decided '%s'", current, routeKey)
+	return next, nil
 }
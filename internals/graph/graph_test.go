@@ -0,0 +1,318 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// appendNode returns a node that appends name to the trail.
+func appendNode(name string) NodeFunc[string] {
+	return func(ctx context.Context, trail string) (string, error) {
+		if trail != "" {
+			trail += ","
+		}
+		return trail + name, nil
+	}
+}
+
+// newReviewGraph builds a graph that drafts, checks and then either sends,
+// forwards or redrafts, as route(trail) decides after each check:
+//
+//	draft -> check -(send)-> send -> END
+//	               -(forward)-> forward (no edges, ends the run)
+//	               -(redraft)-> draft
+func newReviewGraph(t *testing.T, route func(trail string) (string, error)) *Graph[string] {
+	t.Helper()
+	g := NewGraph[string]()
+	for _, name := range []string{"draft", "check", "send", "forward"} {
+		if err := g.AddNode(name, appendNode(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	g.SetEntryPoint("draft")
+	g.AddEdge("draft", "check")
+	g.AddConditionalEdges("check", func(ctx context.Context, trail string) (string, error) {
+		return route(trail)
+	}, map[string]string{"send": "send", "forward": "forward", "redraft": "draft"})
+	g.DeclareRoutes("check", "send", "forward", "redraft")
+	g.AddEdge("send", GraphEnd)
+	g.SetMaxVisits("draft", 3)
+	if _, err := g.Compile(); err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	return g
+}
+
+func TestExecuteRoutes(t *testing.T) {
+	boom := errors.New("boom")
+	tests := []struct {
+		name      string
+		route     func(trail string) (string, error)
+		wantTrail string
+		wantErr   error  // Matched with errors.Is
+		wantText  string // Contained in the error
+	}{
+		{
+			name:      "direct route to END",
+			route:     func(string) (string, error) { return "send", nil },
+			wantTrail: "draft,check,send",
+		},
+		{
+			name:      "node without edges ends the run",
+			route:     func(string) (string, error) { return "forward", nil },
+			wantTrail: "draft,check,forward",
+		},
+		{
+			name: "cycle until the router lets go",
+			route: func(trail string) (string, error) {
+				if strings.Count(trail, "draft") < 2 {
+					return "redraft", nil
+				}
+				return "send", nil
+			},
+			wantTrail: "draft,check,draft,check,send",
+		},
+		{
+			name:      "visit limit stops an endless cycle",
+			route:     func(string) (string, error) { return "redraft", nil },
+			wantTrail: "draft,check,draft,check,draft,check",
+			wantErr:   ErrMaxVisits,
+		},
+		{
+			name:      "unmapped route key",
+			route:     func(string) (string, error) { return "archive", nil },
+			wantTrail: "draft,check",
+			wantText:  "no mapping for decision 'archive'",
+		},
+		{
+			name:      "router error",
+			route:     func(string) (string, error) { return "", boom },
+			wantTrail: "draft,check",
+			wantErr:   boom,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trail, err := newReviewGraph(t, tt.route).Execute(context.Background(), "", 20)
+			if trail != tt.wantTrail {
+				t.Errorf("trail = %q, want %q", trail, tt.wantTrail)
+			}
+			switch {
+			case tt.wantErr != nil && !errors.Is(err, tt.wantErr):
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			case tt.wantText != "" && (err == nil || !strings.Contains(err.Error(), tt.wantText)):
+				t.Errorf("error = %v, want one containing %q", err, tt.wantText)
+			case tt.wantErr == nil && tt.wantText == "" && err != nil:
+				t.Errorf("error = %v, want nil", err)
+			}
+		})
+	}
+}
+
+func TestExecuteMaxIterations(t *testing.T) {
+	g := newReviewGraph(t, func(string) (string, error) { return "send", nil })
+	if _, err := g.Execute(context.Background(), "", 2); !errors.Is(err, ErrMaxIterations) {
+		t.Fatalf("error = %v, want %v", err, ErrMaxIterations)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	noop := appendNode("noop")
+	router := func(ctx context.Context, s string) (string, error) { return "a", nil }
+	tests := []struct {
+		name  string
+		build func(g *Graph[string])
+		want  []string // "<kind> <severity> <node>" of every problem, in order
+	}{
+		{
+			name: "valid",
+			build: func(g *Graph[string]) {
+				g.AddNode("a", noop)
+				g.SetEntryPoint("a")
+				g.AddEdge("a", GraphEnd)
+			},
+		},
+		{
+			name: "no entry point",
+			build: func(g *Graph[string]) {
+				g.AddNode("a", noop)
+			},
+			want: []string{"missing_entry_point error "},
+		},
+		{
+			name: "unknown target",
+			build: func(g *Graph[string]) {
+				g.AddNode("a", noop)
+				g.SetEntryPoint("a")
+				g.AddEdge("a", "b")
+			},
+			want: []string{"undefined_node error a", "no_path_to_end error a"},
+		},
+		{
+			name: "unreachable node",
+			build: func(g *Graph[string]) {
+				g.AddNode("a", noop)
+				g.AddNode("orphan", noop)
+				g.SetEntryPoint("a")
+				g.AddEdge("a", GraphEnd)
+			},
+			want: []string{"unreachable_node warning orphan"},
+		},
+		{
+			name: "missing END",
+			build: func(g *Graph[string]) {
+				g.AddNode("a", noop)
+				g.AddNode("b", noop)
+				g.SetEntryPoint("a")
+				g.AddEdge("a", "b")
+				g.AddEdge("b", "a")
+				g.SetMaxVisits("a", 2)
+			},
+			want: []string{"no_path_to_end error a", "no_path_to_end error b"},
+		},
+		{
+			name: "unguarded cycle",
+			build: func(g *Graph[string]) {
+				g.AddNode("a", noop)
+				g.AddNode("b", noop)
+				g.SetEntryPoint("a")
+				g.AddEdge("a", "b")
+				g.AddConditionalEdges("b", router, map[string]string{"a": "a", "done": GraphEnd})
+				g.DeclareRoutes("b", "a", "done")
+			},
+			want: []string{"unbounded_cycle error a"},
+		},
+		{
+			name: "undeclared routes",
+			build: func(g *Graph[string]) {
+				g.AddNode("a", noop)
+				g.SetEntryPoint("a")
+				g.AddConditionalEdges("a", router, map[string]string{"a": GraphEnd})
+			},
+			want: []string{"undeclared_routes warning a"},
+		},
+		{
+			name: "routes declared without conditional edges",
+			build: func(g *Graph[string]) {
+				g.AddNode("a", noop)
+				g.SetEntryPoint("a")
+				g.AddEdge("a", GraphEnd)
+				g.DeclareRoutes("a", "x")
+			},
+			want: []string{"undeclared_routes error a"},
+		},
+		{
+			name: "declared route without mapping and unused mapping",
+			build: func(g *Graph[string]) {
+				g.AddNode("a", noop)
+				g.SetEntryPoint("a")
+				g.AddConditionalEdges("a", router, map[string]string{"done": GraphEnd, "legacy": GraphEnd})
+				g.DeclareRoutes("a", "done", "retry")
+			},
+			want: []string{"missing_route error a", "unused_route warning a"},
+		},
+		{
+			name: "visit limit on an undefined node",
+			build: func(g *Graph[string]) {
+				g.AddNode("a", noop)
+				g.SetEntryPoint("a")
+				g.AddEdge("a", GraphEnd)
+				g.SetMaxVisits("ghost", 1)
+			},
+			want: []string{"undefined_node error ghost"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGraph[string]()
+			tt.build(g)
+			var got []string
+			hasError := false
+			for _, p := range g.Validate() {
+				got = append(got, string(p.Kind)+" "+string(p.Severity)+" "+p.Node)
+				hasError = hasError || p.Severity == SeverityError
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("problems:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+
+			// Compile fails with exactly the errors; warnings alone do not stop it.
+			_, err := g.Compile()
+			var compileErr *CompileError
+			if hasError != errors.As(err, &compileErr) {
+				t.Fatalf("Compile error = %v, want a *CompileError: %v", err, hasError)
+			}
+			if compileErr != nil {
+				for _, p := range compileErr.Problems {
+					if p.Severity != SeverityError {
+						t.Errorf("CompileError lists warning %s", p)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestExport(t *testing.T) {
+	g := newReviewGraph(t, func(string) (string, error) { return "send", nil })
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{
+			format: FormatMermaid,
+			want: `flowchart TD
+    start((START))
+    n0["draft"]
+    n1["check"]
+    n2["send"]
+    n3["forward"]
+    finish((END))
+    start --> n0
+    n0 --> n1
+    n1 -.->|"forward"| n3
+    n1 -.->|"redraft"| n0
+    n1 -.->|"send"| n2
+    n2 --> finish
+    n3 --> finish
+`,
+		},
+		{
+			format: FormatDOT,
+			want: `digraph workflow {
+    rankdir=TB;
+    node [shape=box, style=rounded];
+    "__START__" [shape=circle, label="START"];
+    "draft";
+    "check";
+    "send";
+    "forward";
+    "__END__" [shape=doublecircle, label="END"];
+    "__START__" -> "draft";
+    "draft" -> "check";
+    "check" -> "forward" [label="forward", style=dashed];
+    "check" -> "draft" [label="redraft", style=dashed];
+    "check" -> "send" [label="send", style=dashed];
+    "send" -> "__END__";
+    "forward" -> "__END__";
+}
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			got, err := g.Export(tt.format)
+			if err != nil {
+				t.Fatalf("Export: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Export(%q) =\n%s\nwant:\n%s", tt.format, got, tt.want)
+			}
+		})
+	}
+	if _, err := g.Export("svg"); err == nil {
+		t.Error("Export of an unknown format succeeded")
+	}
+}
@@ -0,0 +1,374 @@
+package graph
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Severity tells whether a Problem prevents the graph from compiling.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// ProblemKind classifies a Problem found while validating a graph.
+type ProblemKind string
+
+const (
+	ProblemMissingEntryPoint ProblemKind = "missing_entry_point"
+	ProblemUndefinedNode     ProblemKind = "undefined_node"
+	ProblemUnreachableNode   ProblemKind = "unreachable_node"
+	ProblemNoPathToEnd       ProblemKind = "no_path_to_end"
+	ProblemMissingRoute      ProblemKind = "missing_route"
+	ProblemUnusedRoute       ProblemKind = "unused_route"
+	ProblemUndeclaredRoutes  ProblemKind = "undeclared_routes"
+	ProblemUnboundedCycle    ProblemKind = "unbounded_cycle"
+)
+
+// Problem is a single finding of graph validation.
+type Problem struct {
+	Kind     ProblemKind
+	Severity Severity
+	Node     string // Node the problem is attached to, empty for graph-wide problems
+	Message  string
+}
+
+func (p Problem) String() string {
+	if p.Node == "" {
+		return fmt.Sprintf("%s: %s", p.Kind, p.Message)
+	}
+	return fmt.Sprintf("%s at '%s': %s", p.Kind, p.Node, p.Message)
+}
+
+// CompileError lists every error that prevented a graph from compiling.
+type CompileError struct {
+	Problems []Problem
+}
+
+func (e *CompileError) Error() string {
+	lines := make([]string, 0, len(e.Problems))
+	for _, p := range e.Problems {
+		lines = append(lines, "  - "+p.String())
+	}
+	return fmt.Sprintf("graph has %d problem(s):\n%s", len(e.Problems), strings.Join(lines, "\n"))
+}
+
+// Validate reports every problem in the graph definition, errors and warnings alike.
+func (g *Graph[S]) Validate() []Problem {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.validate()
+}
+
+func (g *Graph[S]) validate() []Problem {
+	var problems []Problem
+	add := func(kind ProblemKind, severity Severity, node, format string, args ...interface{}) {
+		problems = append(problems, Problem{Kind: kind, Severity: severity, Node: node, Message: fmt.Sprintf(format, args...)})
+	}
+
+	entryOK := false
+	switch _, ok := g.nodes[g.entryPoint]; {
+	case g.entryPoint == "":
+		add(ProblemMissingEntryPoint, SeverityError, "", "no entry point defined for the graph")
+	case !ok:
+		add(ProblemMissingEntryPoint, SeverityError, g.entryPoint, "entry point node is not defined")
+	default:
+		entryOK = true
+	}
+
+	for _, from := range g.edgeSources() {
+		if _, ok := g.nodes[from]; !ok {
+			add(ProblemUndefinedNode, SeverityError, from, "outgoing edges are defined for an undefined node")
+		}
+		for _, to := range g.targets(from) {
+			if _, ok := g.nodes[to]; !ok && to != GraphEnd {
+				add(ProblemUndefinedNode, SeverityError, from, "edge targets undefined node '%s'", to)
+			}
+		}
+	}
+
+	problems = append(problems, g.validateRoutes()...)
+
+	for _, name := range sortedKeys(g.maxVisits) {
+		if _, ok := g.nodes[name]; !ok {
+			add(ProblemUndefinedNode, SeverityError, name, "visit limit set on an undefined node")
+		}
+	}
+
+	if entryOK {
+		reachable := g.reachableFrom(g.entryPoint)
+		for _, name := range g.nodeOrder {
+			if !reachable[name] {
+				add(ProblemUnreachableNode, SeverityWarning, name, "node cannot be reached from entry point '%s'", g.entryPoint)
+			}
+		}
+	}
+
+	canEnd := g.nodesReachingEnd()
+	for _, name := range g.nodeOrder {
+		if !canEnd[name] {
+			add(ProblemNoPathToEnd, SeverityError, name, "no path from this node leads to END")
+		}
+	}
+
+	for _, cycle := range g.unguardedCycles() {
+		add(ProblemUnboundedCycle, SeverityError, cycle[0], "cycle %s has no node guarded by SetMaxVisits", strings.Join(cycle, " -> "))
+	}
+
+	return problems
+}
+
+// validateRoutes compares declared router outputs with the conditional edge maps.
+func (g *Graph[S]) validateRoutes() []Problem {
+	var problems []Problem
+	for _, from := range g.edgeSources() {
+		e := g.edges[from]
+		if !e.isConditional() {
+			continue
+		}
+		declared, ok := g.routeSets[from]
+		if !ok {
+			problems = append(problems, Problem{Kind: ProblemUndeclaredRoutes, Severity: SeverityWarning, Node: from,
+				Message: "router outputs are not declared, missing route keys cannot be detected"})
+			continue
+		}
+		declaredSet := make(map[string]bool, len(declared))
+		for _, key := range declared {
+			declaredSet[key] = true
+			if _, mapped := e.routes[key]; !mapped {
+				problems = append(problems, Problem{Kind: ProblemMissingRoute, Severity: SeverityError, Node: from,
+					Message: fmt.Sprintf("router can return '%s' but the conditional edge map has no entry for it", key)})
+			}
+		}
+		for _, key := range sortedKeys(e.routes) {
+			if !declaredSet[key] {
+				problems = append(problems, Problem{Kind: ProblemUnusedRoute, Severity: SeverityWarning, Node: from,
+					Message: fmt.Sprintf("conditional edge map entry '%s' is never returned by the router", key)})
+			}
+		}
+	}
+	for _, from := range sortedKeys(g.routeSets) {
+		if e, ok := g.edges[from]; !ok || !e.isConditional() {
+			problems = append(problems, Problem{Kind: ProblemUndeclaredRoutes, Severity: SeverityError, Node: from,
+				Message: "routes are declared for a node without conditional edges"})
+		}
+	}
+	return problems
+}
+
+// targets lists the nodes an edge out of from can lead to, in a stable order.
+func (g *Graph[S]) targets(from string) []string {
+	e, ok := g.edges[from]
+	if !ok {
+		return nil
+	}
+	if !e.isConditional() {
+		return []string{e.to}
+	}
+	seen := make(map[string]bool, len(e.routes))
+	var targets []string
+	for _, key := range sortedKeys(e.routes) {
+		if to := e.routes[key]; !seen[to] {
+			seen[to] = true
+			targets = append(targets, to)
+		}
+	}
+	return targets
+}
+
+// edgeSources returns the nodes with outgoing edges, defined nodes first in insertion order.
+func (g *Graph[S]) edgeSources() []string {
+	var sources []string
+	for _, name := range g.nodeOrder {
+		if _, ok := g.edges[name]; ok {
+			sources = append(sources, name)
+		}
+	}
+	for _, name := range sortedKeys(g.edges) {
+		if _, ok := g.nodes[name]; !ok {
+			sources = append(sources, name)
+		}
+	}
+	return sources
+}
+
+func (g *Graph[S]) reachableFrom(start string) map[string]bool {
+	reachable := map[string]bool{start: true}
+	queue := []string{start}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, to := range g.targets(current) {
+			if !reachable[to] {
+				reachable[to] = true
+				queue = append(queue, to)
+			}
+		}
+	}
+	return reachable
+}
+
+// nodesReachingEnd returns the nodes from which some path reaches END. A node
+// without outgoing edges ends the run implicitly and therefore counts as well.
+func (g *Graph[S]) nodesReachingEnd() map[string]bool {
+	reverse := make(map[string][]string)
+	var queue []string
+	canEnd := make(map[string]bool)
+	for _, name := range g.nodeOrder {
+		if _, ok := g.edges[name]; !ok {
+			canEnd[name] = true
+			queue = append(queue, name)
+		}
+		for _, to := range g.targets(name) {
+			if to == GraphEnd {
+				if !canEnd[name] {
+					canEnd[name] = true
+					queue = append(queue, name)
+				}
+				continue
+			}
+			reverse[to] = append(reverse[to], name)
+		}
+	}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, from := range reverse[current] {
+			if !canEnd[from] {
+				canEnd[from] = true
+				queue = append(queue, from)
+			}
+		}
+	}
+	return canEnd
+}
+
+// unguardedCycles finds cycles that never pass through a node with a visit
+// limit. Guarded nodes are removed and one example cycle is reported for every
+// strongly connected component that still loops.
+func (g *Graph[S]) unguardedCycles() [][]string {
+	index := 0
+	indices := make(map[string]int)
+	lowlink := make(map[string]int)
+	onStack := make(map[string]bool)
+	var stack []string
+	var cycles [][]string
+
+	successors := func(name string) []string {
+		var next []string
+		for _, to := range g.targets(name) {
+			if _, defined := g.nodes[to]; !defined {
+				continue
+			}
+			if _, guarded := g.maxVisits[to]; guarded {
+				continue
+			}
+			next = append(next, to)
+		}
+		return next
+	}
+
+	var strongConnect func(name string)
+	strongConnect = func(name string) {
+		indices[name] = index
+		lowlink[name] = index
+		index++
+		stack = append(stack, name)
+		onStack[name] = true
+
+		for _, to := range successors(name) {
+			if _, visited := indices[to]; !visited {
+				strongConnect(to)
+				lowlink[name] = min(lowlink[name], lowlink[to])
+			} else if onStack[to] {
+				lowlink[name] = min(lowlink[name], indices[to])
+			}
+		}
+
+		if lowlink[name] != indices[name] {
+			return
+		}
+		var component []string
+		for {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[top] = false
+			component = append(component, top)
+			if top == name {
+				break
+			}
+		}
+		if len(component) > 1 || containsString(successors(name), name) {
+			cycles = append(cycles, g.exampleCycle(name, component, successors))
+		}
+	}
+
+	for _, name := range g.nodeOrder {
+		if _, guarded := g.maxVisits[name]; guarded {
+			continue
+		}
+		if _, visited := indices[name]; !visited {
+			strongConnect(name)
+		}
+	}
+	return cycles
+}
+
+// exampleCycle walks the component from start back to start for the error message.
+func (g *Graph[S]) exampleCycle(start string, component []string, successors func(string) []string) []string {
+	inComponent := make(map[string]bool, len(component))
+	for _, name := range component {
+		inComponent[name] = true
+	}
+	parent := map[string]string{}
+	queue := []string{start}
+	visited := map[string]bool{}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, to := range successors(current) {
+			if !inComponent[to] {
+				continue
+			}
+			if to == start {
+				path := []string{start}
+				for node := current; node != start; node = parent[node] {
+					path = append([]string{node}, path...)
+				}
+				if current != start {
+					path = append([]string{start}, path...)
+				} else {
+					path = append(path, start)
+				}
+				return path
+			}
+			if !visited[to] {
+				visited[to] = true
+				parent[to] = current
+				queue = append(queue, to)
+			}
+		}
+	}
+	return component
+}
+
+func containsString(values []string, target string) bool {
+	for _, v := range values {
+		if v == target {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,133 @@
+// Package inbox turns unanswered emails into workflow runs.
+package inbox
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"mailflow/internals/ai"
+	"mailflow/internals/email"
+	"mailflow/internals/review"
+	"mailflow/pkg/logging"
+)
+
+// DefaultBatchSize caps how many unanswered emails one poll picks up.
+const DefaultBatchSize = 50
+
+// Poller fetches unanswered emails and submits each to a Pool as its own workflow run.
+type Poller struct {
+	mail      email.Service
+	pool      *Pool
+	batchSize int64
+	reviews   *review.Store
+	wake      chan struct{}
+}
+
+// NewPoller creates a poller. A batchSize of 0 selects DefaultBatchSize.
+func NewPoller(mail email.Service, pool *Pool, batchSize int64) *Poller {
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	return &Poller{mail: mail, pool: pool, batchSize: batchSize, wake: make(chan struct{}, 1)}
+}
+
+// SetReviews makes every poll also act on review decisions: approved replies
+// are sent and rejected ones are redrafted with the reviewer's feedback.
+func (p *Poller) SetReviews(reviews *review.Store) {
+	p.reviews = reviews
+}
+
+// PollOnce submits every unanswered email, and every decided review item when
+// a review queue is set, to the pool and returns how many were accepted. Jobs
+// still queued or in progress are skipped. Results are delivered through the
+// pool's callback.
+func (p *Poller) PollOnce(ctx context.Context) (int, error) {
+	var emails []email.EmailInfo
+	var err error
+	if fetcher, ok := p.mail.(email.NewEmailFetcher); ok {
+		emails, err = fetcher.FetchNewEmails(ctx, p.batchSize)
+	} else {
+		emails, err = p.mail.FetchUnansweredEmails(ctx, p.batchSize)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error loading new emails: %w", err)
+	}
+	logging.Info("Fetched %d unanswered email(s)", len(emails))
+
+	submitted := 0
+	for _, msg := range emails {
+		accepted, err := p.pool.Submit(ctx, msg)
+		if err != nil {
+			return submitted, err
+		}
+		if accepted {
+			submitted++
+		}
+	}
+
+	if p.reviews == nil {
+		return submitted, nil
+	}
+	decided, err := p.submitReviewDecisions(ctx)
+	return submitted + decided, err
+}
+
+func (p *Poller) submitReviewDecisions(ctx context.Context) (int, error) {
+	items, err := p.reviews.List(ctx, review.StatusApproved, review.StatusRejected)
+	if err != nil {
+		return 0, fmt.Errorf("error loading review decisions: %w", err)
+	}
+
+	workflow := p.pool.workflow
+	submitted := 0
+	for _, item := range items {
+		run := func(ctx context.Context) ai.RunResult { return workflow.SendApproved(ctx, item) }
+		if item.Status == review.StatusRejected {
+			run = func(ctx context.Context) ai.RunResult { return workflow.Redraft(ctx, item) }
+		}
+		accepted, err := p.pool.SubmitTask(ctx, "review:"+item.ID, item.Email, run)
+		if err != nil {
+			return submitted, err
+		}
+		if accepted {
+			submitted++
+		}
+	}
+	return submitted, nil
+}
+
+// Run polls immediately and then every interval until ctx is cancelled. A
+// failed poll is logged and retried on the next tick rather than stopping the daemon.
+func (p *Poller) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		submitted, err := p.PollOnce(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			logging.Error("Inbox poll failed: %v", err)
+		} else if submitted > 0 {
+			logging.Info("Submitted %d email(s) for processing", submitted)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		case <-p.wake:
+			logging.Debug("Poll triggered by mailbox notification")
+		}
+	}
+}
+
+// Wake makes a running Run poll now instead of waiting for the next tick,
+// e.g. when a push notification reports new mail. It never blocks.
+func (p *Poller) Wake() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,248 @@
+package inbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+
+	"mailflow/internals/ai"
+	"mailflow/internals/email"
+	"mailflow/internals/ledger"
+	"mailflow/pkg/logging"
+
+	"github.com/google/uuid"
+)
+
+// DefaultWorkers is the number of emails processed in parallel when none is configured.
+const DefaultWorkers = 4
+
+// ErrPoolClosed is returned by Submit after Shutdown has been called.
+var ErrPoolClosed = errors.New("inbox: worker pool is shut down")
+
+// ErrRunPanicked is the error of a run that panicked instead of returning.
+var ErrRunPanicked = errors.New("inbox: run panicked")
+
+// Pool runs per-email workflows on a fixed number of workers. Emails of the
+// same thread are never processed concurrently: they queue behind the one in
+// progress and run in submission order.
+type Pool struct {
+	workflow *ai.Workflow
+	ledger   *ledger.Ledger // Optional, skips messages that were already processed
+	onResult func(ai.RunResult)
+	jobs     chan job
+
+	// Runs use their own context, so a shutdown signal lets in-flight emails
+	// finish instead of aborting them mid-draft.
+	runCtx    context.Context
+	cancelRun context.CancelFunc
+
+	sendMu sync.RWMutex // Held for writing only while closing jobs
+	closed bool
+
+	mu       sync.Mutex
+	threads  map[string][]job // Busy threads and the jobs waiting behind them
+	inFlight map[string]bool  // Job IDs queued or running, to drop duplicate submissions
+
+	pending sync.WaitGroup // Accepted emails not yet finished
+	workers sync.WaitGroup
+}
+
+// NewPool starts workers goroutines (DefaultWorkers if workers <= 0). When
+// led is not nil, every email is claimed in it before its run and its outcome
+// recorded afterwards. onResult is called from the worker goroutines after every run.
+func NewPool(workflow *ai.Workflow, workers int, led *ledger.Ledger, onResult func(ai.RunResult)) *Pool {
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	if onResult == nil {
+		onResult = func(ai.RunResult) {}
+	}
+	runCtx, cancelRun := context.WithCancel(context.Background())
+	p := &Pool{
+		workflow:  workflow,
+		ledger:    led,
+		onResult:  onResult,
+		jobs:      make(chan job, workers),
+		runCtx:    runCtx,
+		cancelRun: cancelRun,
+		threads:   make(map[string][]job),
+		inFlight:  make(map[string]bool),
+	}
+	p.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// job is a unit of work on the thread of msg. Email jobs run the workflow
+// and hold a ledger claim; tasks run a follow-up such as sending an approved reply.
+type job struct {
+	id      string
+	runID   string // Workflow run claiming msg in the ledger, empty for tasks
+	msg     email.EmailInfo
+	run     func(context.Context) ai.RunResult
+	claimed bool
+}
+
+// Submit queues msg for processing. It blocks while all workers are busy, and
+// reports false when msg is already queued or running, or the ledger shows it
+// was processed before.
+func (p *Pool) Submit(ctx context.Context, msg email.EmailInfo) (bool, error) {
+	runID := uuid.NewString()
+	return p.submit(ctx, job{
+		id:    msg.ID,
+		runID: runID,
+		msg:   msg,
+		run: func(ctx context.Context) ai.RunResult {
+			return p.workflow.RunWithID(ctx, runID, msg)
+		},
+		claimed: p.ledger != nil,
+	})
+}
+
+// SubmitTask queues run on the thread of msg, so it never overlaps with a
+// workflow run of the same conversation. Tasks are deduplicated by id and are
+// not claimed in the ledger; their outcome is recorded only when they succeed,
+// so a failing task never makes msg eligible for a fresh run.
+func (p *Pool) SubmitTask(ctx context.Context, id string, msg email.EmailInfo, run func(context.Context) ai.RunResult) (bool, error) {
+	return p.submit(ctx, job{id: id, msg: msg, run: run})
+}
+
+func (p *Pool) submit(ctx context.Context, j job) (bool, error) {
+	p.sendMu.RLock()
+	defer p.sendMu.RUnlock()
+	if p.closed {
+		return false, ErrPoolClosed
+	}
+
+	key := threadKey(j.msg)
+	p.mu.Lock()
+	if p.inFlight[j.id] {
+		p.mu.Unlock()
+		return false, nil
+	}
+	p.inFlight[j.id] = true
+	p.mu.Unlock()
+
+	if j.claimed {
+		claimed, err := p.ledger.Claim(ctx, j.msg, j.runID)
+		if err != nil || !claimed {
+			p.mu.Lock()
+			delete(p.inFlight, j.id)
+			p.mu.Unlock()
+			return false, err
+		}
+	}
+
+	p.mu.Lock()
+	p.pending.Add(1)
+	if waiting, busy := p.threads[key]; busy {
+		p.threads[key] = append(waiting, j)
+		p.mu.Unlock()
+		logging.Debug("Thread %s is busy, queued job %s behind it", key, j.id)
+		return true, nil
+	}
+	p.threads[key] = nil
+	p.mu.Unlock()
+
+	select {
+	case p.jobs <- j:
+		return true, nil
+	case <-ctx.Done():
+		// Nothing of this thread runs yet, so give back j and anything queued behind it.
+		for next, ok := j, true; ok; next, ok = p.finish(next) {
+			if next.claimed {
+				if err := p.ledger.Release(context.Background(), next.msg.ID); err != nil {
+					logging.Error("%v", err)
+				}
+			}
+		}
+		return false, ctx.Err()
+	}
+}
+
+// Shutdown stops accepting emails and waits for queued and in-flight runs to
+// finish. If ctx expires first, running workflows are cancelled and ctx's
+// error is returned.
+func (p *Pool) Shutdown(ctx context.Context) error {
+	p.sendMu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.jobs)
+	}
+	p.sendMu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		p.pending.Wait()
+		p.workers.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		p.cancelRun()
+		return nil
+	case <-ctx.Done():
+		logging.Info("Shutdown deadline reached, cancelling in-flight workflow runs")
+		p.cancelRun()
+		<-drained
+		return ctx.Err()
+	}
+}
+
+func (p *Pool) work() {
+	defer p.workers.Done()
+	for j := range p.jobs {
+		// Keep draining the thread on this worker, which preserves per-thread order.
+		for next, ok := j, true; ok; next, ok = p.finish(next) {
+			result := p.run(next)
+			if p.ledger != nil && (next.claimed || result.Err == nil) {
+				// Recorded even when shutdown cancelled the run, hence not runCtx.
+				if err := p.ledger.Finish(context.Background(), result); err != nil {
+					logging.Error("%v", err)
+				}
+			}
+			p.onResult(result)
+		}
+	}
+}
+
+// run executes j, turning a panic into a failed run so the worker survives,
+// the thread moves on to its next job and Shutdown is not left waiting.
+func (p *Pool) run(j job) (result ai.RunResult) {
+	defer func() {
+		if r := recover(); r != nil {
+			logging.Error("Job %s panicked: %v\n%s", j.id, r, debug.Stack())
+			result = ai.RunResult{RunID: j.runID, Email: j.msg, Outcome: ai.OutcomeFailed, Err: fmt.Errorf("%w: %v", ErrRunPanicked, r)}
+		}
+	}()
+	return j.run(p.runCtx)
+}
+
+// finish marks j as done and hands back the next job waiting on its thread, if any.
+func (p *Pool) finish(j job) (job, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	defer p.pending.Done()
+
+	delete(p.inFlight, j.id)
+	key := threadKey(j.msg)
+	waiting := p.threads[key]
+	if len(waiting) == 0 {
+		delete(p.threads, key)
+		return job{}, false
+	}
+	p.threads[key] = waiting[1:]
+	return waiting[0], true
+}
+
+func threadKey(msg email.EmailInfo) string {
+	if msg.ThreadID != "" {
+		return msg.ThreadID
+	}
+	return msg.ID
+}
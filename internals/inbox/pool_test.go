@@ -0,0 +1,258 @@
+package inbox
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"mailflow/internals/ai"
+	"mailflow/internals/email"
+	"mailflow/internals/email/memory"
+	"mailflow/internals/ledger"
+	"mailflow/internals/llm"
+)
+
+// results collects the results a pool reports.
+type results struct {
+	mu  sync.Mutex
+	all []ai.RunResult
+}
+
+func (r *results) add(result ai.RunResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.all = append(r.all, result)
+}
+
+func (r *results) get() []ai.RunResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]ai.RunResult(nil), r.all...)
+}
+
+func newTestPool(t *testing.T, workers int) (*Pool, *results) {
+	t.Helper()
+	var got results
+	pool := NewPool(nil, workers, nil, got.add)
+	t.Cleanup(func() { pool.Shutdown(context.Background()) })
+	return pool, &got
+}
+
+// task returns a run that records its ID in order and then waits for release,
+// if it is not nil.
+func task(id string, order *[]string, mu *sync.Mutex, release <-chan struct{}) func(context.Context) ai.RunResult {
+	return func(ctx context.Context) ai.RunResult {
+		mu.Lock()
+		*order = append(*order, id)
+		mu.Unlock()
+		if release != nil {
+			<-release
+		}
+		return ai.RunResult{RunID: id, Outcome: ai.OutcomeDrafted}
+	}
+}
+
+func submitTask(t *testing.T, pool *Pool, id, thread string, run func(context.Context) ai.RunResult) bool {
+	t.Helper()
+	accepted, err := pool.SubmitTask(context.Background(), id, email.EmailInfo{ID: id, ThreadID: thread}, run)
+	if err != nil {
+		t.Fatalf("SubmitTask %s: %v", id, err)
+	}
+	return accepted
+}
+
+func shutdown(t *testing.T, pool *Pool) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := pool.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+}
+
+func TestPoolSerializesThreads(t *testing.T) {
+	pool, got := newTestPool(t, 2)
+	var (
+		mu    sync.Mutex
+		order []string
+	)
+	release := make(chan struct{})
+	otherDone := make(chan struct{})
+
+	// a1 holds its thread until the job on another thread has finished, which
+	// needs the second worker; a2 and a3 must wait behind a1 all along.
+	submitTask(t, pool, "a1", "a", task("a1", &order, &mu, release))
+	submitTask(t, pool, "a2", "a", task("a2", &order, &mu, nil))
+	submitTask(t, pool, "a3", "a", task("a3", &order, &mu, nil))
+	submitTask(t, pool, "b1", "b", func(ctx context.Context) ai.RunResult {
+		defer close(otherDone)
+		return task("b1", &order, &mu, nil)(ctx)
+	})
+
+	select {
+	case <-otherDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a job on another thread did not run while thread a was busy")
+	}
+	mu.Lock()
+	for _, id := range order {
+		if id == "a2" || id == "a3" {
+			t.Errorf("%s started while a1 was still running (order %v)", id, order)
+		}
+	}
+	mu.Unlock()
+	close(release)
+	shutdown(t, pool)
+
+	var thread []string
+	for _, id := range order {
+		if id != "b1" {
+			thread = append(thread, id)
+		}
+	}
+	if len(thread) != 3 || thread[0] != "a1" || thread[1] != "a2" || thread[2] != "a3" {
+		t.Errorf("thread a ran as %v, want [a1 a2 a3]", thread)
+	}
+	if n := len(got.get()); n != 4 {
+		t.Errorf("reported %d results, want 4", n)
+	}
+}
+
+func TestPoolDropsDuplicates(t *testing.T) {
+	pool, got := newTestPool(t, 2)
+	var (
+		mu    sync.Mutex
+		order []string
+	)
+	release := make(chan struct{})
+
+	if !submitTask(t, pool, "m1", "t1", task("m1", &order, &mu, release)) {
+		t.Fatal("first submission was not accepted")
+	}
+	// Neither a running nor a queued duplicate is accepted.
+	if submitTask(t, pool, "m1", "t1", task("m1", &order, &mu, nil)) {
+		t.Error("duplicate of a running job was accepted")
+	}
+	if !submitTask(t, pool, "m2", "t1", task("m2", &order, &mu, nil)) {
+		t.Fatal("second message of the thread was not accepted")
+	}
+	if submitTask(t, pool, "m2", "t1", task("m2", &order, &mu, nil)) {
+		t.Error("duplicate of a queued job was accepted")
+	}
+	close(release)
+	shutdown(t, pool)
+
+	if len(order) != 2 || len(got.get()) != 2 {
+		t.Errorf("ran %v and reported %d results, want m1 and m2 once each", order, len(got.get()))
+	}
+}
+
+func TestPoolShutdownDrainsInFlightJobs(t *testing.T) {
+	pool, got := newTestPool(t, 2)
+	var (
+		mu    sync.Mutex
+		order []string
+	)
+	slow := func(id string) func(context.Context) ai.RunResult {
+		return func(ctx context.Context) ai.RunResult {
+			time.Sleep(20 * time.Millisecond)
+			return task(id, &order, &mu, nil)(ctx)
+		}
+	}
+	for _, id := range []string{"a1", "a2", "b1", "c1"} {
+		submitTask(t, pool, id, id[:1], slow(id))
+	}
+	shutdown(t, pool)
+
+	if n := len(got.get()); n != 4 {
+		t.Errorf("Shutdown returned after %d of 4 jobs finished", n)
+	}
+	if _, err := pool.SubmitTask(context.Background(), "late", email.EmailInfo{ID: "late"}, slow("late")); !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("SubmitTask after Shutdown returned %v, want %v", err, ErrPoolClosed)
+	}
+}
+
+func TestPoolShutdownDeadlineCancelsRuns(t *testing.T) {
+	pool, got := newTestPool(t, 1)
+	submitTask(t, pool, "m1", "t1", func(ctx context.Context) ai.RunResult {
+		<-ctx.Done()
+		return ai.RunResult{RunID: "m1", Outcome: ai.OutcomeFailed, Err: ctx.Err()}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := pool.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	if results := got.get(); len(results) != 1 || !errors.Is(results[0].Err, context.Canceled) {
+		t.Errorf("results = %+v, want the run cancelled", results)
+	}
+}
+
+func TestPoolRecoversPanics(t *testing.T) {
+	pool, got := newTestPool(t, 1)
+	var (
+		mu    sync.Mutex
+		order []string
+	)
+	submitTask(t, pool, "m1", "t1", func(ctx context.Context) ai.RunResult { panic("nil map") })
+	submitTask(t, pool, "m2", "t1", task("m2", &order, &mu, nil))
+	shutdown(t, pool)
+
+	results := got.get()
+	if len(results) != 2 {
+		t.Fatalf("reported %d results, want 2", len(results))
+	}
+	if !errors.Is(results[0].Err, ErrRunPanicked) || results[0].Outcome != ai.OutcomeFailed || results[0].Email.ID != "m1" {
+		t.Errorf("panicking run reported %+v, want a failed run of m1", results[0])
+	}
+	if results[1].Err != nil || len(order) != 1 {
+		t.Errorf("the job queued behind the panic reported %+v, want it run", results[1])
+	}
+}
+
+func TestPoolSkipsMessagesInLedger(t *testing.T) {
+	ctx := context.Background()
+	mailbox := memory.NewMailbox("support@agency.example.com")
+	if err := mailbox.LoadFixtures("../data/fixtures/inbox"); err != nil {
+		t.Fatalf("LoadFixtures: %v", err)
+	}
+	generator, err := llm.LoadScriptedGenerator("../data/fixtures/llm/script.json")
+	if err != nil {
+		t.Fatalf("LoadScriptedGenerator: %v", err)
+	}
+	workflow, err := ai.NewWorkflow(generator, mailbox, ai.Options{})
+	if err != nil {
+		t.Fatalf("NewWorkflow: %v", err)
+	}
+	led, err := ledger.Open(filepath.Join(t.TempDir(), "ledger.db"), 3)
+	if err != nil {
+		t.Fatalf("ledger.Open: %v", err)
+	}
+	defer led.Close()
+	emails, err := mailbox.FetchUnansweredEmails(ctx, 10)
+	if err != nil || len(emails) == 0 {
+		t.Fatalf("FetchUnansweredEmails = %d emails, %v", len(emails), err)
+	}
+	msg := emails[0]
+
+	var got results
+	pool := NewPool(workflow, 2, led, got.add)
+	if accepted, err := pool.Submit(ctx, msg); !accepted || err != nil {
+		t.Fatalf("Submit = %v, %v, want accepted", accepted, err)
+	}
+	shutdown(t, pool)
+	if results := got.get(); len(results) != 1 || results[0].Outcome != ai.OutcomeDrafted {
+		t.Fatalf("results = %+v, want one drafted run", results)
+	}
+
+	// A fresh pool, as after a restart, does not process the message again.
+	pool = NewPool(workflow, 2, led, got.add)
+	defer shutdown(t, pool)
+	if accepted, err := pool.Submit(ctx, msg); accepted || err != nil {
+		t.Fatalf("Submit of a processed message = %v, %v, want not accepted", accepted, err)
+	}
+}
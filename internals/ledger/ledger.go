@@ -0,0 +1,257 @@
+// Package ledger records every email Mailflow has processed, so no message is
+// answered twice even after its draft is sent or deleted.
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"mailflow/internals/ai"
+	"mailflow/internals/email"
+
+	_ "modernc.org/sqlite"
+)
+
+// StatusProcessing marks a message claimed by a run that has not finished yet.
+const StatusProcessing = "processing"
+
+// DefaultMaxAttempts is how often a failing message is retried before it is left alone.
+const DefaultMaxAttempts = 3
+
+// staleClaim is how long a processing claim is honoured. Older claims belong
+// to a process that died without resuming the run, so they may be retried.
+const staleClaim = 30 * time.Minute
+
+// ErrNotFound is returned by Get for messages the ledger has never seen.
+var ErrNotFound = errors.New("ledger: message not found")
+
+const schema = `
+CREATE TABLE IF NOT EXISTS processed_messages (
+	message_id TEXT PRIMARY KEY,
+	thread_id  TEXT NOT NULL,
+	sender     TEXT NOT NULL,
+	subject    TEXT NOT NULL,
+	run_id     TEXT NOT NULL DEFAULT '',
+	category   TEXT NOT NULL DEFAULT '',
+	status     TEXT NOT NULL,
+	error      TEXT NOT NULL DEFAULT '',
+	attempts   INTEGER NOT NULL DEFAULT 0,
+	created_at TEXT NOT NULL,
+	updated_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS processed_messages_thread ON processed_messages (thread_id);`
+
+// Entry is the ledger record of one message. Status is StatusProcessing or an ai.Outcome.
+type Entry struct {
+	MessageID string    `json:"message_id"`
+	ThreadID  string    `json:"thread_id"`
+	Sender    string    `json:"sender"`
+	Subject   string    `json:"subject"`
+	RunID     string    `json:"run_id"`
+	Category  string    `json:"category"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	Attempts  int       `json:"attempts"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Ledger is a SQLite-backed record of processed messages.
+type Ledger struct {
+	db          *sql.DB
+	maxAttempts int
+}
+
+// Open opens (or creates) the ledger database at path. Failed messages are
+// retried until they have been attempted maxAttempts times (DefaultMaxAttempts if <= 0).
+func Open(path string, maxAttempts int) (*Ledger, error) {
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ledger %s: %w", path, err)
+	}
+	// A single connection serializes writers, which SQLite requires anyway.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec("PRAGMA journal_mode=WAL; PRAGMA busy_timeout=5000;" + schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize ledger %s: %w", path, err)
+	}
+	return &Ledger{db: db, maxAttempts: maxAttempts}, nil
+}
+
+func (l *Ledger) Close() error {
+	return l.db.Close()
+}
+
+// Claim reserves msg for processing by run runID and reports whether the
+// caller may go ahead. It refuses messages that already reached a final
+// outcome, are being processed by a live run, or have used up their retries.
+func (l *Ledger) Claim(ctx context.Context, msg email.EmailInfo, runID string) (bool, error) {
+	return l.claim(ctx, msg, runID, false)
+}
+
+// ClaimResume reserves msg for runID, a run interrupted by a crash or
+// shutdown, before it is resumed from its checkpoint. Besides what Claim
+// refuses, it refuses messages the ledger attributes to another run, which
+// retried the message after runID was interrupted. The interrupted run's own
+// processing claim is taken over without waiting for it to go stale, and
+// without using up another attempt, since the resumed run continues it.
+func (l *Ledger) ClaimResume(ctx context.Context, msg email.EmailInfo, runID string) (bool, error) {
+	return l.claim(ctx, msg, runID, true)
+}
+
+func (l *Ledger) claim(ctx context.Context, msg email.EmailInfo, runID string, resume bool) (bool, error) {
+	tx, err := l.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim message %s: %w", msg.ID, err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	var status, owner, updatedAt string
+	var attempts int
+	err = tx.QueryRowContext(ctx, `SELECT status, run_id, attempts, updated_at FROM processed_messages WHERE message_id = ?`, msg.ID).
+		Scan(&status, &owner, &attempts, &updatedAt)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO processed_messages (message_id, thread_id, sender, subject, run_id, status, attempts, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, 1, ?, ?)`,
+			msg.ID, msg.ThreadID, msg.Sender, msg.Subject, runID, StatusProcessing, formatTime(now), formatTime(now))
+	case err != nil:
+		return false, fmt.Errorf("failed to claim message %s: %w", msg.ID, err)
+	default:
+		if resume && owner != "" && owner != runID {
+			return false, nil
+		}
+		attempt := 1
+		if resume && owner == runID && status == StatusProcessing {
+			attempt = 0
+		} else if !l.retryable(status, attempts, updatedAt, now) {
+			return false, nil
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE processed_messages SET run_id = ?, status = ?, error = '', attempts = attempts + ?, updated_at = ?
+			WHERE message_id = ?`, runID, StatusProcessing, attempt, formatTime(now), msg.ID)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to claim message %s: %w", msg.ID, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to claim message %s: %w", msg.ID, err)
+	}
+	return true, nil
+}
+
+func (l *Ledger) retryable(status string, attempts int, updatedAt string, now time.Time) bool {
+	switch status {
+	case string(ai.OutcomeFailed):
+		return attempts < l.maxAttempts
+	case StatusProcessing:
+		updated, err := time.Parse(timeLayout, updatedAt)
+		return err == nil && now.Sub(updated) > staleClaim && attempts < l.maxAttempts
+	default:
+		return false
+	}
+}
+
+// Release gives back a claim whose run never started, without using up an attempt.
+func (l *Ledger) Release(ctx context.Context, messageID string) error {
+	_, err := l.db.ExecContext(ctx, `
+		UPDATE processed_messages SET status = ?, attempts = attempts - 1, updated_at = ?
+		WHERE message_id = ? AND status = ?`,
+		string(ai.OutcomeFailed), formatTime(time.Now().UTC()), messageID, StatusProcessing)
+	if err != nil {
+		return fmt.Errorf("failed to release message %s: %w", messageID, err)
+	}
+	return nil
+}
+
+// Finish records the outcome of a run. Messages not claimed before (such as
+// runs resumed from a checkpoint after an upgrade) are inserted.
+func (l *Ledger) Finish(ctx context.Context, result ai.RunResult) error {
+	var category, errText string
+	if result.State != nil {
+		category = result.State.EmailCategory
+	}
+	if result.Err != nil {
+		errText = result.Err.Error()
+	}
+	now := formatTime(time.Now().UTC())
+	msg := result.Email
+	_, err := l.db.ExecContext(ctx, `
+		INSERT INTO processed_messages (message_id, thread_id, sender, subject, run_id, category, status, error, attempts, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, 1, ?, ?)
+		ON CONFLICT (message_id) DO UPDATE SET
+			run_id = excluded.run_id, category = COALESCE(NULLIF(excluded.category, ''), category), status = excluded.status,
+			error = excluded.error, updated_at = excluded.updated_at`,
+		msg.ID, msg.ThreadID, msg.Sender, msg.Subject, result.RunID, category, string(result.Outcome), errText, now, now)
+	if err != nil {
+		return fmt.Errorf("failed to record outcome of message %s: %w", msg.ID, err)
+	}
+	return nil
+}
+
+// Get returns the ledger entry of a message or ErrNotFound.
+func (l *Ledger) Get(ctx context.Context, messageID string) (*Entry, error) {
+	row := l.db.QueryRowContext(ctx, `
+		SELECT message_id, thread_id, sender, subject, run_id, category, status, error, attempts, created_at, updated_at
+		FROM processed_messages WHERE message_id = ?`, messageID)
+	entry, err := scanEntry(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ledger entry %s: %w", messageID, err)
+	}
+	return entry, nil
+}
+
+// List returns the most recently updated entries, newest first.
+func (l *Ledger) List(ctx context.Context, limit int) ([]Entry, error) {
+	rows, err := l.db.QueryContext(ctx, `
+		SELECT message_id, thread_id, sender, subject, run_id, category, status, error, attempts, created_at, updated_at
+		FROM processed_messages ORDER BY updated_at DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ledger entries: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		entry, err := scanEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list ledger entries: %w", err)
+		}
+		entries = append(entries, *entry)
+	}
+	return entries, rows.Err()
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanEntry(row scanner) (*Entry, error) {
+	var entry Entry
+	var createdAt, updatedAt string
+	if err := row.Scan(&entry.MessageID, &entry.ThreadID, &entry.Sender, &entry.Subject, &entry.RunID,
+		&entry.Category, &entry.Status, &entry.Error, &entry.Attempts, &createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+	entry.CreatedAt, _ = time.Parse(timeLayout, createdAt)
+	entry.UpdatedAt, _ = time.Parse(timeLayout, updatedAt)
+	return &entry, nil
+}
+
+// timeLayout has a fixed width, so timestamps stored as text sort chronologically.
+const timeLayout = "2006-01-02T15:04:05.000000000Z"
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeLayout)
+}
@@ -0,0 +1,136 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"mailflow/internals/ai"
+	"mailflow/internals/email"
+)
+
+func openTestLedger(t *testing.T, maxAttempts int) *Ledger {
+	t.Helper()
+	led, err := Open(filepath.Join(t.TempDir(), "ledger.db"), maxAttempts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { led.Close() })
+	return led
+}
+
+func finish(t *testing.T, led *Ledger, msg email.EmailInfo, runID string, outcome ai.Outcome) {
+	t.Helper()
+	result := ai.RunResult{RunID: runID, Email: msg, Outcome: outcome}
+	if outcome == ai.OutcomeFailed {
+		result.Err = errors.New("boom")
+	}
+	if err := led.Finish(context.Background(), result); err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+}
+
+func TestClaimResume(t *testing.T) {
+	msg := email.EmailInfo{ID: "m1", ThreadID: "t1", Sender: "a@example.com", Subject: "Hi"}
+	tests := []struct {
+		name        string
+		setup       func(t *testing.T, led *Ledger)
+		want        bool
+		attempts    int
+		maxAttempts int
+	}{
+		{
+			name:     "interrupted while processing",
+			setup:    func(t *testing.T, led *Ledger) { led.Claim(context.Background(), msg, "run-a") },
+			want:     true,
+			attempts: 1,
+		},
+		{
+			name: "cancelled by shutdown",
+			setup: func(t *testing.T, led *Ledger) {
+				led.Claim(context.Background(), msg, "run-a")
+				finish(t, led, msg, "run-a", ai.OutcomeFailed)
+			},
+			want:     true,
+			attempts: 2,
+		},
+		{
+			name: "already final",
+			setup: func(t *testing.T, led *Ledger) {
+				led.Claim(context.Background(), msg, "run-a")
+				finish(t, led, msg, "run-a", ai.OutcomeDrafted)
+			},
+			want:     false,
+			attempts: 1,
+		},
+		{
+			name: "retried under another run",
+			setup: func(t *testing.T, led *Ledger) {
+				led.Claim(context.Background(), msg, "run-a")
+				finish(t, led, msg, "run-a", ai.OutcomeFailed)
+				led.Claim(context.Background(), msg, "run-b")
+			},
+			want:     false,
+			attempts: 2,
+		},
+		{
+			name: "out of attempts",
+			setup: func(t *testing.T, led *Ledger) {
+				led.Claim(context.Background(), msg, "run-a")
+				finish(t, led, msg, "run-a", ai.OutcomeFailed)
+			},
+			want:        false,
+			attempts:    1,
+			maxAttempts: 1,
+		},
+		{
+			name:     "unknown to the ledger",
+			setup:    func(t *testing.T, led *Ledger) {},
+			want:     true,
+			attempts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			led := openTestLedger(t, tt.maxAttempts)
+			tt.setup(t, led)
+
+			claimed, err := led.ClaimResume(context.Background(), msg, "run-a")
+			if err != nil {
+				t.Fatalf("ClaimResume: %v", err)
+			}
+			if claimed != tt.want {
+				t.Fatalf("ClaimResume = %v, want %v", claimed, tt.want)
+			}
+			entry, err := led.Get(context.Background(), msg.ID)
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			if entry.Attempts != tt.attempts {
+				t.Errorf("Attempts = %d, want %d", entry.Attempts, tt.attempts)
+			}
+			if claimed && (entry.Status != StatusProcessing || entry.RunID != "run-a") {
+				t.Errorf("entry = %+v, want processing by run-a", entry)
+			}
+		})
+	}
+}
+
+func TestClaimRecordsRunID(t *testing.T) {
+	led := openTestLedger(t, 0)
+	msg := email.EmailInfo{ID: "m1", ThreadID: "t1"}
+	if claimed, err := led.Claim(context.Background(), msg, "run-a"); err != nil || !claimed {
+		t.Fatalf("Claim = %v, %v, want true", claimed, err)
+	}
+	if claimed, err := led.Claim(context.Background(), msg, "run-b"); err != nil || claimed {
+		t.Fatalf("second Claim = %v, %v, want false while run-a is processing", claimed, err)
+	}
+	entry, err := led.Get(context.Background(), msg.ID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if entry.RunID != "run-a" {
+		t.Errorf("RunID = %q, want run-a", entry.RunID)
+	}
+}
@@ -1,184 +1,213 @@
 package llm
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"mailflow/pkg/logging"
-	"net/http"
+	"strings"
 	"time"
 )
 
 const (
-	geminiEmbedAPIEndpoint    = "https://generativelanguage.googleapis.com/v1beta/models/embedding-001:batchEmbedContents?key="
-	geminiGenerateAPIEndpoint = "https://generativelanguage.googleapis.com/v1beta/models/gemini-2.5-flash:generateContent?key="
-	embeddingModel            = "models/embedding-001"
-	generationModel           = "gemini-2.5-flash"
-	defaultHTTPTimeout        = 30 * time.Second
+	geminiBaseURL      = "https://generativelanguage.googleapis.com/v1beta"
+	embeddingModel     = "embedding-001"
+	generationModel    = "gemini-2.5-flash"
+	defaultHTTPTimeout = 30 * time.Second
+
+	// geminiMaxEmbedBatch is the most texts batchEmbedContents accepts in one call.
+	geminiMaxEmbedBatch = 100
 )
 
+// geminiHeaders authenticates a request with apiKey. The key goes in a header
+// rather than the ?key= query parameter so that it never shows up in the URL
+// of a *url.Error, and so in retry logs or returned errors.
+func geminiHeaders(apiKey string) map[string]string {
+	return map[string]string{"x-goog-api-key": apiKey}
+}
+
 type GeminiEmbedder struct {
-	apiKey string
-	client *http.Client
+	apiKey  string
+	baseURL string
+	client  *httpClient
 }
 
-func NewGeminiEmbedder(apiKey string) *GeminiEmbedder {
+// NewGeminiEmbedder creates an embedder for embedding-001. An empty baseURL
+// selects the public endpoint.
+func NewGeminiEmbedder(apiKey, baseURL string) *GeminiEmbedder {
+	if baseURL == "" {
+		baseURL = geminiBaseURL
+	}
 	return &GeminiEmbedder{
-		apiKey: apiKey,
-		client: &http.Client{Timeout: defaultHTTPTimeout},
+		apiKey:  apiKey,
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  newHTTPClient(defaultHTTPTimeout),
 	}
 }
 
-func (ge *GeminiEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
-	logging.Debug("Calling Gemini API for embedding text (length: %d)", len(text))
-
-	if ge.apiKey == "" {
-		return nil, fmt.Errorf("Google API key is not set for GeminiEmbedder")
-	}
-
-	requestBody := EmbedContentRequest{
-		Requests: []struct {
-			Model   string `json:"model"`
-			Content struct {
-				Parts []struct {
-					Text string `json:"text"`
-				} `json:"parts"`
-			} `json:"content"`
-		}{
-			{
-				Model: embeddingModel,
-				Content: struct {
-					Parts []struct {
-						Text string `json:"text"`
-					} `json:"parts"`
-				}{
-					Parts: []struct {
-						Text string `json:"text"`
-					}{
-						{Text: text},
-					},
-				},
-			},
-		},
-	}
+// WithRetryPolicy replaces the default retry, rate limit and breaker settings.
+func (ge *GeminiEmbedder) WithRetryPolicy(policy RetryPolicy) *GeminiEmbedder {
+	ge.client.policy = policy
+	return ge
+}
 
-	jsonBody, err := json.Marshal(requestBody)
+func (ge *GeminiEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	embeddings, err := ge.EmbedBatch(ctx, []string{text})
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal embedding request: %w", err)
+		return nil, err
 	}
+	return embeddings[0], nil
+}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", geminiEmbedAPIEndpoint+ge.apiKey, bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create embedding request: %w", err)
+// EmbedBatch embeds texts in as few batchEmbedContents calls as the API's
+// limit of geminiMaxEmbedBatch texts per call allows, returning the
+// embeddings in the order of texts.
+func (ge *GeminiEmbedder) EmbedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	if ge.apiKey == "" {
+		return nil, fmt.Errorf("Google API key is not set for GeminiEmbedder")
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := ge.client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send embedding request: %w", err)
+	embeddings := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += geminiMaxEmbedBatch {
+		batch := texts[start:min(start+geminiMaxEmbedBatch, len(texts))]
+		values, err := ge.embedBatch(ctx, batch)
+		if err != nil {
+			return nil, err
+		}
+		embeddings = append(embeddings, values...)
 	}
-	defer resp.Body.Close()
+	return embeddings, nil
+}
 
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read embedding response body: %w", err)
-	}
+func (ge *GeminiEmbedder) embedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	logging.Debug("Calling Gemini API for embedding %d texts", len(texts))
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Gemini API returned non-OK status: %d - %s", resp.StatusCode, string(bodyBytes))
+	requestBody := EmbedContentRequest{Requests: make([]EmbedRequest, len(texts))}
+	for i, text := range texts {
+		requestBody.Requests[i] = EmbedRequest{
+			Model:   "models/" + embeddingModel,
+			Content: Content{Parts: []Part{{Text: text}}},
+		}
 	}
 
+	url := fmt.Sprintf("%s/models/%s:batchEmbedContents", ge.baseURL, embeddingModel)
 	var response EmbedContentResponse
-	err = json.Unmarshal(bodyBytes, &response)
-	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal embedding response: %w", err)
+	if err := ge.client.postJSON(ctx, "Gemini", embeddingModel, url, geminiHeaders(ge.apiKey), requestBody, &response); err != nil {
+		return nil, err
 	}
 
-	if len(response.Embeddings) == 0 || len(response.Embeddings[0].Values) == 0 {
-		return nil, fmt.Errorf("no embedding values returned from Gemini API")
+	if len(response.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("Gemini API returned %d embeddings for %d texts", len(response.Embeddings), len(texts))
+	}
+	embeddings := make([][]float32, len(texts))
+	for i, embedding := range response.Embeddings {
+		if len(embedding.Values) == 0 {
+			return nil, fmt.Errorf("no embedding values returned from Gemini API for text %d", i+1)
+		}
+		embeddings[i] = embedding.Values
 	}
 
-	logging.Debug("Successfully generated embedding of size %d", len(response.Embeddings[0].Values))
-	return response.Embeddings[0].Values, nil
+	logging.Debug("Successfully generated %d embeddings of size %d", len(embeddings), len(embeddings[0]))
+	return embeddings, nil
 }
 
 type GeminiGenerator struct {
-	apiKey string
-	client *http.Client
+	apiKey  string
+	model   string
+	baseURL string
+	client  *httpClient
 }
 
-func NewGeminiGenerator(apiKey string) *GeminiGenerator {
-	return &GeminiGenerator{
-		apiKey: apiKey,
-		client: &http.Client{Timeout: defaultHTTPTimeout},
-	}
-}
-
-func (gg *GeminiGenerator) GenerateContent(ctx context.Context, prompt string, genConfig *GenerationConfig) (string, error) {
-	logging.Debug("Calling Gemini API for content generation (prompt length: %d)", len(prompt))
-
-	if gg.apiKey == "" {
-		return "", fmt.Errorf("Google API key is not set for GeminiGenerator")
-	}
-
-	requestBody := GenerateContentRequest{
-		Contents: []struct {
-			Role  string `json:"role"`
-			Parts []struct {
-				Text string `json:"text"`
-			} `json:"parts"`
-		}{
-			{
-				Role: "user",
-				Parts: []struct {
-					Text string `json:"text"`
-				}{
-					{Text: prompt},
-				},
-			},
-		},
-		GenerationConfig: genConfig,
-	}
+var _ Generator = (*GeminiGenerator)(nil)
 
-	jsonBody, err := json.Marshal(requestBody)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal generation request: %w", err)
+// NewGeminiGenerator creates a generator for the given Gemini model.
+// Empty model and baseURL select gemini-2.5-flash on the public endpoint.
+func NewGeminiGenerator(apiKey, model, baseURL string) *GeminiGenerator {
+	if model == "" {
+		model = generationModel
 	}
-
-	req, err := http.NewRequestWithContext(ctx, "POST", geminiGenerateAPIEndpoint+gg.apiKey, bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return "", fmt.Errorf("failed to create generation request: %w", err)
+	if baseURL == "" {
+		baseURL = geminiBaseURL
 	}
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := gg.client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("failed to send generation request: %w", err)
+	return &GeminiGenerator{
+		apiKey:  apiKey,
+		model:   model,
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  newHTTPClient(defaultHTTPTimeout),
 	}
-	defer resp.Body.Close()
+}
 
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read generation response body: %w", err)
-	}
+// WithRetryPolicy replaces the default retry, rate limit and breaker settings.
+func (gg *GeminiGenerator) WithRetryPolicy(policy RetryPolicy) *GeminiGenerator {
+	gg.client.policy = policy
+	return gg
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("Gemini API returned non-OK status: %d - %s", resp.StatusCode, string(bodyBytes))
-	}
+func (gg *GeminiGenerator) Generate(ctx context.Context, req Request) (*Response, error) {
+	logging.Debug("Calling Gemini API for content generation (model: %s, messages: %d)", gg.model, len(req.Messages))
 
+	if gg.apiKey == "" {
+		return nil, fmt.Errorf("Google API key is not set for GeminiGenerator")
+	}
+
+	var requestBody GenerateContentRequest
+	for _, msg := range req.Messages {
+		switch msg.Role {
+		case RoleSystem:
+			if requestBody.SystemInstruction == nil {
+				requestBody.SystemInstruction = &Content{}
+			}
+			requestBody.SystemInstruction.Parts = append(requestBody.SystemInstruction.Parts, Part{Text: msg.Content})
+		case RoleAssistant:
+			requestBody.Contents = append(requestBody.Contents, Content{Role: "model", Parts: []Part{{Text: msg.Content}}})
+		default:
+			requestBody.Contents = append(requestBody.Contents, Content{Role: "user", Parts: []Part{{Text: msg.Content}}})
+		}
+	}
+
+	if req.JSON || req.Temperature != nil || req.MaxTokens > 0 {
+		genConfig := &GenerationConfig{Temperature: req.Temperature}
+		if req.JSON {
+			genConfig.ResponseMimeType = "application/json"
+			if req.Schema != nil {
+				genConfig.ResponseSchema = req.Schema.geminiSchema()
+			}
+		}
+		if req.MaxTokens > 0 {
+			maxTokens := int32(req.MaxTokens)
+			genConfig.MaxOutputTokens = &maxTokens
+		}
+		requestBody.GenerationConfig = genConfig
+	}
+
+	url := fmt.Sprintf("%s/models/%s:generateContent", gg.baseURL, gg.model)
 	var response GenerateContentResponse
-	err = json.Unmarshal(bodyBytes, &response)
-	if err != nil {
-		return "", fmt.Errorf("failed to unmarshal generation response: %w", err)
+	if err := gg.client.postJSON(ctx, "Gemini", gg.model, url, geminiHeaders(gg.apiKey), requestBody, &response); err != nil {
+		return nil, err
 	}
 
 	if len(response.Candidates) == 0 || len(response.Candidates[0].Content.Parts) == 0 {
-		return "", fmt.Errorf("no content generated from Gemini API")
+		return nil, fmt.Errorf("no content generated from Gemini API")
 	}
 
-	generatedText := response.Candidates[0].Content.Parts[0].Text
+	var sb strings.Builder
+	for _, part := range response.Candidates[0].Content.Parts {
+		sb.WriteString(part.Text)
+	}
+	generatedText := sb.String()
 	logging.Debug("Successfully generated content (length: %d)", len(generatedText))
-	return generatedText, nil
+
+	model := response.ModelVersion
+	if model == "" {
+		model = gg.model
+	}
+	return &Response{
+		Text:         generatedText,
+		Model:        model,
+		FinishReason: response.Candidates[0].FinishReason,
+		Usage: Usage{
+			PromptTokens:     response.UsageMetadata.PromptTokenCount,
+			CompletionTokens: response.UsageMetadata.CandidatesTokenCount,
+			TotalTokens:      response.UsageMetadata.TotalTokenCount,
+		},
+	}, nil
 }
@@ -0,0 +1,127 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testGeminiKey = "AIza-test-key"
+
+func TestGeminiSendsKeyInHeader(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.RawQuery != "" {
+			t.Errorf("request URL carries a query: %s", r.URL)
+		}
+		if got := r.Header.Get("x-goog-api-key"); got != testGeminiKey {
+			t.Errorf("x-goog-api-key = %q, want %q", got, testGeminiKey)
+		}
+		w.Write([]byte(`{"candidates": [{"content": {"parts": [{"text": "Hello"}]}, "finishReason": "STOP"}]}`))
+	}))
+	defer server.Close()
+
+	resp, err := NewGeminiGenerator(testGeminiKey, "header-test", server.URL).Generate(context.Background(), UserPrompt("", "Hi"))
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if resp.Text != "Hello" {
+		t.Errorf("Text = %q, want %q", resp.Text, "Hello")
+	}
+}
+
+func TestGeminiErrorsOmitKey(t *testing.T) {
+	// A server that is gone fails every attempt with a *url.Error, which
+	// quotes the request URL in both the retry log and the returned error.
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	policy := DefaultRetryPolicy()
+	policy.MaxRetries = 1
+	policy.BaseDelay = time.Millisecond
+	policy.BreakerFailures = 0
+	_, err := NewGeminiGenerator(testGeminiKey, "error-test", server.URL).WithRetryPolicy(policy).Generate(context.Background(), UserPrompt("", "Hi"))
+	if err == nil {
+		t.Fatal("Generate against a closed server succeeded")
+	}
+	if strings.Contains(err.Error(), testGeminiKey) {
+		t.Fatalf("error leaks the API key: %v", err)
+	}
+}
+
+// embedServer answers batchEmbedContents with the number of each text,
+// "text-7" embedding as [7], records the size of every batch and fails the
+// call numbered failCall (from 1) with a 400.
+func embedServer(t *testing.T, failCall int32) (*httptest.Server, *[]int) {
+	var batches []int
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, ":batchEmbedContents") {
+			t.Errorf("request to %s, want batchEmbedContents", r.URL.Path)
+		}
+		if calls.Add(1) == failCall {
+			http.Error(w, `{"error": {"message": "quota"}}`, http.StatusBadRequest)
+			return
+		}
+		var req EmbedContentRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+		batches = append(batches, len(req.Requests))
+		var resp EmbedContentResponse
+		resp.Embeddings = make([]struct {
+			Values []float32 `json:"values"`
+		}, len(req.Requests))
+		for i, embed := range req.Requests {
+			if embed.Model != "models/"+embeddingModel {
+				t.Errorf("model = %q, want models/%s", embed.Model, embeddingModel)
+			}
+			n, _ := strconv.Atoi(strings.TrimPrefix(embed.Content.Parts[0].Text, "text-"))
+			resp.Embeddings[i].Values = []float32{float32(n)}
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(server.Close)
+	return server, &batches
+}
+
+func TestGeminiEmbedBatchSplitsCalls(t *testing.T) {
+	policy := testPolicy()
+	policy.MaxRetries = 0
+	texts := make([]string, 2*geminiMaxEmbedBatch+50)
+	for i := range texts {
+		texts[i] = fmt.Sprintf("text-%d", i)
+	}
+
+	server, batches := embedServer(t, 0)
+	embeddings, err := NewGeminiEmbedder(testGeminiKey, server.URL).WithRetryPolicy(policy).EmbedBatch(context.Background(), texts)
+	if err != nil {
+		t.Fatalf("EmbedBatch: %v", err)
+	}
+	if want := fmt.Sprint([]int{geminiMaxEmbedBatch, geminiMaxEmbedBatch, 50}); fmt.Sprint(*batches) != want {
+		t.Errorf("batch sizes = %v, want %s", *batches, want)
+	}
+	if len(embeddings) != len(texts) {
+		t.Fatalf("got %d embeddings for %d texts", len(embeddings), len(texts))
+	}
+	for i, embedding := range embeddings {
+		if len(embedding) != 1 || embedding[0] != float32(i) {
+			t.Fatalf("embedding %d = %v, want [%d]", i, embedding, i)
+		}
+	}
+
+	// A failed call fails the whole batch rather than returning part of it.
+	server, _ = embedServer(t, 2)
+	embeddings, err = NewGeminiEmbedder(testGeminiKey, server.URL).WithRetryPolicy(policy).EmbedBatch(context.Background(), texts)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest || embeddings != nil {
+		t.Errorf("EmbedBatch with a failing second call = %d embeddings, %v, want a 400 *APIError", len(embeddings), err)
+	}
+}
@@ -0,0 +1,138 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+)
+
+// Role identifies the author of a chat message.
+type Role string
+
+const (
+	RoleSystem    Role = "system"
+	RoleUser      Role = "user"
+	RoleAssistant Role = "assistant"
+)
+
+// Message is a single provider-neutral chat turn.
+type Message struct {
+	Role    Role
+	Content string
+}
+
+// Request is a provider-neutral generation request.
+type Request struct {
+	Tag         string // Agent or prompt template issuing the request, used by fixtures and logs
+	Messages    []Message
+	JSON        bool     // Ask the provider for a JSON object response
+	Schema      *Schema  // Optional, constrains a JSON response to this schema where the provider supports it
+	Temperature *float32 // Optional sampling temperature
+	MaxTokens   int      // Optional cap on generated tokens, 0 means provider default
+}
+
+// Usage reports token accounting for a single generation.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// Response is the provider-neutral result of a generation.
+type Response struct {
+	Text         string
+	Model        string
+	FinishReason string
+	Usage        Usage
+}
+
+// Generator is implemented by every LLM backend (Gemini, OpenAI-compatible servers, Ollama).
+type Generator interface {
+	Generate(ctx context.Context, req Request) (*Response, error)
+}
+
+// UserPrompt builds a single-turn request, the shape used by every Mailflow agent.
+func UserPrompt(tag, prompt string) Request {
+	return Request{Tag: tag, Messages: []Message{{Role: RoleUser, Content: prompt}}}
+}
+
+// Provider names accepted by NewGenerator.
+const (
+	ProviderGemini   = "gemini"
+	ProviderOpenAI   = "openai"
+	ProviderOllama   = "ollama"
+	ProviderScripted = "scripted"
+	ProviderReplay   = "replay"
+)
+
+// ProviderConfig selects and configures a Generator.
+type ProviderConfig struct {
+	Provider string // "gemini" (default), "openai", "ollama", "scripted" or "replay"
+	Model    string // Empty selects the provider default
+	BaseURL  string // Empty selects the provider default
+	APIKey   string
+	Fixture  string       // Script ("scripted") or recording ("replay") to serve responses from
+	RecordTo string       // When set, responses of a live provider are recorded to this fixture
+	Retry    *RetryPolicy // Retry, rate limit and breaker settings of live providers; nil selects DefaultRetryPolicy
+}
+
+// NewGenerator builds the Generator described by cfg.
+func NewGenerator(cfg ProviderConfig) (Generator, error) {
+	generator, err := newProvider(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.RecordTo != "" {
+		if cfg.Provider == ProviderScripted || cfg.Provider == ProviderReplay {
+			return nil, fmt.Errorf("recording requires a live LLM provider, got %q", cfg.Provider)
+		}
+		recorder, err := NewRecordingGenerator(generator, cfg.RecordTo)
+		if err != nil {
+			return nil, err
+		}
+		return recorder, nil
+	}
+	return generator, nil
+}
+
+func newProvider(cfg ProviderConfig) (Generator, error) {
+	switch cfg.Provider {
+	case "", ProviderGemini:
+		if cfg.APIKey == "" {
+			return nil, fmt.Errorf("Google API key is not set for GeminiGenerator")
+		}
+		return NewGeminiGenerator(cfg.APIKey, cfg.Model, cfg.BaseURL).WithRetryPolicy(cfg.retryPolicy()), nil
+	case ProviderOpenAI:
+		generator, err := NewOpenAIGenerator(cfg.BaseURL, cfg.APIKey, cfg.Model)
+		if err != nil {
+			return nil, err
+		}
+		return generator.WithRetryPolicy(cfg.retryPolicy()), nil
+	case ProviderOllama:
+		generator, err := NewOllamaGenerator(cfg.BaseURL, cfg.Model)
+		if err != nil {
+			return nil, err
+		}
+		return generator.WithRetryPolicy(cfg.retryPolicy()), nil
+	case ProviderScripted:
+		generator, err := LoadScriptedGenerator(cfg.Fixture)
+		if err != nil {
+			return nil, err
+		}
+		return generator, nil
+	case ProviderReplay:
+		generator, err := NewReplayGenerator(cfg.Fixture)
+		if err != nil {
+			return nil, err
+		}
+		return generator, nil
+	default:
+		return nil, fmt.Errorf("unknown LLM provider %q", cfg.Provider)
+	}
+}
+
+func (cfg ProviderConfig) retryPolicy() RetryPolicy {
+	if cfg.Retry == nil {
+		return DefaultRetryPolicy()
+	}
+	return *cfg.Retry
+}
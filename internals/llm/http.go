@@ -0,0 +1,14 @@
+package llm
+
+import "fmt"
+
+// APIError is returned when a provider answers with a non-200 status.
+type APIError struct {
+	Provider   string
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s API returned non-OK status: %d - %s", e.Provider, e.StatusCode, e.Body)
+}
@@ -1,14 +1,12 @@
 package llm
 
 type EmbedContentRequest struct {
-	Requests []struct {
-		Model   string `json:"model"`
-		Content struct {
-			Parts []struct {
-				Text string `json:"text"`
-			} `json:"parts"`
-		} `json:"content"`
-	} `json:"requests"`
+	Requests []EmbedRequest `json:"requests"`
+}
+
+type EmbedRequest struct {
+	Model   string  `json:"model"`
+	Content Content `json:"content"`
 }
 
 type EmbedContentResponse struct {
@@ -17,34 +15,50 @@ type EmbedContentResponse struct {
 	} `json:"embeddings"`
 }
 
+type Part struct {
+	Text string `json:"text"`
+}
+
+type Content struct {
+	Role  string `json:"role,omitempty"`
+	Parts []Part `json:"parts"`
+}
+
 type GenerateContentRequest struct {
-	Contents []struct {
-		Role  string `json:"role"`
-		Parts []struct {
-			Text string `json:"text"`
-		} `json:"parts"`
-	} `json:"contents"`
-	GenerationConfig *GenerationConfig `json:"generationConfig,omitempty"`
+	Contents          []Content         `json:"contents"`
+	SystemInstruction *Content          `json:"systemInstruction,omitempty"`
+	GenerationConfig  *GenerationConfig `json:"generationConfig,omitempty"`
 }
 
 type GenerationConfig struct {
-	ResponseMimeType string   `json:"responseMimeType,omitempty"`
-	Temperature      *float32 `json:"temperature,omitempty"`
-	TopP             *float32 `json:"topP,omitempty"`
-	TopK             *int32   `json:"topK,omitempty"`
-	CandidateCount   *int32   `json:"candidateCount,omitempty"`
-	MaxOutputTokens  *int32   `json:"maxOutputTokens,omitempty"`
-	StopSequences    []string `json:"stopSequences,omitempty"`
+	ResponseMimeType string        `json:"responseMimeType,omitempty"`
+	ResponseSchema   *GeminiSchema `json:"responseSchema,omitempty"`
+	Temperature      *float32      `json:"temperature,omitempty"`
+	TopP             *float32      `json:"topP,omitempty"`
+	TopK             *int32        `json:"topK,omitempty"`
+	CandidateCount   *int32        `json:"candidateCount,omitempty"`
+	MaxOutputTokens  *int32        `json:"maxOutputTokens,omitempty"`
+	StopSequences    []string      `json:"stopSequences,omitempty"`
+}
+
+// GeminiSchema is the OpenAPI schema object accepted as responseSchema.
+type GeminiSchema struct {
+	Type             string                   `json:"type"`
+	Format           string                   `json:"format,omitempty"`
+	Description      string                   `json:"description,omitempty"`
+	Enum             []string                 `json:"enum,omitempty"`
+	Minimum          *float64                 `json:"minimum,omitempty"`
+	Maximum          *float64                 `json:"maximum,omitempty"`
+	Items            *GeminiSchema            `json:"items,omitempty"`
+	Properties       map[string]*GeminiSchema `json:"properties,omitempty"`
+	Required         []string                 `json:"required,omitempty"`
+	PropertyOrdering []string                 `json:"propertyOrdering,omitempty"`
 }
 
 type GenerateContentResponse struct {
 	Candidates []struct {
-		Content struct {
-			Parts []struct {
-				Text string `json:"text"`
-			} `json:"parts"`
-		} `json:"content"`
-		FinishReason  string `json:"finishReason"`
+		Content       Content `json:"content"`
+		FinishReason  string  `json:"finishReason"`
 		SafetyRatings []struct {
 			Category    string `json:"category"`
 			Probability string `json:"probability"`
@@ -56,4 +70,10 @@ type GenerateContentResponse struct {
 			Probability string `json:"probability"`
 		} `json:"safetyRatings"`
 	} `json:"promptFeedback"`
+	UsageMetadata struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+		TotalTokenCount      int `json:"totalTokenCount"`
+	} `json:"usageMetadata"`
+	ModelVersion string `json:"modelVersion"`
 }
@@ -0,0 +1,106 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"mailflow/pkg/logging"
+	"strings"
+	"time"
+)
+
+const ollamaBaseURL = "http://localhost:11434"
+
+type ollamaOptions struct {
+	Temperature *float32 `json:"temperature,omitempty"`
+	NumPredict  int      `json:"num_predict,omitempty"`
+}
+
+type ollamaChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []openAIMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+	Format   any             `json:"format,omitempty"` // "json" or a JSON schema
+	Options  *ollamaOptions  `json:"options,omitempty"`
+}
+
+type ollamaChatResponse struct {
+	Model           string        `json:"model"`
+	Message         openAIMessage `json:"message"`
+	DoneReason      string        `json:"done_reason"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+}
+
+// OllamaGenerator runs generations against a local Ollama server, keeping
+// mailbox content on infrastructure we control.
+type OllamaGenerator struct {
+	baseURL string
+	model   string
+	client  *httpClient
+}
+
+var _ Generator = (*OllamaGenerator)(nil)
+
+// NewOllamaGenerator creates a generator for a model pulled into Ollama.
+// An empty baseURL selects http://localhost:11434.
+func NewOllamaGenerator(baseURL, model string) (*OllamaGenerator, error) {
+	if model == "" {
+		return nil, fmt.Errorf("a model name is required for OllamaGenerator")
+	}
+	if baseURL == "" {
+		baseURL = ollamaBaseURL
+	}
+	return &OllamaGenerator{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		model:   model,
+		// Local models can be slow to load and generate, allow more than the hosted default.
+		client: newHTTPClient(5 * time.Minute),
+	}, nil
+}
+
+// WithRetryPolicy replaces the default retry, rate limit and breaker settings.
+func (g *OllamaGenerator) WithRetryPolicy(policy RetryPolicy) *OllamaGenerator {
+	g.client.policy = policy
+	return g
+}
+
+func (g *OllamaGenerator) Generate(ctx context.Context, req Request) (*Response, error) {
+	logging.Debug("Calling Ollama API for content generation (model: %s, messages: %d)", g.model, len(req.Messages))
+
+	requestBody := ollamaChatRequest{
+		Model:  g.model,
+		Stream: false,
+	}
+	for _, msg := range req.Messages {
+		requestBody.Messages = append(requestBody.Messages, openAIMessage{Role: string(msg.Role), Content: msg.Content})
+	}
+	if req.JSON {
+		requestBody.Format = "json"
+		if req.Schema != nil {
+			requestBody.Format = req.Schema.jsonSchema()
+		}
+	}
+	if req.Temperature != nil || req.MaxTokens > 0 {
+		requestBody.Options = &ollamaOptions{Temperature: req.Temperature, NumPredict: req.MaxTokens}
+	}
+
+	var response ollamaChatResponse
+	if err := g.client.postJSON(ctx, "Ollama", g.model, g.baseURL+"/api/chat", nil, requestBody, &response); err != nil {
+		return nil, err
+	}
+
+	if response.Message.Content == "" {
+		return nil, fmt.Errorf("no content generated from Ollama API")
+	}
+
+	return &Response{
+		Text:         response.Message.Content,
+		Model:        response.Model,
+		FinishReason: response.DoneReason,
+		Usage: Usage{
+			PromptTokens:     response.PromptEvalCount,
+			CompletionTokens: response.EvalCount,
+			TotalTokens:      response.PromptEvalCount + response.EvalCount,
+		},
+	}, nil
+}
@@ -0,0 +1,135 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestOllamaRequestShape(t *testing.T) {
+	tests := map[string]string{
+		"plain":        `{"model": "%m", "messages": ` + testMessagesJSON + `, "stream": false}`,
+		"options":      `{"model": "%m", "messages": ` + testMessagesJSON + `, "stream": false, "options": {"temperature": 0.25, "num_predict": 64}}`,
+		"JSON":         `{"model": "%m", "messages": ` + testMessagesJSON + `, "stream": false, "format": "json"}`,
+		"schema":       `{"model": "%m", "messages": ` + testMessagesJSON + `, "stream": false, "format": ` + testReplySchemaJSON + `}`,
+		"loose schema": `{"model": "%m", "messages": ` + testMessagesJSON + `, "stream": false, "format": ` + testDraftSchemaJSON + `}`,
+		// A schema is only sent with requests for JSON.
+		"schema only": `{"model": "%m", "messages": ` + testMessagesJSON + `, "stream": false}`,
+	}
+	for name, req := range chatRequests() {
+		t.Run(name, func(t *testing.T) {
+			server, calls := chatServer(t, http.StatusOK, `{"model": "llama3.2:3b", "message": {"role": "assistant", "content": "Hello"}, "done": true, `+
+				`"done_reason": "stop", "prompt_eval_count": 12, "eval_count": 3}`)
+			model := uniqueModel(t)
+			generator, err := NewOllamaGenerator(server.URL+"/", model)
+			if err != nil {
+				t.Fatalf("NewOllamaGenerator: %v", err)
+			}
+			resp, err := generator.WithRetryPolicy(testPolicy()).Generate(context.Background(), req)
+			if err != nil {
+				t.Fatalf("Generate: %v", err)
+			}
+
+			got := calls()
+			if len(got) != 1 {
+				t.Fatalf("server got %d calls, want 1", len(got))
+			}
+			if got[0].Path != "/api/chat" {
+				t.Errorf("path = %q, want /api/chat", got[0].Path)
+			}
+			if got[0].Authorization != "" || got[0].ContentType != "application/json" {
+				t.Errorf("Authorization = %q, Content-Type = %q", got[0].Authorization, got[0].ContentType)
+			}
+			assertJSON(t, got[0].Body, strings.ReplaceAll(tests[name], "%m", model))
+
+			want := Response{Text: "Hello", Model: "llama3.2:3b", FinishReason: "stop", Usage: Usage{PromptTokens: 12, CompletionTokens: 3, TotalTokens: 15}}
+			if *resp != want {
+				t.Errorf("response = %+v, want %+v", *resp, want)
+			}
+		})
+	}
+}
+
+func TestOllamaResponses(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		want       string // Text of the response
+		wantErr    string // Contained in the error; empty for success
+		wantStatus int    // Of the *APIError
+		wantCalls  int
+	}{
+		{name: "message", status: http.StatusOK, body: `{"message": {"role": "assistant", "content": "Hello"}, "done": true}`, want: "Hello", wantCalls: 1},
+		{
+			// A model still loading can answer done without any content.
+			name:      "empty message",
+			status:    http.StatusOK,
+			body:      `{"message": {"role": "assistant", "content": ""}, "done": true, "done_reason": "load"}`,
+			wantErr:   "no content generated",
+			wantCalls: 1,
+		},
+		{name: "malformed body", status: http.StatusOK, body: `{"message": `, wantErr: "Ollama", wantCalls: 1},
+		{
+			name:       "model not pulled",
+			status:     http.StatusNotFound,
+			body:       `{"error": "model \"llama3.2:3b\" not found, try pulling it first"}`,
+			wantErr:    "try pulling it first",
+			wantStatus: http.StatusNotFound,
+			wantCalls:  1,
+		},
+		{
+			name:       "server error is retried",
+			status:     http.StatusInternalServerError,
+			body:       `{"error": "llama runner process has terminated"}`,
+			wantErr:    "gave up after 4 attempts",
+			wantStatus: http.StatusInternalServerError,
+			wantCalls:  4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, calls := chatServer(t, tt.status, tt.body)
+			generator, err := NewOllamaGenerator(server.URL, uniqueModel(t))
+			if err != nil {
+				t.Fatalf("NewOllamaGenerator: %v", err)
+			}
+			resp, err := generator.WithRetryPolicy(testPolicy()).Generate(context.Background(), UserPrompt("", "Hi"))
+			if n := len(calls()); n != tt.wantCalls {
+				t.Errorf("server got %d calls, want %d", n, tt.wantCalls)
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Generate error = %v, want one containing %q", err, tt.wantErr)
+				}
+				var apiErr *APIError
+				if isAPIErr := errors.As(err, &apiErr); isAPIErr != (tt.wantStatus != 0) ||
+					isAPIErr && (apiErr.StatusCode != tt.wantStatus || apiErr.Provider != "Ollama" || !strings.Contains(apiErr.Body, tt.body)) {
+					t.Errorf("Generate error = %#v, want an *APIError with status %d and the body", err, tt.wantStatus)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Generate: %v", err)
+			}
+			if resp.Text != tt.want {
+				t.Errorf("Text = %q, want %q", resp.Text, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewOllamaGenerator(t *testing.T) {
+	generator, err := NewOllamaGenerator("", "llama3.2:3b")
+	if err != nil {
+		t.Fatalf("NewOllamaGenerator: %v", err)
+	}
+	if generator.baseURL != ollamaBaseURL {
+		t.Errorf("base URL = %q, want %q", generator.baseURL, ollamaBaseURL)
+	}
+	if _, err := NewOllamaGenerator(ollamaBaseURL, ""); err == nil || !strings.Contains(err.Error(), "a model name is required") {
+		t.Errorf("NewOllamaGenerator without a model: %v, want a missing model error", err)
+	}
+}
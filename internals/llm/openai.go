@@ -0,0 +1,157 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"mailflow/pkg/logging"
+	"strings"
+	"time"
+)
+
+const (
+	openAIBaseURL      = "https://api.openai.com/v1"
+	openAIDefaultModel = "gpt-4o-mini"
+)
+
+type openAIMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type openAIResponseFormat struct {
+	Type       string            `json:"type"`
+	JSONSchema *openAIJSONSchema `json:"json_schema,omitempty"`
+}
+
+type openAIJSONSchema struct {
+	Name   string         `json:"name"`
+	Schema map[string]any `json:"schema"`
+	Strict bool           `json:"strict"`
+}
+
+type openAIChatRequest struct {
+	Model          string                `json:"model"`
+	Messages       []openAIMessage       `json:"messages"`
+	Temperature    *float32              `json:"temperature,omitempty"`
+	MaxTokens      int                   `json:"max_tokens,omitempty"`
+	ResponseFormat *openAIResponseFormat `json:"response_format,omitempty"`
+}
+
+type openAIChatResponse struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Message      openAIMessage `json:"message"`
+		FinishReason string        `json:"finish_reason"`
+	} `json:"choices"`
+	Usage struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+		TotalTokens      int `json:"total_tokens"`
+	} `json:"usage"`
+}
+
+// OpenAIGenerator talks to any server exposing the OpenAI /v1/chat/completions
+// API: OpenAI itself, Azure-compatible gateways, vLLM, LM Studio, llama.cpp, etc.
+type OpenAIGenerator struct {
+	baseURL string
+	apiKey  string
+	model   string
+	client  *httpClient
+}
+
+var _ Generator = (*OpenAIGenerator)(nil)
+
+// NewOpenAIGenerator creates a generator for an OpenAI-compatible server.
+// baseURL includes the version prefix, e.g. "http://localhost:8000/v1".
+func NewOpenAIGenerator(baseURL, apiKey, model string) (*OpenAIGenerator, error) {
+	if baseURL == "" {
+		baseURL = openAIBaseURL
+	}
+	if model == "" {
+		if baseURL != openAIBaseURL {
+			return nil, fmt.Errorf("a model name is required for OpenAI-compatible server %s", baseURL)
+		}
+		model = openAIDefaultModel
+	}
+	if apiKey == "" && baseURL == openAIBaseURL {
+		return nil, fmt.Errorf("API key is not set for OpenAIGenerator")
+	}
+	return &OpenAIGenerator{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		apiKey:  apiKey,
+		model:   model,
+		client:  newHTTPClient(2 * time.Minute),
+	}, nil
+}
+
+// WithRetryPolicy replaces the default retry, rate limit and breaker settings.
+func (g *OpenAIGenerator) WithRetryPolicy(policy RetryPolicy) *OpenAIGenerator {
+	g.client.policy = policy
+	return g
+}
+
+func (g *OpenAIGenerator) Generate(ctx context.Context, req Request) (*Response, error) {
+	logging.Debug("Calling OpenAI-compatible API for content generation (model: %s, messages: %d)", g.model, len(req.Messages))
+
+	requestBody := openAIChatRequest{
+		Model:       g.model,
+		Temperature: req.Temperature,
+		MaxTokens:   req.MaxTokens,
+	}
+	for _, msg := range req.Messages {
+		requestBody.Messages = append(requestBody.Messages, openAIMessage{Role: string(msg.Role), Content: msg.Content})
+	}
+	if req.JSON {
+		requestBody.ResponseFormat = &openAIResponseFormat{Type: "json_object"}
+		if req.Schema != nil {
+			requestBody.ResponseFormat = &openAIResponseFormat{
+				Type:       "json_schema",
+				JSONSchema: &openAIJSONSchema{Name: schemaName(req.Tag), Schema: req.Schema.jsonSchema(), Strict: req.Schema.strict()},
+			}
+		}
+	}
+
+	headers := map[string]string{}
+	if g.apiKey != "" {
+		headers["Authorization"] = "Bearer " + g.apiKey
+	}
+
+	var response openAIChatResponse
+	if err := g.client.postJSON(ctx, "OpenAI", g.model, g.baseURL+"/chat/completions", headers, requestBody, &response); err != nil {
+		return nil, err
+	}
+
+	if len(response.Choices) == 0 {
+		return nil, fmt.Errorf("no content generated from OpenAI-compatible API")
+	}
+
+	model := response.Model
+	if model == "" {
+		model = g.model
+	}
+	return &Response{
+		Text:         response.Choices[0].Message.Content,
+		Model:        model,
+		FinishReason: response.Choices[0].FinishReason,
+		Usage: Usage{
+			PromptTokens:     response.Usage.PromptTokens,
+			CompletionTokens: response.Usage.CompletionTokens,
+			TotalTokens:      response.Usage.TotalTokens,
+		},
+	}, nil
+}
+
+// schemaName turns a request tag into the name OpenAI requires for a schema
+// (letters, digits, underscores and dashes).
+func schemaName(tag string) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '_' || r == '-' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, tag)
+	if name == "" {
+		return "response"
+	}
+	return name
+}
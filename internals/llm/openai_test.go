@@ -0,0 +1,281 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testOpenAIKey = "sk-test-key"
+
+// chatRequest is what a chat server stand-in received in one call.
+type chatRequest struct {
+	Path          string
+	Authorization string
+	ContentType   string
+	Body          string
+}
+
+// chatServer answers every call with status and body and records the calls.
+func chatServer(t *testing.T, status int, body string) (*httptest.Server, func() []chatRequest) {
+	var mu sync.Mutex
+	var calls []chatRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("reading request: %v", err)
+		}
+		mu.Lock()
+		calls = append(calls, chatRequest{
+			Path:          r.URL.Path,
+			Authorization: r.Header.Get("Authorization"),
+			ContentType:   r.Header.Get("Content-Type"),
+			Body:          string(data),
+		})
+		mu.Unlock()
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []chatRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]chatRequest(nil), calls...)
+	}
+}
+
+// assertJSON fails the test unless got and want hold the same JSON value.
+func assertJSON(t *testing.T, got, want string) {
+	t.Helper()
+	var gotValue, wantValue any
+	if err := json.Unmarshal([]byte(got), &gotValue); err != nil {
+		t.Fatalf("request body is not JSON: %v\n%s", err, got)
+	}
+	if err := json.Unmarshal([]byte(want), &wantValue); err != nil {
+		t.Fatalf("bad expectation: %v\n%s", err, want)
+	}
+	if !reflect.DeepEqual(gotValue, wantValue) {
+		t.Errorf("request body:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+type testReply struct {
+	Reply string `json:"reply"`
+	Send  bool   `json:"send"`
+}
+
+type testDraft struct {
+	Reply string `json:"reply"`
+	Note  string `json:"note,omitempty"`
+}
+
+// chatRequests are the requests both chat backends are tested with.
+func chatRequests() map[string]Request {
+	temperature := float32(0.25)
+	conversation := []Message{{Role: RoleSystem, Content: "Be brief."}, {Role: RoleUser, Content: "Hi"}}
+	return map[string]Request{
+		"plain":        {Tag: "Greeting", Messages: conversation},
+		"options":      {Tag: "Greeting", Messages: conversation, Temperature: &temperature, MaxTokens: 64},
+		"JSON":         {Tag: "Greeting", Messages: conversation, JSON: true},
+		"schema":       {Tag: "Email Writer/v2", Messages: conversation, JSON: true, Schema: SchemaFor[testReply]()},
+		"loose schema": {Tag: "", Messages: conversation, JSON: true, Schema: SchemaFor[testDraft]()},
+		"schema only":  {Tag: "Greeting", Messages: conversation, Schema: SchemaFor[testReply]()},
+	}
+}
+
+const (
+	testMessagesJSON    = `[{"role": "system", "content": "Be brief."}, {"role": "user", "content": "Hi"}]`
+	testReplySchemaJSON = `{"type": "object", "properties": {"reply": {"type": "string"}, "send": {"type": "boolean"}}, "required": ["reply", "send"], "additionalProperties": false}`
+	testDraftSchemaJSON = `{"type": "object", "properties": {"reply": {"type": "string"}, "note": {"type": "string"}}, "required": ["reply"], "additionalProperties": false}`
+)
+
+func TestOpenAIRequestShape(t *testing.T) {
+	tests := map[string]string{
+		"plain":   `{"model": "%m", "messages": ` + testMessagesJSON + `}`,
+		"options": `{"model": "%m", "messages": ` + testMessagesJSON + `, "temperature": 0.25, "max_tokens": 64}`,
+		"JSON":    `{"model": "%m", "messages": ` + testMessagesJSON + `, "response_format": {"type": "json_object"}}`,
+		"schema": `{"model": "%m", "messages": ` + testMessagesJSON + `, "response_format": {"type": "json_schema", ` +
+			`"json_schema": {"name": "Email_Writer_v2", "schema": ` + testReplySchemaJSON + `, "strict": true}}}`,
+		// Optional fields can't be expressed in strict mode.
+		"loose schema": `{"model": "%m", "messages": ` + testMessagesJSON + `, "response_format": {"type": "json_schema", ` +
+			`"json_schema": {"name": "response", "schema": ` + testDraftSchemaJSON + `, "strict": false}}}`,
+		// A schema is only sent with requests for JSON.
+		"schema only": `{"model": "%m", "messages": ` + testMessagesJSON + `}`,
+	}
+	for name, req := range chatRequests() {
+		t.Run(name, func(t *testing.T) {
+			server, calls := chatServer(t, http.StatusOK, `{"model": "gpt-test-0613", "choices": [{"message": {"role": "assistant", "content": "Hello"}, "finish_reason": "stop"}], `+
+				`"usage": {"prompt_tokens": 12, "completion_tokens": 3, "total_tokens": 15}}`)
+			model := uniqueModel(t)
+			generator, err := NewOpenAIGenerator(server.URL+"/v1/", testOpenAIKey, model)
+			if err != nil {
+				t.Fatalf("NewOpenAIGenerator: %v", err)
+			}
+			resp, err := generator.WithRetryPolicy(testPolicy()).Generate(context.Background(), req)
+			if err != nil {
+				t.Fatalf("Generate: %v", err)
+			}
+
+			got := calls()
+			if len(got) != 1 {
+				t.Fatalf("server got %d calls, want 1", len(got))
+			}
+			if got[0].Path != "/v1/chat/completions" {
+				t.Errorf("path = %q, want /v1/chat/completions", got[0].Path)
+			}
+			if got[0].Authorization != "Bearer "+testOpenAIKey || got[0].ContentType != "application/json" {
+				t.Errorf("Authorization = %q, Content-Type = %q", got[0].Authorization, got[0].ContentType)
+			}
+			assertJSON(t, got[0].Body, strings.ReplaceAll(tests[name], "%m", model))
+
+			want := Response{Text: "Hello", Model: "gpt-test-0613", FinishReason: "stop", Usage: Usage{PromptTokens: 12, CompletionTokens: 3, TotalTokens: 15}}
+			if *resp != want {
+				t.Errorf("response = %+v, want %+v", *resp, want)
+			}
+		})
+	}
+}
+
+func TestOpenAIResponses(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		want       string // Text of the response
+		wantModel  string // Empty for the configured model
+		wantErr    string // Contained in the error; empty for success
+		wantStatus int    // Of the *APIError
+		wantCalls  int
+	}{
+		{
+			name:      "first choice",
+			status:    http.StatusOK,
+			body:      `{"model": "served-model", "choices": [{"message": {"content": "One"}}, {"message": {"content": "Two"}}]}`,
+			want:      "One",
+			wantModel: "served-model",
+			wantCalls: 1,
+		},
+		{
+			// Some compatible servers leave the model out.
+			name:      "no model",
+			status:    http.StatusOK,
+			body:      `{"choices": [{"message": {"content": "Hello"}}]}`,
+			want:      "Hello",
+			wantCalls: 1,
+		},
+		{name: "no choices", status: http.StatusOK, body: `{"choices": []}`, wantErr: "no content generated", wantCalls: 1},
+		{name: "malformed body", status: http.StatusOK, body: `{"choices": [`, wantErr: "OpenAI", wantCalls: 1},
+		{
+			name:       "client error",
+			status:     http.StatusBadRequest,
+			body:       `{"error": {"message": "Invalid schema for response_format"}}`,
+			wantErr:    "Invalid schema for response_format",
+			wantStatus: http.StatusBadRequest,
+			wantCalls:  1,
+		},
+		{
+			name:       "unauthorized",
+			status:     http.StatusUnauthorized,
+			body:       `{"error": {"message": "Incorrect API key provided"}}`,
+			wantErr:    "401",
+			wantStatus: http.StatusUnauthorized,
+			wantCalls:  1,
+		},
+		{
+			name:       "server error is retried",
+			status:     http.StatusServiceUnavailable,
+			body:       `{"error": {"message": "overloaded"}}`,
+			wantErr:    "gave up after 4 attempts",
+			wantStatus: http.StatusServiceUnavailable,
+			wantCalls:  4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, calls := chatServer(t, tt.status, tt.body)
+			model := uniqueModel(t)
+			generator, err := NewOpenAIGenerator(server.URL, "", model)
+			if err != nil {
+				t.Fatalf("NewOpenAIGenerator: %v", err)
+			}
+			resp, err := generator.WithRetryPolicy(testPolicy()).Generate(context.Background(), UserPrompt("", "Hi"))
+			if n := len(calls()); n != tt.wantCalls {
+				t.Errorf("server got %d calls, want %d", n, tt.wantCalls)
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Generate error = %v, want one containing %q", err, tt.wantErr)
+				}
+				var apiErr *APIError
+				if isAPIErr := errors.As(err, &apiErr); isAPIErr != (tt.wantStatus != 0) ||
+					isAPIErr && (apiErr.StatusCode != tt.wantStatus || apiErr.Provider != "OpenAI" || !strings.Contains(apiErr.Body, tt.body)) {
+					t.Errorf("Generate error = %#v, want an *APIError with status %d and the body", err, tt.wantStatus)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Generate: %v", err)
+			}
+			wantModel := tt.wantModel
+			if wantModel == "" {
+				wantModel = model
+			}
+			if resp.Text != tt.want || resp.Model != wantModel {
+				t.Errorf("response = %q from %q, want %q from %q", resp.Text, resp.Model, tt.want, wantModel)
+			}
+		})
+	}
+
+	// Local servers need no key, and none is sent.
+	server, calls := chatServer(t, http.StatusOK, `{"choices": [{"message": {"content": "Hello"}}]}`)
+	generator, err := NewOpenAIGenerator(server.URL, "", uniqueModel(t))
+	if err != nil {
+		t.Fatalf("NewOpenAIGenerator: %v", err)
+	}
+	if _, err := generator.Generate(context.Background(), UserPrompt("", "Hi")); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if auth := calls()[0].Authorization; auth != "" {
+		t.Errorf("Authorization = %q without an API key", auth)
+	}
+}
+
+func TestNewOpenAIGenerator(t *testing.T) {
+	tests := []struct {
+		name      string
+		baseURL   string
+		apiKey    string
+		model     string
+		wantModel string
+		wantErr   string
+	}{
+		{name: "OpenAI defaults", apiKey: testOpenAIKey, wantModel: openAIDefaultModel},
+		{name: "OpenAI without a key", model: "gpt-4o", wantErr: "API key is not set"},
+		{name: "compatible server", baseURL: "http://localhost:8000/v1", model: "llama-3", wantModel: "llama-3"},
+		{name: "compatible server without a model", baseURL: "http://localhost:8000/v1", wantErr: "a model name is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			generator, err := NewOpenAIGenerator(tt.baseURL, tt.apiKey, tt.model)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("NewOpenAIGenerator error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewOpenAIGenerator: %v", err)
+			}
+			if generator.model != tt.wantModel {
+				t.Errorf("model = %q, want %q", generator.model, tt.wantModel)
+			}
+		})
+	}
+}
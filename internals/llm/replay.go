@@ -0,0 +1,157 @@
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Interaction is one recorded request/response pair in a replay fixture.
+type Interaction struct {
+	Tag         string `json:"tag"`
+	RequestHash string `json:"request_hash"`
+	Response    string `json:"response"`
+	Model       string `json:"model,omitempty"`
+	Usage       Usage  `json:"usage"`
+}
+
+// Fixture is the on-disk format shared by RecordingGenerator and ReplayGenerator.
+type Fixture struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// RequestHash fingerprints the parts of a request that influence the answer.
+func RequestHash(req Request) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "tag=%s json=%t\n", req.Tag, req.JSON)
+	for _, msg := range req.Messages {
+		fmt.Fprintf(h, "%s:%d:%s\n", msg.Role, len(msg.Content), msg.Content)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// RecordingGenerator forwards requests to a real Generator and appends every
+// successful response to a fixture file, which ReplayGenerator can serve later.
+type RecordingGenerator struct {
+	mu      sync.Mutex
+	next    Generator
+	path    string
+	fixture Fixture
+}
+
+var _ Generator = (*RecordingGenerator)(nil)
+
+// NewRecordingGenerator wraps next. Interactions already in path are kept so
+// several runs can contribute to the same fixture.
+func NewRecordingGenerator(next Generator, path string) (*RecordingGenerator, error) {
+	r := &RecordingGenerator{next: next, path: path}
+	if _, err := os.Stat(path); err == nil {
+		fixture, err := loadFixture(path)
+		if err != nil {
+			return nil, err
+		}
+		r.fixture = *fixture
+	}
+	return r, nil
+}
+
+func (r *RecordingGenerator) Generate(ctx context.Context, req Request) (*Response, error) {
+	resp, err := r.next.Generate(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fixture.Interactions = append(r.fixture.Interactions, Interaction{
+		Tag:         req.Tag,
+		RequestHash: RequestHash(req),
+		Response:    resp.Text,
+		Model:       resp.Model,
+		Usage:       resp.Usage,
+	})
+	// Written after every call so a crashed run still leaves a usable fixture.
+	if err := saveFixture(r.path, &r.fixture); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ReplayGenerator serves responses from a fixture recorded by RecordingGenerator.
+// Requests are matched on their exact hash first; when prompts have drifted
+// since recording, the next unused interaction with the same tag is served.
+type ReplayGenerator struct {
+	mu      sync.Mutex
+	fixture Fixture
+	used    []bool
+}
+
+var _ Generator = (*ReplayGenerator)(nil)
+
+func NewReplayGenerator(path string) (*ReplayGenerator, error) {
+	fixture, err := loadFixture(path)
+	if err != nil {
+		return nil, err
+	}
+	return &ReplayGenerator{fixture: *fixture, used: make([]bool, len(fixture.Interactions))}, nil
+}
+
+func (r *ReplayGenerator) Generate(ctx context.Context, req Request) (*Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	hash := RequestHash(req)
+	match := -1
+	for i, interaction := range r.fixture.Interactions {
+		if interaction.RequestHash == hash {
+			match = i
+			break
+		}
+	}
+	if match < 0 {
+		for i, interaction := range r.fixture.Interactions {
+			if !r.used[i] && interaction.Tag == req.Tag {
+				match = i
+				break
+			}
+		}
+	}
+	if match < 0 {
+		return nil, fmt.Errorf("no recorded response for tag %q (hash %s)", req.Tag, hash)
+	}
+
+	r.used[match] = true
+	interaction := r.fixture.Interactions[match]
+	return &Response{Text: interaction.Response, Model: interaction.Model, FinishReason: "STOP", Usage: interaction.Usage}, nil
+}
+
+func loadFixture(path string) (*Fixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read LLM fixture %s: %w", path, err)
+	}
+	var fixture Fixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return nil, fmt.Errorf("failed to decode LLM fixture %s: %w", path, err)
+	}
+	return &fixture, nil
+}
+
+func saveFixture(path string, fixture *Fixture) error {
+	data, err := json.MarshalIndent(fixture, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode LLM fixture: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write LLM fixture %s: %w", path, err)
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,209 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func request(tag, prompt string) Request {
+	return Request{Tag: tag, JSON: true, Messages: []Message{{Role: RoleUser, Content: prompt}}}
+}
+
+func writeFixture(t *testing.T, fixture string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "fixture.json")
+	if err := os.WriteFile(path, []byte(fixture), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReplayGenerator(t *testing.T) {
+	exact := request("EmailWriter", "Write a reply to Jane")
+	fixture := `{"interactions": [
+		{"tag": "CategorizeEmail", "request_hash": "stale", "response": "first", "model": "gemini-2.0-flash", "usage": {"prompt_tokens": 10, "completion_tokens": 2, "total_tokens": 12}},
+		{"tag": "CategorizeEmail", "request_hash": "stale", "response": "second"},
+		{"tag": "EmailWriter", "request_hash": "other", "response": "by tag"},
+		{"tag": "EmailWriter", "request_hash": "` + RequestHash(exact) + `", "response": "by hash"}
+	]}`
+
+	tests := []struct {
+		name     string
+		requests []Request
+		want     []string // Response text per request, "error: <text>" for a miss
+	}{
+		{
+			name:     "exact hash wins over tag order",
+			requests: []Request{exact, exact},
+			want:     []string{"by hash", "by hash"},
+		},
+		{
+			name:     "drifted prompts take unused interactions of their tag in order",
+			requests: []Request{request("CategorizeEmail", "a"), request("CategorizeEmail", "b")},
+			want:     []string{"first", "second"},
+		},
+		{
+			name:     "miss once the tag is used up",
+			requests: []Request{request("CategorizeEmail", "a"), request("CategorizeEmail", "b"), request("CategorizeEmail", "c")},
+			want:     []string{"first", "second", `error: no recorded response for tag "CategorizeEmail"`},
+		},
+		{
+			name:     "miss for an unknown tag",
+			requests: []Request{request("EmailProofreader", "check this")},
+			want:     []string{`error: no recorded response for tag "EmailProofreader"`},
+		},
+		{
+			name:     "hash match does not use up the tag fallback",
+			requests: []Request{request("EmailWriter", "drifted"), exact},
+			want:     []string{"by tag", "by hash"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			replay, err := NewReplayGenerator(writeFixture(t, fixture))
+			if err != nil {
+				t.Fatalf("NewReplayGenerator: %v", err)
+			}
+			for i, req := range tt.requests {
+				resp, err := replay.Generate(context.Background(), req)
+				var got string
+				if err != nil {
+					got = "error: " + err.Error()
+				} else {
+					got = resp.Text
+				}
+				if !strings.HasPrefix(got, tt.want[i]) {
+					t.Errorf("request %d returned %q, want %q", i+1, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReplayGeneratorFixtureFormat(t *testing.T) {
+	fixture := `{"interactions": [{"tag": "CategorizeEmail", "request_hash": "x", "response": "{\"labels\": []}", "model": "gpt-4o-mini", "usage": {"prompt_tokens": 7, "completion_tokens": 3, "total_tokens": 10}}]}`
+	replay, err := NewReplayGenerator(writeFixture(t, fixture))
+	if err != nil {
+		t.Fatalf("NewReplayGenerator: %v", err)
+	}
+	resp, err := replay.Generate(context.Background(), request("CategorizeEmail", "hi"))
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	want := Response{Text: `{"labels": []}`, Model: "gpt-4o-mini", FinishReason: "STOP", Usage: Usage{PromptTokens: 7, CompletionTokens: 3, TotalTokens: 10}}
+	if *resp != want {
+		t.Errorf("response = %+v, want %+v", *resp, want)
+	}
+
+	for name, bad := range map[string]string{"invalid JSON": `{"interactions": [`, "missing file": ""} {
+		path := filepath.Join(t.TempDir(), "missing.json")
+		if bad != "" {
+			path = writeFixture(t, bad)
+		}
+		if _, err := NewReplayGenerator(path); err == nil {
+			t.Errorf("NewReplayGenerator with %s succeeded, want an error", name)
+		}
+	}
+}
+
+func TestRecordingGenerator(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recorded.json")
+	live := NewScriptedGenerator(
+		ScriptedResponse{Tag: "CategorizeEmail", Response: `{"labels": [{"category": "PRODUCT_ENQUIRY", "confidence": 0.9}]}`},
+		ScriptedResponse{Tag: "EmailWriter", Response: `{"email_content": "Hello"}`},
+	)
+	requests := []Request{request("CategorizeEmail", "Is product A compatible?"), request("EmailWriter", "Write to Jane")}
+
+	recorder, err := NewRecordingGenerator(live, path)
+	if err != nil {
+		t.Fatalf("NewRecordingGenerator: %v", err)
+	}
+	for _, req := range requests {
+		if _, err := recorder.Generate(context.Background(), req); err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+	}
+	// Failed calls are not recorded.
+	if _, err := recorder.Generate(context.Background(), request("EmailProofreader", "?")); err == nil {
+		t.Fatal("Generate for an unscripted tag succeeded, want an error")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("fixture not written: %v", err)
+	}
+	var fixture Fixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		t.Fatalf("fixture is not valid JSON: %v", err)
+	}
+	if len(fixture.Interactions) != 2 {
+		t.Fatalf("recorded %d interactions, want 2", len(fixture.Interactions))
+	}
+	for i, interaction := range fixture.Interactions {
+		if interaction.Tag != requests[i].Tag || interaction.RequestHash != RequestHash(requests[i]) || interaction.Model != "scripted" {
+			t.Errorf("interaction %d = %+v, want tag %s and the request's hash", i, interaction, requests[i].Tag)
+		}
+	}
+
+	// A second recorder appends to the same fixture.
+	recorder, err = NewRecordingGenerator(live, path)
+	if err != nil {
+		t.Fatalf("NewRecordingGenerator on an existing fixture: %v", err)
+	}
+	if _, err := recorder.Generate(context.Background(), requests[0]); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+
+	replay, err := NewReplayGenerator(path)
+	if err != nil {
+		t.Fatalf("NewReplayGenerator: %v", err)
+	}
+	for _, req := range requests {
+		want, _ := live.Generate(context.Background(), req)
+		got, err := replay.Generate(context.Background(), req)
+		if err != nil {
+			t.Fatalf("replaying %s: %v", req.Tag, err)
+		}
+		if got.Text != want.Text {
+			t.Errorf("replayed %s = %q, want %q", req.Tag, got.Text, want.Text)
+		}
+	}
+	if len(replay.fixture.Interactions) != 3 {
+		t.Errorf("fixture holds %d interactions after a second recording, want 3", len(replay.fixture.Interactions))
+	}
+}
+
+func TestScriptedGenerator(t *testing.T) {
+	scripted := NewScriptedGenerator(
+		ScriptedResponse{Tag: "CategorizeEmail", Contains: "refund", Response: "billing"},
+		ScriptedResponse{Tag: "CategorizeEmail", Response: "fallback"},
+	)
+	tests := []struct {
+		req  Request
+		want string
+	}{
+		{req: request("CategorizeEmail", "Where is my refund?"), want: "billing"},
+		{req: request("CategorizeEmail", "Hello"), want: "fallback"},
+		{req: request("CategorizeEmail", "Another refund"), want: "billing"}, // Rules are never consumed
+		{req: request("EmailWriter", "Hello"), want: `error: no scripted response for tag "EmailWriter"`},
+	}
+	for _, tt := range tests {
+		resp, err := scripted.Generate(context.Background(), tt.req)
+		got := ""
+		if err != nil {
+			got = "error: " + err.Error()
+		} else {
+			got = resp.Text
+		}
+		if got != tt.want {
+			t.Errorf("%s %q returned %q, want %q", tt.req.Tag, tt.req.Messages[0].Content, got, tt.want)
+		}
+	}
+	if calls := scripted.Calls(); len(calls) != len(tests) {
+		t.Errorf("recorded %d calls, want %d", len(calls), len(tests))
+	}
+}
@@ -0,0 +1,380 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"mailflow/pkg/logging"
+)
+
+// ErrCircuitOpen is returned without calling the provider while a model's
+// circuit breaker is open after repeated failures.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// RetryPolicy controls how provider calls survive rate limits and outages.
+type RetryPolicy struct {
+	MaxRetries        int           // Retries after the first attempt of a call; 0 disables retrying
+	BaseDelay         time.Duration // Backoff before the first retry, doubled for every further one
+	MaxDelay          time.Duration // Cap on the backoff between two attempts, Retry-After included
+	RequestsPerMinute float64       // Client-side limit per model; 0 disables it
+	Burst             int           // Requests a model may issue at once before the limit applies
+	BreakerFailures   int           // Consecutive failed attempts that open a model's breaker; 0 disables it
+	BreakerCooldown   time.Duration // How long an open breaker rejects calls before letting one through
+}
+
+// DefaultRetryPolicy retries four times over roughly half a minute and opens
+// the breaker after five failures in a row. Rate limiting is off.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxRetries:      4,
+		BaseDelay:       500 * time.Millisecond,
+		MaxDelay:        30 * time.Second,
+		Burst:           1,
+		BreakerFailures: 5,
+		BreakerCooldown: 30 * time.Second,
+	}
+}
+
+// ModelStats counts the calls made to one provider model since startup.
+type ModelStats struct {
+	Model            string        `json:"model"` // "<provider>/<model>"
+	Requests         int64         `json:"requests"`
+	Retries          int64         `json:"retries"`
+	RateLimited      int64         `json:"rate_limited"` // Attempts answered with 429
+	Failures         int64         `json:"failures"`     // Calls that returned an error
+	BreakerOpened    int64         `json:"breaker_opened"`
+	BreakerRejected  int64         `json:"breaker_rejected"`
+	ThrottledFor     time.Duration `json:"throttled_ns"` // Time spent waiting for the client-side limit
+	BackedOffFor     time.Duration `json:"backed_off_ns"`
+	BreakerOpenUntil *time.Time    `json:"breaker_open_until,omitempty"`
+}
+
+// modelState is shared by every client calling the same model, so the
+// generator and embedder of one process draw from the same budget.
+type modelState struct {
+	mu      sync.Mutex
+	bucket  tokenBucket
+	breaker circuitBreaker
+	stats   ModelStats
+}
+
+var (
+	modelsMu sync.Mutex
+	models   = make(map[string]*modelState)
+)
+
+func stateFor(provider, model string) *modelState {
+	key := provider + "/" + model
+	modelsMu.Lock()
+	defer modelsMu.Unlock()
+	state, ok := models[key]
+	if !ok {
+		state = &modelState{stats: ModelStats{Model: key}}
+		models[key] = state
+	}
+	return state
+}
+
+// Stats returns the call counters of every model used so far, sorted by name.
+func Stats() []ModelStats {
+	modelsMu.Lock()
+	states := make([]*modelState, 0, len(models))
+	for _, state := range models {
+		states = append(states, state)
+	}
+	modelsMu.Unlock()
+
+	stats := make([]ModelStats, 0, len(states))
+	for _, state := range states {
+		state.mu.Lock()
+		s := state.stats
+		if until := state.breaker.openUntil; time.Now().Before(until) {
+			s.BreakerOpenUntil = &until
+		}
+		state.mu.Unlock()
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Model < stats[j].Model })
+	return stats
+}
+
+// tokenBucket refills at rate tokens per second up to burst tokens.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// reserve takes a token and returns how long the caller must wait before using it.
+func (b *tokenBucket) reserve(now time.Time, rate float64, burst int) time.Duration {
+	capacity := float64(max(burst, 1))
+	if b.last.IsZero() {
+		b.tokens = capacity
+	} else {
+		b.tokens = min(capacity, b.tokens+now.Sub(b.last).Seconds()*rate)
+	}
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
+
+// circuitBreaker opens after a run of failed attempts. Once the cooldown has
+// passed a single probe is let through; its result closes or reopens it.
+type circuitBreaker struct {
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func (c *circuitBreaker) allow(now time.Time) bool {
+	if c.openUntil.IsZero() {
+		return true
+	}
+	if now.Before(c.openUntil) || c.probing {
+		return false
+	}
+	c.probing = true
+	return true
+}
+
+// record notes an attempt's result and reports whether the breaker just opened.
+func (c *circuitBreaker) record(now time.Time, ok bool, threshold int, cooldown time.Duration) bool {
+	c.probing = false
+	if ok {
+		c.failures = 0
+		c.openUntil = time.Time{}
+		return false
+	}
+	c.failures++
+	if threshold <= 0 || c.failures < threshold {
+		return false
+	}
+	c.openUntil = now.Add(cooldown)
+	return true
+}
+
+// httpClient posts JSON to a provider, retrying transient failures with
+// jittered exponential backoff, honoring Retry-After, and applying the
+// per-model rate limit and circuit breaker of its policy.
+type httpClient struct {
+	client *http.Client
+	policy RetryPolicy
+}
+
+func newHTTPClient(timeout time.Duration) *httpClient {
+	return &httpClient{client: &http.Client{Timeout: timeout}, policy: DefaultRetryPolicy()}
+}
+
+// postJSON sends body as JSON to url and decodes a 200 response into out.
+// model names the rate limit and breaker the call counts against.
+func (c *httpClient) postJSON(ctx context.Context, provider, model, url string, headers map[string]string, body, out interface{}) error {
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s request: %w", provider, err)
+	}
+
+	state := stateFor(provider, model)
+	state.mu.Lock()
+	state.stats.Requests++
+	state.mu.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		if err := c.admit(ctx, state); err != nil {
+			c.fail(state)
+			return fmt.Errorf("%s %s: %w", provider, model, err)
+		}
+
+		retryAfter, transient, err := c.attempt(ctx, provider, url, headers, jsonBody, out)
+		if ctx.Err() != nil {
+			// A cancelled call says nothing about the provider's health.
+			transient = false
+			c.release(state)
+		} else if c.record(state, err == nil || !transient) {
+			// Further attempts would only be rejected until the cooldown ends.
+			transient = false
+		}
+		if err == nil {
+			return nil
+		}
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusTooManyRequests {
+			state.mu.Lock()
+			state.stats.RateLimited++
+			state.mu.Unlock()
+		}
+		if !transient || attempt >= c.policy.MaxRetries {
+			c.fail(state)
+			if attempt > 0 {
+				return fmt.Errorf("%w (gave up after %d attempts)", err, attempt+1)
+			}
+			return err
+		}
+
+		delay := c.backoff(attempt)
+		if retryAfter > delay {
+			delay = retryAfter
+			if c.policy.MaxDelay > 0 && delay > c.policy.MaxDelay {
+				delay = c.policy.MaxDelay
+			}
+		}
+		logging.Info("%s request to %s failed (%v), retry %d/%d in %s", provider, model, err, attempt+1, c.policy.MaxRetries, delay.Round(time.Millisecond))
+		state.mu.Lock()
+		state.stats.Retries++
+		state.stats.BackedOffFor += delay
+		state.mu.Unlock()
+		if err := sleep(ctx, delay); err != nil {
+			c.fail(state)
+			return fmt.Errorf("%s request abandoned while backing off: %w", provider, err)
+		}
+	}
+}
+
+// admit checks the breaker and waits for the rate limit before an attempt.
+// A probe that never gets to make its attempt hands the probe back, so the
+// next call can try instead of finding the breaker open for good.
+func (c *httpClient) admit(ctx context.Context, state *modelState) error {
+	now := time.Now()
+	state.mu.Lock()
+	if c.policy.BreakerFailures > 0 && !state.breaker.allow(now) {
+		state.stats.BreakerRejected++
+		until := state.breaker.openUntil
+		state.mu.Unlock()
+		return fmt.Errorf("%w until %s", ErrCircuitOpen, until.Format(time.TimeOnly))
+	}
+	probe := state.breaker.probing
+	var wait time.Duration
+	if c.policy.RequestsPerMinute > 0 {
+		wait = state.bucket.reserve(now, c.policy.RequestsPerMinute/60, c.policy.Burst)
+		state.stats.ThrottledFor += wait
+	}
+	state.mu.Unlock()
+
+	if wait > 0 {
+		logging.Debug("Rate limit reached for %s, waiting %s", state.stats.Model, wait.Round(time.Millisecond))
+	}
+	if err := sleep(ctx, wait); err != nil {
+		if probe {
+			c.release(state)
+		}
+		return err
+	}
+	return nil
+}
+
+// record feeds an attempt's result to the breaker and reports whether it opened.
+func (c *httpClient) record(state *modelState, ok bool) bool {
+	if c.policy.BreakerFailures <= 0 {
+		return false
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if !state.breaker.record(time.Now(), ok, c.policy.BreakerFailures, c.policy.BreakerCooldown) {
+		return false
+	}
+	state.stats.BreakerOpened++
+	logging.Error("%s failed %d times in a row, pausing calls for %s", state.stats.Model, state.breaker.failures, c.policy.BreakerCooldown)
+	return true
+}
+
+func (c *httpClient) release(state *modelState) {
+	state.mu.Lock()
+	state.breaker.probing = false
+	state.mu.Unlock()
+}
+
+func (c *httpClient) fail(state *modelState) {
+	state.mu.Lock()
+	state.stats.Failures++
+	state.mu.Unlock()
+}
+
+// attempt makes one request. It returns the delay the provider asked for, if
+// any, and whether a failure is transient and worth another attempt: network
+// errors, 408, 429 and 5xx answers other than 501.
+func (c *httpClient) attempt(ctx context.Context, provider, url string, headers map[string]string, jsonBody []byte, out interface{}) (time.Duration, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(jsonBody))
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to create %s request: %w", provider, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, true, fmt.Errorf("failed to send %s request: %w", provider, err)
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, true, fmt.Errorf("failed to read %s response body: %w", provider, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		transient := resp.StatusCode == http.StatusRequestTimeout ||
+			resp.StatusCode == http.StatusTooManyRequests ||
+			(resp.StatusCode >= 500 && resp.StatusCode != http.StatusNotImplemented)
+		return parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()), transient,
+			&APIError{Provider: provider, StatusCode: resp.StatusCode, Body: string(bodyBytes)}
+	}
+
+	if err := json.Unmarshal(bodyBytes, out); err != nil {
+		return 0, false, fmt.Errorf("failed to unmarshal %s response: %w", provider, err)
+	}
+	return 0, false, nil
+}
+
+// backoff returns a random delay up to BaseDelay*2^attempt, capped at MaxDelay
+// ("full jitter"), so clients hitting the same limit do not retry in lockstep.
+func (c *httpClient) backoff(attempt int) time.Duration {
+	ceiling := c.policy.BaseDelay << min(attempt, 30)
+	if ceiling <= 0 || (c.policy.MaxDelay > 0 && ceiling > c.policy.MaxDelay) {
+		ceiling = c.policy.MaxDelay
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
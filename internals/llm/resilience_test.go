@@ -0,0 +1,248 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// scriptedStatus answers the i-th request with statuses[i] (200 once they
+// run out) and counts the requests it served.
+type scriptedStatus struct {
+	statuses   []int
+	retryAfter string
+	served     atomic.Int32
+}
+
+func (s *scriptedStatus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	i := int(s.served.Add(1)) - 1
+	if i < len(s.statuses) && s.statuses[i] != http.StatusOK {
+		if s.retryAfter != "" {
+			w.Header().Set("Retry-After", s.retryAfter)
+		}
+		w.WriteHeader(s.statuses[i])
+		w.Write([]byte(`{"error": "scripted"}`))
+		return
+	}
+	w.Write([]byte(`{"ok": true}`))
+}
+
+// testPolicy retries quickly and leaves the rate limit and breaker off.
+func testPolicy() RetryPolicy {
+	return RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond, Burst: 1}
+}
+
+var modelCount atomic.Int32
+
+// uniqueModel names a model no other test, or earlier run of the same test,
+// has used, as breaker, rate limit and stats are shared per model.
+func uniqueModel(t *testing.T) string {
+	return fmt.Sprintf("%s-%d", t.Name(), modelCount.Add(1))
+}
+
+// post sends one call to model through a client with policy.
+func post(ctx context.Context, t *testing.T, server *httptest.Server, model string, policy RetryPolicy) error {
+	t.Helper()
+	c := &httpClient{client: server.Client(), policy: policy}
+	var out struct{ OK bool }
+	return c.postJSON(ctx, "Test", model, server.URL, nil, map[string]string{}, &out)
+}
+
+func statsFor(model string) ModelStats {
+	for _, s := range Stats() {
+		if s.Model == "Test/"+model {
+			return s
+		}
+	}
+	return ModelStats{}
+}
+
+func TestPostJSONRetries(t *testing.T) {
+	tests := []struct {
+		name        string
+		statuses    []int
+		maxRetries  int
+		wantErr     bool
+		wantServed  int32
+		wantRetries int64
+	}{
+		{name: "5xx then success", statuses: []int{500, 503}, maxRetries: 3, wantServed: 3, wantRetries: 2},
+		{name: "429 then success", statuses: []int{429}, maxRetries: 3, wantServed: 2, wantRetries: 1},
+		{name: "retries run out", statuses: []int{502, 502, 502}, maxRetries: 2, wantErr: true, wantServed: 3, wantRetries: 2},
+		{name: "4xx is not retried", statuses: []int{400}, maxRetries: 3, wantErr: true, wantServed: 1},
+		{name: "501 is not retried", statuses: []int{501}, maxRetries: 3, wantErr: true, wantServed: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &scriptedStatus{statuses: tt.statuses}
+			server := httptest.NewServer(handler)
+			defer server.Close()
+			policy := testPolicy()
+			policy.MaxRetries = tt.maxRetries
+			model := uniqueModel(t)
+
+			err := post(context.Background(), t, server, model, policy)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("postJSON error = %v, want error %v", err, tt.wantErr)
+			}
+			var apiErr *APIError
+			if tt.wantErr && !errors.As(err, &apiErr) {
+				t.Fatalf("error %v does not wrap an *APIError", err)
+			}
+			if served := handler.served.Load(); served != tt.wantServed {
+				t.Errorf("server saw %d requests, want %d", served, tt.wantServed)
+			}
+			if retries := statsFor(model).Retries; retries != tt.wantRetries {
+				t.Errorf("stats count %d retries, want %d", retries, tt.wantRetries)
+			}
+		})
+	}
+}
+
+func TestPostJSONRetryAfter(t *testing.T) {
+	// The provider asks for an hour; MaxDelay caps the wait.
+	handler := &scriptedStatus{statuses: []int{429}, retryAfter: "3600"}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	policy := testPolicy()
+	policy.MaxDelay = 20 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	model := uniqueModel(t)
+	if err := post(ctx, t, server, model, policy); err != nil {
+		t.Fatalf("postJSON: %v", err)
+	}
+	stats := statsFor(model)
+	if stats.BackedOffFor != policy.MaxDelay || stats.RateLimited != 1 {
+		t.Errorf("backed off %s after %d rate limited attempts, want %s after 1", stats.BackedOffFor, stats.RateLimited, policy.MaxDelay)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{value: "", want: 0},
+		{value: "7", want: 7 * time.Second},
+		{value: "-3", want: 0},
+		{value: now.Add(90 * time.Second).Format(http.TimeFormat), want: 90 * time.Second},
+		{value: now.Add(-time.Minute).Format(http.TimeFormat), want: 0},
+		{value: "soon", want: 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	var bucket tokenBucket
+	const rate, burst = 2.0, 2 // Two tokens per second, two at once
+	steps := []struct {
+		at   time.Duration
+		want time.Duration
+	}{
+		{at: 0, want: 0},
+		{at: 0, want: 0},
+		{at: 0, want: 500 * time.Millisecond}, // The burst is used up
+		{at: 0, want: time.Second},            // Waits queue behind each other
+		// Refilled, but only up to the burst.
+		{at: 3 * time.Second, want: 0},
+		{at: 3 * time.Second, want: 0},
+		{at: 3 * time.Second, want: 500 * time.Millisecond},
+	}
+	for i, step := range steps {
+		if got := bucket.reserve(start.Add(step.at), rate, burst); got != step.want {
+			t.Errorf("reservation %d at +%s waits %s, want %s", i+1, step.at, got, step.want)
+		}
+	}
+}
+
+func TestPostJSONRateLimit(t *testing.T) {
+	server := httptest.NewServer(&scriptedStatus{})
+	defer server.Close()
+	policy := testPolicy()
+	policy.RequestsPerMinute = 1200 // One request per 50ms
+
+	model := uniqueModel(t)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := post(context.Background(), t, server, model, policy); err != nil {
+			t.Fatalf("postJSON: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("3 calls at 20/s took %s, want at least 100ms", elapsed)
+	}
+	if throttled := statsFor(model).ThrottledFor; throttled < 90*time.Millisecond {
+		t.Errorf("stats show %s throttled, want about 100ms", throttled)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	handler := &scriptedStatus{statuses: []int{500, 500, 500}}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	policy := testPolicy()
+	policy.MaxRetries = 0
+	policy.BreakerFailures = 2
+	policy.BreakerCooldown = 30 * time.Millisecond
+	model := uniqueModel(t)
+	ctx := context.Background()
+
+	// Two failures in a row open the breaker, which then rejects calls
+	// without sending them.
+	for i := 0; i < 2; i++ {
+		if err := post(ctx, t, server, model, policy); err == nil {
+			t.Fatalf("call %d succeeded against a failing server", i+1)
+		}
+	}
+	if err := post(ctx, t, server, model, policy); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("call on an open breaker returned %v, want %v", err, ErrCircuitOpen)
+	}
+	if served := handler.served.Load(); served != 2 {
+		t.Fatalf("server saw %d requests, want 2", served)
+	}
+
+	// After the cooldown a failing probe reopens it...
+	time.Sleep(policy.BreakerCooldown)
+	if err := post(ctx, t, server, model, policy); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("probe returned %v, want the server's error", err)
+	}
+	if err := post(ctx, t, server, model, policy); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("call after a failed probe returned %v, want %v", err, ErrCircuitOpen)
+	}
+
+	// ...a probe that is cancelled before it is sent hands the probe back...
+	time.Sleep(policy.BreakerCooldown)
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := post(cancelled, t, server, model, policy); !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled probe returned %v, want %v", err, context.Canceled)
+	}
+
+	// ...and a successful probe closes it.
+	if err := post(ctx, t, server, model, policy); err != nil {
+		t.Fatalf("probe after a cancelled one: %v", err)
+	}
+	if err := post(ctx, t, server, model, policy); err != nil {
+		t.Fatalf("call on a closed breaker: %v", err)
+	}
+	stats := statsFor(model)
+	if stats.BreakerOpened != 2 || stats.BreakerOpenUntil != nil {
+		t.Errorf("breaker opened %d times and is open until %v, want 2 times and closed", stats.BreakerOpened, stats.BreakerOpenUntil)
+	}
+	if served := handler.served.Load(); served != 5 {
+		t.Errorf("server saw %d requests, want 5", served)
+	}
+}
@@ -0,0 +1,309 @@
+package llm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Schema is the subset of JSON Schema that Gemini, OpenAI and Ollama all
+// accept for structured output. Providers translate it to their own format.
+type Schema struct {
+	Type        string             `json:"type"` // "object", "array", "string", "number", "integer" or "boolean"
+	Description string             `json:"description,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+	Enum        []string           `json:"enum,omitempty"`
+	Minimum     *float64           `json:"minimum,omitempty"`
+	Maximum     *float64           `json:"maximum,omitempty"`
+
+	// Order lists the object properties in struct field order, which Gemini
+	// uses to generate them in a stable order.
+	Order []string `json:"-"`
+}
+
+// SchemaFor derives the schema of T from its json tags. Fields tagged
+// omitempty are optional, and a `schema` tag refines a field:
+//
+//	Category   string  `json:"category" schema:"enum=A|B"`
+//	Confidence float64 `json:"confidence" schema:"min=0,max=1"`
+//	Legacy     string  `json:"legacy,omitempty" schema:"-"` // Left out of the schema
+func SchemaFor[T any]() *Schema {
+	return schemaOf(reflect.TypeOf((*T)(nil)).Elem())
+}
+
+func schemaOf(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() || field.Tag.Get("schema") == "-" {
+				continue
+			}
+			name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			prop := schemaOf(field.Type)
+			applySchemaTag(prop, field.Tag.Get("schema"))
+			s.Properties[name] = prop
+			s.Order = append(s.Order, name)
+			if !slices.Contains(strings.Split(opts, ","), "omitempty") {
+				s.Required = append(s.Required, name)
+			}
+		}
+		return s
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: schemaOf(t.Elem())}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	default:
+		panic(fmt.Sprintf("llm: no JSON schema for %s", t))
+	}
+}
+
+func applySchemaTag(s *Schema, tag string) {
+	if tag == "" {
+		return
+	}
+	for _, option := range strings.Split(tag, ",") {
+		key, value, _ := strings.Cut(option, "=")
+		switch key {
+		case "enum":
+			s.Enum = strings.Split(value, "|")
+		case "min", "max":
+			limit, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				panic(fmt.Sprintf("llm: invalid schema tag %q: %v", tag, err))
+			}
+			if key == "min" {
+				s.Minimum = &limit
+			} else {
+				s.Maximum = &limit
+			}
+		}
+	}
+}
+
+// Property returns the schema found by following path through object
+// properties and array items, or nil when there is none. It is used to refine
+// derived schemas, e.g. to set the enum of a nested field at runtime.
+func (s *Schema) Property(path ...string) *Schema {
+	current := s
+	for _, name := range path {
+		for current != nil && current.Type == "array" {
+			current = current.Items
+		}
+		if current == nil {
+			return nil
+		}
+		current = current.Properties[name]
+	}
+	return current
+}
+
+// ExtractJSON returns the first JSON object or array in text, ignoring
+// Markdown fences and any prose before or after it.
+func ExtractJSON(text string) ([]byte, error) {
+	start := strings.IndexAny(text, "{[")
+	if start < 0 {
+		return nil, fmt.Errorf("response contains no JSON object")
+	}
+	var value json.RawMessage
+	if err := json.NewDecoder(strings.NewReader(text[start:])).Decode(&value); err != nil {
+		return nil, fmt.Errorf("response is not valid JSON: %w", err)
+	}
+	return value, nil
+}
+
+// Validate checks a JSON document against the schema and reports the first
+// violation with its path, e.g. `labels[0].category: "X" is not one of [A B]`.
+func (s *Schema) Validate(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return fmt.Errorf("response is not valid JSON: %w", err)
+	}
+	return s.validate("", value)
+}
+
+func (s *Schema) validate(path string, value any) error {
+	at := path
+	if at == "" {
+		at = "response"
+	}
+	switch s.Type {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: expected an object", at)
+		}
+		for _, name := range s.Required {
+			if _, ok := object[name]; !ok {
+				return fmt.Errorf("%s: missing required field %q", at, name)
+			}
+		}
+		for _, name := range sortedNames(object) {
+			prop, known := s.Properties[name]
+			if !known {
+				return fmt.Errorf("%s: unexpected field %q", at, name)
+			}
+			if err := prop.validate(joinPath(path, name), object[name]); err != nil {
+				return err
+			}
+		}
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			return fmt.Errorf("%s: expected an array", at)
+		}
+		for i, item := range items {
+			if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+				return err
+			}
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s: expected a string", at)
+		}
+		if len(s.Enum) > 0 && !slices.Contains(s.Enum, str) {
+			return fmt.Errorf("%s: %q is not one of %v", at, str, s.Enum)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: expected true or false", at)
+		}
+	case "number", "integer":
+		number, ok := value.(json.Number)
+		if !ok {
+			return fmt.Errorf("%s: expected a number", at)
+		}
+		if s.Type == "integer" {
+			if _, err := number.Int64(); err != nil {
+				return fmt.Errorf("%s: expected an integer, got %s", at, number)
+			}
+		}
+		f, err := number.Float64()
+		if err != nil {
+			return fmt.Errorf("%s: invalid number %s", at, number)
+		}
+		if s.Minimum != nil && f < *s.Minimum {
+			return fmt.Errorf("%s: %s is below the minimum %v", at, number, *s.Minimum)
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			return fmt.Errorf("%s: %s is above the maximum %v", at, number, *s.Maximum)
+		}
+	}
+	return nil
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func sortedNames(object map[string]any) []string {
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// strict reports whether the schema satisfies OpenAI's strict mode, which
+// requires every object property to be required.
+func (s *Schema) strict() bool {
+	if s == nil {
+		return true
+	}
+	if s.Type == "object" && len(s.Required) != len(s.Properties) {
+		return false
+	}
+	for _, prop := range s.Properties {
+		if !prop.strict() {
+			return false
+		}
+	}
+	return s.Items.strict()
+}
+
+// jsonSchema renders s as standard JSON Schema, closing every object to
+// additional properties, for OpenAI-compatible servers and Ollama.
+func (s *Schema) jsonSchema() map[string]any {
+	out := map[string]any{"type": s.Type}
+	if s.Description != "" {
+		out["description"] = s.Description
+	}
+	if len(s.Enum) > 0 {
+		out["enum"] = s.Enum
+	}
+	if s.Minimum != nil {
+		out["minimum"] = *s.Minimum
+	}
+	if s.Maximum != nil {
+		out["maximum"] = *s.Maximum
+	}
+	if s.Items != nil {
+		out["items"] = s.Items.jsonSchema()
+	}
+	if s.Type == "object" {
+		props := make(map[string]any, len(s.Properties))
+		for name, prop := range s.Properties {
+			props[name] = prop.jsonSchema()
+		}
+		out["properties"] = props
+		out["required"] = append([]string{}, s.Required...)
+		out["additionalProperties"] = false
+	}
+	return out
+}
+
+// geminiSchema renders s in the OpenAPI dialect of Gemini's responseSchema.
+func (s *Schema) geminiSchema() *GeminiSchema {
+	out := &GeminiSchema{
+		Type:             strings.ToUpper(s.Type),
+		Description:      s.Description,
+		Enum:             s.Enum,
+		Minimum:          s.Minimum,
+		Maximum:          s.Maximum,
+		Required:         s.Required,
+		PropertyOrdering: s.Order,
+	}
+	if len(s.Enum) > 0 {
+		out.Format = "enum"
+	}
+	if s.Items != nil {
+		out.Items = s.Items.geminiSchema()
+	}
+	if len(s.Properties) > 0 {
+		out.Properties = make(map[string]*GeminiSchema, len(s.Properties))
+		for name, prop := range s.Properties {
+			out.Properties[name] = prop.geminiSchema()
+		}
+	}
+	return out
+}
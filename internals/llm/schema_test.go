@@ -0,0 +1,162 @@
+package llm
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testLabel struct {
+	Category   string  `json:"category" schema:"enum=BILLING|SUPPORT"`
+	Confidence float64 `json:"confidence" schema:"min=0,max=1"`
+}
+
+type testOutput struct {
+	Labels  []testLabel `json:"labels"`
+	Count   int         `json:"count"`
+	Urgent  bool        `json:"urgent"`
+	Note    string      `json:"note,omitempty"`
+	Legacy  string      `json:"legacy,omitempty" schema:"-"`
+	Skipped string      `json:"-"`
+	Plain   string
+	private string
+}
+
+func TestSchemaFor(t *testing.T) {
+	s := SchemaFor[testOutput]()
+	if s.Type != "object" {
+		t.Fatalf("type = %q, want object", s.Type)
+	}
+	if want := []string{"labels", "count", "urgent", "note", "Plain"}; !reflect.DeepEqual(s.Order, want) {
+		t.Errorf("order = %v, want %v", s.Order, want)
+	}
+	if want := []string{"labels", "count", "urgent", "Plain"}; !reflect.DeepEqual(s.Required, want) {
+		t.Errorf("required = %v, want %v", s.Required, want)
+	}
+	types := map[string]string{"labels": "array", "count": "integer", "urgent": "boolean", "note": "string"}
+	for name, want := range types {
+		if got := s.Property(name); got == nil || got.Type != want {
+			t.Errorf("property %s = %+v, want type %s", name, got, want)
+		}
+	}
+
+	category := s.Property("labels", "category")
+	if category == nil || !reflect.DeepEqual(category.Enum, []string{"BILLING", "SUPPORT"}) {
+		t.Errorf("labels.category = %+v, want the enum BILLING|SUPPORT", category)
+	}
+	confidence := s.Property("labels", "confidence")
+	if confidence == nil || confidence.Minimum == nil || *confidence.Minimum != 0 || confidence.Maximum == nil || *confidence.Maximum != 1 {
+		t.Errorf("labels.confidence = %+v, want the range [0, 1]", confidence)
+	}
+	if got := s.Property("labels", "missing"); got != nil {
+		t.Errorf("unknown property = %+v, want nil", got)
+	}
+}
+
+func TestSchemaValidate(t *testing.T) {
+	s := SchemaFor[testOutput]()
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string // Empty when the document is valid
+	}{
+		{
+			name: "valid",
+			data: `{"labels": [{"category": "BILLING", "confidence": 0.9}], "count": 1, "urgent": false, "Plain": ""}`,
+		},
+		{
+			name: "optional field present",
+			data: `{"labels": [], "count": 0, "urgent": true, "note": "n", "Plain": ""}`,
+		},
+		{
+			name:    "unknown field",
+			data:    `{"labels": [], "count": 0, "urgent": true, "Plain": "", "priority": "high"}`,
+			wantErr: `response: unexpected field "priority"`,
+		},
+		{
+			name:    "field left out of the schema",
+			data:    `{"labels": [], "count": 0, "urgent": true, "Plain": "", "legacy": "x"}`,
+			wantErr: `response: unexpected field "legacy"`,
+		},
+		{
+			name:    "missing required field",
+			data:    `{"labels": [], "urgent": true, "Plain": ""}`,
+			wantErr: `response: missing required field "count"`,
+		},
+		{
+			name:    "nested missing field",
+			data:    `{"labels": [{"category": "BILLING"}], "count": 1, "urgent": true, "Plain": ""}`,
+			wantErr: `labels[0]: missing required field "confidence"`,
+		},
+		{
+			name:    "value outside the enum",
+			data:    `{"labels": [{"category": "BILLING", "confidence": 1}, {"category": "SALES", "confidence": 1}], "count": 2, "urgent": true, "Plain": ""}`,
+			wantErr: `labels[1].category: "SALES" is not one of [BILLING SUPPORT]`,
+		},
+		{
+			name:    "number above the maximum",
+			data:    `{"labels": [{"category": "BILLING", "confidence": 1.5}], "count": 1, "urgent": true, "Plain": ""}`,
+			wantErr: `labels[0].confidence: 1.5 is above the maximum 1`,
+		},
+		{
+			name:    "fraction for an integer",
+			data:    `{"labels": [], "count": 1.5, "urgent": true, "Plain": ""}`,
+			wantErr: `count: expected an integer, got 1.5`,
+		},
+		{
+			name:    "wrong type",
+			data:    `{"labels": [], "count": 1, "urgent": "yes", "Plain": ""}`,
+			wantErr: `urgent: expected true or false`,
+		},
+		{
+			name:    "array instead of object",
+			data:    `[]`,
+			wantErr: `response: expected an object`,
+		},
+		{
+			name:    "not JSON",
+			data:    `{"labels": `,
+			wantErr: `response is not valid JSON`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.Validate([]byte(tt.data))
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Validate: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("Validate error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExtractJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    string
+		wantErr bool
+	}{
+		{name: "bare object", text: `{"send": true}`, want: `{"send": true}`},
+		{name: "fenced", text: "```json\n{\"send\": true}\n```", want: `{"send": true}`},
+		{name: "prose around", text: "Here is the result:\n{\"send\": true}\nLet me know if you need more.", want: `{"send": true}`},
+		{name: "braces in strings", text: `Sure: {"feedback": "use {name}", "send": false} done`, want: `{"feedback": "use {name}", "send": false}`},
+		{name: "array", text: "Queries: [\"a\", \"b\"]", want: `["a", "b"]`},
+		{name: "first of two", text: `{"a": 1} {"b": 2}`, want: `{"a": 1}`},
+		{name: "no JSON", text: "I cannot answer that.", wantErr: true},
+		{name: "truncated", text: "```json\n{\"send\": tr", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExtractJSON(tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ExtractJSON error = %v, want error %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("ExtractJSON = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
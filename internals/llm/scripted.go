@@ -0,0 +1,83 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ScriptedResponse is a canned answer for requests carrying Tag. When Contains
+// is set, the rule only applies to requests whose messages include that text.
+type ScriptedResponse struct {
+	Tag      string `json:"tag"`
+	Contains string `json:"contains,omitempty"`
+	Response string `json:"response"`
+}
+
+// ScriptedGenerator answers from a fixed script instead of calling a model, so
+// agents and whole workflows can run deterministically without network access.
+// Rules are matched in order and never consumed, which keeps results stable
+// when emails are processed concurrently or a node is retried.
+type ScriptedGenerator struct {
+	mu    sync.Mutex
+	rules []ScriptedResponse
+	calls []Request
+}
+
+var _ Generator = (*ScriptedGenerator)(nil)
+
+func NewScriptedGenerator(rules ...ScriptedResponse) *ScriptedGenerator {
+	return &ScriptedGenerator{rules: rules}
+}
+
+// LoadScriptedGenerator reads a JSON array of ScriptedResponse rules from path.
+func LoadScriptedGenerator(path string) (*ScriptedGenerator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read LLM script %s: %w", path, err)
+	}
+	var rules []ScriptedResponse
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to decode LLM script %s: %w", path, err)
+	}
+	return NewScriptedGenerator(rules...), nil
+}
+
+func (g *ScriptedGenerator) Generate(ctx context.Context, req Request) (*Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.calls = append(g.calls, req)
+	for _, rule := range g.rules {
+		if rule.Tag != req.Tag {
+			continue
+		}
+		if rule.Contains != "" && !requestContains(req, rule.Contains) {
+			continue
+		}
+		return &Response{Text: rule.Response, Model: "scripted", FinishReason: "STOP"}, nil
+	}
+	return nil, fmt.Errorf("no scripted response for tag %q", req.Tag)
+}
+
+// Calls returns every request received so far, in arrival order.
+func (g *ScriptedGenerator) Calls() []Request {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]Request(nil), g.calls...)
+}
+
+func requestContains(req Request, text string) bool {
+	for _, msg := range req.Messages {
+		if strings.Contains(msg.Content, text) {
+			return true
+		}
+	}
+	return false
+}
@@ -9,18 +9,17 @@ You are a highly skilled customer support specialist working for a SaaS company
 # **Instructions:**
 
 1. Review the provided email content thoroughly.
-2. Use the following rules to assign the correct category:
-   - **PRODUCT_ENQUIRY**: When the email seeks information about a product feature, benefit, service, or pricing.
-   - **CUSTOMER_COMPLAINT**: When the email communicates dissatisfaction or a complaint.
-   - **CUSTOMER_FEEDBACK**: When the email provides feedback or suggestions regarding a product or service.
-   - **UNRELATED**: When the email content does not match any of the above categories.
+2. Use the following rules to assign the correct categories:
+%[1]s
+3. An email may belong to more than one category, e.g. a complaint that also asks a product question. List every category that applies, most likely first.
+4. Give each category a confidence between 0 and 1 that reflects how sure you are it applies. Use low values when the email is ambiguous.
 
 ---
-Your response MUST be a JSON object with a single key "category".
-For example: {"category": "PRODUCT_ENQUIRY"}
+Your response MUST be a JSON object with a single key "labels", whose value is a JSON array of objects with the keys "category" (one of the category names above) and "confidence" (a number between 0 and 1).
+For example: {"labels": [{"category": "%[2]s", "confidence": 0.9}]}
 
 # **EMAIL CONTENT:**
-%s
+%[3]s
 
 ---
 
@@ -184,4 +183,9 @@ Example if needs rewrite: {"feedback": "The tone is too formal. Make it more fri
 * Be objective and fair in your assessment. Only reject the email if necessary.
 * Ensure feedback is clear, concise, and actionable.
 `
+
+	// REPAIR_STRUCTURED_OUTPUT follows an agent's invalid JSON answer; %s is the validation error.
+	REPAIR_STRUCTURED_OUTPUT = `Your previous response could not be used: %s
+
+Respond again with only the corrected JSON object, following the required format exactly and without any other text.`
 )
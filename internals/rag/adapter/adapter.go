@@ -0,0 +1,74 @@
+package adapter
+
+import (
+	"fmt"
+
+	"mailflow/internals/rag"
+)
+
+// Store names accepted by New.
+const (
+	StoreMemory = "memory"
+	StoreSQLite = "sqlite"
+)
+
+// Index names accepted by New.
+const (
+	IndexExact = "exact" // Score every chunk
+	IndexHNSW  = "hnsw"  // Approximate nearest neighbours, see HNSWConfig
+)
+
+// Config selects and configures a vector store.
+type Config struct {
+	Store string     // "sqlite" or "memory"
+	Path  string     // Database file for "sqlite", "vectors.db" when empty
+	Index string     // "exact" (default) or "hnsw"
+	HNSW  HNSWConfig // Used by the "hnsw" index; zero fields select DefaultHNSWConfig
+}
+
+// Store is a rag.VectorStore that also answers keyword searches, replaces
+// documents in one step, reports its size and releases its resources on Close.
+type Store interface {
+	rag.VectorStore
+	rag.KeywordIndex
+	rag.DocumentReplacer
+	GetTotalChunks() int
+	Close() error
+}
+
+var (
+	_ Store = (*InMemoryVectorStore)(nil)
+	_ Store = (*HNSWVectorStore)(nil)
+	_ Store = (*SQLiteVectorStore)(nil)
+)
+
+// New opens the vector store described by cfg.
+func New(cfg Config) (Store, error) {
+	switch cfg.Index {
+	case "", IndexExact, IndexHNSW:
+	default:
+		return nil, fmt.Errorf("unknown vector index %q", cfg.Index)
+	}
+	switch cfg.Store {
+	case StoreMemory:
+		if cfg.Index == IndexHNSW {
+			return NewHNSWVectorStore(cfg.HNSW), nil
+		}
+		return NewInMemoryVectorStore(), nil
+	case StoreSQLite:
+		path := cfg.Path
+		if path == "" {
+			path = "vectors.db"
+		}
+		sqliteStore, err := OpenSQLiteVectorStore(path)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Index == IndexHNSW {
+			sqliteStore.WithHNSW(cfg.HNSW)
+		}
+		return sqliteStore, nil
+	default:
+		return nil, fmt.Errorf("unknown vector store %q", cfg.Store)
+	}
+}
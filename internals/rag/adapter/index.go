@@ -0,0 +1,140 @@
+package adapter
+
+import (
+	"context"
+
+	"mailflow/internals/rag"
+)
+
+// chunkIndex holds chunks for searching, either exhaustively or through an
+// HNSW graph, and keeps a BM25 index of their content for keyword searches.
+// Removed chunks are skipped until they make up a quarter of the
+// graph, which is then rebuilt from the live ones. Searches may run
+// concurrently; add and deleteDocument need exclusive access.
+type chunkIndex struct {
+	hnsw   *HNSW       // nil searches exhaustively
+	config *HNSWConfig // nil for exhaustive search
+
+	chunks    []rag.Chunk // By HNSW ID
+	removed   []bool
+	nRemoved  int
+	positions map[string]int // Chunk ID to HNSW ID
+	keywords  *rag.BM25Index
+}
+
+func newChunkIndex(config *HNSWConfig) *chunkIndex {
+	c := &chunkIndex{config: config, positions: make(map[string]int), keywords: rag.NewBM25Index(rag.DefaultBM25Params())}
+	if config != nil {
+		c.hnsw = NewHNSW(*config)
+	}
+	return c
+}
+
+// add indexes chunk and reports whether it was new.
+func (c *chunkIndex) add(chunk rag.Chunk) bool {
+	if _, exists := c.positions[chunk.ID]; exists {
+		return false
+	}
+	c.positions[chunk.ID] = len(c.chunks)
+	c.chunks = append(c.chunks, chunk)
+	c.removed = append(c.removed, false)
+	if c.hnsw != nil {
+		c.hnsw.Add(chunk.Embedding)
+	}
+	c.keywords.AddChunks(context.Background(), []rag.Chunk{chunk})
+	return true
+}
+
+// deleteDocument removes the chunks of a document and returns how many there were.
+func (c *chunkIndex) deleteDocument(documentID string) int {
+	n := 0
+	for i, chunk := range c.chunks {
+		if !c.removed[i] && chunk.DocumentID == documentID {
+			c.removed[i] = true
+			delete(c.positions, chunk.ID)
+			n++
+		}
+	}
+	c.nRemoved += n
+	c.keywords.DeleteDocument(context.Background(), documentID)
+	if c.nRemoved > 0 && c.nRemoved*4 >= len(c.chunks) {
+		c.rebuild()
+	}
+	return n
+}
+
+func (c *chunkIndex) rebuild() {
+	live := c.live()
+	*c = *newChunkIndex(c.config)
+	for _, chunk := range live {
+		c.add(chunk)
+	}
+}
+
+func (c *chunkIndex) live() []rag.Chunk {
+	live := make([]rag.Chunk, 0, len(c.chunks)-c.nRemoved)
+	for i, chunk := range c.chunks {
+		if !c.removed[i] {
+			live = append(live, chunk)
+		}
+	}
+	return live
+}
+
+func (c *chunkIndex) len() int {
+	return len(c.chunks) - c.nRemoved
+}
+
+// selectiveFilterShare is the share of the chunks below which a filtered HNSW
+// search scores the matching chunks exhaustively instead of walking the graph.
+const selectiveFilterShare = 0.1
+
+// search returns the opts.TopN chunks most similar to queryEmbedding that
+// pass opts. Through HNSW it widens the candidate list until enough live,
+// matching chunks are found or the remaining ones score below opts.MinScore.
+// Filters matching only a few chunks are answered by scoring those directly,
+// which is exact and cheaper than walking past everything they exclude.
+func (c *chunkIndex) search(queryEmbedding []float32, opts rag.SearchOptions) []rag.ScoredChunk {
+	if c.hnsw == nil {
+		return rankByCosine(c.live(), queryEmbedding, opts)
+	}
+	if opts.TopN <= 0 {
+		return nil
+	}
+	if !opts.Filter.IsZero() {
+		if matching := c.matching(opts.Filter); float64(len(matching)) < selectiveFilterShare*float64(c.len()) {
+			return rankByCosine(matching, queryEmbedding, opts)
+		}
+	}
+	for ef := max(c.config.EfSearch, opts.TopN); ; ef *= 2 {
+		hits := c.hnsw.Search(queryEmbedding, ef, ef)
+		var results []rag.ScoredChunk
+		for _, hit := range hits {
+			if !opts.Accepts(hit.Similarity) {
+				// Hits come best first, so no later one qualifies either.
+				return results
+			}
+			if c.removed[hit.ID] || !opts.Filter.Matches(c.chunks[hit.ID]) {
+				continue
+			}
+			results = append(results, rag.ScoredChunk{Chunk: c.chunks[hit.ID], Score: hit.Similarity})
+			if len(results) == opts.TopN {
+				return results
+			}
+		}
+		if len(hits) < ef || ef >= len(c.chunks) {
+			return results
+		}
+	}
+}
+
+// matching returns the live chunks that pass filter.
+func (c *chunkIndex) matching(filter rag.Filter) []rag.Chunk {
+	var matching []rag.Chunk
+	for i, chunk := range c.chunks {
+		if !c.removed[i] && filter.Matches(chunk) {
+			matching = append(matching, chunk)
+		}
+	}
+	return matching
+}
@@ -13,13 +13,15 @@ import (
 // This is suitable for development and small datasets.
 // [TODO] For production, a dedicated vector DB is recommended.
 type InMemoryVectorStore struct {
-	mu     sync.RWMutex
-	chunks map[string]rag.Chunk // Chunk ID to Chunk
+	mu       sync.RWMutex
+	chunks   map[string]rag.Chunk // Chunk ID to Chunk
+	keywords *rag.BM25Index
 }
 
 func NewInMemoryVectorStore() *InMemoryVectorStore {
 	return &InMemoryVectorStore{
-		chunks: make(map[string]rag.Chunk),
+		chunks:   make(map[string]rag.Chunk),
+		keywords: rag.NewBM25Index(rag.DefaultBM25Params()),
 	}
 }
 
@@ -27,7 +29,11 @@ func NewInMemoryVectorStore() *InMemoryVectorStore {
 func (s *InMemoryVectorStore) AddChunks(ctx context.Context, chunks []rag.Chunk) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.addChunks(ctx, chunks)
+	return nil
+}
 
+func (s *InMemoryVectorStore) addChunks(ctx context.Context, chunks []rag.Chunk) {
 	for _, chunk := range chunks {
 		if _, exists := s.chunks[chunk.ID]; exists {
 			logging.Debug("Chunk with ID %s already exists, skipping.", chunk.ID)
@@ -36,46 +42,91 @@ func (s *InMemoryVectorStore) AddChunks(ctx context.Context, chunks []rag.Chunk)
 		s.chunks[chunk.ID] = chunk
 		logging.Debug("Added chunk %s from document %s", chunk.ID, chunk.DocumentID)
 	}
+	s.keywords.AddChunks(ctx, chunks)
 	logging.Info("Successfully added %d chunks to in-memory store. Total chunks: %d", len(chunks), len(s.chunks))
-	return nil
 }
 
-func (s *InMemoryVectorStore) Search(ctx context.Context, queryEmbedding []float32, topN int) ([]rag.Chunk, error) {
+func (s *InMemoryVectorStore) Search(ctx context.Context, queryEmbedding []float32, opts rag.SearchOptions) ([]rag.ScoredChunk, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	logging.Info("Searching in-memory store for top %d chunks using cosine similarity...", topN)
+	logging.Info("Searching in-memory store for top %d chunks using cosine similarity...", opts.TopN)
 
-	if len(s.chunks) == 0 {
-		return nil, nil
+	chunks := make([]rag.Chunk, 0, len(s.chunks))
+	for _, chunk := range s.chunks {
+		chunks = append(chunks, chunk)
 	}
+	results := rankByCosine(chunks, queryEmbedding, opts)
 
-	type ScoredChunk struct {
-		Chunk rag.Chunk
-		Score float64
+	logging.Info("Found %d chunks in search (cosine similarity).", len(results))
+	return results, nil
+}
+
+// DeleteDocument removes every chunk of a document, so it can be re-indexed.
+func (s *InMemoryVectorStore) DeleteDocument(ctx context.Context, documentID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.deleteDocument(ctx, documentID)
+}
+
+func (s *InMemoryVectorStore) deleteDocument(ctx context.Context, documentID string) error {
+	for id, chunk := range s.chunks {
+		if chunk.DocumentID == documentID {
+			delete(s.chunks, id)
+		}
 	}
-	var scoredChunks []ScoredChunk
+	return s.keywords.DeleteDocument(ctx, documentID)
+}
 
-	for _, chunk := range s.chunks {
+// ReplaceDocument swaps the chunks of a document for chunks, which no search sees half done.
+func (s *InMemoryVectorStore) ReplaceDocument(ctx context.Context, documentID string, chunks []rag.Chunk) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.deleteDocument(ctx, documentID); err != nil {
+		return err
+	}
+	s.addChunks(ctx, chunks)
+	return nil
+}
+
+// KeywordSearch ranks chunks by BM25 over their content.
+func (s *InMemoryVectorStore) KeywordSearch(ctx context.Context, query string, opts rag.SearchOptions) ([]rag.ScoredChunk, error) {
+	return s.keywords.KeywordSearch(ctx, query, opts)
+}
+
+// Close is a no-op; it lets the in-memory store stand in for persistent ones.
+func (s *InMemoryVectorStore) Close() error {
+	return nil
+}
+
+// rankByCosine returns the opts.TopN chunks most similar to queryEmbedding
+// that pass opts, skipping chunks whose embedding is missing or has another
+// dimension.
+func rankByCosine(chunks []rag.Chunk, queryEmbedding []float32, opts rag.SearchOptions) []rag.ScoredChunk {
+	var scoredChunks []rag.ScoredChunk
+
+	for _, chunk := range chunks {
+		if !opts.Filter.Matches(chunk) {
+			continue
+		}
 		if len(chunk.Embedding) == 0 || len(chunk.Embedding) != len(queryEmbedding) {
 			logging.Error("Skipping chunk %s due to invalid or mismatched embedding length.", chunk.ID)
 			continue
 		}
 		score := cosineSimilarity(queryEmbedding, chunk.Embedding)
-		scoredChunks = append(scoredChunks, ScoredChunk{Chunk: chunk, Score: score})
+		if opts.Accepts(score) {
+			scoredChunks = append(scoredChunks, rag.ScoredChunk{Chunk: chunk, Score: score})
+		}
 	}
 
 	sort.Slice(scoredChunks, func(i, j int) bool {
 		return scoredChunks[i].Score > scoredChunks[j].Score
 	})
 
-	var results []rag.Chunk
-	for i := 0; i < len(scoredChunks) && i < topN; i++ {
-		results = append(results, scoredChunks[i].Chunk)
+	if len(scoredChunks) > opts.TopN {
+		scoredChunks = scoredChunks[:max(opts.TopN, 0)]
 	}
-
-	logging.Info("Found %d chunks in search (cosine similarity).", len(results))
-	return results, nil
+	return scoredChunks
 }
 
 // Calculates the cosine similarity between two vectors.
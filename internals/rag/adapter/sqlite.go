@@ -0,0 +1,364 @@
+package adapter
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"mailflow/internals/rag"
+	"mailflow/pkg/logging"
+
+	_ "modernc.org/sqlite"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS chunks (
+	id          TEXT PRIMARY KEY,
+	document_id TEXT NOT NULL,
+	content     TEXT NOT NULL,
+	embedding   BLOB NOT NULL,
+	source_type TEXT NOT NULL DEFAULT '',
+	source      TEXT NOT NULL DEFAULT '',
+	page_number INTEGER NOT NULL DEFAULT 0,
+	section     TEXT NOT NULL DEFAULT '',
+	tags        TEXT NOT NULL DEFAULT '',
+	created_at  TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS chunks_document ON chunks (document_id);
+CREATE TABLE IF NOT EXISTS store_meta (
+	key   TEXT PRIMARY KEY,
+	value INTEGER NOT NULL
+);
+INSERT OR IGNORE INTO store_meta (key, value) VALUES ('generation', 0);`
+
+// SQLiteVectorStore keeps chunks and their embeddings in a SQLite database,
+// so indexed documents survive restarts and are shared by every process
+// opening the same file. Searches run against an in-memory copy of the
+// chunks, optionally indexed with HNSW, which this store's own writes update
+// in place and changes by other processes cause to be reloaded.
+type SQLiteVectorStore struct {
+	db   *sql.DB
+	hnsw *HNSWConfig // nil searches exhaustively
+
+	mu         sync.RWMutex
+	generation int64 // Database generation the cache was loaded at, -1 before the first load
+	cache      *chunkIndex
+}
+
+// OpenSQLiteVectorStore opens (or creates) the vector store database at path.
+func OpenSQLiteVectorStore(path string) (*SQLiteVectorStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open vector store %s: %w", path, err)
+	}
+	// A single connection serializes writers, which SQLite requires anyway.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec("PRAGMA journal_mode=WAL; PRAGMA busy_timeout=5000;" + sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize vector store %s: %w", path, err)
+	}
+	if err := upgradeSchema(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to upgrade vector store %s: %w", path, err)
+	}
+	return &SQLiteVectorStore{db: db, generation: -1}, nil
+}
+
+// WithHNSW answers searches from an HNSW graph built over the stored chunks
+// instead of scoring every chunk.
+func (s *SQLiteVectorStore) WithHNSW(config HNSWConfig) *SQLiteVectorStore {
+	config = config.withDefaults()
+	s.mu.Lock()
+	s.hnsw, s.generation, s.cache = &config, -1, nil
+	s.mu.Unlock()
+	return s
+}
+
+func (s *SQLiteVectorStore) Close() error {
+	return s.db.Close()
+}
+
+// AddChunks stores chunks, skipping IDs that are already present.
+func (s *SQLiteVectorStore) AddChunks(ctx context.Context, chunks []rag.Chunk) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to add chunks: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := insertChunks(ctx, tx, chunks); err != nil {
+		return err
+	}
+	generation, err := bumpGeneration(ctx, tx)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to add chunks: %w", err)
+	}
+	s.update(generation, func(cache *chunkIndex) {
+		for _, chunk := range chunks {
+			cache.add(chunk)
+		}
+	})
+	logging.Info("Successfully added %d chunks to SQLite vector store.", len(chunks))
+	return nil
+}
+
+// DeleteDocument removes every chunk of a document, so it can be re-indexed.
+func (s *SQLiteVectorStore) DeleteDocument(ctx context.Context, documentID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete document %s: %w", documentID, err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM chunks WHERE document_id = ?`, documentID)
+	if err != nil {
+		return fmt.Errorf("failed to delete document %s: %w", documentID, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+	generation, err := bumpGeneration(ctx, tx)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to delete document %s: %w", documentID, err)
+	}
+	s.update(generation, func(cache *chunkIndex) {
+		cache.deleteDocument(documentID)
+	})
+	return nil
+}
+
+// ReplaceDocument swaps the chunks of a document for chunks in one
+// transaction, so no process sees the document missing or indexed twice.
+func (s *SQLiteVectorStore) ReplaceDocument(ctx context.Context, documentID string, chunks []rag.Chunk) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to replace document %s: %w", documentID, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM chunks WHERE document_id = ?`, documentID); err != nil {
+		return fmt.Errorf("failed to replace document %s: %w", documentID, err)
+	}
+	if err := insertChunks(ctx, tx, chunks); err != nil {
+		return err
+	}
+	generation, err := bumpGeneration(ctx, tx)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to replace document %s: %w", documentID, err)
+	}
+	s.update(generation, func(cache *chunkIndex) {
+		cache.deleteDocument(documentID)
+		for _, chunk := range chunks {
+			cache.add(chunk)
+		}
+	})
+	logging.Info("Replaced document %s with %d chunks in SQLite vector store.", documentID, len(chunks))
+	return nil
+}
+
+// insertChunks stores chunks in tx, skipping IDs that are already present.
+func insertChunks(ctx context.Context, tx *sql.Tx, chunks []rag.Chunk) error {
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT OR IGNORE INTO chunks (id, document_id, content, embedding, source_type, source, page_number, section, tags, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("failed to add chunks: %w", err)
+	}
+	defer stmt.Close()
+
+	now := time.Now().UTC().Format(timeLayout)
+	for _, chunk := range chunks {
+		tags, err := encodeTags(chunk.Metadata.Tags)
+		if err != nil {
+			return fmt.Errorf("failed to add chunk %s: %w", chunk.ID, err)
+		}
+		_, err = stmt.ExecContext(ctx, chunk.ID, chunk.DocumentID, chunk.Content, encodeEmbedding(chunk.Embedding),
+			chunk.Metadata.SourceType, chunk.Metadata.Source, chunk.Metadata.PageNumber, chunk.Metadata.Section, tags, now)
+		if err != nil {
+			return fmt.Errorf("failed to add chunk %s: %w", chunk.ID, err)
+		}
+	}
+	return nil
+}
+
+// update applies a change this store committed as generation to the cache,
+// as long as the cache holds the generation before it. Otherwise another
+// process wrote in between and the next search reloads everything.
+func (s *SQLiteVectorStore) update(generation int64, apply func(cache *chunkIndex)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cache == nil || s.generation != generation-1 {
+		return
+	}
+	apply(s.cache)
+	s.generation = generation
+}
+
+// Search ranks chunks by cosine similarity to queryEmbedding, which is the
+// Score of each result and what opts.MinScore is compared against.
+func (s *SQLiteVectorStore) Search(ctx context.Context, queryEmbedding []float32, opts rag.SearchOptions) ([]rag.ScoredChunk, error) {
+	if err := s.refresh(ctx); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	logging.Info("Searching SQLite vector store for top %d chunks using cosine similarity...", opts.TopN)
+	results := s.cache.search(queryEmbedding, opts)
+	logging.Info("Found %d chunks in search (cosine similarity).", len(results))
+	return results, nil
+}
+
+// KeywordSearch ranks chunks by BM25 over their content; results are scored
+// by BM25 and opts.MinScore is ignored. The keyword index is rebuilt with the
+// rest of the cache, so it covers chunks other processes added.
+func (s *SQLiteVectorStore) KeywordSearch(ctx context.Context, query string, opts rag.SearchOptions) ([]rag.ScoredChunk, error) {
+	if err := s.refresh(ctx); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cache.keywords.KeywordSearch(ctx, query, opts)
+}
+
+func (s *SQLiteVectorStore) GetTotalChunks() int {
+	if err := s.refresh(context.Background()); err != nil {
+		logging.Error("Failed to count chunks: %v", err)
+		return 0
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cache.len()
+}
+
+// refresh reloads the cache if the database generation moved on since it was read.
+func (s *SQLiteVectorStore) refresh(ctx context.Context) error {
+	var generation int64
+	if err := s.db.QueryRowContext(ctx, `SELECT value FROM store_meta WHERE key = 'generation'`).Scan(&generation); err != nil {
+		return fmt.Errorf("failed to read vector store generation: %w", err)
+	}
+
+	s.mu.RLock()
+	current := generation == s.generation
+	s.mu.RUnlock()
+	if current {
+		return nil
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, document_id, content, embedding, source_type, source, page_number, section, tags
+		FROM chunks ORDER BY document_id, rowid`)
+	if err != nil {
+		return fmt.Errorf("failed to load chunks: %w", err)
+	}
+	defer rows.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cache := newChunkIndex(s.hnsw)
+	for rows.Next() {
+		var chunk rag.Chunk
+		var embedding []byte
+		var tags string
+		if err := rows.Scan(&chunk.ID, &chunk.DocumentID, &chunk.Content, &embedding,
+			&chunk.Metadata.SourceType, &chunk.Metadata.Source, &chunk.Metadata.PageNumber, &chunk.Metadata.Section, &tags); err != nil {
+			return fmt.Errorf("failed to load chunks: %w", err)
+		}
+		chunk.Embedding = decodeEmbedding(embedding)
+		if chunk.Metadata.Tags, err = decodeTags(tags); err != nil {
+			return fmt.Errorf("failed to load tags of chunk %s: %w", chunk.ID, err)
+		}
+		cache.add(chunk)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to load chunks: %w", err)
+	}
+	s.cache, s.generation = cache, generation
+	logging.Debug("Loaded %d chunks from SQLite vector store (generation %d)", cache.len(), generation)
+	return nil
+}
+
+// addedColumns are columns of chunks that stores created by earlier versions lack.
+var addedColumns = []struct{ name, definition string }{
+	{"source", "TEXT NOT NULL DEFAULT ''"},
+	{"tags", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// upgradeSchema adds the columns missing from stores created by earlier versions.
+func upgradeSchema(db *sql.DB) error {
+	for _, column := range addedColumns {
+		var n int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('chunks') WHERE name = ?`, column.name).Scan(&n); err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+		if _, err := db.Exec(`ALTER TABLE chunks ADD COLUMN ` + column.name + ` ` + column.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bumpGeneration marks the database as changed and returns its new generation.
+func bumpGeneration(ctx context.Context, tx *sql.Tx) (int64, error) {
+	var generation int64
+	err := tx.QueryRowContext(ctx, `UPDATE store_meta SET value = value + 1 WHERE key = 'generation' RETURNING value`).Scan(&generation)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update vector store generation: %w", err)
+	}
+	return generation, nil
+}
+
+// encodeEmbedding stores a vector as little-endian float32s.
+func encodeEmbedding(embedding []float32) []byte {
+	buf := make([]byte, 4*len(embedding))
+	for i, v := range embedding {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(v))
+	}
+	return buf
+}
+
+// encodeTags stores tags as a JSON object, or an empty string when there are none.
+func encodeTags(tags map[string]string) (string, error) {
+	if len(tags) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(tags)
+	return string(data), err
+}
+
+func decodeTags(data string) (map[string]string, error) {
+	if data == "" {
+		return nil, nil
+	}
+	var tags map[string]string
+	err := json.Unmarshal([]byte(data), &tags)
+	return tags, err
+}
+
+func decodeEmbedding(buf []byte) []float32 {
+	embedding := make([]float32, len(buf)/4)
+	for i := range embedding {
+		embedding[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+	}
+	return embedding
+}
+
+// timeLayout has a fixed width, so timestamps stored as text sort chronologically.
+const timeLayout = "2006-01-02T15:04:05.000000000Z"
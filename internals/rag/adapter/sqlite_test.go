@@ -0,0 +1,183 @@
+package adapter
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"mailflow/internals/rag"
+)
+
+func openSQLite(t *testing.T, path string) *SQLiteVectorStore {
+	t.Helper()
+	s, err := OpenSQLiteVectorStore(path)
+	if err != nil {
+		t.Fatalf("OpenSQLiteVectorStore: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// searchAll returns the IDs of every chunk in s, best match for storeQuery first.
+func searchAll(t *testing.T, s *SQLiteVectorStore) string {
+	t.Helper()
+	results, err := s.Search(context.Background(), storeQuery, rag.SearchOptions{TopN: 100, Filter: rag.Filter{DocumentIDs: []string{"billing", "pricing", "faq"}}})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	return chunkIDs(results)
+}
+
+func TestSQLiteVectorStoreSurvivesReopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "vectors.db")
+	s := openSQLite(t, path)
+	want := storeChunks()
+	if err := s.AddChunks(ctx, want); err != nil {
+		t.Fatalf("AddChunks: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	reopened := openSQLite(t, path)
+	if n := reopened.GetTotalChunks(); n != len(want) {
+		t.Fatalf("reopened store holds %d chunks, want %d", n, len(want))
+	}
+	results, err := reopened.Search(ctx, storeQuery, rag.SearchOptions{TopN: 1, Filter: rag.Filter{Sections: []string{"Billing > Invoices"}}})
+	if err != nil || len(results) != 1 {
+		t.Fatalf("Search = %v, %v, want the invoices chunk", results, err)
+	}
+	got := results[0].Chunk
+	if !reflect.DeepEqual(got, want[1]) {
+		t.Errorf("reopened chunk = %+v, want %+v", got, want[1])
+	}
+}
+
+func TestSQLiteVectorStoreSharedFile(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "vectors.db")
+	indexer := openSQLite(t, path)
+	server := openSQLite(t, path).WithHNSW(DefaultHNSWConfig())
+	chunks := storeChunks()
+
+	if err := indexer.AddChunks(ctx, chunks[:2]); err != nil {
+		t.Fatalf("AddChunks: %v", err)
+	}
+	if got := searchAll(t, server); got != "refunds,invoices" {
+		t.Fatalf("second store found %q, want refunds,invoices", got)
+	}
+
+	// Each store sees what the other adds and deletes after its cache was loaded.
+	if err := indexer.AddChunks(ctx, chunks[2:]); err != nil {
+		t.Fatalf("AddChunks: %v", err)
+	}
+	if got := searchAll(t, server); got != "router,refunds,invoices,plans,reset" {
+		t.Errorf("after more chunks were added, second store found %q", got)
+	}
+	if err := server.DeleteDocument(ctx, "faq"); err != nil {
+		t.Fatalf("DeleteDocument: %v", err)
+	}
+	if got := searchAll(t, indexer); got != "refunds,invoices,plans" {
+		t.Errorf("after a delete by the second store, first store found %q", got)
+	}
+	if n := server.GetTotalChunks(); n != len(chunks)-2 {
+		t.Errorf("second store counts %d chunks, want %d", n, len(chunks)-2)
+	}
+}
+
+func TestSQLiteVectorStoreDeleteDocument(t *testing.T) {
+	ctx := context.Background()
+	s := openSQLite(t, filepath.Join(t.TempDir(), "vectors.db"))
+	if err := s.AddChunks(ctx, storeChunks()); err != nil {
+		t.Fatalf("AddChunks: %v", err)
+	}
+	if got := searchAll(t, s); got != "router,refunds,invoices,plans,reset" {
+		t.Fatalf("found %q before the delete", got)
+	}
+
+	if err := s.DeleteDocument(ctx, "billing"); err != nil {
+		t.Fatalf("DeleteDocument: %v", err)
+	}
+	if got := searchAll(t, s); got != "router,plans,reset" {
+		t.Errorf("found %q after deleting billing, want router,plans,reset", got)
+	}
+	keyword, err := s.KeywordSearch(ctx, "refunds", rag.SearchOptions{TopN: 5})
+	if err != nil || len(keyword) != 0 {
+		t.Errorf("keyword search after the delete = %v, %v, want nothing", keyword, err)
+	}
+	// Deleting an unknown document is not an error.
+	if err := s.DeleteDocument(ctx, "missing"); err != nil {
+		t.Errorf("DeleteDocument of an unknown document: %v", err)
+	}
+
+	// Re-adding the document brings its chunks back.
+	if err := s.AddChunks(ctx, storeChunks()[:2]); err != nil {
+		t.Fatalf("AddChunks: %v", err)
+	}
+	if got := searchAll(t, s); got != "router,refunds,invoices,plans,reset" {
+		t.Errorf("found %q after re-adding billing", got)
+	}
+}
+
+func TestSQLiteVectorStoreUpgradesSchema(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "vectors.db")
+
+	// The layout of stores created before chunks recorded their source and tags.
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	_, err = db.Exec(`
+		CREATE TABLE chunks (
+			id          TEXT PRIMARY KEY,
+			document_id TEXT NOT NULL,
+			content     TEXT NOT NULL,
+			embedding   BLOB NOT NULL,
+			source_type TEXT NOT NULL DEFAULT '',
+			page_number INTEGER NOT NULL DEFAULT 0,
+			section     TEXT NOT NULL DEFAULT '',
+			created_at  TEXT NOT NULL
+		);
+		CREATE TABLE store_meta (key TEXT PRIMARY KEY, value INTEGER NOT NULL);
+		INSERT INTO store_meta (key, value) VALUES ('generation', 3);`)
+	if err == nil {
+		_, err = db.Exec(`INSERT INTO chunks (id, document_id, content, embedding, source_type, page_number, section, created_at)
+			VALUES ('old', 'legacy', 'Indexed before tags.', ?, 'text_file', 2, 'Intro', '2024-01-01T00:00:00.000000000Z')`,
+			encodeEmbedding([]float32{1, 0.2, 0}))
+	}
+	if err != nil {
+		t.Fatalf("creating the old layout: %v", err)
+	}
+	db.Close()
+
+	s := openSQLite(t, path)
+	if err := s.AddChunks(ctx, storeChunks()[:1]); err != nil {
+		t.Fatalf("AddChunks after the upgrade: %v", err)
+	}
+	results, err := s.Search(ctx, storeQuery, rag.SearchOptions{TopN: 5, Filter: rag.Filter{DocumentIDs: []string{"legacy", "billing"}}})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if got := chunkIDs(results); got != "refunds,old" {
+		t.Fatalf("found %q, want refunds,old", got)
+	}
+	old := results[1].Metadata
+	if old.SourceType != rag.SourceText || old.PageNumber != 2 || old.Section != "Intro" || old.Source != "" || old.Tags != nil {
+		t.Errorf("old chunk metadata = %+v, want its columns kept and no source or tags", old)
+	}
+	if tags := results[0].Metadata.Tags; tags["topic"] != "billing" {
+		t.Errorf("new chunk tags = %v, want topic=billing", tags)
+	}
+
+	// Opening the upgraded store again leaves it as it is.
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if n := openSQLite(t, path).GetTotalChunks(); n != 2 {
+		t.Errorf("store holds %d chunks after reopening, want 2", n)
+	}
+}
@@ -0,0 +1,158 @@
+package adapter
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"mailflow/internals/rag"
+)
+
+// storeQuery is the embedding every search of the store tests uses.
+var storeQuery = []float32{1, 0, 0}
+
+// storeChunks returns a small knowledge base: billing documentation in
+// Markdown and HTML, a plain text FAQ and filler chunks pointing away from
+// storeQuery. By similarity to it: router, refunds, invoices, plans, reset.
+func storeChunks() []rag.Chunk {
+	billing := map[string]string{"topic": "billing"}
+	chunks := []rag.Chunk{
+		{ID: "refunds", DocumentID: "billing", Content: "Refunds take five days.", Embedding: []float32{1, 0.1, 0},
+			Metadata: rag.Metadata{SourceType: rag.SourceMarkdown, Section: "Billing > Refunds", Tags: billing}},
+		{ID: "invoices", DocumentID: "billing", Content: "Invoices are sent monthly.", Embedding: []float32{1, 0.5, 0},
+			Metadata: rag.Metadata{SourceType: rag.SourceMarkdown, Section: "Billing > Invoices", Tags: map[string]string{"topic": "billing", "audience": "customers"}}},
+		{ID: "plans", DocumentID: "pricing", Content: "Plans start at $10.", Embedding: []float32{1, 1, 0},
+			Metadata: rag.Metadata{SourceType: rag.SourceHTML, Section: "Pricing", Tags: billing}},
+		{ID: "router", DocumentID: "faq", Content: "Restart the router.", Embedding: []float32{1, 0.05, 0},
+			Metadata: rag.Metadata{SourceType: rag.SourceText, Tags: map[string]string{"topic": "support"}}},
+		{ID: "reset", DocumentID: "faq", Content: "Reset your password from the login page.", Embedding: []float32{0, 1, 0},
+			Metadata: rag.Metadata{SourceType: rag.SourceText, Tags: map[string]string{"topic": "support"}}},
+	}
+	for i := 0; i < 40; i++ {
+		chunks = append(chunks, rag.Chunk{ID: fmt.Sprintf("filler-%d", i), DocumentID: "filler", Content: "Unrelated.",
+			Embedding: []float32{-1, float32(i) * 0.01, 1}, Metadata: rag.Metadata{SourceType: rag.SourceText}})
+	}
+	return chunks
+}
+
+// testStores opens every kind of store, empty, for a test.
+func testStores(t *testing.T) map[string]Store {
+	t.Helper()
+	open := func(name string, hnsw bool) Store {
+		s, err := OpenSQLiteVectorStore(filepath.Join(t.TempDir(), name+".db"))
+		if err != nil {
+			t.Fatalf("OpenSQLiteVectorStore: %v", err)
+		}
+		if hnsw {
+			s.WithHNSW(DefaultHNSWConfig())
+		}
+		return s
+	}
+	stores := map[string]Store{
+		"memory":      NewInMemoryVectorStore(),
+		"memory-hnsw": NewHNSWVectorStore(DefaultHNSWConfig()),
+		"sqlite":      open("exact", false),
+		"sqlite-hnsw": open("hnsw", true),
+	}
+	t.Cleanup(func() {
+		for _, s := range stores {
+			s.Close()
+		}
+	})
+	return stores
+}
+
+func chunkIDs(results []rag.ScoredChunk) string {
+	ids := make([]string, len(results))
+	for i, r := range results {
+		ids[i] = r.ID
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestSearchFiltersAndMinScore(t *testing.T) {
+	tests := []struct {
+		name string
+		opts rag.SearchOptions
+		want string // Chunk IDs, best first
+	}{
+		{name: "no filter", opts: rag.SearchOptions{TopN: 3}, want: "router,refunds,invoices"},
+		{name: "source type", opts: rag.SearchOptions{TopN: 5, Filter: rag.Filter{SourceTypes: []string{rag.SourceMarkdown}}}, want: "refunds,invoices"},
+		{name: "source types", opts: rag.SearchOptions{TopN: 5, Filter: rag.Filter{SourceTypes: []string{rag.SourceMarkdown, rag.SourceHTML}}}, want: "refunds,invoices,plans"},
+		{name: "section", opts: rag.SearchOptions{TopN: 5, Filter: rag.Filter{Sections: []string{"Billing > Invoices", "Pricing"}}}, want: "invoices,plans"},
+		{name: "document ID", opts: rag.SearchOptions{TopN: 5, Filter: rag.Filter{DocumentIDs: []string{"billing"}}}, want: "refunds,invoices"},
+		{name: "billing docs by tag", opts: rag.SearchOptions{TopN: 5, Filter: rag.Filter{Tags: map[string]string{"topic": "billing"}}}, want: "refunds,invoices,plans"},
+		{name: "every tag", opts: rag.SearchOptions{TopN: 5, Filter: rag.Filter{Tags: map[string]string{"topic": "billing", "audience": "customers"}}}, want: "invoices"},
+		{name: "TopN of a filter", opts: rag.SearchOptions{TopN: 1, Filter: rag.Filter{Tags: map[string]string{"topic": "support"}}}, want: "router"},
+		// Most chunks are text, so HNSW stores walk the graph for this filter
+		// rather than scoring the matching chunks directly.
+		{name: "broad filter", opts: rag.SearchOptions{TopN: 2, Filter: rag.Filter{SourceTypes: []string{rag.SourceText}}}, want: "router,reset"},
+		{name: "no match", opts: rag.SearchOptions{TopN: 5, Filter: rag.Filter{Tags: map[string]string{"topic": "sales"}}}, want: ""},
+		{name: "MinScore", opts: rag.SearchOptions{TopN: 10, MinScore: 0.9}, want: "router,refunds"},
+		{name: "MinScore and filter", opts: rag.SearchOptions{TopN: 10, MinScore: 0.8, Filter: rag.Filter{Tags: map[string]string{"topic": "billing"}}}, want: "refunds,invoices"},
+		{name: "MinScore and broad filter", opts: rag.SearchOptions{TopN: 10, MinScore: 0.5, Filter: rag.Filter{SourceTypes: []string{rag.SourceText}}}, want: "router"},
+		{name: "MinScore above every chunk", opts: rag.SearchOptions{TopN: 10, MinScore: 0.9999}, want: ""},
+	}
+	ctx := context.Background()
+	for name, store := range testStores(t) {
+		if err := store.AddChunks(ctx, storeChunks()); err != nil {
+			t.Fatalf("%s: AddChunks: %v", name, err)
+		}
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				results, err := store.Search(ctx, storeQuery, tt.opts)
+				if err != nil {
+					t.Fatalf("Search: %v", err)
+				}
+				if got := chunkIDs(results); got != tt.want {
+					t.Errorf("Search returned %q, want %q", got, tt.want)
+				}
+				for i, r := range results {
+					if !tt.opts.Accepts(r.Score) || (i > 0 && r.Score > results[i-1].Score) {
+						t.Errorf("result %d (%s) scores %.4f, after %v", i, r.ID, r.Score, results[:i])
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestReplaceDocument(t *testing.T) {
+	ctx := context.Background()
+	replacement := rag.Chunk{ID: "billing-v2", DocumentID: "billing", Content: "Billing moved to the portal.", Embedding: []float32{1, 0.3, 0},
+		Metadata: rag.Metadata{SourceType: rag.SourceMarkdown, Tags: map[string]string{"topic": "billing"}}}
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := store.AddChunks(ctx, storeChunks()); err != nil {
+				t.Fatalf("AddChunks: %v", err)
+			}
+			// Search once, so stores that cache chunks update their cache in place.
+			if _, err := store.Search(ctx, storeQuery, rag.SearchOptions{TopN: 1}); err != nil {
+				t.Fatalf("Search: %v", err)
+			}
+			if err := store.ReplaceDocument(ctx, "billing", []rag.Chunk{replacement}); err != nil {
+				t.Fatalf("ReplaceDocument: %v", err)
+			}
+
+			results, err := store.Search(ctx, storeQuery, rag.SearchOptions{TopN: 5, Filter: rag.Filter{Tags: map[string]string{"topic": "billing"}}})
+			if err != nil {
+				t.Fatalf("Search: %v", err)
+			}
+			if got := chunkIDs(results); got != "billing-v2,plans" {
+				t.Errorf("billing search found %q after the replace, want billing-v2,plans", got)
+			}
+			keyword, err := store.KeywordSearch(ctx, "refunds portal", rag.SearchOptions{TopN: 5})
+			if err != nil {
+				t.Fatalf("KeywordSearch: %v", err)
+			}
+			if got := chunkIDs(keyword); got != "billing-v2" {
+				t.Errorf("keyword search found %q after the replace, want billing-v2", got)
+			}
+			if n, want := store.GetTotalChunks(), len(storeChunks())-1; n != want {
+				t.Errorf("store holds %d chunks, want %d", n, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package rag
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// BM25Params tunes keyword scoring. K1 controls how quickly repeating a term
+// stops adding to a chunk's score, B how strongly long chunks are penalized.
+type BM25Params struct {
+	K1 float64
+	B  float64
+}
+
+// DefaultBM25Params returns the values commonly used by search engines.
+func DefaultBM25Params() BM25Params {
+	return BM25Params{K1: 1.2, B: 0.75}
+}
+
+// KeywordIndex is a lexical index kept next to a VectorStore, for queries
+// naming plan names, error codes or SKUs that embeddings match poorly.
+type KeywordIndex interface {
+	AddChunks(ctx context.Context, chunks []Chunk) error
+	DeleteDocument(ctx context.Context, documentID string) error
+
+	// KeywordSearch returns the opts.TopN chunks passing opts.Filter that
+	// best match the terms of query, by BM25 score. opts.MinScore is ignored,
+	// as BM25 scores have no fixed range.
+	KeywordSearch(ctx context.Context, query string, opts SearchOptions) ([]ScoredChunk, error)
+}
+
+// BM25Index is an in-memory inverted index scoring chunks with Okapi BM25.
+// Removed chunks are skipped until they make up a quarter of the index,
+// which is then rebuilt. It is safe for concurrent use.
+type BM25Index struct {
+	params BM25Params
+
+	mu          sync.RWMutex
+	chunks      []Chunk // By position
+	lengths     []int   // Terms per chunk
+	removed     []bool
+	nRemoved    int
+	totalLength int                  // Terms in live chunks
+	positions   map[string]int       // Chunk ID to position
+	postings    map[string][]posting // Term to the chunks containing it
+}
+
+type posting struct {
+	chunk     int32
+	frequency int32
+}
+
+// NewBM25Index creates an empty index; zero params select DefaultBM25Params.
+func NewBM25Index(params BM25Params) *BM25Index {
+	defaults := DefaultBM25Params()
+	if params.K1 <= 0 {
+		params.K1 = defaults.K1
+	}
+	if params.B <= 0 || params.B > 1 {
+		params.B = defaults.B
+	}
+	return &BM25Index{
+		params:    params,
+		positions: make(map[string]int),
+		postings:  make(map[string][]posting),
+	}
+}
+
+// AddChunks indexes chunks, skipping IDs that are already present.
+func (x *BM25Index) AddChunks(ctx context.Context, chunks []Chunk) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for _, chunk := range chunks {
+		x.add(chunk)
+	}
+	return nil
+}
+
+func (x *BM25Index) add(chunk Chunk) {
+	if _, exists := x.positions[chunk.ID]; exists {
+		return
+	}
+	position := len(x.chunks)
+	x.positions[chunk.ID] = position
+	x.chunks = append(x.chunks, chunk)
+	x.removed = append(x.removed, false)
+
+	terms := keywordTerms(chunk.Content)
+	x.lengths = append(x.lengths, len(terms))
+	x.totalLength += len(terms)
+	frequencies := make(map[string]int32)
+	for _, term := range terms {
+		frequencies[term]++
+	}
+	for term, frequency := range frequencies {
+		x.postings[term] = append(x.postings[term], posting{chunk: int32(position), frequency: frequency})
+	}
+}
+
+// DeleteDocument removes every chunk of a document, so it can be re-indexed.
+func (x *BM25Index) DeleteDocument(ctx context.Context, documentID string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for i, chunk := range x.chunks {
+		if !x.removed[i] && chunk.DocumentID == documentID {
+			x.removed[i] = true
+			delete(x.positions, chunk.ID)
+			x.totalLength -= x.lengths[i]
+			x.nRemoved++
+		}
+	}
+	if x.nRemoved > 0 && x.nRemoved*4 >= len(x.chunks) {
+		live := make([]Chunk, 0, len(x.chunks)-x.nRemoved)
+		for i, chunk := range x.chunks {
+			if !x.removed[i] {
+				live = append(live, chunk)
+			}
+		}
+		x.chunks, x.lengths, x.removed, x.nRemoved, x.totalLength = nil, nil, nil, 0, 0
+		x.positions, x.postings = make(map[string]int), make(map[string][]posting)
+		for _, chunk := range live {
+			x.add(chunk)
+		}
+	}
+	return nil
+}
+
+// Len returns how many chunks are indexed.
+func (x *BM25Index) Len() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return len(x.chunks) - x.nRemoved
+}
+
+func (x *BM25Index) KeywordSearch(ctx context.Context, query string, opts SearchOptions) ([]ScoredChunk, error) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	live := len(x.chunks) - x.nRemoved
+	if live == 0 || opts.TopN <= 0 {
+		return nil, nil
+	}
+	averageLength := float64(x.totalLength) / float64(live)
+	if averageLength == 0 {
+		return nil, nil
+	}
+
+	scores := make(map[int32]float64)
+	seen := make(map[string]bool)
+	for _, term := range keywordTerms(query) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+
+		postings := x.postings[term]
+		documentFrequency := 0
+		for _, p := range postings {
+			if !x.removed[p.chunk] {
+				documentFrequency++
+			}
+		}
+		if documentFrequency == 0 {
+			continue
+		}
+		idf := math.Log(1 + (float64(live)-float64(documentFrequency)+0.5)/(float64(documentFrequency)+0.5))
+		for _, p := range postings {
+			if x.removed[p.chunk] {
+				continue
+			}
+			tf := float64(p.frequency)
+			norm := x.params.K1 * (1 - x.params.B + x.params.B*float64(x.lengths[p.chunk])/averageLength)
+			scores[p.chunk] += idf * tf * (x.params.K1 + 1) / (tf + norm)
+		}
+	}
+
+	results := make([]ScoredChunk, 0, len(scores))
+	for position, score := range scores {
+		if chunk := x.chunks[position]; opts.Filter.Matches(chunk) {
+			results = append(results, ScoredChunk{Chunk: chunk, Score: score})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ID < results[j].ID
+	})
+	if len(results) > opts.TopN {
+		results = results[:opts.TopN]
+	}
+	return results, nil
+}
+
+// keywordJoiners connect the parts of identifiers such as "SKU-42-B",
+// "ERR_TIMEOUT" or "v2.1".
+const keywordJoiners = "-_./"
+
+// keywordTerms splits text into lower-cased terms: runs of letters and digits,
+// plus every identifier whose parts are connected by keywordJoiners as a
+// whole, so an exact code ranks above chunks that merely share its parts.
+// Common English words are dropped.
+func keywordTerms(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune(keywordJoiners, r)
+	})
+	var terms []string
+	for _, field := range fields {
+		field = strings.Trim(field, keywordJoiners)
+		parts := strings.FieldsFunc(field, func(r rune) bool {
+			return strings.ContainsRune(keywordJoiners, r)
+		})
+		for _, part := range parts {
+			if !stopWords[part] {
+				terms = append(terms, part)
+			}
+		}
+		if len(parts) > 1 {
+			terms = append(terms, field)
+		}
+	}
+	return terms
+}
+
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "but": true,
+	"by": true, "can": true, "do": true, "does": true, "for": true, "from": true, "has": true, "have": true,
+	"how": true, "i": true, "if": true, "in": true, "is": true, "it": true, "its": true, "me": true,
+	"my": true, "of": true, "on": true, "or": true, "our": true, "so": true, "that": true, "the": true,
+	"their": true, "this": true, "to": true, "was": true, "we": true, "were": true, "what": true, "when": true,
+	"where": true, "which": true, "who": true, "why": true, "will": true, "with": true, "you": true, "your": true,
+}
@@ -0,0 +1,406 @@
+package rag
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+const (
+	// DefaultChunkTokens keeps chunks well inside embedding models' input limits
+	// while holding a few paragraphs of context.
+	DefaultChunkTokens = 256
+	// DefaultOverlapTokens is how much of the end of a chunk the next one repeats.
+	DefaultOverlapTokens = 32
+)
+
+// Document types, recorded as the SourceType of their chunks.
+const (
+	SourceText     = "text_file"
+	SourceMarkdown = "markdown_file"
+	SourceHTML     = "html_file"
+)
+
+// Chunker names accepted by NewChunker.
+const (
+	ChunkerFixed    = "fixed"    // SimpleTextChunker: fixed-size character windows
+	ChunkerSentence = "sentence" // SentenceChunker: paragraphs and sentences
+	ChunkerMarkdown = "markdown" // MarkdownChunker: sections under headings
+	ChunkerHTML     = "html"     // HTMLChunker: sections under headings, without markup
+)
+
+// DocumentType guesses a document's type from the extension of its source,
+// defaulting to plain text.
+func DocumentType(source string) string {
+	switch strings.ToLower(filepath.Ext(source)) {
+	case ".md", ".markdown", ".mdx":
+		return SourceMarkdown
+	case ".html", ".htm", ".xhtml":
+		return SourceHTML
+	default:
+		return SourceText
+	}
+}
+
+// DocumentTypes lists the types DocumentType returns.
+func DocumentTypes() []string {
+	return []string{SourceText, SourceMarkdown, SourceHTML}
+}
+
+// DefaultChunkerNames maps every document type to the chunker that follows its structure.
+func DefaultChunkerNames() map[string]string {
+	return map[string]string{
+		SourceText:     ChunkerSentence,
+		SourceMarkdown: ChunkerMarkdown,
+		SourceHTML:     ChunkerHTML,
+	}
+}
+
+// NewChunker returns the named chunker, sized by limits. MaxTokens defaults to
+// DefaultChunkTokens; an overlap of half of it or more is an error.
+func NewChunker(name string, limits TokenLimits) (TextChunker, error) {
+	if limits.MaxTokens <= 0 {
+		limits.MaxTokens = DefaultChunkTokens
+	}
+	if limits.OverlapTokens < 0 || limits.OverlapTokens >= limits.MaxTokens/2 {
+		return nil, fmt.Errorf("chunk overlap must be at least 0 and less than half of the chunk size, got %d of %d tokens", limits.OverlapTokens, limits.MaxTokens)
+	}
+	switch name {
+	case ChunkerFixed:
+		// About four characters make a token.
+		return NewSimpleTextChunker(4*limits.MaxTokens, 4*limits.OverlapTokens), nil
+	case ChunkerSentence:
+		return &SentenceChunker{Limits: limits}, nil
+	case ChunkerMarkdown:
+		return &MarkdownChunker{Limits: limits}, nil
+	case ChunkerHTML:
+		return &HTMLChunker{Limits: limits}, nil
+	default:
+		return nil, fmt.Errorf("unknown chunker %q (want %s, %s, %s or %s)", name, ChunkerFixed, ChunkerSentence, ChunkerMarkdown, ChunkerHTML)
+	}
+}
+
+// NewChunkers builds the chunker of every document type from their names,
+// such as DefaultChunkerNames, for RAGSystem.WithChunkers.
+func NewChunkers(names map[string]string, limits TokenLimits) (map[string]TextChunker, error) {
+	chunkers := make(map[string]TextChunker, len(names))
+	for docType, name := range names {
+		chunker, err := NewChunker(name, limits)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", docType, err)
+		}
+		chunkers[docType] = chunker
+	}
+	return chunkers, nil
+}
+
+// TokenLimits bounds the size of chunks in estimated tokens (see EstimateTokens).
+type TokenLimits struct {
+	MaxTokens     int // Most tokens per chunk; longer sentences are split between words
+	OverlapTokens int // Trailing sentences of a chunk, up to this many tokens, start the next one; less than MaxTokens/2
+}
+
+// withDefaults fills in MaxTokens and replaces an overlap that is negative or
+// half of MaxTokens or more, with which chunks would mostly repeat each other,
+// by DefaultOverlapTokens, at most a quarter of MaxTokens. The New*Chunker
+// constructors size chunkers with it; NewChunker rejects such an overlap.
+func (l TokenLimits) withDefaults() TokenLimits {
+	if l.MaxTokens <= 0 {
+		l.MaxTokens = DefaultChunkTokens
+	}
+	if l.OverlapTokens < 0 || l.OverlapTokens >= l.MaxTokens/2 {
+		l.OverlapTokens = min(DefaultOverlapTokens, l.MaxTokens/4)
+	}
+	return l
+}
+
+// EstimateTokens approximates the number of tokens an embedding model's
+// subword tokenizer makes of text: one per four letters or digits of a word,
+// at least one per word, and one per punctuation mark.
+func EstimateTokens(text string) int {
+	tokens, word := 0, 0
+	for _, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			word++
+			continue
+		}
+		tokens += (word + 3) / 4
+		word = 0
+		if !unicode.IsSpace(r) {
+			tokens++
+		}
+	}
+	return tokens + (word+3)/4
+}
+
+// SentenceChunker splits plain text into chunks of whole sentences, starting
+// a new paragraph's sentences on a new line. Form feeds, which PDF-to-text
+// converters put between pages, number the pages of the chunks.
+type SentenceChunker struct {
+	Limits TokenLimits
+}
+
+func NewSentenceChunker(maxTokens, overlapTokens int) *SentenceChunker {
+	return &SentenceChunker{Limits: TokenLimits{MaxTokens: maxTokens, OverlapTokens: overlapTokens}.withDefaults()}
+}
+
+func (s *SentenceChunker) Chunk(text string, docID string, docMetadata Metadata) ([]Chunk, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, fmt.Errorf("cannot chunk empty text")
+	}
+	p := newPacker(docID, docMetadata, s.Limits)
+	pages := strings.Split(text, "\f")
+	for i, page := range pages {
+		number := 0
+		if len(pages) > 1 {
+			number = i + 1
+		}
+		for _, paragraph := range paragraphBreak.Split(page, -1) {
+			p.addParagraph(paragraph, number)
+		}
+	}
+	return p.finish()
+}
+
+var paragraphBreak = regexp.MustCompile(`\n[ \t\r]*\n`)
+
+// unit is the smallest piece of text a chunk boundary may fall after.
+type unit struct {
+	text    string
+	tokens  int
+	page    int
+	sep     string // Joins it to the unit before it in the same chunk
+	heading bool   // Section headings alone do not make a chunk
+}
+
+// packer fills chunks with units up to the token limit. Chunks never span
+// sections, so each carries the heading path of the section it comes from.
+type packer struct {
+	docID  string
+	meta   Metadata
+	limits TokenLimits
+	chunks []Chunk
+
+	units  []unit // Of the chunk being filled
+	tokens int
+	fresh  int // Units not repeated from the previous chunk
+}
+
+func newPacker(docID string, meta Metadata, limits TokenLimits) *packer {
+	return &packer{docID: docID, meta: meta, limits: limits.withDefaults()}
+}
+
+// startSection finishes the current chunk; the following ones belong to section.
+func (p *packer) startSection(section string) {
+	p.flush()
+	p.units, p.tokens, p.fresh = nil, 0, 0
+	p.meta.Section = section
+}
+
+func (p *packer) addHeading(title string, page int) {
+	if title = strings.TrimSpace(title); title != "" {
+		p.add(unit{text: title, page: page, sep: "\n\n", heading: true})
+	}
+}
+
+// addParagraph adds prose sentence by sentence. Lists and tables keep one
+// line per item or row.
+func (p *packer) addParagraph(paragraph string, page int) {
+	lines := strings.Split(strings.TrimSpace(paragraph), "\n")
+	if len(lines) == 1 && lines[0] == "" {
+		return
+	}
+	itemized := false
+	for _, line := range lines {
+		if listItem.MatchString(line) {
+			itemized = true
+			break
+		}
+	}
+	if !itemized {
+		lines = []string{strings.Join(strings.Fields(strings.Join(lines, " ")), " ")}
+	}
+	sep := "\n\n"
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		for i, sentence := range splitSentences(line) {
+			if i > 0 {
+				sep = " "
+			}
+			p.add(unit{text: sentence, page: page, sep: sep})
+		}
+		sep = "\n"
+	}
+}
+
+// addVerbatim adds code, keeping its lines and indentation.
+func (p *packer) addVerbatim(text string, page int) {
+	sep := "\n\n"
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		p.add(unit{text: strings.TrimRight(line, " \t\r"), page: page, sep: sep})
+		sep = "\n"
+	}
+}
+
+func (p *packer) add(u unit) {
+	u.tokens = EstimateTokens(u.text)
+	if u.tokens > p.limits.MaxTokens {
+		for _, piece := range splitWords(u.text, p.limits.MaxTokens) {
+			p.add(unit{text: piece, page: u.page, sep: u.sep, heading: u.heading})
+			u.sep = " "
+		}
+		return
+	}
+	if p.tokens+u.tokens > p.limits.MaxTokens && p.fresh > 0 {
+		p.flush()
+		p.keepOverlap()
+	}
+	// The overlap gives way when it leaves no room for the new unit.
+	for len(p.units) > 0 && p.tokens+u.tokens > p.limits.MaxTokens {
+		p.tokens -= p.units[0].tokens
+		p.units = p.units[1:]
+	}
+	p.units = append(p.units, u)
+	p.tokens += u.tokens
+	p.fresh++
+}
+
+// flush turns the units into a chunk, unless they are all headings or were
+// already part of the previous chunk.
+func (p *packer) flush() {
+	body := false
+	for _, u := range p.units[len(p.units)-p.fresh:] {
+		body = body || !u.heading
+	}
+	if !body {
+		return
+	}
+	var content strings.Builder
+	for i, u := range p.units {
+		if i > 0 {
+			content.WriteString(u.sep)
+		}
+		content.WriteString(u.text)
+	}
+	meta := p.meta
+	meta.PageNumber = p.units[0].page
+	p.chunks = append(p.chunks, NewChunk(p.docID, content.String(), nil, meta))
+}
+
+// keepOverlap starts the next chunk with the trailing units of the last one
+// that fit in OverlapTokens.
+func (p *packer) keepOverlap() {
+	start, tokens := len(p.units), 0
+	for start > 0 && tokens+p.units[start-1].tokens <= p.limits.OverlapTokens {
+		start--
+		tokens += p.units[start].tokens
+	}
+	p.units, p.tokens, p.fresh = append([]unit(nil), p.units[start:]...), tokens, 0
+}
+
+func (p *packer) finish() ([]Chunk, error) {
+	p.flush()
+	if len(p.chunks) == 0 {
+		return nil, fmt.Errorf("no text to chunk in document %s", p.docID)
+	}
+	return p.chunks, nil
+}
+
+// listItem matches the start of list items and table rows in plain text and Markdown.
+var listItem = regexp.MustCompile(`^\s*([-*+•]\s|\d+[.)]\s|\|)`)
+
+// abbreviations end in a period without ending the sentence.
+var abbreviations = map[string]bool{
+	"e.g": true, "i.e": true, "mr": true, "mrs": true, "ms": true, "dr": true, "prof": true,
+	"vs": true, "inc": true, "ltd": true, "no": true, "fig": true, "approx": true, "st": true,
+}
+
+// splitSentences splits text after '.', '!' and '?' followed by a space,
+// except after abbreviations and single letters such as initials.
+func splitSentences(text string) []string {
+	var sentences []string
+	runes := []rune(text)
+	start := 0
+	for i := 0; i < len(runes); i++ {
+		if r := runes[i]; r != '.' && r != '!' && r != '?' {
+			continue
+		}
+		end := i + 1
+		for end < len(runes) && strings.ContainsRune(`"')]”’`, runes[end]) {
+			end++
+		}
+		if end < len(runes) && !unicode.IsSpace(runes[end]) {
+			continue
+		}
+		if runes[i] == '.' {
+			words := strings.Fields(string(runes[start:i]))
+			if len(words) > 0 {
+				last := strings.ToLower(strings.TrimLeft(words[len(words)-1], `"'([`))
+				if abbreviations[last] || len([]rune(last)) == 1 {
+					continue
+				}
+			}
+		}
+		if sentence := strings.TrimSpace(string(runes[start:end])); sentence != "" {
+			sentences = append(sentences, sentence)
+		}
+		start = end
+	}
+	if rest := strings.TrimSpace(string(runes[start:])); rest != "" {
+		sentences = append(sentences, rest)
+	}
+	return sentences
+}
+
+// splitWords breaks text longer than maxTokens between words. Single words
+// over the limit are cut.
+func splitWords(text string, maxTokens int) []string {
+	var pieces, piece []string
+	tokens := 0
+	flush := func() {
+		if len(piece) > 0 {
+			pieces = append(pieces, strings.Join(piece, " "))
+			piece, tokens = nil, 0
+		}
+	}
+	for _, word := range strings.Fields(text) {
+		for n := EstimateTokens(word); n > maxTokens; n = EstimateTokens(word) {
+			flush()
+			cut := len([]rune(word)) * maxTokens / n
+			pieces = append(pieces, string([]rune(word)[:cut]))
+			word = string([]rune(word)[cut:])
+		}
+		n := EstimateTokens(word)
+		if tokens+n > maxTokens {
+			flush()
+		}
+		piece = append(piece, word)
+		tokens += n
+	}
+	flush()
+	return pieces
+}
+
+// headingPath tracks the headings enclosing the current position and names
+// sections after them, e.g. "Pricing > Plans".
+type headingPath []string
+
+func (h *headingPath) enter(level int, title string) string {
+	path := (*h)[:min(len(*h), level-1)]
+	for len(path) < level-1 {
+		path = append(path, "")
+	}
+	*h = append(path, strings.Join(strings.Fields(title), " "))
+	var named []string
+	for _, t := range *h {
+		if t != "" {
+			named = append(named, t)
+		}
+	}
+	return strings.Join(named, " > ")
+}
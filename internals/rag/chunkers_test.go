@@ -0,0 +1,326 @@
+package rag
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// describe renders chunks as "section|page|content" for comparison.
+func describe(chunks []Chunk) []string {
+	out := make([]string, len(chunks))
+	for i, c := range chunks {
+		out[i] = fmt.Sprintf("%s|%d|%s", c.Metadata.Section, c.Metadata.PageNumber, c.Content)
+	}
+	return out
+}
+
+func assertChunks(t *testing.T, chunks []Chunk, want []string) {
+	t.Helper()
+	got := describe(chunks)
+	if strings.Join(got, "\n---\n") != strings.Join(want, "\n---\n") {
+		t.Errorf("chunks:\n%s\nwant:\n%s", strings.Join(got, "\n---\n"), strings.Join(want, "\n---\n"))
+	}
+}
+
+func TestSplitSentences(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{text: "Hello world. How are you? Fine!", want: []string{"Hello world.", "How are you?", "Fine!"}},
+		{text: "Ask Dr. Smith, e.g. by phone. Thanks.", want: []string{"Ask Dr. Smith, e.g. by phone.", "Thanks."}},
+		{text: "J. R. R. Tolkien wrote it. Read it.", want: []string{"J. R. R. Tolkien wrote it.", "Read it."}},
+		{text: `He said "stop." Then he left.`, want: []string{`He said "stop."`, "Then he left."}},
+		{text: "Version 2.5 is out (see the notes.) Update now.", want: []string{"Version 2.5 is out (see the notes.)", "Update now."}},
+		{text: "Plan A costs $10.Plan B more", want: []string{"Plan A costs $10.Plan B more"}},
+		{text: "No terminal punctuation", want: []string{"No terminal punctuation"}},
+	}
+	for _, tt := range tests {
+		got := splitSentences(tt.text)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("splitSentences(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSplitWords(t *testing.T) {
+	long := strings.Repeat("a", 40) // Ten tokens
+	tests := []struct {
+		name      string
+		text      string
+		maxTokens int
+		want      []string
+	}{
+		{name: "between words", text: "one two three four five", maxTokens: 3, want: []string{"one two", "three four", "five"}},
+		{name: "oversized word is cut", text: long, maxTokens: 4, want: []string{long[:16], long[16:32], long[32:]}},
+		{name: "words around a cut word keep their order", text: "x " + long + " y", maxTokens: 4, want: []string{"x", long[:16], long[16:32], long[32:] + " y"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitWords(tt.text, tt.maxTokens)
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("splitWords = %q, want %q", got, tt.want)
+			}
+			for _, piece := range got {
+				if n := EstimateTokens(piece); n > tt.maxTokens {
+					t.Errorf("piece %q has %d tokens, more than %d", piece, n, tt.maxTokens)
+				}
+			}
+		})
+	}
+}
+
+func TestSentenceChunker(t *testing.T) {
+	// Each sentence is four one-token words and a period, five tokens.
+	const (
+		s1 = "Ants bite cold dogs."
+		s2 = "Eels fish good hats."
+		s3 = "Inks jump kind legs."
+		s4 = "Mice nest over pans."
+	)
+	tests := []struct {
+		name   string
+		text   string
+		limits TokenLimits
+		want   []string
+	}{
+		{
+			name:   "sentences packed with overlap",
+			text:   strings.Join([]string{s1, s2, s3, s4}, " "),
+			limits: TokenLimits{MaxTokens: 12, OverlapTokens: 5},
+			want:   []string{"|0|" + s1 + " " + s2, "|0|" + s2 + " " + s3, "|0|" + s3 + " " + s4},
+		},
+		{
+			name:   "no overlap",
+			text:   strings.Join([]string{s1, s2, s3, s4}, " "),
+			limits: TokenLimits{MaxTokens: 12},
+			want:   []string{"|0|" + s1 + " " + s2, "|0|" + s3 + " " + s4},
+		},
+		{
+			// The repeated s2 leaves no room for the ten-token sentence.
+			name:   "overlap evicted for a long sentence",
+			text:   s1 + " " + s2 + " Aa bb cc dd ee ff gg hh ii.",
+			limits: TokenLimits{MaxTokens: 12, OverlapTokens: 5},
+			want:   []string{"|0|" + s1 + " " + s2, "|0|Aa bb cc dd ee ff gg hh ii."},
+		},
+		{
+			name:   "oversized sentence split between words",
+			text:   "a b c d e f g h i j k l m n.",
+			limits: TokenLimits{MaxTokens: 6, OverlapTokens: 2},
+			want:   []string{"|0|a b c d e f", "|0|g h i j k l", "|0|m n."},
+		},
+		{
+			name:   "paragraphs and list items",
+			text:   s1 + "\nwrapped line.\n\n- first item\n- second item",
+			limits: TokenLimits{MaxTokens: 100},
+			want:   []string{"|0|" + s1 + " wrapped line.\n\n- first item\n- second item"},
+		},
+		{
+			// A chunk is numbered by the page it starts on; empty pages count.
+			name:   "form feeds number pages",
+			text:   s1 + "\f" + s2 + " " + s3 + "\f\f" + s4,
+			limits: TokenLimits{MaxTokens: 10},
+			want:   []string{"|1|" + s1 + "\n\n" + s2, "|2|" + s3 + "\n\n" + s4},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks, err := (&SentenceChunker{Limits: tt.limits}).Chunk(tt.text, "doc", Metadata{SourceType: SourceText})
+			if err != nil {
+				t.Fatalf("Chunk: %v", err)
+			}
+			assertChunks(t, chunks, tt.want)
+			for _, c := range chunks {
+				if c.DocumentID != "doc" || c.Metadata.SourceType != SourceText {
+					t.Errorf("chunk %q has document %q and source type %q", c.Content, c.DocumentID, c.Metadata.SourceType)
+				}
+				if n := EstimateTokens(c.Content); n > tt.limits.MaxTokens {
+					t.Errorf("chunk %q has %d tokens, more than %d", c.Content, n, tt.limits.MaxTokens)
+				}
+			}
+		})
+	}
+}
+
+func TestMarkdownChunker(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{
+			name: "sections named by heading path",
+			text: `---
+title: Billing guide
+---
+# Billing
+
+How we bill.
+
+## Refunds ##
+
+Refunds take five days.
+
+### Card
+
+Card refunds are instant.
+
+## Invoices
+
+Invoices are monthly.
+`,
+			want: []string{
+				"Billing|0|Billing\n\nHow we bill.",
+				"Billing > Refunds|0|Refunds\n\nRefunds take five days.",
+				"Billing > Refunds > Card|0|Card\n\nCard refunds are instant.",
+				"Billing > Invoices|0|Invoices\n\nInvoices are monthly.",
+			},
+		},
+		{
+			name: "setext headings and thematic breaks",
+			text: `Plans
+=====
+
+Monthly or yearly.
+
+---
+
+After the break.
+
+Extras
+------
+
+- Support
+---
+
+Not a heading either
+- item
+===
+`,
+			want: []string{
+				"Plans|0|Plans\n\nMonthly or yearly.\n\nAfter the break.",
+				"Plans > Extras|0|Extras\n\n- Support\n\nNot a heading either\n- item\n===",
+			},
+		},
+		{
+			name: "fenced code keeps its lines",
+			text: "# Setup\n\nRun this:\n\n```sh\nmake build\n  # indented, not a heading\n```\n\nDone.",
+			want: []string{"Setup|0|Setup\n\nRun this:\n\n```sh\nmake build\n  # indented, not a heading\n```\n\nDone."},
+		},
+		{
+			name: "unclosed fence runs to the end",
+			text: "# Code\n\n~~~\nfoo()\n# not a heading\n",
+			want: []string{"Code|0|Code\n\n~~~\nfoo()\n# not a heading"},
+		},
+		{
+			name: "headings without body make no chunk",
+			text: "# Empty\n\n## Also empty\n\n## Full\n\nText.",
+			want: []string{"Empty > Full|0|Full\n\nText."},
+		},
+		{
+			name: "unterminated front matter is text",
+			text: "---\ntitle: x\n\nBody.",
+			want: []string{"|0|title: x\n\nBody."},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks, err := (&MarkdownChunker{Limits: TokenLimits{MaxTokens: 100}}).Chunk(tt.text, "doc", Metadata{})
+			if err != nil {
+				t.Fatalf("Chunk: %v", err)
+			}
+			assertChunks(t, chunks, tt.want)
+		})
+	}
+}
+
+func TestHTMLChunker(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{
+			name: "sections, tables and preformatted text",
+			text: `<html><head><title>Pricing</title><style>p { color: red }</style></head><body>
+<nav><a href="/">Home</a></nav>
+<h1>Pricing</h1>
+<p>Plans start
+   at <b>$10</b>. Cancel any time.</p>
+<h2>Plans</h2>
+<table>
+<tr><th>Plan</th><th>Price</th></tr>
+<tr><td>Basic</td><td> $10 </td></tr>
+</table>
+<h2>API</h2>
+<pre>curl -X POST \
+  /v1/plans</pre>
+<script>track()</script>
+</body></html>`,
+			want: []string{
+				"Pricing|0|Pricing\n\nPlans start at $10. Cancel any time.",
+				"Pricing > Plans|0|Plans\n\n| Plan | Price |\n\n| Basic | $10 |",
+				"Pricing > API|0|API\n\ncurl -X POST \\\n  /v1/plans",
+			},
+		},
+		{
+			name: "lists and line breaks",
+			text: `<h3>Support</h3><ul><li>Email</li><li>Phone</li></ul><p>Call us<br>any day.</p>`,
+			want: []string{"Support|0|Support\n\nEmail\n\nPhone\n\nCall us any day."},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks, err := (&HTMLChunker{Limits: TokenLimits{MaxTokens: 100}}).Chunk(tt.text, "doc", Metadata{})
+			if err != nil {
+				t.Fatalf("Chunk: %v", err)
+			}
+			assertChunks(t, chunks, tt.want)
+		})
+	}
+}
+
+func TestNewChunker(t *testing.T) {
+	tests := []struct {
+		name    string
+		chunker string
+		limits  TokenLimits
+		want    TokenLimits
+		wantErr bool
+	}{
+		{name: "defaults", chunker: ChunkerSentence, want: TokenLimits{MaxTokens: DefaultChunkTokens}},
+		{name: "explicit", chunker: ChunkerMarkdown, limits: TokenLimits{MaxTokens: 100, OverlapTokens: 49}, want: TokenLimits{MaxTokens: 100, OverlapTokens: 49}},
+		{name: "overlap of half the chunk", chunker: ChunkerHTML, limits: TokenLimits{MaxTokens: 100, OverlapTokens: 50}, wantErr: true},
+		{name: "negative overlap", chunker: ChunkerSentence, limits: TokenLimits{OverlapTokens: -1}, wantErr: true},
+		{name: "unknown chunker", chunker: "paragraph", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunker, err := NewChunker(tt.chunker, tt.limits)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewChunker error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			var got TokenLimits
+			switch c := chunker.(type) {
+			case *SentenceChunker:
+				got = c.Limits
+			case *MarkdownChunker:
+				got = c.Limits
+			case *HTMLChunker:
+				got = c.Limits
+			}
+			if got != tt.want {
+				t.Errorf("limits = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	// The constructors without an error replace an overlap that is too large.
+	if got := NewSentenceChunker(100, 80).Limits; got.OverlapTokens != 25 {
+		t.Errorf("NewSentenceChunker(100, 80) overlaps %d tokens, want 25", got.OverlapTokens)
+	}
+}
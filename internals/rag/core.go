@@ -4,11 +4,17 @@ import (
 	"context"
 	"fmt"
 	"mailflow/pkg/logging"
+	"sync"
 )
 
 const (
 	DefaultChunkSize    = 1000
 	DefaultChunkOverlap = 200
+
+	// DefaultEmbedBatchSize matches the most texts Gemini embeds in one call.
+	DefaultEmbedBatchSize = 100
+	// DefaultEmbedWorkers is how many batches of one document are embedded at once.
+	DefaultEmbedWorkers = 4
 )
 
 type TextChunker interface {
@@ -34,8 +40,8 @@ func NewSimpleTextChunker(chunkSize, chunkOverlap int) *SimpleTextChunker {
 }
 
 // Chunk breaks down a large text into smaller, overlapping chunks.
-// This is a basic implementation; more sophisticated chunking would consider
-// sentence boundaries, paragraphs, or semantic meaning.
+// It cuts at fixed character offsets, mid-sentence if need be; SentenceChunker,
+// MarkdownChunker and HTMLChunker split at the boundaries of the text's structure.
 func (s *SimpleTextChunker) Chunk(text string, docID string, docMetadata Metadata) ([]Chunk, error) {
 	logging.Debug("Chunking text for document %s with size %d and overlap %d", docID, s.ChunkSize, s.ChunkOverlap)
 	var chunks []Chunk
@@ -73,67 +79,252 @@ func (s *SimpleTextChunker) Chunk(text string, docID string, docMetadata Metadat
 
 type Embedder interface {
 	Embed(ctx context.Context, text string) ([]float32, error)
+	// EmbedBatch embeds every text, splitting them into as many provider calls
+	// as its batch-size limit requires, and returns the embeddings in order.
+	EmbedBatch(ctx context.Context, texts []string) ([][]float32, error)
 }
 
 // Orchestrater of chunking, embedding, and storage of documents.
 type RAGSystem struct {
-	Chunker     TextChunker
-	Embedder    Embedder
-	VectorStore VectorStore
+	Chunker        TextChunker            // Chunks documents of types missing from Chunkers
+	Chunkers       map[string]TextChunker // By document type, e.g. SourceMarkdown
+	Embedder       Embedder
+	VectorStore    VectorStore
+	Keywords       KeywordIndex // Answers keyword and hybrid retrievals; nil disables them
+	EmbedBatchSize int          // Chunks sent to the embedder per EmbedBatch call
+	EmbedWorkers   int          // EmbedBatch calls in flight while indexing one document
 }
 
+// NewRAGSystem uses store for keyword searches as well when it maintains its
+// own KeywordIndex, as the stores in package adapter do.
 func NewRAGSystem(chunker TextChunker, embedder Embedder, store VectorStore) *RAGSystem {
+	keywords, _ := store.(KeywordIndex)
 	return &RAGSystem{
-		Chunker:     chunker,
-		Embedder:    embedder,
-		VectorStore: store,
+		Chunker:        chunker,
+		Embedder:       embedder,
+		VectorStore:    store,
+		Keywords:       keywords,
+		EmbedBatchSize: DefaultEmbedBatchSize,
+		EmbedWorkers:   DefaultEmbedWorkers,
 	}
 }
 
+// WithChunkers chunks documents with the chunker of their type, such as the
+// ones NewChunkers builds.
+func (r *RAGSystem) WithChunkers(chunkers map[string]TextChunker) *RAGSystem {
+	r.Chunkers = chunkers
+	return r
+}
+
 // IndexDocument processes a document by chunking its content, embedding each chunk,
 // and adding them to the vector store.
 func (r *RAGSystem) IndexDocument(ctx context.Context, doc Document) error {
-	logging.Info("Indexing document: %s (Source: %s)", doc.ID, doc.Source)
+	docType := doc.Type
+	if docType == "" {
+		docType = DocumentType(doc.Source)
+	}
+	logging.Info("Indexing document: %s (Source: %s, type %s)", doc.ID, doc.Source, docType)
 
-	chunks, err := r.Chunker.Chunk(doc.Content, doc.ID, Metadata{SourceType: "text_file"}) // Assuming text_file for agency.txt
+	chunker := r.Chunkers[docType]
+	if chunker == nil {
+		chunker = r.Chunker
+	}
+	if chunker == nil {
+		return fmt.Errorf("no chunker for document %s of type %s", doc.ID, docType)
+	}
+	chunks, err := chunker.Chunk(doc.Content, doc.ID, Metadata{SourceType: docType, Source: doc.Source, Tags: doc.Tags})
 	if err != nil {
 		return fmt.Errorf("failed to chunk document %s: %w", doc.ID, err)
 	}
 
-	// Embed each chunk and prepare for storage
-	var embeddedChunks []Chunk
-	for i, chunk := range chunks {
-		logging.Debug("Embedding chunk %d/%d for document %s...", i+1, len(chunks), doc.ID)
-		embedding, err := r.Embedder.Embed(ctx, chunk.Content)
-		if err != nil {
-			return fmt.Errorf("failed to embed chunk %s for document %s: %w", chunk.ID, doc.ID, err)
-		}
-		chunk.Embedding = embedding
-		embeddedChunks = append(embeddedChunks, chunk)
-	}
-
-	err = r.VectorStore.AddChunks(ctx, embeddedChunks)
+	// The previous chunks are only replaced once every new one is embedded,
+	// so a failed embedding leaves the document indexed as it was.
+	embeddedChunks, err := r.embedChunks(ctx, chunks)
 	if err != nil {
-		return fmt.Errorf("failed to add chunks to vector store for document %s: %w", doc.ID, err)
+		return fmt.Errorf("failed to embed document %s: %w", doc.ID, err)
+	}
+	if err := r.replaceChunks(ctx, doc.ID, embeddedChunks); err != nil {
+		return err
+	}
+	if r.Keywords != nil && !r.storeIndexesKeywords() {
+		if err := r.Keywords.DeleteDocument(ctx, doc.ID); err != nil {
+			return fmt.Errorf("failed to remove previous keywords of document %s: %w", doc.ID, err)
+		}
+		if err := r.Keywords.AddChunks(ctx, embeddedChunks); err != nil {
+			return fmt.Errorf("failed to add chunks to keyword index for document %s: %w", doc.ID, err)
+		}
 	}
 
 	logging.Info("Successfully indexed document: %s. Added %d chunks.", doc.ID, len(chunks))
 	return nil
 }
 
-func (r *RAGSystem) Retrieve(ctx context.Context, query string, topN int) ([]Chunk, error) {
-	logging.Info("Retrieving chunks for query: '%s'", query)
+// replaceChunks swaps the chunks of a document in the vector store, in one
+// step if it is a DocumentReplacer.
+func (r *RAGSystem) replaceChunks(ctx context.Context, docID string, chunks []Chunk) error {
+	if replacer, ok := r.VectorStore.(DocumentReplacer); ok {
+		if err := replacer.ReplaceDocument(ctx, docID, chunks); err != nil {
+			return fmt.Errorf("failed to replace chunks of document %s: %w", docID, err)
+		}
+		return nil
+	}
+	if err := r.VectorStore.DeleteDocument(ctx, docID); err != nil {
+		return fmt.Errorf("failed to remove previous chunks of document %s: %w", docID, err)
+	}
+	if err := r.VectorStore.AddChunks(ctx, chunks); err != nil {
+		return fmt.Errorf("failed to add chunks to vector store for document %s: %w", docID, err)
+	}
+	return nil
+}
+
+// embedChunks embeds chunks in batches of EmbedBatchSize, with up to
+// EmbedWorkers batches in flight. The first failure cancels the others.
+func (r *RAGSystem) embedChunks(ctx context.Context, chunks []Chunk) ([]Chunk, error) {
+	batchSize := r.EmbedBatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultEmbedBatchSize
+	}
+	workers := r.EmbedWorkers
+	if workers <= 0 {
+		workers = DefaultEmbedWorkers
+	}
 
-	queryEmbedding, err := r.Embedder.Embed(ctx, query)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	slots := make(chan struct{}, workers)
+	embedded := make([]Chunk, len(chunks))
+	copy(embedded, chunks)
+
+	for start := 0; start < len(chunks); start += batchSize {
+		end := min(start+batchSize, len(chunks))
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(batch []Chunk) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			logging.Debug("Embedding chunks %d-%d of %d...", start+1, end, len(chunks))
+			texts := make([]string, len(batch))
+			for i, chunk := range batch {
+				texts[i] = chunk.Content
+			}
+			embeddings, err := r.Embedder.EmbedBatch(ctx, texts)
+			if err == nil && len(embeddings) != len(batch) {
+				err = fmt.Errorf("embedder returned %d embeddings for %d chunks", len(embeddings), len(batch))
+			}
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("failed to embed chunks %d-%d: %w", start+1, end, err)
+				}
+				mu.Unlock()
+				cancel()
+				return
+			}
+			for i := range batch {
+				batch[i].Embedding = embeddings[i]
+			}
+		}(embedded[start:end])
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return embedded, nil
+}
+
+// storeIndexesKeywords reports whether Keywords is the vector store itself,
+// which already indexed the chunks it was given.
+func (r *RAGSystem) storeIndexesKeywords() bool {
+	store, ok := r.VectorStore.(KeywordIndex)
+	return ok && store == r.Keywords
+}
+
+// Retrieve returns the chunks of the knowledge base most relevant to query,
+// found by embedding similarity, BM25 keyword matching or both, as opts.Mode
+// selects, and narrowed by opts.SearchOptions.
+func (r *RAGSystem) Retrieve(ctx context.Context, query string, opts RetrieveOptions) ([]ScoredChunk, error) {
+	mode, err := ParseRetrievalMode(string(opts.Mode))
 	if err != nil {
-		return nil, fmt.Errorf("failed to embed query: %w", err)
+		return nil, err
 	}
+	logging.Info("Retrieving chunks for query: '%s' (%s)", query, mode)
 
-	relevantChunks, err := r.VectorStore.Search(ctx, queryEmbedding, topN)
+	var relevantChunks []ScoredChunk
+	switch mode {
+	case RetrieveVector:
+		relevantChunks, err = r.vectorSearch(ctx, query, opts.SearchOptions)
+	case RetrieveKeyword:
+		relevantChunks, err = r.keywordSearch(ctx, query, opts.SearchOptions)
+	case RetrieveHybrid:
+		relevantChunks, err = r.hybridSearch(ctx, query, opts)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to search vector store: %w", err)
+		return nil, err
 	}
 
 	logging.Info("Retrieved %d relevant chunks for query.", len(relevantChunks))
 	return relevantChunks, nil
 }
+
+func (r *RAGSystem) vectorSearch(ctx context.Context, query string, opts SearchOptions) ([]ScoredChunk, error) {
+	if r.Embedder == nil {
+		return nil, fmt.Errorf("vector retrieval needs an embedder")
+	}
+	queryEmbedding, err := r.Embedder.Embed(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed query: %w", err)
+	}
+	chunks, err := r.VectorStore.Search(ctx, queryEmbedding, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search vector store: %w", err)
+	}
+	return chunks, nil
+}
+
+func (r *RAGSystem) keywordSearch(ctx context.Context, query string, opts SearchOptions) ([]ScoredChunk, error) {
+	if r.Keywords == nil {
+		return nil, fmt.Errorf("keyword retrieval needs a keyword index")
+	}
+	chunks, err := r.Keywords.KeywordSearch(ctx, query, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search keyword index: %w", err)
+	}
+	return chunks, nil
+}
+
+// hybridSearch fetches hybridDepth candidates per result from both the vector
+// store and the keyword index and fuses their rankings.
+func (r *RAGSystem) hybridSearch(ctx context.Context, query string, opts RetrieveOptions) ([]ScoredChunk, error) {
+	candidates := opts.SearchOptions
+	candidates.TopN = opts.TopN * hybridDepth
+	vector, err := r.vectorSearch(ctx, query, candidates)
+	if err != nil {
+		return nil, err
+	}
+	keyword, err := r.keywordSearch(ctx, query, candidates)
+	if err != nil {
+		return nil, err
+	}
+	fusion := opts.Fusion.withDefaults()
+	return fuseReciprocalRank(
+		[][]ScoredChunk{vector, keyword},
+		[]float64{fusion.VectorWeight, fusion.KeywordWeight},
+		fusion.K, opts.TopN), nil
+}
@@ -0,0 +1,155 @@
+package rag_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"mailflow/internals/rag"
+	"mailflow/internals/rag/adapter"
+)
+
+// numberedText is n chunks of ten characters, "c00000000;" to "c<n-1>;", for
+// a SimpleTextChunker of size 10 and no overlap.
+func numberedText(n int) string {
+	var text strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&text, "c%08d;", i)
+	}
+	return text.String()
+}
+
+// countingEmbedder embeds a numbered chunk as its number, records the size
+// of every batch and how many were in flight at once, and fails a batch that
+// contains failOn.
+type countingEmbedder struct {
+	failOn string
+	short  bool // Returns one embedding too few
+
+	mu          sync.Mutex
+	batches     []int
+	inFlight    int
+	maxInFlight int
+}
+
+func (e *countingEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	return []float32{1}, nil
+}
+
+func (e *countingEmbedder) EmbedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	e.mu.Lock()
+	e.batches = append(e.batches, len(texts))
+	e.inFlight++
+	e.maxInFlight = max(e.maxInFlight, e.inFlight)
+	e.mu.Unlock()
+	defer func() {
+		e.mu.Lock()
+		e.inFlight--
+		e.mu.Unlock()
+	}()
+
+	// Long enough for the batches a worker bound allows to overlap.
+	select {
+	case <-time.After(20 * time.Millisecond):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if slices.Contains(texts, e.failOn) {
+		return nil, errors.New("embedder down")
+	}
+	embeddings := make([][]float32, len(texts))
+	for i, text := range texts {
+		n, err := strconv.Atoi(text[1:9])
+		if err != nil {
+			return nil, err
+		}
+		embeddings[i] = []float32{float32(n), 1}
+	}
+	if e.short {
+		embeddings = embeddings[1:]
+	}
+	return embeddings, nil
+}
+
+func TestIndexDocumentEmbedsInBatches(t *testing.T) {
+	tests := []struct {
+		name        string
+		batchSize   int
+		workers     int
+		wantBatches []int // Sorted
+		wantWorkers int   // Most batches in flight
+	}{
+		{name: "bounded by workers", batchSize: 3, workers: 2, wantBatches: []int{1, 3, 3, 3}, wantWorkers: 2},
+		{name: "one worker", batchSize: 4, workers: 1, wantBatches: []int{2, 4, 4}, wantWorkers: 1},
+		{name: "more workers than batches", batchSize: 5, workers: 8, wantBatches: []int{5, 5}, wantWorkers: 2},
+		{name: "defaults", wantBatches: []int{10}, wantWorkers: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			embedder := &countingEmbedder{}
+			store := adapter.NewInMemoryVectorStore()
+			system := rag.NewRAGSystem(rag.NewSimpleTextChunker(10, 0), embedder, store)
+			system.EmbedBatchSize, system.EmbedWorkers = tt.batchSize, tt.workers
+
+			if err := system.IndexDocument(ctx, rag.Document{ID: "kb", Content: numberedText(10)}); err != nil {
+				t.Fatalf("IndexDocument: %v", err)
+			}
+			slices.Sort(embedder.batches)
+			if !slices.Equal(embedder.batches, tt.wantBatches) {
+				t.Errorf("batch sizes = %v, want %v", embedder.batches, tt.wantBatches)
+			}
+			if embedder.maxInFlight != tt.wantWorkers {
+				t.Errorf("%d batches in flight at once, want %d", embedder.maxInFlight, tt.wantWorkers)
+			}
+
+			// Every chunk got the embedding of its own text.
+			results, err := store.Search(ctx, []float32{1, 1}, rag.SearchOptions{TopN: 20})
+			if err != nil || len(results) != 10 {
+				t.Fatalf("Search = %d chunks, %v, want 10", len(results), err)
+			}
+			for _, r := range results {
+				if want := fmt.Sprintf("c%08d;", int(r.Embedding[0])); r.Content != want {
+					t.Errorf("chunk %q has the embedding of %q", r.Content, want)
+				}
+			}
+		})
+	}
+}
+
+func TestIndexDocumentKeepsOldChunksWhenEmbeddingFails(t *testing.T) {
+	tests := []struct {
+		name     string
+		embedder *countingEmbedder
+		wantErr  string
+	}{
+		{name: "batch fails", embedder: &countingEmbedder{failOn: "c00000004;"}, wantErr: "failed to embed chunks 4-6: embedder down"},
+		{name: "embeddings missing", embedder: &countingEmbedder{short: true}, wantErr: "embedder returned 2 embeddings for 3 chunks"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := adapter.NewInMemoryVectorStore()
+			chunker := rag.NewSimpleTextChunker(10, 0)
+			if err := rag.NewRAGSystem(chunker, &countingEmbedder{}, store).IndexDocument(ctx, rag.Document{ID: "kb", Content: numberedText(4)}); err != nil {
+				t.Fatalf("IndexDocument: %v", err)
+			}
+
+			system := rag.NewRAGSystem(chunker, tt.embedder, store)
+			system.EmbedBatchSize, system.EmbedWorkers = 3, 2
+			err := system.IndexDocument(ctx, rag.Document{ID: "kb", Content: numberedText(9)})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("IndexDocument error = %v, want one containing %q", err, tt.wantErr)
+			}
+			if n := store.GetTotalChunks(); n != 4 {
+				t.Errorf("store holds %d chunks after the failed re-index, want the 4 indexed before", n)
+			}
+		})
+	}
+}
@@ -2,14 +2,19 @@ package rag
 
 import (
 	"fmt"
+	"strings"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 type Document struct {
-	ID        string    // Unique ID for the document
-	Source    string    // e.g., "agency.txt", "FAQ_page.html"
-	Content   string    // Full content of the document
-	CreatedAt time.Time // Timestamp when the document was added/indexed
+	ID        string            // Unique ID for the document
+	Source    string            // e.g., "agency.txt", "FAQ_page.html"
+	Content   string            // Full content of the document
+	CreatedAt time.Time         // Timestamp when the document was added/indexed
+	Tags      map[string]string // Custom labels copied to every chunk, e.g. "topic": "billing"
+	Type      string            // SourceText, SourceMarkdown or SourceHTML; guessed from Source when empty
 }
 
 // Chunk represents a smaller, semantically meaningful piece of a Document.
@@ -23,20 +28,37 @@ type Chunk struct {
 }
 
 type Metadata struct {
-	SourceType string `json:"source_type"`           // e.g., "text_file", "web_page", "database_record"
-	PageNumber int    `json:"page_number,omitempty"` // For documents with pages
-	Section    string `json:"section,omitempty"`     // For documents with sections
+	SourceType string            `json:"source_type"`           // e.g., "text_file", "web_page", "database_record"
+	Source     string            `json:"source,omitempty"`      // Source of the parent document, e.g. "agency.txt"
+	PageNumber int               `json:"page_number,omitempty"` // For documents with pages
+	Section    string            `json:"section,omitempty"`     // For documents with sections
+	Tags       map[string]string `json:"tags,omitempty"`        // Custom labels of the parent document, for filtering searches
 }
 
 func NewChunk(docID, content string, embedding []float32, meta Metadata) Chunk {
-	//[TODO] use a UUID generator for IDs.
-	// For simplicity, we'll use a basic approach for now.
-	chunkID := fmt.Sprintf("%s-%d", docID, time.Now().UnixNano())
 	return Chunk{
-		ID:         chunkID,
+		ID:         docID + "-" + uuid.NewString(),
 		DocumentID: docID,
 		Content:    content,
 		Embedding:  embedding,
 		Metadata:   meta,
 	}
 }
+
+// ParseTags reads tags written as "key=value" pairs separated by commas,
+// e.g. "topic=billing,audience=customers".
+func ParseTags(s string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("tag %q is not of the form key=value", strings.TrimSpace(pair))
+		}
+		tags[key] = value
+	}
+	return tags, nil
+}
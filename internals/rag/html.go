@@ -0,0 +1,137 @@
+package rag
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// HTMLChunker extracts the text of an HTML page and splits it into sections
+// at its <h1>-<h6> headings, naming each chunk's Section after the headings
+// above it. Paragraphs, list items and table rows are packed like
+// SentenceChunker packs plain text; <pre> blocks keep their lines. Scripts,
+// styles and navigation are dropped.
+type HTMLChunker struct {
+	Limits TokenLimits
+}
+
+func NewHTMLChunker(maxTokens, overlapTokens int) *HTMLChunker {
+	return &HTMLChunker{Limits: TokenLimits{MaxTokens: maxTokens, OverlapTokens: overlapTokens}.withDefaults()}
+}
+
+func (h *HTMLChunker) Chunk(text string, docID string, docMetadata Metadata) ([]Chunk, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, fmt.Errorf("cannot chunk empty text")
+	}
+	root, err := html.Parse(strings.NewReader(text))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse HTML of document %s: %w", docID, err)
+	}
+
+	w := &htmlWalker{packer: newPacker(docID, docMetadata, h.Limits)}
+	w.walk(root)
+	w.endBlock()
+	return w.packer.finish()
+}
+
+// htmlWalker collects the inline text of the current block element until a
+// block boundary hands it to the packer.
+type htmlWalker struct {
+	packer   *packer
+	headings headingPath
+	block    strings.Builder
+}
+
+func (w *htmlWalker) endBlock() {
+	w.packer.addParagraph(collapseSpace(w.block.String()), 0)
+	w.block.Reset()
+}
+
+func (w *htmlWalker) walk(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		w.block.WriteString(n.Data)
+		return
+	case html.ElementNode:
+		switch n.DataAtom {
+		case atom.Head, atom.Script, atom.Style, atom.Noscript, atom.Template, atom.Nav, atom.Iframe, atom.Svg:
+			return
+		case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+			w.endBlock()
+			title := collapseSpace(nodeText(n))
+			level := int(n.Data[1] - '0')
+			w.packer.startSection(w.headings.enter(level, title))
+			w.packer.addHeading(title, 0)
+			return
+		case atom.Pre:
+			w.endBlock()
+			w.packer.addVerbatim(nodeText(n), 0)
+			return
+		case atom.Tr:
+			w.endBlock()
+			var cells []string
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				if c.Type == html.ElementNode && (c.DataAtom == atom.Td || c.DataAtom == atom.Th) {
+					cells = append(cells, collapseSpace(nodeText(c)))
+				}
+			}
+			w.packer.addParagraph("| "+strings.Join(cells, " | ")+" |", 0)
+			return
+		case atom.Br:
+			w.block.WriteString("\n")
+			return
+		}
+		if isHTMLBlock(n.DataAtom) {
+			w.endBlock()
+			defer w.endBlock()
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		w.walk(c)
+	}
+}
+
+// isHTMLBlock reports whether an element starts a new paragraph.
+func isHTMLBlock(a atom.Atom) bool {
+	switch a {
+	case atom.P, atom.Div, atom.Li, atom.Ul, atom.Ol, atom.Dl, atom.Dt, atom.Dd,
+		atom.Section, atom.Article, atom.Main, atom.Aside, atom.Header, atom.Footer,
+		atom.Blockquote, atom.Table, atom.Figure, atom.Figcaption, atom.Form, atom.Hr,
+		atom.Address, atom.Details, atom.Summary, atom.Body:
+		return true
+	}
+	return false
+}
+
+func nodeText(n *html.Node) string {
+	var text strings.Builder
+	var collect func(*html.Node)
+	collect = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			text.WriteString(n.Data)
+		}
+		if n.Type == html.ElementNode && n.DataAtom == atom.Br {
+			text.WriteString("\n")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			collect(c)
+		}
+	}
+	collect(n)
+	return text.String()
+}
+
+// collapseSpace keeps line breaks but turns other runs of whitespace into
+// single spaces, as browsers render them.
+func collapseSpace(s string) string {
+	lines := strings.Split(s, "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
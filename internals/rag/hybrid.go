@@ -0,0 +1,98 @@
+package rag
+
+import (
+	"fmt"
+	"sort"
+)
+
+// RetrievalMode selects how Retrieve finds chunks for a query.
+type RetrievalMode string
+
+const (
+	RetrieveVector  RetrievalMode = "vector"  // Embedding similarity, the default
+	RetrieveKeyword RetrievalMode = "keyword" // BM25 over the KeywordIndex; needs no embedder
+	RetrieveHybrid  RetrievalMode = "hybrid"  // Both, fused by reciprocal rank
+)
+
+// ParseRetrievalMode validates a mode name; empty selects RetrieveVector.
+func ParseRetrievalMode(s string) (RetrievalMode, error) {
+	switch mode := RetrievalMode(s); mode {
+	case "":
+		return RetrieveVector, nil
+	case RetrieveVector, RetrieveKeyword, RetrieveHybrid:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown retrieval mode %q (want %s, %s or %s)", s, RetrieveVector, RetrieveKeyword, RetrieveHybrid)
+	}
+}
+
+// Fusion weighs the rankings a hybrid retrieval combines. A chunk scores
+// weight/(K+rank) for each ranking it appears in, so raising a weight favours
+// that ranking, and a larger K evens out the influence of the top ranks.
+type Fusion struct {
+	VectorWeight  float64
+	KeywordWeight float64
+	K             int
+}
+
+// DefaultFusion weighs both rankings equally with the customary K of 60.
+func DefaultFusion() Fusion {
+	return Fusion{VectorWeight: 1, KeywordWeight: 1, K: 60}
+}
+
+func (f Fusion) withDefaults() Fusion {
+	defaults := DefaultFusion()
+	if f.VectorWeight == 0 && f.KeywordWeight == 0 {
+		f.VectorWeight, f.KeywordWeight = defaults.VectorWeight, defaults.KeywordWeight
+	}
+	if f.K <= 0 {
+		f.K = defaults.K
+	}
+	return f
+}
+
+// RetrieveOptions configures one Retrieve call. MinScore is a cosine
+// similarity and only applies to vector hits: keyword retrieval ignores it,
+// and hybrid retrieval drops vector candidates below it before fusing, while
+// keyword candidates and the fused scores are not thresholded.
+type RetrieveOptions struct {
+	SearchOptions
+	Mode   RetrievalMode // RetrieveVector when empty
+	Fusion Fusion        // Used by RetrieveHybrid; zero selects DefaultFusion
+}
+
+// hybridDepth is how many candidates per result each ranking contributes to
+// a hybrid retrieval; chunks ranked lower by one list can still surface
+// through the other.
+const hybridDepth = 4
+
+// fuseReciprocalRank merges rankings by weighted reciprocal rank fusion and
+// returns the topN chunks, scored by their fused score.
+func fuseReciprocalRank(rankings [][]ScoredChunk, weights []float64, k, topN int) []ScoredChunk {
+	fused := make(map[string]*ScoredChunk)
+	var order []string
+	for i, ranking := range rankings {
+		for rank, chunk := range ranking {
+			score := weights[i] / float64(k+rank+1)
+			if existing, ok := fused[chunk.ID]; ok {
+				existing.Score += score
+				continue
+			}
+			fused[chunk.ID] = &ScoredChunk{Chunk: chunk.Chunk, Score: score}
+			order = append(order, chunk.ID)
+		}
+	}
+
+	results := make([]ScoredChunk, len(order))
+	for i, id := range order {
+		results[i] = *fused[id]
+	}
+	// Stable, so ties keep the order of the first ranking listing them.
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	if len(results) > topN {
+		results = results[:max(topN, 0)]
+	}
+	return results
+}
@@ -0,0 +1,108 @@
+package rag
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MarkdownChunker splits Markdown into sections at its headings and names
+// each chunk's Section after the headings above it, e.g. "Billing > Refunds".
+// Within a section it packs whole sentences, list items and table rows like
+// SentenceChunker; fenced code blocks keep their lines.
+type MarkdownChunker struct {
+	Limits TokenLimits
+}
+
+func NewMarkdownChunker(maxTokens, overlapTokens int) *MarkdownChunker {
+	return &MarkdownChunker{Limits: TokenLimits{MaxTokens: maxTokens, OverlapTokens: overlapTokens}.withDefaults()}
+}
+
+var (
+	atxHeading   = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	setextMarker = regexp.MustCompile(`^ {0,3}(=+|-+)[ \t]*$`)
+	codeFence    = regexp.MustCompile("^ {0,3}(```+|~~~+)")
+)
+
+func (m *MarkdownChunker) Chunk(text string, docID string, docMetadata Metadata) ([]Chunk, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, fmt.Errorf("cannot chunk empty text")
+	}
+	p := newPacker(docID, docMetadata, m.Limits)
+	var headings headingPath
+	var paragraph, code []string
+	fence := ""
+
+	endParagraph := func() {
+		p.addParagraph(strings.Join(paragraph, "\n"), 0)
+		paragraph = nil
+	}
+	heading := func(level int, title string) {
+		endParagraph()
+		p.startSection(headings.enter(level, title))
+		p.addHeading(title, 0)
+	}
+
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	lines = skipFrontMatter(lines)
+	for _, line := range lines {
+		if fence != "" {
+			code = append(code, line)
+			if strings.HasPrefix(strings.TrimSpace(line), fence) {
+				p.addVerbatim(strings.Join(code, "\n"), 0)
+				fence, code = "", nil
+			}
+			continue
+		}
+		if match := codeFence.FindStringSubmatch(line); match != nil {
+			endParagraph()
+			fence, code = match[1], []string{line}
+			continue
+		}
+		if match := atxHeading.FindStringSubmatch(line); match != nil {
+			heading(len(match[1]), match[2])
+			continue
+		}
+		if match := setextMarker.FindStringSubmatch(line); match != nil {
+			if len(paragraph) == 1 && !listItem.MatchString(paragraph[0]) {
+				title := paragraph[0]
+				paragraph = nil
+				level := 1
+				if match[1][0] == '-' {
+					level = 2
+				}
+				heading(level, title)
+				continue
+			}
+			if len(paragraph) == 0 || (match[1][0] == '-' && len(match[1]) >= 3) {
+				// A thematic break.
+				endParagraph()
+				continue
+			}
+		}
+		if strings.TrimSpace(line) == "" {
+			endParagraph()
+			continue
+		}
+		paragraph = append(paragraph, line)
+	}
+	endParagraph()
+	if len(code) > 0 {
+		// An unclosed fence runs to the end of the document.
+		p.addVerbatim(strings.Join(code, "\n"), 0)
+	}
+	return p.finish()
+}
+
+// skipFrontMatter drops a YAML front matter block delimited by "---" lines.
+func skipFrontMatter(lines []string) []string {
+	if len(lines) == 0 || strings.TrimSpace(lines[0]) != "---" {
+		return lines
+	}
+	for i := 1; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == "---" {
+			return lines[i+1:]
+		}
+	}
+	return lines
+}
@@ -0,0 +1,67 @@
+package rag_test
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	"mailflow/internals/rag"
+	"mailflow/internals/rag/adapter"
+)
+
+// fixedEmbedder embeds every query as the same vector.
+type fixedEmbedder []float32
+
+func (e fixedEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	return e, nil
+}
+
+func (e fixedEmbedder) EmbedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	embeddings := make([][]float32, len(texts))
+	for i := range texts {
+		embeddings[i] = e
+	}
+	return embeddings, nil
+}
+
+func TestRetrieveMinScoreAppliesToVectorHitsOnly(t *testing.T) {
+	ctx := context.Background()
+	store := adapter.NewInMemoryVectorStore()
+	err := store.AddChunks(ctx, []rag.Chunk{
+		{ID: "router", DocumentID: "faq", Content: "Restart the router to restore the connection", Embedding: []float32{1, 0}},
+		{ID: "sku", DocumentID: "catalog", Content: "SKU-42 is the annual plan", Embedding: []float32{0, 1}},
+	})
+	if err != nil {
+		t.Fatalf("AddChunks: %v", err)
+	}
+	system := rag.NewRAGSystem(nil, fixedEmbedder{1, 0}, store)
+
+	tests := []struct {
+		mode rag.RetrievalMode
+		want string // Chunk IDs, sorted
+	}{
+		{mode: rag.RetrieveVector, want: "router"}, // "sku" has cosine 0
+		{mode: rag.RetrieveKeyword, want: "sku"},   // Its BM25 score is not compared against MinScore
+		{mode: rag.RetrieveHybrid, want: "router,sku"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			results, err := system.Retrieve(ctx, "SKU-42", rag.RetrieveOptions{
+				SearchOptions: rag.SearchOptions{TopN: 5, MinScore: 0.5},
+				Mode:          tt.mode,
+			})
+			if err != nil {
+				t.Fatalf("Retrieve: %v", err)
+			}
+			var ids []string
+			for _, r := range results {
+				ids = append(ids, r.ID)
+			}
+			sort.Strings(ids)
+			if got := strings.Join(ids, ","); got != tt.want {
+				t.Errorf("retrieved %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
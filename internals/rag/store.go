@@ -1,6 +1,9 @@
 package rag
 
-import "context"
+import (
+	"context"
+	"slices"
+)
 
 // VectorStore defines the interface for interacting with a vector database or knowledge store.
 // It allows adding chunks (with their embeddings) and searching for relevant chunks.
@@ -8,7 +11,77 @@ type VectorStore interface {
 	// AddChunks adds a slice of chunks to the store.
 	AddChunks(ctx context.Context, chunks []Chunk) error
 
-	// Search searches the store for the top-N most relevant chunks to a given query embedding.
-	// It returns the relevant chunks and any error encountered.
-	Search(ctx context.Context, queryEmbedding []float32, topN int) ([]Chunk, error)
+	// Search returns the opts.TopN chunks most similar to a query embedding
+	// that pass opts.Filter and score at least opts.MinScore, best first.
+	Search(ctx context.Context, queryEmbedding []float32, opts SearchOptions) ([]ScoredChunk, error)
+
+	// DeleteDocument removes every chunk of a document, so re-indexing it
+	// replaces its chunks instead of adding duplicates.
+	DeleteDocument(ctx context.Context, documentID string) error
+}
+
+// DocumentReplacer is implemented by vector stores that can swap the chunks
+// of a document for new ones in one step, so that no search finds the
+// document missing or indexed twice and a failed write keeps the old chunks.
+type DocumentReplacer interface {
+	ReplaceDocument(ctx context.Context, documentID string, chunks []Chunk) error
+}
+
+// SearchOptions narrows a vector store search.
+type SearchOptions struct {
+	TopN     int     // Most chunks returned
+	MinScore float64 // Cosine similarity below which vector hits are dropped; 0 disables it. Keyword search ignores it
+	Filter   Filter  // Only chunks matching it are considered
+}
+
+// ScoredChunk is a search result. What Score measures depends on the search
+// that produced it, and scores of different kinds must not be compared:
+//
+//   - VectorStore.Search: cosine similarity to the query, between -1 and 1
+//   - KeywordIndex.KeywordSearch: BM25 score, 0 or more, unbounded and
+//     relative to the corpus
+//   - hybrid Retrieve: weighted reciprocal rank fusion score, the sum of
+//     weight/(K+rank) over the rankings the chunk appears in
+type ScoredChunk struct {
+	Chunk
+	Score float64
+}
+
+// Filter restricts a search to chunks whose metadata matches. A chunk must
+// match every field that is set, and one of the values listed for it; tags
+// must all be present with the given values. The zero Filter matches every chunk.
+type Filter struct {
+	DocumentIDs []string          `json:"document_ids,omitempty"`
+	SourceTypes []string          `json:"source_types,omitempty"`
+	Sections    []string          `json:"sections,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+}
+
+// IsZero reports whether f matches every chunk.
+func (f Filter) IsZero() bool {
+	return len(f.DocumentIDs) == 0 && len(f.SourceTypes) == 0 && len(f.Sections) == 0 && len(f.Tags) == 0
+}
+
+// Matches reports whether chunk passes the filter.
+func (f Filter) Matches(chunk Chunk) bool {
+	if len(f.DocumentIDs) > 0 && !slices.Contains(f.DocumentIDs, chunk.DocumentID) {
+		return false
+	}
+	if len(f.SourceTypes) > 0 && !slices.Contains(f.SourceTypes, chunk.Metadata.SourceType) {
+		return false
+	}
+	if len(f.Sections) > 0 && !slices.Contains(f.Sections, chunk.Metadata.Section) {
+		return false
+	}
+	for key, value := range f.Tags {
+		if tag, ok := chunk.Metadata.Tags[key]; !ok || tag != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Accepts reports whether a chunk with the given score makes the MinScore threshold.
+func (o SearchOptions) Accepts(score float64) bool {
+	return o.MinScore == 0 || score >= o.MinScore
 }
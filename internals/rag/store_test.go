@@ -0,0 +1,52 @@
+package rag
+
+import "testing"
+
+func TestFilterMatches(t *testing.T) {
+	chunk := Chunk{
+		ID:         "billing-1",
+		DocumentID: "billing",
+		Metadata: Metadata{
+			SourceType: SourceMarkdown,
+			Section:    "Billing > Refunds",
+			Tags:       map[string]string{"topic": "billing", "audience": "customers"},
+		},
+	}
+	tests := []struct {
+		name   string
+		filter Filter
+		want   bool
+	}{
+		{name: "zero filter", want: true},
+		{name: "document ID", filter: Filter{DocumentIDs: []string{"faq", "billing"}}, want: true},
+		{name: "other document ID", filter: Filter{DocumentIDs: []string{"faq"}}},
+		{name: "source type", filter: Filter{SourceTypes: []string{SourceMarkdown}}, want: true},
+		{name: "other source type", filter: Filter{SourceTypes: []string{SourceText, SourceHTML}}},
+		{name: "section", filter: Filter{Sections: []string{"Billing > Refunds"}}, want: true},
+		{name: "parent section", filter: Filter{Sections: []string{"Billing"}}},
+		{name: "tag", filter: Filter{Tags: map[string]string{"topic": "billing"}}, want: true},
+		{name: "all tags", filter: Filter{Tags: map[string]string{"topic": "billing", "audience": "customers"}}, want: true},
+		{name: "tag with another value", filter: Filter{Tags: map[string]string{"topic": "support"}}},
+		{name: "missing tag", filter: Filter{Tags: map[string]string{"topic": "billing", "region": "eu"}}},
+		{name: "empty tag value", filter: Filter{Tags: map[string]string{"region": ""}}},
+		{
+			name:   "every field",
+			filter: Filter{DocumentIDs: []string{"billing"}, SourceTypes: []string{SourceMarkdown}, Sections: []string{"Billing > Refunds"}, Tags: map[string]string{"topic": "billing"}},
+			want:   true,
+		},
+		{
+			name:   "one field fails",
+			filter: Filter{DocumentIDs: []string{"billing"}, SourceTypes: []string{SourceHTML}, Tags: map[string]string{"topic": "billing"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Matches(chunk); got != tt.want {
+				t.Errorf("Matches = %v, want %v", got, tt.want)
+			}
+			if tt.filter.IsZero() != (tt.name == "zero filter") {
+				t.Errorf("IsZero = %v", tt.filter.IsZero())
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package review
+
+import (
+	"context"
+)
+
+type Endpoints struct {
+	ListEndpoint    func(ctx context.Context, request interface{}) (response interface{}, err error)
+	GetEndpoint     func(ctx context.Context, request interface{}) (response interface{}, err error)
+	UpdateEndpoint  func(ctx context.Context, request interface{}) (response interface{}, err error)
+	ApproveEndpoint func(ctx context.Context, request interface{}) (response interface{}, err error)
+	RejectEndpoint  func(ctx context.Context, request interface{}) (response interface{}, err error)
+}
+
+func NewEndpoints(s *Store) Endpoints {
+	return Endpoints{
+		ListEndpoint:    MakeListEndpoint(s),
+		GetEndpoint:     MakeGetEndpoint(s),
+		UpdateEndpoint:  MakeUpdateEndpoint(s),
+		ApproveEndpoint: MakeApproveEndpoint(s),
+		RejectEndpoint:  MakeRejectEndpoint(s),
+	}
+}
+
+func MakeListEndpoint(s *Store) func(ctx context.Context, request interface{}) (response interface{}, err error) {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(ListRequest)
+		var statuses []Status
+		if req.Status != "" {
+			statuses = append(statuses, req.Status)
+		}
+		items, err := s.List(ctx, statuses...)
+		if err != nil {
+			return nil, err
+		}
+		if items == nil {
+			items = []Item{}
+		}
+		return items, nil
+	}
+}
+
+func MakeGetEndpoint(s *Store) func(ctx context.Context, request interface{}) (response interface{}, err error) {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(ItemRequest)
+		return s.Get(ctx, req.ID)
+	}
+}
+
+func MakeUpdateEndpoint(s *Store) func(ctx context.Context, request interface{}) (response interface{}, err error) {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(UpdateRequest)
+		return s.UpdateDraft(ctx, req.ID, req.Draft)
+	}
+}
+
+func MakeApproveEndpoint(s *Store) func(ctx context.Context, request interface{}) (response interface{}, err error) {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(ItemRequest)
+		return s.Approve(ctx, req.ID)
+	}
+}
+
+func MakeRejectEndpoint(s *Store) func(ctx context.Context, request interface{}) (response interface{}, err error) {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(RejectRequest)
+		return s.Reject(ctx, req.ID, req.Reason)
+	}
+}
+
+type ListRequest struct {
+	Status Status
+}
+
+type ItemRequest struct {
+	ID string
+}
+
+type UpdateRequest struct {
+	ID    string `json:"-"`
+	Draft string `json:"draft"`
+}
+
+type RejectRequest struct {
+	ID     string `json:"-"`
+	Reason string `json:"reason"`
+}
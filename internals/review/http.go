@@ -0,0 +1,106 @@
+package review
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// MakeHTTPHandler mounts the review queue API:
+//
+//	GET  /reviews?status=pending   list items, optionally filtered by status
+//	GET  /reviews/{id}             original email, draft and feedback
+//	PUT  /reviews/{id}             {"draft": "..."} edit a pending draft
+//	POST /reviews/{id}/approve     send the draft
+//	POST /reviews/{id}/reject      {"reason": "..."} redraft with the reason as feedback
+func MakeHTTPHandler(r *mux.Router, endpoints Endpoints) {
+	r.HandleFunc("/reviews", decodeListRequest(endpoints.ListEndpoint)).Methods("GET")
+	r.HandleFunc("/reviews/{id}", decodeItemRequest(endpoints.GetEndpoint)).Methods("GET")
+	r.HandleFunc("/reviews/{id}", decodeUpdateRequest(endpoints.UpdateEndpoint)).Methods("PUT")
+	r.HandleFunc("/reviews/{id}/approve", decodeItemRequest(endpoints.ApproveEndpoint)).Methods("POST")
+	r.HandleFunc("/reviews/{id}/reject", decodeRejectRequest(endpoints.RejectEndpoint)).Methods("POST")
+}
+
+func decodeListRequest(endpoint func(ctx context.Context, request interface{}) (response interface{}, err error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := ListRequest{Status: Status(r.URL.Query().Get("status"))}
+		serve(w, r, endpoint, req)
+	}
+}
+
+func decodeItemRequest(endpoint func(ctx context.Context, request interface{}) (response interface{}, err error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := ItemRequest{ID: mux.Vars(r)["id"]}
+		serve(w, r, endpoint, req)
+	}
+}
+
+func decodeUpdateRequest(endpoint func(ctx context.Context, request interface{}) (response interface{}, err error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req UpdateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			encodeErrorResponse(r.Context(), fmt.Errorf("%w: %v", ErrInvalid, err), w)
+			return
+		}
+		req.ID = mux.Vars(r)["id"]
+		serve(w, r, endpoint, req)
+	}
+}
+
+func decodeRejectRequest(endpoint func(ctx context.Context, request interface{}) (response interface{}, err error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req RejectRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			encodeErrorResponse(r.Context(), fmt.Errorf("%w: %v", ErrInvalid, err), w)
+			return
+		}
+		req.ID = mux.Vars(r)["id"]
+		serve(w, r, endpoint, req)
+	}
+}
+
+func serve(w http.ResponseWriter, r *http.Request, endpoint func(ctx context.Context, request interface{}) (response interface{}, err error), req interface{}) {
+	resp, err := endpoint(r.Context(), req)
+	if err != nil {
+		fmt.Printf("Error processing review request: %v\n", err)
+		encodeErrorResponse(r.Context(), err, w)
+		return
+	}
+	encodeResponse(w, resp)
+}
+
+func encodeResponse(w http.ResponseWriter, response interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	jsonBytes, err := json.Marshal(response)
+	if err != nil {
+		fmt.Printf("Error marshaling successful response to JSON bytes: %v\n", err)
+		http.Error(w, "Internal Server Error: Failed to encode response", http.StatusInternalServerError)
+		return err
+	}
+	_, err = w.Write(jsonBytes)
+	return err
+}
+
+func encodeErrorResponse(_ context.Context, err error, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	switch {
+	case errors.Is(err, ErrNotFound):
+		w.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, ErrConflict):
+		w.WriteHeader(http.StatusConflict)
+	case errors.Is(err, ErrInvalid):
+		w.WriteHeader(http.StatusBadRequest)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": err.Error(),
+	})
+}
@@ -0,0 +1,276 @@
+// Package review holds generated replies that wait for a human decision
+// before they are sent.
+package review
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"mailflow/internals/email"
+
+	"github.com/google/uuid"
+	_ "modernc.org/sqlite"
+)
+
+// Status is the position of an Item in the review lifecycle:
+// pending -> approved -> sending -> sent, or pending -> rejected -> redrafted.
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusApproved  Status = "approved"
+	StatusSending   Status = "sending" // Claimed by the workflow, the reply may be on its way
+	StatusRejected  Status = "rejected"
+	StatusSent      Status = "sent"
+	StatusRedrafted Status = "redrafted"
+)
+
+var (
+	// ErrNotFound is returned for unknown review items.
+	ErrNotFound = errors.New("review: item not found")
+	// ErrConflict is returned when an item is not in the status an operation requires.
+	ErrConflict = errors.New("review: item is not in the required status")
+	// ErrInvalid is returned for edits and decisions with missing input.
+	ErrInvalid = errors.New("review: invalid request")
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS review_items (
+	id            TEXT PRIMARY KEY,
+	run_id        TEXT NOT NULL,
+	parent_id     TEXT NOT NULL DEFAULT '',
+	revision      INTEGER NOT NULL,
+	message_id    TEXT NOT NULL,
+	email         TEXT NOT NULL,
+	category      TEXT NOT NULL,
+	draft         TEXT NOT NULL,
+	information   TEXT NOT NULL,
+	feedback      TEXT NOT NULL,
+	status        TEXT NOT NULL,
+	reject_reason TEXT NOT NULL DEFAULT '',
+	created_at    TEXT NOT NULL,
+	updated_at    TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS review_items_status ON review_items (status, created_at);`
+
+// Item is a generated reply waiting for, or past, a human decision.
+type Item struct {
+	ID           string          `json:"id"`
+	RunID        string          `json:"run_id"`
+	ParentID     string          `json:"parent_id,omitempty"` // Rejected item this one redrafts
+	Revision     int             `json:"revision"`
+	Email        email.EmailInfo `json:"email"`
+	Category     string          `json:"category"`
+	Draft        string          `json:"draft"`
+	Information  string          `json:"information,omitempty"` // Knowledge base context the draft was written from
+	Feedback     []string        `json:"feedback"`              // Earlier drafts and proofreader/reviewer feedback
+	Status       Status          `json:"status"`
+	RejectReason string          `json:"reject_reason,omitempty"`
+	CreatedAt    time.Time       `json:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at"`
+}
+
+// Store is a SQLite-backed review queue shared by the workflow and the API.
+type Store struct {
+	db *sql.DB
+}
+
+// Open opens (or creates) the review database at path.
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open review queue %s: %w", path, err)
+	}
+	// A single connection serializes writers, which SQLite requires anyway.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec("PRAGMA journal_mode=WAL; PRAGMA busy_timeout=5000;" + schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize review queue %s: %w", path, err)
+	}
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Add queues item as pending and returns it with its ID and timestamps set.
+func (s *Store) Add(ctx context.Context, item Item) (*Item, error) {
+	item.ID = uuid.NewString()
+	item.Status = StatusPending
+	if item.Revision == 0 {
+		item.Revision = 1
+	}
+	if item.Feedback == nil {
+		item.Feedback = []string{}
+	}
+	now := time.Now().UTC()
+	item.CreatedAt, item.UpdatedAt = now, now
+
+	emailJSON, err := json.Marshal(item.Email)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode review email: %w", err)
+	}
+	feedbackJSON, err := json.Marshal(item.Feedback)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode review feedback: %w", err)
+	}
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO review_items (id, run_id, parent_id, revision, message_id, email, category, draft, information, feedback, status, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		item.ID, item.RunID, item.ParentID, item.Revision, item.Email.ID, string(emailJSON), item.Category,
+		item.Draft, item.Information, string(feedbackJSON), string(item.Status), formatTime(now), formatTime(now))
+	if err != nil {
+		return nil, fmt.Errorf("failed to queue reply for review: %w", err)
+	}
+	return &item, nil
+}
+
+// Get returns the item with the given ID or ErrNotFound.
+func (s *Store) Get(ctx context.Context, id string) (*Item, error) {
+	items, err := s.query(ctx, `WHERE id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, ErrNotFound
+	}
+	return &items[0], nil
+}
+
+// List returns items with any of the given statuses (all items when none are
+// given), oldest first.
+func (s *Store) List(ctx context.Context, statuses ...Status) ([]Item, error) {
+	if len(statuses) == 0 {
+		return s.query(ctx, `ORDER BY created_at`)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(statuses)), ",")
+	args := make([]any, len(statuses))
+	for i, status := range statuses {
+		args[i] = string(status)
+	}
+	return s.query(ctx, `WHERE status IN (`+placeholders+`) ORDER BY created_at`, args...)
+}
+
+// UpdateDraft replaces the reply text of a pending item.
+func (s *Store) UpdateDraft(ctx context.Context, id, draft string) (*Item, error) {
+	if strings.TrimSpace(draft) == "" {
+		return nil, fmt.Errorf("%w: draft must not be empty", ErrInvalid)
+	}
+	return s.transition(ctx, id, StatusPending, StatusPending, "draft = ?", draft)
+}
+
+// Approve marks a pending item for sending.
+func (s *Store) Approve(ctx context.Context, id string) (*Item, error) {
+	return s.transition(ctx, id, StatusPending, StatusApproved, "")
+}
+
+// Reject sends a pending item back to the writer with reason as feedback.
+func (s *Store) Reject(ctx context.Context, id, reason string) (*Item, error) {
+	if strings.TrimSpace(reason) == "" {
+		return nil, fmt.Errorf("%w: a reason is required to reject a reply", ErrInvalid)
+	}
+	return s.transition(ctx, id, StatusPending, StatusRejected, "reject_reason = ?", reason)
+}
+
+// ClaimSend moves an approved item to sending before its reply goes out, so
+// only one caller sends it. An item left sending by a failed MarkSent or a
+// crash is not sent again; it needs a human to check the outbox.
+func (s *Store) ClaimSend(ctx context.Context, id string) (*Item, error) {
+	return s.transition(ctx, id, StatusApproved, StatusSending, "")
+}
+
+// ReleaseSend returns an item claimed by ClaimSend to approved after its reply
+// failed to go out, so it is tried again.
+func (s *Store) ReleaseSend(ctx context.Context, id string) error {
+	_, err := s.transition(ctx, id, StatusSending, StatusApproved, "")
+	return err
+}
+
+// MarkSent records that a claimed item has been sent.
+func (s *Store) MarkSent(ctx context.Context, id string) error {
+	_, err := s.transition(ctx, id, StatusSending, StatusSent, "")
+	return err
+}
+
+// MarkRedrafted claims a rejected item for the run that replaces it with a new
+// draft, so only one caller redrafts it.
+func (s *Store) MarkRedrafted(ctx context.Context, id string) error {
+	_, err := s.transition(ctx, id, StatusRejected, StatusRedrafted, "")
+	return err
+}
+
+// ReleaseRedraft returns an item claimed by MarkRedrafted to rejected after
+// its redraft failed without queueing a new draft, so it is tried again.
+func (s *Store) ReleaseRedraft(ctx context.Context, id string) error {
+	_, err := s.transition(ctx, id, StatusRedrafted, StatusRejected, "")
+	return err
+}
+
+// transition moves item id from status from to status to, applying the extra
+// assignment (e.g. "draft = ?") in the same statement.
+func (s *Store) transition(ctx context.Context, id string, from, to Status, assignment string, args ...any) (*Item, error) {
+	set := "status = ?, updated_at = ?"
+	if assignment != "" {
+		set += ", " + assignment
+	}
+	params := append([]any{string(to), formatTime(time.Now().UTC())}, args...)
+	params = append(params, id, string(from))
+	res, err := s.db.ExecContext(ctx, `UPDATE review_items SET `+set+` WHERE id = ? AND status = ?`, params...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update review item %s: %w", id, err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, fmt.Errorf("failed to update review item %s: %w", id, err)
+	} else if n == 0 {
+		item, err := s.Get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: item %s is %s, expected %s", ErrConflict, id, item.Status, from)
+	}
+	return s.Get(ctx, id)
+}
+
+func (s *Store) query(ctx context.Context, clause string, args ...any) ([]Item, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, run_id, parent_id, revision, email, category, draft, information, feedback, status, reject_reason, created_at, updated_at
+		FROM review_items `+clause, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query review items: %w", err)
+	}
+	defer rows.Close()
+
+	var items []Item
+	for rows.Next() {
+		var item Item
+		var emailJSON, feedbackJSON, status, createdAt, updatedAt string
+		if err := rows.Scan(&item.ID, &item.RunID, &item.ParentID, &item.Revision, &emailJSON, &item.Category, &item.Draft,
+			&item.Information, &feedbackJSON, &status, &item.RejectReason, &createdAt, &updatedAt); err != nil {
+			return nil, fmt.Errorf("failed to read review item: %w", err)
+		}
+		if err := json.Unmarshal([]byte(emailJSON), &item.Email); err != nil {
+			return nil, fmt.Errorf("failed to decode email of review item %s: %w", item.ID, err)
+		}
+		if err := json.Unmarshal([]byte(feedbackJSON), &item.Feedback); err != nil {
+			return nil, fmt.Errorf("failed to decode feedback of review item %s: %w", item.ID, err)
+		}
+		item.Status = Status(status)
+		item.CreatedAt, _ = time.Parse(timeLayout, createdAt)
+		item.UpdatedAt, _ = time.Parse(timeLayout, updatedAt)
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// timeLayout has a fixed width, so timestamps stored as text sort chronologically.
+const timeLayout = "2006-01-02T15:04:05.000000000Z"
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeLayout)
+}
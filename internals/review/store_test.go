@@ -0,0 +1,57 @@
+package review
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"mailflow/internals/email"
+)
+
+func TestClaimSend(t *testing.T) {
+	ctx := context.Background()
+	store, err := Open(filepath.Join(t.TempDir(), "reviews.db"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer store.Close()
+
+	item, err := store.Add(ctx, Item{RunID: "run-1", Email: email.EmailInfo{ID: "m1"}, Category: "BILLING", Draft: "Hello"})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if _, err := store.ClaimSend(ctx, item.ID); !errors.Is(err, ErrConflict) {
+		t.Fatalf("ClaimSend of a pending item returned %v, want %v", err, ErrConflict)
+	}
+	if _, err := store.Approve(ctx, item.ID); err != nil {
+		t.Fatalf("Approve: %v", err)
+	}
+
+	claimed, err := store.ClaimSend(ctx, item.ID)
+	if err != nil {
+		t.Fatalf("ClaimSend: %v", err)
+	}
+	if claimed.Status != StatusSending {
+		t.Fatalf("claimed status = %s, want %s", claimed.Status, StatusSending)
+	}
+	if _, err := store.ClaimSend(ctx, item.ID); !errors.Is(err, ErrConflict) {
+		t.Fatalf("second ClaimSend returned %v, want %v", err, ErrConflict)
+	}
+	if approved, _ := store.List(ctx, StatusApproved); len(approved) != 0 {
+		t.Fatalf("approved items = %v, want none while the item is being sent", approved)
+	}
+
+	if err := store.ReleaseSend(ctx, item.ID); err != nil {
+		t.Fatalf("ReleaseSend: %v", err)
+	}
+	if _, err := store.ClaimSend(ctx, item.ID); err != nil {
+		t.Fatalf("ClaimSend after release: %v", err)
+	}
+	if err := store.MarkSent(ctx, item.ID); err != nil {
+		t.Fatalf("MarkSent: %v", err)
+	}
+	if err := store.MarkSent(ctx, item.ID); !errors.Is(err, ErrConflict) {
+		t.Fatalf("second MarkSent returned %v, want %v", err, ErrConflict)
+	}
+}
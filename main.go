@@ -2,54 +2,279 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"mailflow/internals/ai"
+	"mailflow/internals/config"
+	"mailflow/internals/email"
+	"mailflow/internals/email/backend"
+	"mailflow/internals/email/gmail"
+	"mailflow/internals/email/memory"
+	"mailflow/internals/graph"
+	"mailflow/internals/graph/checkpoint"
+	"mailflow/internals/inbox"
+	"mailflow/internals/ledger"
+	"mailflow/internals/llm"
+	"mailflow/internals/rag"
+	"mailflow/internals/rag/adapter"
+	"mailflow/internals/review"
 
 	"github.com/fatih/color"
-	"github.com/zaynkorai/mailflow/internals/ai"
-	"github.com/zaynkorai/mailflow/util/gmail"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "graph" {
+		if err := runGraphCommand(os.Args[2:]); err != nil {
+			log.Fatalf("Failed to export workflow graph: %v", err)
+		}
+		return
+	}
+
+	// SIGINT/SIGTERM stop new work; emails already picked up are drained below.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	ctx := context.Background()
-	googleAPIKey := os.Getenv("GOOGLE_API_KEY")
-	if googleAPIKey == "" {
-		log.Fatal("GOOGLE_API_KEY environment variable not set. Please set it to your Gemini API key.")
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
-	maxIterations := 70 // Corresponds to recursion_limit
+	mailService, err := backend.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize %s mail backend: %v", cfg.MailProvider, err)
+	}
 
-	workflowApp, err := ai.NewWorkflow(ctx, googleAPIKey)
+	generator, err := llm.NewGenerator(llm.ProviderConfig{
+		Provider: cfg.LLM.Provider,
+		Model:    cfg.LLM.Model,
+		BaseURL:  cfg.LLM.BaseURL,
+		APIKey:   cfg.LLM.APIKey,
+		Fixture:  cfg.LLM.Fixture,
+		RecordTo: cfg.LLM.RecordTo,
+		Retry:    &cfg.LLM.Retry,
+	})
 	if err != nil {
-		log.Fatalf("Failed to initialize workflow: %v", err)
+		log.Fatalf("Failed to initialize %s LLM provider: %v", cfg.LLM.Provider, err)
+	}
+
+	taxonomy := ai.DefaultTaxonomy()
+	if cfg.Categories != "" {
+		if taxonomy, err = ai.LoadTaxonomy(cfg.Categories); err != nil {
+			log.Fatalf("Failed to load email categories: %v", err)
+		}
+	}
+
+	policy, err := ai.ParseDeliveryPolicy(cfg.ReplyPolicy, ai.DeliveryMode(cfg.ReplyMode))
+	if err != nil {
+		log.Fatalf("Invalid REPLY_POLICY: %v", err)
+	}
+
+	var reviews *review.Store
+	if policy.Uses(ai.DeliveryApproval) || cfg.Threshold > 0 {
+		reviews, err = review.Open(cfg.ReviewDB)
+		if err != nil {
+			log.Fatalf("Failed to open review queue: %v", err)
+		}
+		defer reviews.Close()
 	}
 
-	initialState := ai.GraphState{
-		EmailsInfo:         []gmail.EmailInfo{},
-		CurrentEmailInfo:   gmail.EmailInfo{},
-		EmailCategory:      "",
-		GeneratedEmail:     "",
-		RAGQueries:         []string{},
-		RetrievedDocuments: "",
-		WriterMessages:     []string{},
-		Sendable:           false,
-		Trials:             0,
+	// Product questions are answered from the knowledge base built by
+	// cmd/rag-indexer and the API, which needs Gemini for query embeddings
+	// unless it is only searched by keyword.
+	var retriever ai.Retriever
+	if cfg.GoogleAPIKey != "" || cfg.RAGMode == rag.RetrieveKeyword {
+		vectorStore, err := adapter.New(cfg.VectorStore)
+		if err != nil {
+			log.Fatalf("Failed to open %s vector store: %v", cfg.VectorStore.Store, err)
+		}
+		defer vectorStore.Close()
+		var embedder rag.Embedder
+		if cfg.GoogleAPIKey != "" {
+			embedder = llm.NewGeminiEmbedder(cfg.GoogleAPIKey, "").WithRetryPolicy(cfg.LLM.Retry)
+		}
+		retriever = rag.NewRAGSystem(nil, embedder, vectorStore)
+	} else {
+		log.Printf("GOOGLE_API_KEY is not set, product questions are answered without the knowledge base")
 	}
 
-	initialState.EmailsInfo = append(initialState.EmailsInfo, gmail.EmailInfo{
-		ID: "mock_id_1", ThreadID: "mock_thread_1", MessageID: "mock_msg_1",
-		Sender: "test@example.com", Subject: "Inquiry about product A",
-		Body: "Hi team, I have a question about the features of product A. Could you clarify its compatibility?",
+	workflowApp, err := ai.NewWorkflow(generator, mailService, ai.Options{
+		Taxonomy:  taxonomy,
+		Threshold: cfg.Threshold,
+		Policy:    policy,
+		Reviews:   reviews,
+		ForwardTo: cfg.ForwardTo,
+		Retriever: retriever,
+		TopK:      cfg.RAGTopK,
+		MinScore:  cfg.RAGMinScore,
+		Retrieval: cfg.RAGMode,
+		Fusion:    cfg.RAGFusion,
 	})
+	if err != nil {
+		log.Fatalf("Failed to initialize workflow: %v", err)
+	}
+
+	var led *ledger.Ledger
+	if cfg.Ledger.Path != "" {
+		led, err = ledger.Open(cfg.Ledger.Path, cfg.Ledger.MaxAttempts)
+		if err != nil {
+			log.Fatalf("Failed to open processed-message ledger: %v", err)
+		}
+		defer led.Close()
+	}
+
+	if cfg.Checkpoint.Store != "" {
+		checkpointer, err := checkpoint.New[*ai.GraphState](cfg.Checkpoint.Store, cfg.Checkpoint.Path)
+		if err != nil {
+			log.Fatalf("Failed to open %s checkpoint store: %v", cfg.Checkpoint.Store, err)
+		}
+		workflowApp.Graph.SetCheckpointer(checkpointer)
+		if cfg.Checkpoint.Retention > 0 {
+			pruneCheckpoints(ctx, checkpointer, cfg.Checkpoint.Retention)
+			go func() {
+				ticker := time.NewTicker(checkpointPruneInterval)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-ctx.Done():
+						return
+					case <-ticker.C:
+						pruneCheckpoints(ctx, checkpointer, cfg.Checkpoint.Retention)
+					}
+				}
+			}()
+		}
+
+		// Finish runs interrupted by a crash or restart before looking at the inbox again.
+		if err := resumeInterrupted(ctx, workflowApp, checkpointer, led); err != nil {
+			log.Fatalf("Failed to list interrupted workflow runs: %v", err)
+		}
+	}
 
 	fmt.Println(color.GreenString("Starting workflow..."))
 
-	finalState, err := workflowApp.Graph.Execute(ctx, initialState, maxIterations)
+	pool := inbox.NewPool(workflowApp, cfg.Workers, led, printRunResult)
+	poller := inbox.NewPoller(mailService, pool, inbox.DefaultBatchSize)
+	if reviews != nil {
+		poller.SetReviews(reviews)
+	}
+	var pollErr error
+	if cfg.PollInterval > 0 {
+		if gmailService, ok := mailService.(*gmail.GmailUtils); ok {
+			startGmailNotifications(ctx, gmailService, cfg.Gmail, poller)
+		}
+		fmt.Println(color.GreenString("Polling the inbox every %s, stop with Ctrl+C...", cfg.PollInterval))
+		pollErr = poller.Run(ctx, cfg.PollInterval)
+	} else {
+		var submitted int
+		submitted, pollErr = poller.PollOnce(ctx)
+		if submitted == 0 && pollErr == nil {
+			fmt.Println(color.RedString("No new emails"))
+		}
+	}
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), cfg.DrainTimeout)
+	defer cancel()
+	if err := pool.Shutdown(drainCtx); err != nil {
+		log.Printf("Shutdown did not finish draining in-flight emails: %v", err)
+	}
+	for _, stats := range llm.Stats() {
+		if stats.Retries > 0 || stats.Failures > 0 {
+			log.Printf("%s: %d requests, %d retries (%d rate limited), %d failed, breaker opened %d times",
+				stats.Model, stats.Requests, stats.Retries, stats.RateLimited, stats.Failures, stats.BreakerOpened)
+		}
+	}
+	if pollErr != nil && !errors.Is(pollErr, context.Canceled) {
+		log.Fatalf("Workflow execution failed: %v", pollErr)
+	}
+
+	// With the in-memory backend nothing leaves the process, so show what would have been delivered.
+	if mailbox, ok := mailService.(*memory.Mailbox); ok {
+		for _, draft := range mailbox.Drafts() {
+			fmt.Println(color.CyanString("Draft reply to %s (%s):", draft.InReplyTo.Sender, draft.InReplyTo.Subject))
+			fmt.Println(draft.Body)
+		}
+		for _, reply := range mailbox.Sent() {
+			fmt.Println(color.CyanString("Sent reply to %s (%s):", reply.InReplyTo.Sender, reply.InReplyTo.Subject))
+			fmt.Println(reply.Body)
+		}
+		for _, forward := range mailbox.Forwards() {
+			fmt.Println(color.CyanString("Forwarded %s (%s) to %s", forward.Original.Sender, forward.Original.Subject, forward.To))
+		}
+	}
+}
+
+// checkpointPruneInterval is how often a long-running process drops the
+// checkpoints of runs that finished longer ago than CHECKPOINT_RETENTION.
+const checkpointPruneInterval = time.Hour
+
+func pruneCheckpoints(ctx context.Context, checkpointer graph.Checkpointer[*ai.GraphState], retention time.Duration) {
+	pruned, err := checkpointer.Prune(ctx, time.Now().Add(-retention))
+	if err != nil {
+		log.Printf("Failed to prune workflow checkpoints: %v", err)
+		return
+	}
+	if pruned > 0 {
+		log.Printf("Pruned %d checkpoints of runs finished more than %s ago", pruned, retention)
+	}
+}
+
+// resumeInterrupted resumes the runs left pending in checkpointer. With a
+// ledger, a run is only resumed if it can claim its message again; runs whose
+// message already has a final outcome, was retried under another run or ran
+// out of attempts lose their checkpoint instead.
+func resumeInterrupted(ctx context.Context, workflowApp *ai.Workflow, checkpointer graph.Checkpointer[*ai.GraphState], led *ledger.Ledger) error {
+	pending, err := checkpointer.Pending(ctx)
 	if err != nil {
-		log.Fatalf("Workflow execution failed: %v", err)
+		return err
 	}
+	for _, runID := range pending {
+		if led != nil {
+			cp, err := checkpointer.Load(ctx, runID)
+			if err != nil {
+				log.Printf("Failed to load interrupted run %s: %v", runID, err)
+				continue
+			}
+			var msg email.EmailInfo
+			if cp.State != nil {
+				msg = cp.State.CurrentEmailInfo
+			}
+			claimed, err := led.ClaimResume(ctx, msg, runID)
+			if err != nil {
+				log.Printf("Failed to claim interrupted run %s: %v", runID, err)
+				continue
+			}
+			if !claimed {
+				fmt.Println(color.YellowString("Dropping interrupted workflow run %s, the ledger shows its email was handled or retried since", runID))
+				if err := checkpointer.Delete(ctx, runID); err != nil {
+					log.Printf("Failed to drop interrupted run %s: %v", runID, err)
+				}
+				continue
+			}
+		}
 
-	log.Printf("Workflow completed. Final state: %+v\n", finalState)
+		fmt.Println(color.YellowString("Resuming interrupted workflow run %s...", runID))
+		result := workflowApp.Resume(ctx, runID)
+		if led != nil {
+			if err := led.Finish(ctx, result); err != nil {
+				log.Printf("Failed to record resumed run %s: %v", runID, err)
+			}
+		}
+		printRunResult(result)
+	}
+	return nil
+}
+
+func printRunResult(result ai.RunResult) {
+	summary := fmt.Sprintf("Run %s: %s from %s (%s)", result.RunID, result.Outcome, result.Email.Sender, result.Email.Subject)
+	if result.Err != nil {
+		fmt.Println(color.RedString("%s: %v", summary, result.Err))
+		return
+	}
+	fmt.Println(color.GreenString(summary))
 }